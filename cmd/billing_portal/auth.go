@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type authenticatedUser struct {
+	CognitoUserID    string
+	StripeCustomerID string
+}
+
+// authenticate reads the claims of the Cognito JWT that the API Gateway JWT authorizer has already verified
+// and checks that the token was issued by this deployment's user pool.
+func authenticate(request events.APIGatewayV2HTTPRequest) (authenticatedUser, error) {
+	authorizer := request.RequestContext.Authorizer
+	if authorizer == nil || authorizer.JWT == nil || len(authorizer.JWT.Claims) == 0 {
+		return authenticatedUser{}, fmt.Errorf("request has no JWT claims")
+	}
+	claims := authorizer.JWT.Claims
+	issuer, err := expectedIssuer()
+	if err != nil {
+		return authenticatedUser{}, err
+	}
+	if claims["iss"] != issuer {
+		return authenticatedUser{}, fmt.Errorf("token issuer %q does not match user pool", claims["iss"])
+	}
+	switch claims["token_use"] {
+	case "id", "access":
+	default:
+		return authenticatedUser{}, fmt.Errorf("unexpected token_use %q", claims["token_use"])
+	}
+	cognitoUserID := firstClaim(claims, "cognito:username", "username", "sub")
+	if cognitoUserID == "" {
+		return authenticatedUser{}, fmt.Errorf("token has no username claim")
+	}
+	return authenticatedUser{
+		CognitoUserID:    cognitoUserID,
+		StripeCustomerID: claims["custom:stripe_customer_id"],
+	}, nil
+}
+
+func expectedIssuer() (string, error) {
+	userPoolID, ok := os.LookupEnv("USER_POOL_ID")
+	if !ok {
+		return "", fmt.Errorf("environment variable USER_POOL_ID is not set")
+	}
+	region, ok := os.LookupEnv("AWS_REGION")
+	if !ok {
+		return "", fmt.Errorf("environment variable AWS_REGION is not set")
+	}
+	return fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s", region, userPoolID), nil
+}
+
+func firstClaim(claims map[string]string, names ...string) string {
+	for _, name := range names {
+		if value := claims[name]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
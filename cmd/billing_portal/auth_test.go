@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func newRequest(claims map[string]string) events.APIGatewayV2HTTPRequest {
+	return events.APIGatewayV2HTTPRequest{
+		RequestContext: events.APIGatewayV2HTTPRequestContext{
+			Authorizer: &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+				JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+					Claims: claims,
+				},
+			},
+		},
+	}
+}
+
+func Test_authenticate(t *testing.T) {
+	const issuer = "https://cognito-idp.us-east-2.amazonaws.com/us-east-2_example"
+	tests := []struct {
+		name    string
+		request events.APIGatewayV2HTTPRequest
+		want    authenticatedUser
+		wantErr bool
+	}{
+		{
+			name: "id_token",
+			request: newRequest(map[string]string{
+				"iss":                       issuer,
+				"token_use":                 "id",
+				"sub":                       "aaaa-bbbb",
+				"cognito:username":          "56789",
+				"custom:stripe_customer_id": "cus_01234",
+			}),
+			want:    authenticatedUser{CognitoUserID: "56789", StripeCustomerID: "cus_01234"},
+			wantErr: false,
+		},
+		{
+			name: "access_token",
+			request: newRequest(map[string]string{
+				"iss":       issuer,
+				"token_use": "access",
+				"sub":       "aaaa-bbbb",
+				"username":  "56789",
+			}),
+			want:    authenticatedUser{CognitoUserID: "56789"},
+			wantErr: false,
+		},
+		{
+			name:    "no_authorizer",
+			request: events.APIGatewayV2HTTPRequest{},
+			wantErr: true,
+		},
+		{
+			name: "wrong_issuer",
+			request: newRequest(map[string]string{
+				"iss":       "https://cognito-idp.us-east-2.amazonaws.com/us-east-2_other",
+				"token_use": "id",
+				"sub":       "aaaa-bbbb",
+			}),
+			wantErr: true,
+		},
+		{
+			name: "wrong_token_use",
+			request: newRequest(map[string]string{
+				"iss":       issuer,
+				"token_use": "refresh",
+				"sub":       "aaaa-bbbb",
+			}),
+			wantErr: true,
+		},
+	}
+	os.Setenv("USER_POOL_ID", "us-east-2_example")
+	os.Setenv("AWS_REGION", "us-east-2")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := authenticate(tt.request)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("authenticate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("authenticate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/seanturner026/maido-lambdas/internal/keys"
+)
+
+type awsDynamoDBAPI interface {
+	GetItem(
+		ctx context.Context,
+		params *dynamodb.GetItemInput,
+		optFns ...func(*dynamodb.Options),
+	) (*dynamodb.GetItemOutput, error)
+}
+
+func generateGetItemInput(schema keys.Schema, cognitoUserID, tableName string) *dynamodb.GetItemInput {
+	return &dynamodb.GetItemInput{
+		Key:                  schema.UserKey(cognitoUserID),
+		TableName:            aws.String(tableName),
+		ProjectionExpression: aws.String("StripeCustomerID"),
+	}
+}
+
+// getStripeCustomerID returns an empty string without an error when the user item does not exist.
+func getStripeCustomerID(ctx context.Context, db awsDynamoDBAPI, cognitoUserID string) (string, error) {
+	tableName, ok := os.LookupEnv("DYNAMODB_TABLE_NAME")
+	if !ok {
+		return "", fmt.Errorf("environment variable DYNAMODB_TABLE_NAME is not set")
+	}
+	schema, err := keys.FromEnv()
+	if err != nil {
+		return "", err
+	}
+	resp, err := db.GetItem(ctx, generateGetItemInput(schema, cognitoUserID, tableName))
+	if err != nil {
+		return "", err
+	}
+	item := struct {
+		StripeCustomerID string `dynamodbav:"StripeCustomerID"`
+	}{}
+	err = attributevalue.UnmarshalMap(resp.Item, &item)
+	if err != nil {
+		return "", err
+	}
+	return item.StripeCustomerID, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/keys"
+)
+
+type mockGetItem struct {
+	Response *dynamodb.GetItemOutput
+	Error    error
+	Input    *dynamodb.GetItemInput
+}
+
+func (m *mockGetItem) GetItem(
+	ctx context.Context,
+	params *dynamodb.GetItemInput,
+	optFns ...func(*dynamodb.Options),
+) (*dynamodb.GetItemOutput, error) {
+	m.Input = params
+	return m.Response, m.Error
+}
+
+func Test_generateGetItemInput(t *testing.T) {
+	want := &dynamodb.GetItemInput{
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "USER#56789"},
+			"SK": &types.AttributeValueMemberS{Value: "USER#MAIDO"},
+		},
+		TableName:            aws.String("example"),
+		ProjectionExpression: aws.String("StripeCustomerID"),
+	}
+	if got := generateGetItemInput(keys.Default(), "56789", "example"); !reflect.DeepEqual(got, want) {
+		t.Errorf("generateGetItemInput() = %v, want %v", got, want)
+	}
+}
+
+func Test_getStripeCustomerID(t *testing.T) {
+	tests := []struct {
+		name    string
+		db      *mockGetItem
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "found",
+			db: &mockGetItem{Response: &dynamodb.GetItemOutput{Item: map[string]types.AttributeValue{
+				"StripeCustomerID": &types.AttributeValueMemberS{Value: "cus_01234"},
+			}}},
+			want:    "cus_01234",
+			wantErr: false,
+		},
+		{
+			name:    "not_found",
+			db:      &mockGetItem{Response: &dynamodb.GetItemOutput{}},
+			want:    "",
+			wantErr: false,
+		},
+		{
+			name:    "error",
+			db:      &mockGetItem{Error: fmt.Errorf("example error")},
+			want:    "",
+			wantErr: true,
+		},
+	}
+	os.Setenv("DYNAMODB_TABLE_NAME", "example")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getStripeCustomerID(context.TODO(), tt.db, "56789")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getStripeCustomerID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("getStripeCustomerID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+	"github.com/seanturner026/maido-lambdas/internal/stripeclient"
+	log "github.com/sirupsen/logrus"
+	"github.com/stripe/stripe-go/v72/client"
+)
+
+var (
+	db           *dynamodb.Client
+	stripeClient *client.API
+)
+
+func init() {
+	log.SetFormatter(&log.JSONFormatter{})
+	if err := logging.Configure(log.StandardLogger(), logging.ConfigFromEnv()); err != nil {
+		log.WithFields(log.Fields{"error": err}).Warn("Logging configuration was overridden")
+	}
+	var err error
+	stripeClient, err = stripeclient.FromEnv()
+	if err != nil {
+		log.Fatalf("unable to configure Stripe client, %v", err)
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+	db = dynamodb.NewFromConfig(cfg)
+}
+
+type sessionResponse struct {
+	URL string `json:"url"`
+}
+
+type errorResponse struct {
+	Message string `json:"message"`
+}
+
+func jsonResponse(statusCode int, body interface{}) events.APIGatewayV2HTTPResponse {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		statusCode = http.StatusInternalServerError
+		payload = []byte(`{"message":"unable to marshal response"}`)
+	}
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}
+}
+
+func createPortalSession(
+	ctx context.Context,
+	request events.APIGatewayV2HTTPRequest,
+	db awsDynamoDBAPI,
+	apiPortal stripeBillingPortalSessionAPI,
+) events.APIGatewayV2HTTPResponse {
+	returnURL, ok := os.LookupEnv("BILLING_PORTAL_RETURN_URL")
+	if !ok {
+		logging.FromContext(ctx).Error("environment variable BILLING_PORTAL_RETURN_URL is not set")
+		return jsonResponse(http.StatusInternalServerError, errorResponse{Message: "billing portal is not configured"})
+	}
+	user, err := authenticate(request)
+	if err != nil {
+		logging.FromContext(ctx).WithFields(log.Fields{"error": err}).Warn("Rejected billing portal request")
+		return jsonResponse(http.StatusUnauthorized, errorResponse{Message: "unauthorized"})
+	}
+	customerID := user.StripeCustomerID
+	if customerID == "" {
+		customerID, err = getStripeCustomerID(ctx, db, user.CognitoUserID)
+		if err != nil {
+			logging.FromContext(ctx).WithFields(log.Fields{"cognito_user_id": user.CognitoUserID, "error": err}).Error("Unable to look up stripe customer ID")
+			return jsonResponse(http.StatusInternalServerError, errorResponse{Message: "unable to look up customer"})
+		}
+	}
+	if customerID == "" {
+		logging.FromContext(ctx).WithFields(log.Fields{"cognito_user_id": user.CognitoUserID}).Warn("User has no stripe customer ID")
+		return jsonResponse(http.StatusNotFound, errorResponse{Message: "customer not found"})
+	}
+	sessionURL, err := createSession(apiPortal, customerID, returnURL)
+	if err != nil {
+		logging.FromContext(ctx).WithFields(log.Fields{"cognito_user_id": user.CognitoUserID, "stripe_customer_id": customerID, "error": err}).
+			Error("Unable to create billing portal session")
+		return jsonResponse(http.StatusBadGateway, errorResponse{Message: "unable to create billing portal session"})
+	}
+	return jsonResponse(http.StatusOK, sessionResponse{URL: sessionURL})
+}
+
+func handler(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	return createPortalSession(ctx, request, db, stripeClient.BillingPortalSessions), nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
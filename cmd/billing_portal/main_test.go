@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stripe/stripe-go/v72"
+)
+
+func Test_createPortalSession(t *testing.T) {
+	const issuer = "https://cognito-idp.us-east-2.amazonaws.com/us-east-2_example"
+	dbFound := &mockGetItem{Response: &dynamodb.GetItemOutput{Item: map[string]types.AttributeValue{
+		"StripeCustomerID": &types.AttributeValueMemberS{Value: "cus_from_dynamodb"},
+	}}}
+	portal := &mockBillingPortalSession{Response: &stripe.BillingPortalSession{URL: "https://billing.stripe.com/session/example"}}
+	tests := []struct {
+		name         string
+		request      events.APIGatewayV2HTTPRequest
+		db           *mockGetItem
+		apiPortal    *mockBillingPortalSession
+		wantStatus   int
+		wantBody     string
+		wantCustomer string
+	}{
+		{
+			name: "customer_id_from_claims",
+			request: newRequest(map[string]string{
+				"iss": issuer, "token_use": "id", "cognito:username": "56789", "custom:stripe_customer_id": "cus_from_claims",
+			}),
+			db:           &mockGetItem{Error: fmt.Errorf("should not be called")},
+			apiPortal:    portal,
+			wantStatus:   http.StatusOK,
+			wantBody:     `{"url":"https://billing.stripe.com/session/example"}`,
+			wantCustomer: "cus_from_claims",
+		},
+		{
+			name: "customer_id_from_dynamodb",
+			request: newRequest(map[string]string{
+				"iss": issuer, "token_use": "access", "username": "56789",
+			}),
+			db:           dbFound,
+			apiPortal:    portal,
+			wantStatus:   http.StatusOK,
+			wantBody:     `{"url":"https://billing.stripe.com/session/example"}`,
+			wantCustomer: "cus_from_dynamodb",
+		},
+		{
+			name: "customer_not_found",
+			request: newRequest(map[string]string{
+				"iss": issuer, "token_use": "access", "username": "56789",
+			}),
+			db:         &mockGetItem{Response: &dynamodb.GetItemOutput{}},
+			apiPortal:  portal,
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"message":"customer not found"}`,
+		},
+		{
+			name:       "unauthenticated",
+			request:    events.APIGatewayV2HTTPRequest{},
+			db:         dbFound,
+			apiPortal:  portal,
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   `{"message":"unauthorized"}`,
+		},
+		{
+			name: "stripe_error",
+			request: newRequest(map[string]string{
+				"iss": issuer, "token_use": "id", "cognito:username": "56789", "custom:stripe_customer_id": "cus_from_claims",
+			}),
+			db:         dbFound,
+			apiPortal:  &mockBillingPortalSession{Error: fmt.Errorf("example error")},
+			wantStatus: http.StatusBadGateway,
+			wantBody:   `{"message":"unable to create billing portal session"}`,
+		},
+	}
+	os.Setenv("USER_POOL_ID", "us-east-2_example")
+	os.Setenv("AWS_REGION", "us-east-2")
+	os.Setenv("DYNAMODB_TABLE_NAME", "example")
+	os.Setenv("BILLING_PORTAL_RETURN_URL", "https://example.com/account")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := createPortalSession(context.TODO(), tt.request, tt.db, tt.apiPortal)
+			if got.StatusCode != tt.wantStatus {
+				t.Errorf("createPortalSession() status = %v, want %v", got.StatusCode, tt.wantStatus)
+			}
+			if got.Body != tt.wantBody {
+				t.Errorf("createPortalSession() body = %v, want %v", got.Body, tt.wantBody)
+			}
+			if tt.wantCustomer != "" && *tt.apiPortal.Params.Customer != tt.wantCustomer {
+				t.Errorf("createPortalSession() customer = %v, want %v", *tt.apiPortal.Params.Customer, tt.wantCustomer)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/stripe/stripe-go/v72"
+)
+
+type stripeBillingPortalSessionAPI interface {
+	New(params *stripe.BillingPortalSessionParams) (*stripe.BillingPortalSession, error)
+}
+
+func createSession(api stripeBillingPortalSessionAPI, customerID, returnURL string) (string, error) {
+	params := &stripe.BillingPortalSessionParams{
+		Customer:  stripe.String(customerID),
+		ReturnURL: stripe.String(returnURL),
+	}
+	session, err := api.New(params)
+	if err != nil {
+		return "", err
+	}
+	return session.URL, nil
+}
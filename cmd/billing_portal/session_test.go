@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+type mockBillingPortalSession struct {
+	Response *stripe.BillingPortalSession
+	Error    error
+	Params   *stripe.BillingPortalSessionParams
+}
+
+func (m *mockBillingPortalSession) New(params *stripe.BillingPortalSessionParams) (*stripe.BillingPortalSession, error) {
+	m.Params = params
+	return m.Response, m.Error
+}
+
+func Test_createSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		api     *mockBillingPortalSession
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "created",
+			api:     &mockBillingPortalSession{Response: &stripe.BillingPortalSession{URL: "https://billing.stripe.com/session/example"}},
+			want:    "https://billing.stripe.com/session/example",
+			wantErr: false,
+		},
+		{
+			name:    "error",
+			api:     &mockBillingPortalSession{Error: fmt.Errorf("example error")},
+			want:    "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := createSession(tt.api, "cus_01234", "https://example.com/account")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("createSession() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("createSession() = %v, want %v", got, tt.want)
+			}
+			if *tt.api.Params.Customer != "cus_01234" || *tt.api.Params.ReturnURL != "https://example.com/account" {
+				t.Errorf("createSession() params = %v", tt.api.Params)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stripe/stripe-go/v72"
+)
+
+type createProviderEvent struct {
+	PK                   string `dynamodbav:"PK"`
+	SK                   string `dynamodbav:"SK"`
+	StripeAccountID      string `dynamodbav:"StripeAccountID"`
+	ChargesEnabled       bool   `dynamodbav:"ChargesEnabled"`
+	PayoutsEnabled       bool   `dynamodbav:"PayoutsEnabled"`
+	AccountLinkURL       string `dynamodbav:"AccountLinkURL"`
+	AccountLinkExpiresAt int64  `dynamodbav:"AccountLinkExpiresAt"`
+	SQSMessageID         string `dynamodbav:"-"`
+	SQSReceiptHandle     string `dynamodbav:"-"`
+	CognitoUserID        string `dynamodbav:"-"                    json:"cognitoUserID"`
+	FirstName            string `dynamodbav:"FirstName"            json:"firstName"`
+	SurName              string `dynamodbav:"SurName"              json:"surName"`
+	EmailAddress         string `dynamodbav:"EmailAddress"         json:"email"`
+	Country              string `dynamodbav:"Country"              json:"country"`
+}
+
+type accountLinkURLs struct {
+	RefreshURL string
+	ReturnURL  string
+}
+
+func accountLinkURLsFromEnv() (accountLinkURLs, error) {
+	refreshURL, ok := os.LookupEnv("STRIPE_CONNECT_REFRESH_URL")
+	if !ok {
+		return accountLinkURLs{}, fmt.Errorf("environment variable STRIPE_CONNECT_REFRESH_URL is not set")
+	}
+	returnURL, ok := os.LookupEnv("STRIPE_CONNECT_RETURN_URL")
+	if !ok {
+		return accountLinkURLs{}, fmt.Errorf("environment variable STRIPE_CONNECT_RETURN_URL is not set")
+	}
+	return accountLinkURLs{RefreshURL: refreshURL, ReturnURL: returnURL}, nil
+}
+
+type resultStripe struct {
+	Message         string
+	Event           createProviderEvent
+	PutRequestInput map[string]types.AttributeValue
+	Error           error
+}
+
+// accountIdempotencyKey is the Stripe idempotency key of cognitoUserID's Connect account. Messages are delivered
+// again whenever a later write fails, and the key makes Stripe return the account it already created instead of
+// creating another.
+func accountIdempotencyKey(cognitoUserID string) string {
+	return "connect-account-" + cognitoUserID
+}
+
+type stripeAccountCreateAPI interface {
+	New(params *stripe.AccountParams) (*stripe.Account, error)
+}
+
+type stripeAccountLinkCreateAPI interface {
+	New(params *stripe.AccountLinkParams) (*stripe.AccountLink, error)
+}
+
+func createAccounts(
+	wg *sync.WaitGroup,
+	ch chan resultStripe,
+	apiAccount stripeAccountCreateAPI,
+	apiAccountLink stripeAccountLinkCreateAPI,
+	links accountLinkURLs,
+	event *createProviderEvent,
+) {
+	defer wg.Done()
+	if event.Country == "" {
+		event.Country = os.Getenv("STRIPE_CONNECT_COUNTRY")
+	}
+	account, err := createAccount(apiAccount, *event)
+	if err != nil {
+		ch <- resultStripe{Message: "Unable to create Connect account", Event: *event, Error: err}
+		return
+	}
+	event.StripeAccountID = account.ID
+	// The flags are as of the account's creation, when they are usually false; keeping them current is left to a
+	// later refresh or an account.updated webhook.
+	event.ChargesEnabled = account.ChargesEnabled
+	event.PayoutsEnabled = account.PayoutsEnabled
+	log.WithFields(log.Fields{"cognito_user_id": event.CognitoUserID, "stripe_account_id": account.ID}).Info("Created stripe connect account")
+	accountLink, err := createAccountLink(apiAccountLink, account.ID, links)
+	if err != nil {
+		ch <- resultStripe{
+			Message: "Unable to create account link",
+			Event:   *event,
+			Error:   err,
+		}
+		return
+	}
+	event.AccountLinkURL = accountLink.URL
+	event.AccountLinkExpiresAt = accountLink.ExpiresAt
+	putRequestInput, err := generatePutRequestInput(*event)
+	if err != nil {
+		ch <- resultStripe{
+			Message: "Unable to generate DynamoDB input",
+			Event:   *event,
+			Error:   err,
+		}
+		return
+	}
+	ch <- resultStripe{PutRequestInput: putRequestInput, Event: *event}
+}
+
+func createAccount(api stripeAccountCreateAPI, event createProviderEvent) (*stripe.Account, error) {
+	params := &stripe.AccountParams{
+		BusinessType: stripe.String(string(stripe.AccountBusinessTypeIndividual)),
+		Capabilities: &stripe.AccountCapabilitiesParams{
+			CardPayments: &stripe.AccountCapabilitiesCardPaymentsParams{
+				Requested: stripe.Bool(true),
+			},
+			Transfers: &stripe.AccountCapabilitiesTransfersParams{
+				Requested: stripe.Bool(true),
+			},
+		},
+		Email: stripe.String(event.EmailAddress),
+		Individual: &stripe.PersonParams{
+			Email:     stripe.String(event.EmailAddress),
+			FirstName: stripe.String(event.FirstName),
+			LastName:  stripe.String(event.SurName),
+		},
+		Type: stripe.String(string(stripe.AccountTypeExpress)),
+	}
+	if event.Country != "" {
+		params.Country = stripe.String(event.Country)
+	}
+	params.AddMetadata("cognito_user_id", event.CognitoUserID)
+	params.SetIdempotencyKey(accountIdempotencyKey(event.CognitoUserID))
+	return api.New(params)
+}
+
+// createAccountLink creates an onboarding link for accountID. It carries no idempotency key: links expire within
+// minutes, so a redelivered message needs a fresh one rather than the link Stripe cached for the first delivery.
+func createAccountLink(api stripeAccountLinkCreateAPI, accountID string, links accountLinkURLs) (*stripe.AccountLink, error) {
+	params := &stripe.AccountLinkParams{
+		Account:    stripe.String(accountID),
+		RefreshURL: stripe.String(links.RefreshURL),
+		ReturnURL:  stripe.String(links.ReturnURL),
+		Type:       stripe.String(string(stripe.AccountLinkTypeAccountOnboarding)),
+	}
+	return api.New(params)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+type mockStripeAccount struct {
+	Response *stripe.Account
+	Error    error
+	Params   *stripe.AccountParams
+}
+
+func (m *mockStripeAccount) New(params *stripe.AccountParams) (*stripe.Account, error) {
+	m.Params = params
+	return m.Response, m.Error
+}
+
+type mockStripeAccountLink struct {
+	Response *stripe.AccountLink
+	Error    error
+	Params   *stripe.AccountLinkParams
+}
+
+func (m *mockStripeAccountLink) New(params *stripe.AccountLinkParams) (*stripe.AccountLink, error) {
+	m.Params = params
+	return m.Response, m.Error
+}
+
+func Test_accountLinkURLsFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    accountLinkURLs
+		wantErr bool
+	}{
+		{
+			name: "both_set",
+			env: map[string]string{
+				"STRIPE_CONNECT_REFRESH_URL": "https://example.com/refresh",
+				"STRIPE_CONNECT_RETURN_URL":  "https://example.com/return",
+			},
+			want: accountLinkURLs{
+				RefreshURL: "https://example.com/refresh",
+				ReturnURL:  "https://example.com/return",
+			},
+			wantErr: false,
+		},
+		{
+			name: "return_url_missing",
+			env: map[string]string{
+				"STRIPE_CONNECT_REFRESH_URL": "https://example.com/refresh",
+			},
+			want:    accountLinkURLs{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		os.Unsetenv("STRIPE_CONNECT_REFRESH_URL")
+		os.Unsetenv("STRIPE_CONNECT_RETURN_URL")
+		for k, v := range tt.env {
+			os.Setenv(k, v)
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := accountLinkURLsFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("accountLinkURLsFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("accountLinkURLsFromEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_createAccounts(t *testing.T) {
+	links := accountLinkURLs{RefreshURL: "https://example.com/refresh", ReturnURL: "https://example.com/return"}
+	type args struct {
+		apiAccount     stripeAccountCreateAPI
+		apiAccountLink stripeAccountLinkCreateAPI
+		event          *createProviderEvent
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    createProviderEvent
+		wantErr bool
+	}{
+		{
+			name: "created",
+			args: args{
+				apiAccount: &mockStripeAccount{
+					Response: &stripe.Account{ID: "acct_01234", ChargesEnabled: false, PayoutsEnabled: true},
+				},
+				apiAccountLink: &mockStripeAccountLink{
+					Response: &stripe.AccountLink{URL: "https://connect.stripe.com/setup/e/acct_01234", ExpiresAt: 1640995200},
+				},
+				event: &createProviderEvent{
+					CognitoUserID: "56789",
+					FirstName:     "first",
+					SurName:       "last",
+					EmailAddress:  "example@example.com",
+					Country:       "US",
+				},
+			},
+			want: createProviderEvent{
+				StripeAccountID:      "acct_01234",
+				ChargesEnabled:       false,
+				PayoutsEnabled:       true,
+				AccountLinkURL:       "https://connect.stripe.com/setup/e/acct_01234",
+				AccountLinkExpiresAt: 1640995200,
+				CognitoUserID:        "56789",
+				FirstName:            "first",
+				SurName:              "last",
+				EmailAddress:         "example@example.com",
+				Country:              "US",
+			},
+			wantErr: false,
+		},
+		{
+			name: "account_link_error",
+			args: args{
+				apiAccount: &mockStripeAccount{
+					Response: &stripe.Account{ID: "acct_01234"},
+				},
+				apiAccountLink: &mockStripeAccountLink{
+					Error: fmt.Errorf("example error"),
+				},
+				event: &createProviderEvent{CognitoUserID: "56789"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wg := &sync.WaitGroup{}
+			ch := make(chan resultStripe, 1)
+			wg.Add(1)
+			createAccounts(wg, ch, tt.args.apiAccount, tt.args.apiAccountLink, links, tt.args.event)
+			wg.Wait()
+			close(ch)
+			got := <-ch
+			if (got.Error != nil) != tt.wantErr {
+				t.Errorf("createAccounts() error = %v, wantErr %v", got.Error, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got.Event, tt.want) {
+				t.Errorf("createAccounts() = %v, want %v", got.Event, tt.want)
+			}
+			link := tt.args.apiAccountLink.(*mockStripeAccountLink)
+			if key := link.Params.IdempotencyKey; key != nil {
+				t.Errorf("createAccounts() account link idempotency key = %v, want none", *key)
+			}
+		})
+	}
+}
+
+func Test_createAccount(t *testing.T) {
+	type args struct {
+		api   *mockStripeAccount
+		event createProviderEvent
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "",
+			args: args{
+				api: &mockStripeAccount{
+					Response: &stripe.Account{ID: "acct_01234"},
+				},
+				event: createProviderEvent{CognitoUserID: "56789", EmailAddress: "foo.bar@gmail.com", FirstName: "Boo", SurName: "Far"},
+			},
+			want:    "acct_01234",
+			wantErr: false,
+		},
+		{
+			name: "",
+			args: args{
+				api: &mockStripeAccount{
+					Error: fmt.Errorf("example error"),
+				},
+				event: createProviderEvent{CognitoUserID: "56789"},
+			},
+			want:    "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := createAccount(tt.args.api, tt.args.event)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("createAccount() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != nil && got.ID != tt.want {
+				t.Errorf("createAccount() = %v, want %v", got.ID, tt.want)
+			}
+			if key := tt.args.api.Params.IdempotencyKey; key == nil || *key != "connect-account-56789" {
+				t.Errorf("createAccount() idempotency key = %v", key)
+			}
+		})
+	}
+}
@@ -25,22 +25,23 @@ type awsCognitoIdentityProviderAPI interface {
 	) (*cognitoidentityprovider.AdminUpdateUserAttributesOutput, error)
 }
 
-func writeStripeIDUserAttribute(
+func writeStripeAccountIDUserAttribute(
 	ctx context.Context,
 	wg *sync.WaitGroup,
 	ch chan resultCognito,
 	cognito awsCognitoIdentityProviderAPI,
-	event createCustomerEvent,
+	event createProviderEvent,
 ) {
 	defer wg.Done()
 	userPoolID, ok := os.LookupEnv("USER_POOL_ID")
 	if !ok {
 		ch <- resultCognito{Error: fmt.Errorf("environment variable USER_POOL_ID is not set"), UserID: event.CognitoUserID, Message: "Unable to add user attribute"}
+		return
 	}
 	input := &cognitoidentityprovider.AdminUpdateUserAttributesInput{
 		UserAttributes: []types.AttributeType{{
-			Name:  aws.String("custom:stripe_customer_id"),
-			Value: aws.String(event.StripeCustomerID),
+			Name:  aws.String("custom:stripe_account_id"),
+			Value: aws.String(event.StripeAccountID),
 		}},
 		UserPoolId: aws.String(userPoolID),
 		Username:   aws.String(event.CognitoUserID),
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+)
+
+type mockAdminUpdateUserAttributes struct {
+	Response *cognitoidentityprovider.AdminUpdateUserAttributesOutput
+	Error    error
+	Input    *cognitoidentityprovider.AdminUpdateUserAttributesInput
+}
+
+func (m *mockAdminUpdateUserAttributes) AdminUpdateUserAttributes(
+	ctx context.Context,
+	params *cognitoidentityprovider.AdminUpdateUserAttributesInput,
+	optFns ...func(*cognitoidentityprovider.Options),
+) (*cognitoidentityprovider.AdminUpdateUserAttributesOutput, error) {
+	m.Input = params
+	return m.Response, m.Error
+}
+
+func Test_writeStripeAccountIDUserAttribute(t *testing.T) {
+	tests := []struct {
+		name    string
+		cognito *mockAdminUpdateUserAttributes
+		event   createProviderEvent
+		wantErr bool
+	}{
+		{
+			name: "updated",
+			cognito: &mockAdminUpdateUserAttributes{
+				Response: &cognitoidentityprovider.AdminUpdateUserAttributesOutput{},
+			},
+			event: createProviderEvent{
+				StripeAccountID: "acct_01234",
+				CognitoUserID:   "56789",
+			},
+			wantErr: false,
+		},
+		{
+			name: "error",
+			cognito: &mockAdminUpdateUserAttributes{
+				Error: fmt.Errorf("example error"),
+			},
+			event: createProviderEvent{
+				StripeAccountID: "acct_01234",
+				CognitoUserID:   "56789",
+			},
+			wantErr: true,
+		},
+	}
+	_ = os.Setenv("USER_POOL_ID", "example")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wg := &sync.WaitGroup{}
+			ch := make(chan resultCognito, 1)
+			wg.Add(1)
+			writeStripeAccountIDUserAttribute(context.TODO(), wg, ch, tt.cognito, tt.event)
+			wg.Wait()
+			close(ch)
+			res := <-ch
+			if (res.Error != nil) != tt.wantErr {
+				t.Errorf("writeStripeAccountIDUserAttribute() error = %v, wantErr %v", res.Error, tt.wantErr)
+			}
+			attribute := tt.cognito.Input.UserAttributes[0]
+			if aws.ToString(attribute.Name) != "custom:stripe_account_id" || aws.ToString(attribute.Value) != tt.event.StripeAccountID {
+				t.Errorf("writeStripeAccountIDUserAttribute() attribute = %v=%v", aws.ToString(attribute.Name), aws.ToString(attribute.Value))
+			}
+		})
+	}
+}
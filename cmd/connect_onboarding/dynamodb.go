@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/awsbatch"
+	"github.com/seanturner026/maido-lambdas/internal/batch"
+	log "github.com/sirupsen/logrus"
+)
+
+const providerKeyPrefix = "PROVIDER#"
+
+// maxBatchWriteItemAttempts bounds how often puts DynamoDB leaves unprocessed are sent. Puts still unprocessed
+// after the last attempt fail their messages, which SQS delivers again.
+const maxBatchWriteItemAttempts = 5
+
+// generatePutRequests collects the puts of the successful results, along with every successful result.
+func generatePutRequests(chanStripe chan resultStripe) ([]types.WriteRequest, items, string, error) {
+	tableName, ok := os.LookupEnv("DYNAMODB_TABLE_NAME")
+	if !ok {
+		return []types.WriteRequest{}, items{}, "", fmt.Errorf("environment variable DYNAMODB_TABLE_NAME is not set")
+	}
+	writeRequests := []types.WriteRequest{}
+	items := &items{}
+	for res := range chanStripe {
+		if res.Error != nil {
+			log.WithFields(log.Fields{
+				"cognito_user_id":   res.Event.CognitoUserID,
+				"stripe_account_id": res.Event.StripeAccountID,
+				"error":             res.Error,
+			}).Error(res.Message)
+			continue
+		}
+		items.Items = append(items.Items, res.Event)
+		writeRequests = append(writeRequests, types.WriteRequest{PutRequest: &types.PutRequest{Item: res.PutRequestInput}})
+	}
+	return writeRequests, *items, tableName, nil
+}
+
+func generatePutRequestInput(item createProviderEvent) (map[string]types.AttributeValue, error) {
+	item.PK = providerKeyPrefix + item.CognitoUserID
+	item.SK = providerKeyPrefix + "MAIDO"
+	putItemInput, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return map[string]types.AttributeValue{}, err
+	}
+	return putItemInput, err
+}
+
+func extractCognitoUserIDSFromWriteRequests(writeRequests []types.WriteRequest) ([]string, error) {
+	type cognitoUser struct {
+		ID string `dynamodbav:"PK"`
+	}
+	cognitoUserIDS := []string{}
+	for _, item := range writeRequests {
+		id := &cognitoUser{}
+		err := attributevalue.UnmarshalMap(item.PutRequest.Item, id)
+		if err != nil {
+			return cognitoUserIDS, err
+		}
+		cognitoUserIDS = append(cognitoUserIDS, strings.TrimPrefix(id.ID, providerKeyPrefix))
+	}
+	return cognitoUserIDS, nil
+}
+
+type resultDB struct {
+	Message string
+	UserIDS []string
+	Error   error
+}
+
+type awsDynamoDBAPI = awsbatch.BatchWriteItemAPI
+
+// batchWriteItems writes writeRequests in batches of up to awsbatch.MaxBatchWriteItemRequests and sends a result for
+// each distinct failure.
+func batchWriteItems(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	ch chan resultDB,
+	db awsDynamoDBAPI,
+	writeRequests []types.WriteRequest,
+	tableName string,
+) {
+	defer wg.Done()
+	opts := batch.Options{MaxAttempts: maxBatchWriteItemAttempts}
+	results := awsbatch.WriteItems(ctx, db, tableName, writeRequests, opts, nil)
+	failures := map[string]*resultDB{}
+	order := []string{}
+	for _, res := range results {
+		if res.Err == nil {
+			continue
+		}
+		cognitoUserIDs, err := extractCognitoUserIDSFromWriteRequests([]types.WriteRequest{res.Item})
+		if err != nil {
+			log.Error("Error batch writing and extracting cognito user IDs from input object")
+		}
+		failure, ok := failures[res.Err.Error()]
+		if !ok {
+			failure = &resultDB{Error: res.Err, Message: "Error writing Stripe Account IDs to dynamodb"}
+			failures[res.Err.Error()] = failure
+			order = append(order, res.Err.Error())
+		}
+		failure.UserIDS = append(failure.UserIDS, cognitoUserIDs...)
+	}
+	for _, key := range order {
+		ch <- *failures[key]
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/fakes"
+)
+
+func Test_generatePutRequests(t *testing.T) {
+	const tableName = "example_table_name"
+	providerEvent := createProviderEvent{
+		StripeAccountID: "acct_01234",
+		CognitoUserID:   "56789",
+	}
+	putRequestInput := map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "PROVIDER#56789"},
+	}
+	newChanStripe := func(count int, failures int) chan resultStripe {
+		ch := make(chan resultStripe, count+failures)
+		for i := 0; i < failures; i++ {
+			ch <- resultStripe{Message: "example", Error: fmt.Errorf("example error")}
+		}
+		for i := 0; i < count; i++ {
+			ch <- resultStripe{Event: providerEvent, PutRequestInput: putRequestInput}
+		}
+		close(ch)
+		return ch
+	}
+	tests := []struct {
+		name       string
+		chanStripe chan resultStripe
+		wantItems  int
+	}{
+		{name: "0_items", chanStripe: newChanStripe(0, 0), wantItems: 0},
+		{name: "1_item_1_failure", chanStripe: newChanStripe(1, 1), wantItems: 1},
+		{name: "26_items", chanStripe: newChanStripe(26, 0), wantItems: 26},
+	}
+	os.Setenv("DYNAMODB_TABLE_NAME", tableName)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotItems, gotTableName, err := generatePutRequests(tt.chanStripe)
+			if err != nil {
+				t.Errorf("generatePutRequests() error = %v", err)
+				return
+			}
+			if len(got) != tt.wantItems {
+				t.Errorf("generatePutRequests() requests = %v, want %v", len(got), tt.wantItems)
+			}
+			for _, request := range got {
+				if !reflect.DeepEqual(request.PutRequest.Item, putRequestInput) {
+					t.Errorf("generatePutRequests() request = %v, want %v", request.PutRequest.Item, putRequestInput)
+				}
+			}
+			if len(gotItems.Items) != tt.wantItems {
+				t.Errorf("generatePutRequests() items = %v, want %v", len(gotItems.Items), tt.wantItems)
+			}
+			if gotTableName != tableName {
+				t.Errorf("generatePutRequests() tableName = %v, want %v", gotTableName, tableName)
+			}
+		})
+	}
+}
+
+func Test_generatePutRequestInput(t *testing.T) {
+	type args struct {
+		item createProviderEvent
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    map[string]types.AttributeValue
+		wantErr bool
+	}{
+		{
+			name: "",
+			args: args{
+				item: createProviderEvent{
+					StripeAccountID:      "acct_01234",
+					ChargesEnabled:       false,
+					PayoutsEnabled:       true,
+					AccountLinkURL:       "https://connect.stripe.com/setup/e/acct_01234",
+					AccountLinkExpiresAt: 1640995200,
+					CognitoUserID:        "56789",
+					FirstName:            "first_example",
+					SurName:              "sur_example",
+					EmailAddress:         "example@example.com",
+					Country:              "US",
+				},
+			},
+			want: map[string]types.AttributeValue{
+				"PK":                   &types.AttributeValueMemberS{Value: "PROVIDER#56789"},
+				"SK":                   &types.AttributeValueMemberS{Value: "PROVIDER#MAIDO"},
+				"StripeAccountID":      &types.AttributeValueMemberS{Value: "acct_01234"},
+				"ChargesEnabled":       &types.AttributeValueMemberBOOL{Value: false},
+				"PayoutsEnabled":       &types.AttributeValueMemberBOOL{Value: true},
+				"AccountLinkURL":       &types.AttributeValueMemberS{Value: "https://connect.stripe.com/setup/e/acct_01234"},
+				"AccountLinkExpiresAt": &types.AttributeValueMemberN{Value: "1640995200"},
+				"FirstName":            &types.AttributeValueMemberS{Value: "first_example"},
+				"SurName":              &types.AttributeValueMemberS{Value: "sur_example"},
+				"EmailAddress":         &types.AttributeValueMemberS{Value: "example@example.com"},
+				"Country":              &types.AttributeValueMemberS{Value: "US"},
+			},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := generatePutRequestInput(tt.args.item)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("generatePutRequestInput() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("generatePutRequestInput() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_extractCognitoUserIDSFromWriteRequests(t *testing.T) {
+	writeRequests := []types.WriteRequest{
+		{
+			PutRequest: &types.PutRequest{
+				Item: map[string]types.AttributeValue{
+					"PK": &types.AttributeValueMemberS{Value: "PROVIDER#12345"},
+				},
+			},
+		}, {
+			PutRequest: &types.PutRequest{
+				Item: map[string]types.AttributeValue{
+					"PK": &types.AttributeValueMemberS{Value: "PROVIDER#67890"},
+				},
+			},
+		},
+	}
+	got, err := extractCognitoUserIDSFromWriteRequests(writeRequests)
+	if err != nil {
+		t.Fatalf("extractCognitoUserIDSFromWriteRequests() error = %v", err)
+	}
+	if want := []string{"12345", "67890"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("extractCognitoUserIDSFromWriteRequests() = %v, want %v", got, want)
+	}
+}
+
+func Test_batchWriteItems(t *testing.T) {
+	const tableName = "example_table_name"
+	writeRequests := func(count int) []types.WriteRequest {
+		requests := []types.WriteRequest{}
+		for i := 0; i < count; i++ {
+			requests = append(requests, types.WriteRequest{PutRequest: &types.PutRequest{
+				Item: map[string]types.AttributeValue{
+					"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("PROVIDER#%02d", i)},
+					"SK": &types.AttributeValueMemberS{Value: "PROVIDER#MAIDO"},
+				},
+			}})
+		}
+		return requests
+	}
+	tests := []struct {
+		name          string
+		requests      int
+		inject        func(db *fakes.DynamoDB)
+		wantCalls     int
+		wantUserIDS   []string
+		wantUnwritten int
+	}{
+		{name: "processed", requests: 2, wantCalls: 1},
+		{name: "26_items", requests: 26, wantCalls: 2},
+		{
+			name:      "unprocessed_retried",
+			requests:  2,
+			inject:    func(db *fakes.DynamoDB) { db.UnprocessedOn(1, 1) },
+			wantCalls: 2,
+		},
+		{
+			name:     "unprocessed_until_last_attempt",
+			requests: 2,
+			inject: func(db *fakes.DynamoDB) {
+				for n := 1; n <= maxBatchWriteItemAttempts; n++ {
+					db.UnprocessedOn(n, 1)
+				}
+			},
+			wantCalls:     maxBatchWriteItemAttempts,
+			wantUserIDS:   []string{"01"},
+			wantUnwritten: 1,
+		},
+		{
+			name:          "error",
+			requests:      2,
+			inject:        func(db *fakes.DynamoDB) { db.FailOn("BatchWriteItem", 1, fmt.Errorf("example error")) },
+			wantCalls:     1,
+			wantUserIDS:   []string{"00", "01"},
+			wantUnwritten: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakes.NewDynamoDB()
+			if tt.inject != nil {
+				tt.inject(db)
+			}
+			wg := &sync.WaitGroup{}
+			ch := make(chan resultDB, tt.requests+1)
+			wg.Add(1)
+			batchWriteItems(context.TODO(), wg, ch, db, writeRequests(tt.requests), tableName)
+			wg.Wait()
+			close(ch)
+			userIDs := []string{}
+			for res := range ch {
+				if res.Error == nil {
+					t.Errorf("batchWriteItems() result without error = %+v", res)
+				}
+				userIDs = append(userIDs, res.UserIDS...)
+			}
+			if len(tt.wantUserIDS) > 0 && !reflect.DeepEqual(userIDs, tt.wantUserIDS) {
+				t.Errorf("batchWriteItems() UserIDS = %v, want %v", userIDs, tt.wantUserIDS)
+			}
+			if len(tt.wantUserIDS) == 0 && len(userIDs) > 0 {
+				t.Errorf("batchWriteItems() UserIDS = %v, want none", userIDs)
+			}
+			if calls := len(db.Calls("BatchWriteItem")); calls != tt.wantCalls {
+				t.Errorf("batchWriteItems() calls = %v, want %v", calls, tt.wantCalls)
+			}
+			if stored := len(db.Items(tableName)); stored != tt.requests-tt.wantUnwritten {
+				t.Errorf("batchWriteItems() stored = %v, want %v", stored, tt.requests-tt.wantUnwritten)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/seanturner026/maido-lambdas/internal/awsbatch"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+	"github.com/seanturner026/maido-lambdas/internal/stripeclient"
+	log "github.com/sirupsen/logrus"
+	"github.com/stripe/stripe-go/v72/client"
+)
+
+var (
+	cognito      *cognitoidentityprovider.Client
+	db           *dynamodb.Client
+	queue        *sqs.Client
+	stripeClient *client.API
+)
+
+func init() {
+	log.SetFormatter(&log.JSONFormatter{})
+	if err := logging.Configure(log.StandardLogger(), logging.ConfigFromEnv()); err != nil {
+		log.WithFields(log.Fields{"error": err}).Warn("Logging configuration was overridden")
+	}
+	var err error
+	stripeClient, err = stripeclient.FromEnv()
+	if err != nil {
+		log.Fatalf("unable to configure Stripe client, %v", err)
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+	db = dynamodb.NewFromConfig(cfg)
+	cognito = cognitoidentityprovider.NewFromConfig(cfg)
+	queue = sqs.NewFromConfig(cfg)
+}
+
+type items struct {
+	Items []createProviderEvent
+}
+
+func unmarshalCreateProviderEvents(event events.SQSEvent) ([]*createProviderEvent, error) {
+	return awsbatch.Unmarshal(event, func(item *createProviderEvent, record events.SQSMessage) {
+		item.SQSMessageID = record.MessageId
+		item.SQSReceiptHandle = record.ReceiptHandle
+	})
+}
+
+// writtenItems returns the items whose user is not in failed. Only their messages are deleted, so that SQS delivers
+// the others again and their writes are retried.
+func writtenItems(all items, failed map[string]bool) items {
+	written := items{}
+	for _, item := range all.Items {
+		if !failed[item.CognitoUserID] {
+			written.Items = append(written.Items, item)
+		}
+	}
+	return written
+}
+
+func onboardProvider(ctx context.Context, event events.SQSEvent) error {
+	providerEvents, err := unmarshalCreateProviderEvents(event)
+	if err != nil {
+		return err
+	}
+	links, err := accountLinkURLsFromEnv()
+	if err != nil {
+		return err
+	}
+	requestCount := len(event.Records)
+	wg := &sync.WaitGroup{}
+	wg.Add(requestCount)
+	chanStripe := make(chan resultStripe, requestCount)
+	for _, providerEvent := range providerEvents {
+		go createAccounts(wg, chanStripe, stripeClient.Account, stripeClient.AccountLinks, links, providerEvent)
+	}
+	wg.Wait()
+	close(chanStripe)
+	writeRequests, items, tableName, err := generatePutRequests(chanStripe)
+	if err != nil {
+		return err
+	}
+	requestCount = 1 + len(items.Items)
+	wg.Add(requestCount)
+	// The batch write sends a result for each distinct failure, so the buffer leaves room for one per put.
+	chanDynamoDB := make(chan resultDB, len(writeRequests)+1)
+	chanCognito := make(chan resultCognito, len(items.Items))
+	go batchWriteItems(ctx, wg, chanDynamoDB, db, writeRequests, tableName)
+	for _, item := range items.Items {
+		go writeStripeAccountIDUserAttribute(ctx, wg, chanCognito, cognito, item)
+	}
+	wg.Wait()
+	close(chanDynamoDB)
+	close(chanCognito)
+	failed := map[string]bool{}
+	for ch := range chanDynamoDB {
+		if ch.Error != nil {
+			for _, userID := range ch.UserIDS {
+				failed[userID] = true
+			}
+			logging.FromContext(ctx).WithFields(log.Fields{"cognito_user_ids": ch.UserIDS, "error": ch.Error}).Error(ch.Message)
+		}
+	}
+	for ch := range chanCognito {
+		if ch.Error != nil {
+			failed[ch.UserID] = true
+			logging.FromContext(ctx).WithFields(log.Fields{"cognito_user_id": ch.UserID, "error": ch.Error}).Error(ch.Message)
+		}
+	}
+	entries, queueURL, err := generateDeleteMessageBatchRequestEntries(writtenItems(items, failed))
+	if err != nil {
+		return err
+	}
+	wg.Add(1)
+	chanSQS := make(chan resultSQS, len(entries)+1)
+	go batchDeleteMessages(ctx, wg, chanSQS, queue, queueURL, entries)
+	wg.Wait()
+	close(chanSQS)
+	for ch := range chanSQS {
+		if ch.Error != nil {
+			logging.FromContext(ctx).WithFields(log.Fields{"message_ids": ch.MessageIDS, "failed_delete_messages": ch.FailedDeleteMessages, "error": ch.Error}).
+				Error(ch.Message)
+		}
+	}
+	return nil
+}
+
+func handler(ctx context.Context, event events.SQSEvent) error {
+	logging.FromContext(ctx).Info(fmt.Sprintf("Handling %v events", len(event.Records)))
+	err := onboardProvider(ctx, event)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -9,14 +9,14 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 )
 
-func Test_unmarshalCreateCustomerEvents(t *testing.T) {
+func Test_unmarshalCreateProviderEvents(t *testing.T) {
 	type args struct {
 		event events.SQSEvent
 	}
 	tests := []struct {
 		name    string
 		args    args
-		want    []*createCustomerEvent
+		want    []*createProviderEvent
 		wantErr bool
 	}{
 		{
@@ -26,20 +26,18 @@ func Test_unmarshalCreateCustomerEvents(t *testing.T) {
 					Records: []events.SQSMessage{{
 						MessageId:     "123456789",
 						ReceiptHandle: "23456789",
-						Body:          "{\"cognitoUserID\": \"56789\", \"email\": \"example@example.com\", \"firstName\": \"first_example\", \"surName\": \"sur_example\"}",
+						Body:          "{\"cognitoUserID\": \"56789\", \"email\": \"example@example.com\", \"firstName\": \"first_example\", \"surName\": \"sur_example\", \"country\": \"US\"}",
 					}},
 				},
 			},
-			want: []*createCustomerEvent{{
-				PK:               "",
-				SK:               "",
-				StripeCustomerID: "",
+			want: []*createProviderEvent{{
 				SQSMessageID:     "123456789",
 				SQSReceiptHandle: "23456789",
 				CognitoUserID:    "56789",
 				FirstName:        "first_example",
 				SurName:          "sur_example",
 				EmailAddress:     "example@example.com",
+				Country:          "US",
 			}},
 			wantErr: false,
 		},
@@ -54,19 +52,51 @@ func Test_unmarshalCreateCustomerEvents(t *testing.T) {
 					}},
 				},
 			},
-			want:    []*createCustomerEvent{},
+			want:    []*createProviderEvent{},
 			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := unmarshalCreateCustomerEvents(tt.args.event)
+			got, err := unmarshalCreateProviderEvents(tt.args.event)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("unmarshalCreateCustomerEvents() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("unmarshalCreateProviderEvents() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("unmarshalCreateCustomerEvents() = %v, want %v", got, tt.want)
+				t.Errorf("unmarshalCreateProviderEvents() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_writtenItems(t *testing.T) {
+	all := items{Items: []createProviderEvent{
+		{CognitoUserID: "12345", SQSMessageID: "message-01"},
+		{CognitoUserID: "23456", SQSMessageID: "message-02"},
+		{CognitoUserID: "34567", SQSMessageID: "message-03"},
+	}}
+	tests := []struct {
+		name   string
+		failed map[string]bool
+		want   items
+	}{
+		{name: "none_failed", failed: map[string]bool{}, want: all},
+		{
+			name:   "dynamodb_and_cognito_failed",
+			failed: map[string]bool{"12345": true, "34567": true},
+			want:   items{Items: []createProviderEvent{{CognitoUserID: "23456", SQSMessageID: "message-02"}}},
+		},
+		{
+			name:   "all_failed",
+			failed: map[string]bool{"12345": true, "23456": true, "34567": true},
+			want:   items{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := writtenItems(all, tt.failed); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("writtenItems() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -95,6 +125,8 @@ func Test_handler(t *testing.T) {
 	}
 	os.Setenv("SQS_QUEUE_URL", "example")
 	os.Setenv("DYNAMODB_TABLE_NAME", "example")
+	os.Setenv("STRIPE_CONNECT_REFRESH_URL", "https://example.com/refresh")
+	os.Setenv("STRIPE_CONNECT_RETURN_URL", "https://example.com/return")
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			if err := handler(tt.args.ctx, tt.args.event); (err != nil) != tt.wantErr {
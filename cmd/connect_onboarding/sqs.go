@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/seanturner026/maido-lambdas/internal/awsbatch"
+)
+
+// generateDeleteMessageBatchRequestEntries returns the delete entries of items and the queue to delete them from.
+func generateDeleteMessageBatchRequestEntries(items items) ([]types.DeleteMessageBatchRequestEntry, string, error) {
+	queueURL, ok := os.LookupEnv("SQS_QUEUE_URL")
+	if !ok {
+		return []types.DeleteMessageBatchRequestEntry{}, "", fmt.Errorf("environment variable SQS_QUEUE_URL is not set")
+	}
+	entries := []types.DeleteMessageBatchRequestEntry{}
+	for _, item := range items.Items {
+		entries = append(entries, awsbatch.DeleteEntry(item.SQSMessageID, item.SQSReceiptHandle))
+	}
+	return entries, queueURL, nil
+}
+
+type resultSQS struct {
+	Message              string
+	MessageIDS           []string
+	FailedDeleteMessages []string
+	Error                error
+}
+
+type awsSQSAPI = awsbatch.DeleteMessageBatchAPI
+
+// batchDeleteMessages deletes entries from queueURL in batches of up to awsbatch.MaxDeleteMessageBatchEntries and
+// sends a result for each failed batch and one for the entries that failed on their own.
+func batchDeleteMessages(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	ch chan resultSQS,
+	queue awsSQSAPI,
+	queueURL string,
+	entries []types.DeleteMessageBatchRequestEntry,
+) {
+	defer wg.Done()
+	results := awsbatch.DeleteMessages(ctx, queue, queueURL, entries, 0)
+	failures := map[string]*resultSQS{}
+	order := []string{}
+	outstanding := []types.BatchResultErrorEntry{}
+	for _, res := range results {
+		if res.Err == nil {
+			continue
+		}
+		var failure awsbatch.EntryFailure
+		if errors.As(res.Err, &failure) {
+			outstanding = append(outstanding, failure.Entry)
+			continue
+		}
+		result, ok := failures[res.Err.Error()]
+		if !ok {
+			result = &resultSQS{Error: res.Err, Message: "Unable to delete message batch"}
+			failures[res.Err.Error()] = result
+			order = append(order, res.Err.Error())
+		}
+		result.MessageIDS = append(result.MessageIDS, aws.ToString(res.Item.Id))
+	}
+	for _, key := range order {
+		ch <- *failures[key]
+	}
+	if len(outstanding) > 0 {
+		ch <- resultSQS{
+			Error:                fmt.Errorf("%d messages failed to delete", len(outstanding)),
+			FailedDeleteMessages: awsbatch.FailedEntries(outstanding),
+			Message:              "Messages failed to batch delete",
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/seanturner026/maido-lambdas/internal/awsbatch"
+	"github.com/seanturner026/maido-lambdas/internal/fakes"
+)
+
+func newItems(count int) items {
+	items := items{}
+	for i := 0; i < count; i++ {
+		items.Items = append(items.Items, createProviderEvent{
+			SQSMessageID:     fmt.Sprintf("message-%02d", i),
+			SQSReceiptHandle: fmt.Sprintf("receipt-%02d", i),
+		})
+	}
+	return items
+}
+
+func Test_generateDeleteMessageBatchRequestEntries(t *testing.T) {
+	const sqsQueueURL = "example_queue_url"
+	tests := []struct {
+		name  string
+		items items
+	}{
+		{name: "0_items", items: newItems(0)},
+		{name: "1_item", items: newItems(1)},
+		{name: "21_items", items: newItems(21)},
+	}
+	os.Setenv("SQS_QUEUE_URL", sqsQueueURL)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, queueURL, err := generateDeleteMessageBatchRequestEntries(tt.items)
+			if err != nil {
+				t.Fatalf("generateDeleteMessageBatchRequestEntries() error = %v", err)
+			}
+			if queueURL != sqsQueueURL {
+				t.Errorf("generateDeleteMessageBatchRequestEntries() queue = %v, want %v", queueURL, sqsQueueURL)
+			}
+			if len(got) != len(tt.items.Items) {
+				t.Fatalf("generateDeleteMessageBatchRequestEntries() entries = %v, want %v", len(got), len(tt.items.Items))
+			}
+			for i, entry := range got {
+				if aws.ToString(entry.Id) != tt.items.Items[i].SQSMessageID {
+					t.Errorf("generateDeleteMessageBatchRequestEntries() entry %d = %v, want %v", i, aws.ToString(entry.Id), tt.items.Items[i].SQSMessageID)
+				}
+			}
+		})
+	}
+}
+
+func Test_batchDeleteMessages(t *testing.T) {
+	tests := []struct {
+		name           string
+		messages       int
+		inject         func(queue *fakes.SQS)
+		wantCalls      int
+		wantMessageIDS int
+		wantFailed     int
+	}{
+		{name: "deleted", messages: 1, wantCalls: 1},
+		{name: "21_messages", messages: 21, wantCalls: 3},
+		{
+			name:       "partially_failed",
+			messages:   3,
+			inject:     func(queue *fakes.SQS) { queue.FailDelete("message-01", "ReceiptHandleIsInvalid") },
+			wantCalls:  1,
+			wantFailed: 1,
+		},
+		{
+			name:           "error",
+			messages:       3,
+			inject:         func(queue *fakes.SQS) { queue.FailOn("DeleteMessageBatch", 1, fmt.Errorf("example error")) },
+			wantCalls:      1,
+			wantMessageIDS: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := fakes.NewSQS()
+			if tt.inject != nil {
+				tt.inject(queue)
+			}
+			entries := []types.DeleteMessageBatchRequestEntry{}
+			for _, item := range newItems(tt.messages).Items {
+				entries = append(entries, awsbatch.DeleteEntry(item.SQSMessageID, item.SQSReceiptHandle))
+			}
+			wg := &sync.WaitGroup{}
+			ch := make(chan resultSQS, len(entries)+1)
+			wg.Add(1)
+			batchDeleteMessages(context.TODO(), wg, ch, queue, "example_queue_url", entries)
+			wg.Wait()
+			close(ch)
+			messageIDs, failed := 0, 0
+			for res := range ch {
+				if res.Error == nil {
+					t.Errorf("batchDeleteMessages() result without error = %+v", res)
+				}
+				messageIDs += len(res.MessageIDS)
+				failed += len(res.FailedDeleteMessages)
+			}
+			if messageIDs != tt.wantMessageIDS {
+				t.Errorf("batchDeleteMessages() message IDs = %v, want %v", messageIDs, tt.wantMessageIDS)
+			}
+			if failed != tt.wantFailed {
+				t.Errorf("batchDeleteMessages() failed = %v, want %v", failed, tt.wantFailed)
+			}
+			if calls := len(queue.Calls("DeleteMessageBatch")); calls != tt.wantCalls {
+				t.Errorf("batchDeleteMessages() calls = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+// Command fake_stripe serves an in-memory Stripe API for integration tests. Point a lambda at it with
+// STRIPE_API_BASE_URL=http://localhost:12111 and any STRIPE_API_KEY:
+//
+//	go run ./cmd/fake_stripe -addr localhost:12111 -promotion-code MAIDO=coupon_maido
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/seanturner026/maido-lambdas/internal/fakestripe"
+	log "github.com/sirupsen/logrus"
+)
+
+// promotionCodes collects repeated -promotion-code CODE=COUPON flags.
+type promotionCodes map[string]string
+
+func (p promotionCodes) String() string {
+	pairs := []string{}
+	for code, coupon := range p {
+		pairs = append(pairs, code+"="+coupon)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (p promotionCodes) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("promotion code must be CODE=COUPON, got %q", value)
+	}
+	p[parts[0]] = parts[1]
+	return nil
+}
+
+func main() {
+	log.SetFormatter(&log.JSONFormatter{})
+	addr := flag.String("addr", "localhost:12111", "address to listen on")
+	codes := promotionCodes{}
+	flag.Var(codes, "promotion-code", "active promotion code to serve as CODE=COUPON, repeatable")
+	flag.Parse()
+
+	server := fakestripe.New()
+	for code, coupon := range codes {
+		server.AddPromotionCode(code, coupon)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.WithFields(log.Fields{"method": r.Method, "path": r.URL.Path}).Info("Handling Stripe request")
+		server.ServeHTTP(w, r)
+	})
+	log.WithFields(log.Fields{"addr": *addr}).Info("Serving fake Stripe API")
+	if err := http.ListenAndServe(*addr, handler); err != nil {
+		log.Fatalf("unable to serve fake Stripe API, %v", err)
+	}
+}
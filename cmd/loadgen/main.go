@@ -0,0 +1,26 @@
+// Command loadgen writes a synthetic SQS event for the onboarding pipeline to stdout, to replay with onboard_local:
+//
+//	go run ./cmd/loadgen -n 10000 | ONBOARDING_MAX_CONCURRENCY=200 go run ./cmd/onboard_local -fakes
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/seanturner026/maido-lambdas/internal/loadgen"
+	log "github.com/sirupsen/logrus"
+)
+
+func main() {
+	opts := loadgen.DefaultOptions()
+	n := flag.Int("n", loadgen.MaxBatchSize, "number of messages in the event")
+	flag.Int64Var(&opts.Seed, "seed", opts.Seed, "seed for the generated messages")
+	flag.Float64Var(&opts.PromoCodeRate, "promo-code-rate", opts.PromoCodeRate, "fraction of messages with a promotion code")
+	flag.Float64Var(&opts.ReferralRate, "referral-rate", opts.ReferralRate, "fraction of messages with a referral code")
+	flag.Float64Var(&opts.SkipSubscriptionRate, "skip-subscription-rate", opts.SkipSubscriptionRate, "fraction of messages skipping the subscription")
+	flag.Parse()
+	if err := json.NewEncoder(os.Stdout).Encode(loadgen.SQSEvent(*n, opts)); err != nil {
+		log.Fatalf("unable to write SQS event, %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+// Command onboard_local replays an SQS event through the stripe_onboarding handler without the Lambda runtime:
+//
+//	go run ./cmd/onboard_local -fakes -event cmd/stripe_onboarding/event.json
+//	go run ./cmd/onboard_local < cmd/stripe_onboarding/event.json
+//
+// With -fakes the handler runs against in-memory AWS and Stripe fakes, or against the Stripe API at
+// STRIPE_API_BASE_URL when it is set, such as cmd/fake_stripe. Without it the handler uses the same clients and
+// environment variables as the deployed lambda. The outcome of each message is printed when the run finishes.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/seanturner026/maido-lambdas/internal/fakes"
+	"github.com/seanturner026/maido-lambdas/internal/keys"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+	"github.com/seanturner026/maido-lambdas/internal/onboarding"
+	"github.com/seanturner026/maido-lambdas/internal/stripeclient"
+	log "github.com/sirupsen/logrus"
+)
+
+// fakeEnvironment is applied, where not already set, when running against fakes.
+var fakeEnvironment = map[string]string{
+	"DYNAMODB_TABLE_NAME": "onboarding-local",
+	"SQS_QUEUE_URL":       "https://sqs.local/onboarding-local",
+	"USER_POOL_ID":        "local_pool",
+}
+
+func readEvent(path string, stdin io.Reader) (events.SQSEvent, error) {
+	event := events.SQSEvent{}
+	r := stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return event, err
+		}
+		defer f.Close()
+		r = f
+	}
+	if err := json.NewDecoder(r).Decode(&event); err != nil {
+		return event, fmt.Errorf("unable to decode SQS event: %w", err)
+	}
+	return event, nil
+}
+
+// newFakeHandler returns a handler that runs against in-memory fakes, using a real Stripe client only when
+// STRIPE_API_BASE_URL points it at a stand-in.
+func newFakeHandler() (*onboarding.Handler, error) {
+	for name, value := range fakeEnvironment {
+		if _, ok := os.LookupEnv(name); !ok {
+			os.Setenv(name, value)
+		}
+	}
+	stripe := fakes.NewStripe()
+	h := &onboarding.Handler{
+		Cognito:  fakes.NewCognito(),
+		DynamoDB: fakes.NewDynamoDB(),
+		SQS:      fakes.NewSQS(),
+		Stripe: onboarding.StripeAPI{
+			Customers:      stripe.Customers,
+			Subscriptions:  stripe.Subscriptions,
+			PromotionCodes: stripe.PromotionCodes,
+		},
+	}
+	if os.Getenv("STRIPE_API_BASE_URL") != "" {
+		sc, err := stripeclient.FromEnv()
+		if err != nil {
+			return nil, err
+		}
+		h.Stripe = onboarding.NewStripeAPI(sc)
+	}
+	return h, nil
+}
+
+// newHandler returns a handler with the deployed lambda's clients.
+func newHandler(ctx context.Context) (*onboarding.Handler, error) {
+	sc, err := stripeclient.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %w", err)
+	}
+	return onboarding.New(cfg, sc), nil
+}
+
+func printSummary(w io.Writer, summary *onboarding.Summary) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "MESSAGE ID\tCOGNITO USER ID\tSTATUS\tFAILED STAGE\tACKNOWLEDGED\tERROR")
+	for _, message := range summary.Messages {
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%s\t%s\n",
+			message.MessageID,
+			message.CognitoUserID,
+			message.Status,
+			message.FailedStage,
+			strconv.FormatBool(message.Acknowledged),
+			message.Error,
+		)
+	}
+	return tw.Flush()
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("onboard_local", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	path := flags.String("event", "-", "file holding the SQS event to handle, or - for stdin")
+	useFakes := flags.Bool("fakes", false, "run against in-memory fakes instead of AWS and Stripe")
+	asJSON := flags.Bool("json", false, "print the whole invocation summary as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	event, err := readEvent(*path, stdin)
+	if err != nil {
+		return err
+	}
+	schema, err := keys.FromEnv()
+	if err != nil {
+		return err
+	}
+	onboarding.UseKeySchema(schema)
+	ctx := context.Background()
+	var h *onboarding.Handler
+	if *useFakes {
+		h, err = newFakeHandler()
+	} else {
+		h, err = newHandler(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	// Keep stdout for the summary; the lambda writes its metrics there.
+	h.Metrics = stderr
+	summary, runErr := h.Run(ctx, event)
+	if summary != nil {
+		if *asJSON {
+			encoder := json.NewEncoder(stdout)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(summary)
+		} else {
+			err = printSummary(stdout, summary)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return runErr
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	if err := logging.Configure(log.StandardLogger(), logging.ConfigFromEnv()); err != nil {
+		log.WithFields(log.Fields{"error": err}).Warn("Logging configuration was overridden")
+	}
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		log.Fatalf("onboarding failed, %v", err)
+	}
+}
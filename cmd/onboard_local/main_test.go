@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/seanturner026/maido-lambdas/internal/onboarding"
+)
+
+func Test_readEvent(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		stdin       string
+		wantRecords int
+		wantErr     bool
+	}{
+		{name: "file", path: "../stripe_onboarding/event.json", wantRecords: 1},
+		{name: "stdin", path: "-", stdin: `{"Records": [{"messageId": "1"}, {"messageId": "2"}]}`, wantRecords: 2},
+		{name: "missing_file", path: "missing.json", wantErr: true},
+		{name: "invalid_json", path: "-", stdin: "{", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readEvent(tt.path, strings.NewReader(tt.stdin))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readEvent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got.Records) != tt.wantRecords {
+				t.Errorf("readEvent() = %v records, want %v", len(got.Records), tt.wantRecords)
+			}
+		})
+	}
+}
+
+func Test_run_fakes(t *testing.T) {
+	t.Setenv("STRIPE_API_BASE_URL", "")
+	t.Setenv("STRIPE_SUBSCRIPTION_PRICE_ID", "")
+	for name, value := range fakeEnvironment {
+		t.Setenv(name, value)
+	}
+	stdout := &bytes.Buffer{}
+	if err := run([]string{"-fakes", "-json", "-event", "../stripe_onboarding/event.json"}, nil, stdout, io.Discard); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	summary := onboarding.Summary{}
+	if err := json.Unmarshal(stdout.Bytes(), &summary); err != nil {
+		t.Fatalf("run() printed %q, want a JSON summary: %v", stdout.String(), err)
+	}
+	if len(summary.Messages) != 1 || summary.Messages[0].Status != onboarding.MessageStatusSucceeded || !summary.Messages[0].Acknowledged {
+		t.Errorf("run() summary messages = %+v, want one acknowledged success", summary.Messages)
+	}
+
+	stdout.Reset()
+	stdin := strings.NewReader(`{"Records": [{"messageId": "1", "body": "{\"cognitoUserID\": \"12345\"}"}]}`)
+	if err := run([]string{"-fakes"}, stdin, stdout, io.Discard); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "MESSAGE ID") || !strings.Contains(lines[1], onboarding.MessageStatusSucceeded) {
+		t.Errorf("run() printed %q, want a header and one succeeded message", stdout.String())
+	}
+}
+
+func Test_run_invalidEvent(t *testing.T) {
+	if err := run([]string{"-fakes"}, strings.NewReader("not json"), io.Discard, io.Discard); err == nil {
+		t.Errorf("run() error = nil, want an error for an invalid event")
+	}
+}
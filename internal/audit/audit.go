@@ -0,0 +1,145 @@
+// Package audit writes an append-only trail of the changes the onboarding lambdas make to Stripe, DynamoDB and
+// Cognito. Every Record is a separate DynamoDB item under the PK of the user it concerns with an AUDIT# sort key,
+// written with a condition that refuses to overwrite an existing item, either to a dedicated audit table or to
+// the onboarding table itself.
+package audit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/seanturner026/maido-lambdas/internal/keys"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+)
+
+// KeyPrefix starts the sort key of every audit item.
+const KeyPrefix = "AUDIT#"
+
+// Actor identifies the code that made a change.
+type Actor struct {
+	Function string `dynamodbav:"Function"`
+	Version  string `dynamodbav:"Version"`
+}
+
+// ActorFromLambda returns the running Lambda function name and version, using defaultFunction outside Lambda.
+func ActorFromLambda(defaultFunction string) Actor {
+	actor := Actor{Function: lambdacontext.FunctionName, Version: lambdacontext.FunctionVersion}
+	if actor.Function == "" {
+		actor.Function = defaultFunction
+	}
+	if actor.Version == "" {
+		actor.Version = "$LATEST"
+	}
+	return actor
+}
+
+// Record is a single audit item. Before is only set when the previous value is known.
+type Record struct {
+	PK            string            `dynamodbav:"PK"`
+	SK            string            `dynamodbav:"SK"`
+	Action        string            `dynamodbav:"Action"`
+	Actor         Actor             `dynamodbav:"Actor"`
+	Targets       map[string]string `dynamodbav:"Targets"`
+	Before        map[string]string `dynamodbav:"Before,omitempty"`
+	After         map[string]string `dynamodbav:"After,omitempty"`
+	Timestamp     string            `dynamodbav:"Timestamp"`
+	MessageID     string            `dynamodbav:"MessageID,omitempty"`
+	CorrelationID string            `dynamodbav:"CorrelationID,omitempty"`
+}
+
+// Change describes what an action did to its targets.
+type Change struct {
+	Action  string
+	Targets map[string]string
+	Before  map[string]string
+	After   map[string]string
+}
+
+// PutItemAPI is the subset of the DynamoDB client used by a Recorder.
+type PutItemAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// Recorder writes audit records. Record is a no-op on a nil Recorder, so callers can use FromContext without
+// checking whether auditing is enabled.
+type Recorder struct {
+	db        PutItemAPI
+	tableName string
+	keys      keys.Schema
+	actor     Actor
+	now       func() time.Time
+}
+
+// New returns a Recorder that writes to tableName as actor, keying records on schema's user partition.
+func New(db PutItemAPI, tableName string, schema keys.Schema, actor Actor) *Recorder {
+	return &Recorder{db: db, tableName: tableName, keys: schema, actor: actor, now: time.Now}
+}
+
+// TableNameFromEnv returns AUDIT_TABLE_NAME, falling back to the onboarding table in DYNAMODB_TABLE_NAME so
+// that records land under the user's own PK.
+func TableNameFromEnv() (string, error) {
+	if tableName, ok := os.LookupEnv("AUDIT_TABLE_NAME"); ok && tableName != "" {
+		return tableName, nil
+	}
+	if tableName, ok := os.LookupEnv("DYNAMODB_TABLE_NAME"); ok && tableName != "" {
+		return tableName, nil
+	}
+	return "", fmt.Errorf("environment variable AUDIT_TABLE_NAME or DYNAMODB_TABLE_NAME is not set")
+}
+
+// Record writes change for cognitoUserID. The message and correlation IDs are taken from ctx.
+func (r *Recorder) Record(ctx context.Context, cognitoUserID string, change Change) error {
+	if r == nil {
+		return nil
+	}
+	now := r.now().UTC()
+	record := Record{
+		PK:        r.keys.UserPK(cognitoUserID),
+		SK:        fmt.Sprintf("%s%s#%s", KeyPrefix, now.Format(time.RFC3339Nano), change.Action),
+		Action:    change.Action,
+		Actor:     r.actor,
+		Targets:   change.Targets,
+		Before:    change.Before,
+		After:     change.After,
+		Timestamp: now.Format(time.RFC3339Nano),
+	}
+	record.MessageID, record.CorrelationID = logging.CorrelationFromContext(ctx)
+	item, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.PutItem(ctx, &dynamodb.PutItemInput{
+		Item:                item,
+		TableName:           aws.String(r.tableName),
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to write audit record %s for %s: %w", change.Action, cognitoUserID, err)
+	}
+	return nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying r. A nil ctx is treated as context.Background.
+func NewContext(ctx context.Context, r *Recorder) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// FromContext returns the Recorder carried by ctx, or nil when there is none.
+func FromContext(ctx context.Context) *Recorder {
+	if ctx == nil {
+		return nil
+	}
+	r, _ := ctx.Value(contextKey{}).(*Recorder)
+	return r
+}
@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/seanturner026/maido-lambdas/internal/keys"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+)
+
+type mockPutItem struct {
+	Inputs []*dynamodb.PutItemInput
+	Error  error
+}
+
+func (m *mockPutItem) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	m.Inputs = append(m.Inputs, params)
+	return &dynamodb.PutItemOutput{}, m.Error
+}
+
+func TestRecorder_Record(t *testing.T) {
+	change := Change{
+		Action:  "stripe.customer.created",
+		Targets: map[string]string{"StripeCustomerID": "cus_01234"},
+		After:   map[string]string{"StripeCustomerID": "cus_01234"},
+	}
+	tests := []struct {
+		name    string
+		db      *mockPutItem
+		want    Record
+		wantErr bool
+	}{
+		{
+			name: "written",
+			db:   &mockPutItem{},
+			want: Record{
+				PK:            "USER#12345",
+				SK:            "AUDIT#2022-01-01T00:00:00Z#stripe.customer.created",
+				Action:        "stripe.customer.created",
+				Actor:         Actor{Function: "stripe_onboarding", Version: "3"},
+				Targets:       map[string]string{"StripeCustomerID": "cus_01234"},
+				After:         map[string]string{"StripeCustomerID": "cus_01234"},
+				Timestamp:     "2022-01-01T00:00:00Z",
+				MessageID:     "message-1",
+				CorrelationID: "correlation-1",
+			},
+		},
+		{
+			name:    "put_error",
+			db:      &mockPutItem{Error: fmt.Errorf("example error")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(tt.db, "audit", keys.Default(), Actor{Function: "stripe_onboarding", Version: "3"})
+			r.now = func() time.Time { return time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC) }
+			ctx := logging.WithMessage(context.TODO(), "message-1", "correlation-1")
+			err := r.Record(ctx, "12345", change)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Record() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			input := tt.db.Inputs[0]
+			if aws.ToString(input.TableName) != "audit" || aws.ToString(input.ConditionExpression) != "attribute_not_exists(PK)" {
+				t.Errorf("Record() input = %v/%v", aws.ToString(input.TableName), aws.ToString(input.ConditionExpression))
+			}
+			got := Record{}
+			if err := attributevalue.UnmarshalMap(input.Item, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Record() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecorder_nil(t *testing.T) {
+	if err := FromContext(context.TODO()).Record(context.TODO(), "12345", Change{}); err != nil {
+		t.Errorf("Record() on nil Recorder error = %v", err)
+	}
+}
+
+func TestTableNameFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{name: "audit_table", env: map[string]string{"AUDIT_TABLE_NAME": "audit", "DYNAMODB_TABLE_NAME": "users"}, want: "audit"},
+		{name: "onboarding_table", env: map[string]string{"AUDIT_TABLE_NAME": "", "DYNAMODB_TABLE_NAME": "users"}, want: "users"},
+		{name: "unset", env: map[string]string{"AUDIT_TABLE_NAME": "", "DYNAMODB_TABLE_NAME": ""}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			got, err := TableNameFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TableNameFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("TableNameFromEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
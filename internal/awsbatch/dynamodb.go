@@ -0,0 +1,102 @@
+package awsbatch
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/batch"
+	"github.com/seanturner026/maido-lambdas/internal/keys"
+)
+
+// MaxBatchWriteItemRequests is the most write requests DynamoDB accepts in one BatchWriteItem call.
+const MaxBatchWriteItemRequests = 25
+
+// BatchWriteItemAPI is the DynamoDB call WriteItems makes.
+type BatchWriteItemAPI interface {
+	BatchWriteItem(
+		ctx context.Context,
+		params *dynamodb.BatchWriteItemInput,
+		optFns ...func(*dynamodb.Options),
+	) (*dynamodb.BatchWriteItemOutput, error)
+}
+
+// WriteItems writes requests to tableName in batches of up to MaxBatchWriteItemRequests, at most
+// opts.Concurrency at once, and resends the puts DynamoDB leaves unprocessed, backing off as opts describe, until
+// opts.MaxAttempts is reached. opts.Size is ignored. sent, when not nil, is called after each successful call with
+// the requests of the call and the ones DynamoDB left unprocessed, and may be called concurrently.
+func WriteItems(
+	ctx context.Context,
+	db BatchWriteItemAPI,
+	tableName string,
+	requests []types.WriteRequest,
+	opts batch.Options,
+	sent func(chunk, unprocessed []types.WriteRequest),
+) []batch.Result[types.WriteRequest] {
+	opts.Size = MaxBatchWriteItemRequests
+	return batch.Run(ctx, requests, opts, func(ctx context.Context, chunk []types.WriteRequest) (batch.Response, error) {
+		resp, err := db.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{tableName: chunk},
+		})
+		if err != nil {
+			return batch.Response{}, err
+		}
+		unprocessed := resp.UnprocessedItems[tableName]
+		if sent != nil {
+			sent(chunk, unprocessed)
+		}
+		return batch.Response{Retry: UnprocessedPositions(chunk, unprocessed)}, nil
+	})
+}
+
+// requestKey identifies the item a put writes, so that requests DynamoDB hands back unprocessed can be matched to
+// the ones sent.
+func requestKey(request types.WriteRequest) string {
+	if request.PutRequest == nil {
+		return ""
+	}
+	return stringValue(request.PutRequest.Item, keys.PartitionKey) + "|" + stringValue(request.PutRequest.Item, keys.SortKey)
+}
+
+func stringValue(item map[string]types.AttributeValue, name string) string {
+	if value, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return value.Value
+	}
+	return ""
+}
+
+// UnprocessedPositions returns the positions in sent of the requests DynamoDB left unprocessed.
+func UnprocessedPositions(sent, unprocessed []types.WriteRequest) []int {
+	if len(unprocessed) == 0 {
+		return nil
+	}
+	pending := map[string]bool{}
+	for _, request := range unprocessed {
+		pending[requestKey(request)] = true
+	}
+	positions := []int{}
+	for i, request := range sent {
+		if pending[requestKey(request)] {
+			positions = append(positions, i)
+		}
+	}
+	return positions
+}
+
+// Written returns the requests in sent that are not in unprocessed, matching on PK and SK.
+func Written(sent, unprocessed []types.WriteRequest) []types.WriteRequest {
+	if len(unprocessed) == 0 {
+		return sent
+	}
+	pending := map[string]bool{}
+	for _, request := range unprocessed {
+		pending[requestKey(request)] = true
+	}
+	written := []types.WriteRequest{}
+	for _, request := range sent {
+		if !pending[requestKey(request)] {
+			written = append(written, request)
+		}
+	}
+	return written
+}
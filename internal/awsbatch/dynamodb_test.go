@@ -0,0 +1,128 @@
+package awsbatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/batch"
+	"github.com/seanturner026/maido-lambdas/internal/fakes"
+)
+
+func putRequest(pk string) types.WriteRequest {
+	return types.WriteRequest{PutRequest: &types.PutRequest{Item: map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: pk},
+		"SK": &types.AttributeValueMemberS{Value: "USER#MAIDO"},
+	}}}
+}
+
+func TestWriteItems(t *testing.T) {
+	const tableName = "example_table_name"
+	requests := []types.WriteRequest{}
+	for i := 0; i < 30; i++ {
+		requests = append(requests, putRequest(fmt.Sprintf("USER#%02d", i)))
+	}
+	tests := []struct {
+		name         string
+		unprocessed  map[int]int
+		maxAttempts  int
+		wantCalls    int
+		wantFailed   int
+		wantAttempts int
+	}{
+		{name: "processed", wantCalls: 2, wantAttempts: 1},
+		{name: "unprocessed_resent", unprocessed: map[int]int{1: 3}, maxAttempts: 3, wantCalls: 3, wantAttempts: 2},
+		{name: "unprocessed_until_last_attempt", unprocessed: map[int]int{1: 3, 2: 2}, maxAttempts: 2, wantCalls: 3, wantFailed: 2, wantAttempts: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakes.NewDynamoDB()
+			for n, count := range tt.unprocessed {
+				db.UnprocessedOn(n, count)
+			}
+			mu := &sync.Mutex{}
+			written := 0
+			opts := batch.Options{Concurrency: 1, MaxAttempts: tt.maxAttempts}
+			results := WriteItems(context.TODO(), db, tableName, requests, opts, func(chunk, unprocessed []types.WriteRequest) {
+				mu.Lock()
+				defer mu.Unlock()
+				written += len(Written(chunk, unprocessed))
+			})
+			failed, attempts := 0, 0
+			for _, res := range results {
+				if res.Err != nil {
+					failed++
+					if !errors.Is(res.Err, batch.ErrUnprocessed) {
+						t.Errorf("WriteItems() error = %v, want %v", res.Err, batch.ErrUnprocessed)
+					}
+				}
+				if res.Attempts > attempts {
+					attempts = res.Attempts
+				}
+			}
+			if calls := len(db.Calls("BatchWriteItem")); calls != tt.wantCalls {
+				t.Errorf("WriteItems() calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if failed != tt.wantFailed {
+				t.Errorf("WriteItems() failed = %d, want %d", failed, tt.wantFailed)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("WriteItems() attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if want := len(requests) - tt.wantFailed; written != want || len(db.Items(tableName)) != want {
+				t.Errorf("WriteItems() written = %d, stored %d, want %d", written, len(db.Items(tableName)), want)
+			}
+		})
+	}
+}
+
+func TestUnprocessedPositions(t *testing.T) {
+	sent := []types.WriteRequest{putRequest("USER#1"), putRequest("USER#2"), putRequest("USER#3")}
+	tests := []struct {
+		name        string
+		unprocessed []types.WriteRequest
+		want        []int
+	}{
+		{name: "none"},
+		{name: "some", unprocessed: []types.WriteRequest{putRequest("USER#3"), putRequest("USER#1")}, want: []int{0, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UnprocessedPositions(sent, tt.unprocessed); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UnprocessedPositions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWritten(t *testing.T) {
+	tests := []struct {
+		name        string
+		sent        []types.WriteRequest
+		unprocessed []types.WriteRequest
+		want        []types.WriteRequest
+	}{
+		{
+			name: "all_written",
+			sent: []types.WriteRequest{putRequest("USER#1"), putRequest("USER#2")},
+			want: []types.WriteRequest{putRequest("USER#1"), putRequest("USER#2")},
+		},
+		{
+			name:        "some_unprocessed",
+			sent:        []types.WriteRequest{putRequest("USER#1"), putRequest("USER#2")},
+			unprocessed: []types.WriteRequest{putRequest("USER#2")},
+			want:        []types.WriteRequest{putRequest("USER#1")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Written(tt.sent, tt.unprocessed); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Written() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+// Package awsbatch sends the SQS and DynamoDB batch calls shared by the onboarding lambdas through package batch,
+// so that every lambda chunks, resends and reports the entries of those calls the same way.
+package awsbatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/seanturner026/maido-lambdas/internal/batch"
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxDeleteMessageBatchEntries is the most entries SQS accepts in one DeleteMessageBatch call.
+const MaxDeleteMessageBatchEntries = 10
+
+// MaxChangeMessageVisibilityBatchEntries is the most entries SQS accepts in one ChangeMessageVisibilityBatch call.
+const MaxChangeMessageVisibilityBatchEntries = 10
+
+// DeleteMessageBatchAPI is the SQS call DeleteMessages makes.
+type DeleteMessageBatchAPI interface {
+	DeleteMessageBatch(ctx context.Context, params *sqs.DeleteMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error)
+}
+
+// ChangeMessageVisibilityBatchAPI is the SQS call ReleaseMessages makes.
+type ChangeMessageVisibilityBatchAPI interface {
+	ChangeMessageVisibilityBatch(
+		ctx context.Context,
+		params *sqs.ChangeMessageVisibilityBatchInput,
+		optFns ...func(*sqs.Options),
+	) (*sqs.ChangeMessageVisibilityBatchOutput, error)
+}
+
+// Unmarshal decodes the body of each record into a T. received is called with each item and the record it came
+// from, so that the caller can keep the message ID and receipt handle it needs to delete the message.
+func Unmarshal[T any](event events.SQSEvent, received func(item *T, record events.SQSMessage)) ([]*T, error) {
+	items := []*T{}
+	for _, record := range event.Records {
+		item := new(T)
+		err := json.Unmarshal([]byte(record.Body), item)
+		if err != nil {
+			return items, fmt.Errorf("unable to unmarshal event ID %s", record.MessageId)
+		}
+		received(item, record)
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// EntryFailure is the error of an entry SQS failed on its own in a batch call.
+type EntryFailure struct {
+	Entry types.BatchResultErrorEntry
+}
+
+func (f EntryFailure) Error() string {
+	return aws.ToString(f.Entry.Message)
+}
+
+// DeleteEntry returns the entry that deletes the message with messageID.
+func DeleteEntry(messageID, receiptHandle string) types.DeleteMessageBatchRequestEntry {
+	return types.DeleteMessageBatchRequestEntry{
+		Id:            aws.String(messageID),
+		ReceiptHandle: aws.String(receiptHandle),
+	}
+}
+
+// DeleteMessages deletes entries from queueURL in batches of up to MaxDeleteMessageBatchEntries, at most
+// concurrency at once. Entries SQS fails on their own carry an EntryFailure.
+func DeleteMessages(
+	ctx context.Context,
+	queue DeleteMessageBatchAPI,
+	queueURL string,
+	entries []types.DeleteMessageBatchRequestEntry,
+	concurrency int,
+) []batch.Result[types.DeleteMessageBatchRequestEntry] {
+	opts := batch.Options{Size: MaxDeleteMessageBatchEntries, Concurrency: concurrency}
+	return batch.Run(ctx, entries, opts, func(ctx context.Context, chunk []types.DeleteMessageBatchRequestEntry) (batch.Response, error) {
+		resp, err := queue.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{
+			Entries:  chunk,
+			QueueUrl: aws.String(queueURL),
+		})
+		if err != nil {
+			return batch.Response{}, err
+		}
+		ids := make([]string, len(chunk))
+		for i, entry := range chunk {
+			ids[i] = aws.ToString(entry.Id)
+		}
+		return batch.Response{Failed: entryFailures(ids, resp.Failed)}, nil
+	})
+}
+
+// ReleaseMessages changes the visibility of entries on queueURL in batches of up to
+// MaxChangeMessageVisibilityBatchEntries, at most concurrency at once. Entries SQS fails on their own carry an
+// EntryFailure.
+func ReleaseMessages(
+	ctx context.Context,
+	queue ChangeMessageVisibilityBatchAPI,
+	queueURL string,
+	entries []types.ChangeMessageVisibilityBatchRequestEntry,
+	concurrency int,
+) []batch.Result[types.ChangeMessageVisibilityBatchRequestEntry] {
+	opts := batch.Options{Size: MaxChangeMessageVisibilityBatchEntries, Concurrency: concurrency}
+	return batch.Run(ctx, entries, opts, func(ctx context.Context, chunk []types.ChangeMessageVisibilityBatchRequestEntry) (batch.Response, error) {
+		resp, err := queue.ChangeMessageVisibilityBatch(ctx, &sqs.ChangeMessageVisibilityBatchInput{
+			Entries:  chunk,
+			QueueUrl: aws.String(queueURL),
+		})
+		if err != nil {
+			return batch.Response{}, err
+		}
+		ids := make([]string, len(chunk))
+		for i, entry := range chunk {
+			ids[i] = aws.ToString(entry.Id)
+		}
+		return batch.Response{Failed: entryFailures(ids, resp.Failed)}, nil
+	})
+}
+
+// entryFailures matches failed to the positions of the entries with ids.
+func entryFailures(ids []string, failed []types.BatchResultErrorEntry) map[int]error {
+	positions := map[string]int{}
+	for i, id := range ids {
+		positions[id] = i
+	}
+	failures := map[int]error{}
+	for _, failure := range failed {
+		if i, ok := positions[aws.ToString(failure.Id)]; ok {
+			failures[i] = EntryFailure{Entry: failure}
+		}
+	}
+	return failures
+}
+
+// FailedEntries renders failed as JSON for logging.
+func FailedEntries(failed []types.BatchResultErrorEntry) []string {
+	type failureResultSQS struct {
+		ID          string `json:"id"`
+		SenderFault bool   `json:"sender_fault"`
+		Message     string `json:"message"`
+	}
+	failures := []string{}
+	for _, failure := range failed {
+		failureJSON, err := json.Marshal(failureResultSQS{
+			ID:          aws.ToString(failure.Id),
+			SenderFault: failure.SenderFault,
+			Message:     aws.ToString(failure.Message),
+		})
+		if err != nil {
+			log.WithFields(log.Fields{"id": aws.ToString(failure.Id), "sender_fault": failure.SenderFault, "message": aws.ToString(failure.Message)}).
+				Error("Unable to marshal failureResultSQS")
+			continue
+		}
+		failures = append(failures, string(failureJSON))
+	}
+	return failures
+}
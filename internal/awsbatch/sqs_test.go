@@ -0,0 +1,186 @@
+package awsbatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/seanturner026/maido-lambdas/internal/fakes"
+)
+
+type message struct {
+	ID            string `json:"id"`
+	MessageID     string `json:"-"`
+	ReceiptHandle string `json:"-"`
+}
+
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   events.SQSEvent
+		want    []*message
+		wantErr bool
+	}{
+		{
+			name: "received",
+			event: events.SQSEvent{Records: []events.SQSMessage{
+				{MessageId: "message-01", ReceiptHandle: "receipt-01", Body: `{"id": "1"}`},
+				{MessageId: "message-02", ReceiptHandle: "receipt-02", Body: `{"id": "2"}`},
+			}},
+			want: []*message{
+				{ID: "1", MessageID: "message-01", ReceiptHandle: "receipt-01"},
+				{ID: "2", MessageID: "message-02", ReceiptHandle: "receipt-02"},
+			},
+		},
+		{
+			name:    "invalid_body",
+			event:   events.SQSEvent{Records: []events.SQSMessage{{MessageId: "message-01", Body: ""}}},
+			want:    []*message{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Unmarshal(tt.event, func(item *message, record events.SQSMessage) {
+				item.MessageID = record.MessageId
+				item.ReceiptHandle = record.ReceiptHandle
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteEntry(t *testing.T) {
+	type args struct {
+		messageID     string
+		receiptHandle string
+	}
+	tests := []struct {
+		name string
+		args args
+		want types.DeleteMessageBatchRequestEntry
+	}{
+		{
+			name: "",
+			args: args{
+				messageID:     "12345",
+				receiptHandle: "67890",
+			},
+			want: types.DeleteMessageBatchRequestEntry{
+				Id:            aws.String("12345"),
+				ReceiptHandle: aws.String("67890"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DeleteEntry(tt.args.messageID, tt.args.receiptHandle); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DeleteEntry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailedEntries(t *testing.T) {
+	type args struct {
+		failed []types.BatchResultErrorEntry
+	}
+	tests := []struct {
+		name string
+		args args
+		want []string
+	}{
+		{
+			name: "1_entry",
+			args: args{
+				failed: []types.BatchResultErrorEntry{
+					{
+						Code:        aws.String("100"),
+						Id:          aws.String("200"),
+						SenderFault: false,
+						Message:     aws.String("example"),
+					},
+				},
+			},
+			want: []string{"{\"id\":\"200\",\"sender_fault\":false,\"message\":\"example\"}"},
+		}, {
+			name: "2_entries",
+			args: args{
+				failed: []types.BatchResultErrorEntry{
+					{
+						Code:        aws.String("100"),
+						Id:          aws.String("200"),
+						SenderFault: false,
+						Message:     aws.String("example"),
+					},
+					{
+						Code:        aws.String("300"),
+						Id:          aws.String("400"),
+						SenderFault: false,
+						Message:     aws.String("example_two"),
+					},
+				},
+			},
+			want: []string{
+				"{\"id\":\"200\",\"sender_fault\":false,\"message\":\"example\"}",
+				"{\"id\":\"400\",\"sender_fault\":false,\"message\":\"example_two\"}",
+			},
+		}, {
+			name: "missing_id_and_message",
+			args: args{
+				failed: []types.BatchResultErrorEntry{
+					{
+						Code:        aws.String("100"),
+						SenderFault: true,
+					},
+				},
+			},
+			want: []string{"{\"id\":\"\",\"sender_fault\":true,\"message\":\"\"}"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FailedEntries(tt.args.failed); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FailedEntries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteMessages(t *testing.T) {
+	entries := []types.DeleteMessageBatchRequestEntry{}
+	for i := 0; i < 21; i++ {
+		entries = append(entries, DeleteEntry(fmt.Sprintf("message-%02d", i), fmt.Sprintf("receipt-%02d", i)))
+	}
+	queue := fakes.NewSQS()
+	queue.FailDelete("message-12", "ReceiptHandleIsInvalid")
+	results := DeleteMessages(context.TODO(), queue, "example_queue_url", entries, 2)
+	if len(results) != len(entries) {
+		t.Fatalf("DeleteMessages() results = %d, want %d", len(results), len(entries))
+	}
+	for i, res := range results {
+		var failure EntryFailure
+		if i == 12 {
+			if !errors.As(res.Err, &failure) || aws.ToString(failure.Entry.Id) != "message-12" {
+				t.Errorf("DeleteMessages() result %d error = %v, want an EntryFailure", i, res.Err)
+			}
+			continue
+		}
+		if res.Err != nil {
+			t.Errorf("DeleteMessages() result %d error = %v", i, res.Err)
+		}
+	}
+	if calls := len(queue.Calls("DeleteMessageBatch")); calls != 3 {
+		t.Errorf("DeleteMessages() calls = %d, want 3", calls)
+	}
+}
@@ -0,0 +1,222 @@
+// Package batch splits work into the fixed-size chunks AWS batch APIs accept and sends the chunks concurrently,
+// resending the entries a call left unprocessed after a capped, jittered exponential backoff:
+//
+//	results := batch.Run(ctx, entries, batch.Options{Size: 10, Concurrency: 4}, send)
+//
+// Run returns one Result per entry, so callers can act on the entries that failed.
+package batch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Defaults used for the Options left at zero.
+const (
+	DefaultMaxAttempts = 5
+	DefaultBaseDelay   = 50 * time.Millisecond
+	DefaultMaxDelay    = time.Second
+)
+
+// ErrUnprocessed is the error of an entry still unprocessed after Options.MaxAttempts sends.
+var ErrUnprocessed = errors.New("entry was still unprocessed after the last attempt")
+
+// ErrPanic wraps the error of the entries of a chunk whose send panicked. The panic is recovered so that it fails
+// only those entries.
+var ErrPanic = errors.New("send panicked")
+
+// Chunk splits items into consecutive chunks of at most size items, sharing items' backing array. A size of 0 or
+// less puts every item in one chunk.
+func Chunk[T any](items []T, size int) [][]T {
+	if size <= 0 {
+		size = len(items)
+	}
+	chunks := [][]T{}
+	for start := 0; start < len(items); start += size {
+		end := start + size
+		if end > len(items) {
+			end = len(items)
+		}
+		chunks = append(chunks, items[start:end:end])
+	}
+	return chunks
+}
+
+// Options configure Run.
+type Options struct {
+	// Size is the most entries sent in one call.
+	Size int
+	// Concurrency is the most calls in flight at once. 0 sends every chunk at once.
+	Concurrency int
+	// MaxAttempts is the most times an entry is sent. 0 uses DefaultMaxAttempts; entries are never resent without
+	// a bound, so that a throttled service cannot keep a caller busy until its context is done.
+	MaxAttempts int
+	// BaseDelay is the longest wait before the first resend, doubling for each later one up to MaxDelay. The wait
+	// is drawn at random up to that bound, so that chunks throttled together do not resend together. 0 uses
+	// DefaultBaseDelay.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait before a resend. 0 uses DefaultMaxDelay.
+	MaxDelay time.Duration
+}
+
+// withDefaults returns o with its zero fields set to their defaults.
+func (o Options) withDefaults() Options {
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = DefaultMaxAttempts
+	}
+	if o.BaseDelay <= 0 {
+		o.BaseDelay = DefaultBaseDelay
+	}
+	if o.MaxDelay <= 0 {
+		o.MaxDelay = DefaultMaxDelay
+	}
+	return o
+}
+
+// jitter draws the random part of the resend delays. Its source is seeded per process so that lambdas started
+// together do not draw the same delays, and guarded because a rand.Rand is not safe for concurrent use.
+var jitter = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+func randomDuration(n time.Duration) time.Duration {
+	jitter.Lock()
+	defer jitter.Unlock()
+	return time.Duration(jitter.Int63n(int64(n)))
+}
+
+// delay returns the wait before resending after the given attempt: a random duration below
+// min(opts.MaxDelay, opts.BaseDelay*2^(attempt-1)), with random drawing below its argument.
+func delay(opts Options, attempt int, random func(time.Duration) time.Duration) time.Duration {
+	bound := opts.BaseDelay
+	for i := 1; i < attempt && bound < opts.MaxDelay; i++ {
+		bound *= 2
+	}
+	if bound > opts.MaxDelay {
+		bound = opts.MaxDelay
+	}
+	return random(bound)
+}
+
+// Response is what a call made of a chunk, by position in the chunk it was given.
+type Response struct {
+	// Retry holds the entries the service left unprocessed, which are sent again.
+	Retry []int
+	// Failed holds the errors of entries that failed on their own and are not sent again.
+	Failed map[int]error
+}
+
+// Func sends chunk in one call. An error fails every entry of the chunk.
+type Func[T any] func(ctx context.Context, chunk []T) (Response, error)
+
+// Result is the outcome of one entry.
+type Result[T any] struct {
+	Item T
+	// Attempts is the number of times the entry was sent.
+	Attempts int
+	Err      error
+}
+
+// Run sends items in chunks of opts.Size and returns their results in the order of items.
+func Run[T any](ctx context.Context, items []T, opts Options, send Func[T]) []Result[T] {
+	opts = opts.withDefaults()
+	results := make([]Result[T], len(items))
+	for i, item := range items {
+		results[i].Item = item
+	}
+	var slots chan struct{}
+	if opts.Concurrency > 0 {
+		slots = make(chan struct{}, opts.Concurrency)
+	}
+	wg := &sync.WaitGroup{}
+	for _, chunk := range Chunk(results, opts.Size) {
+		if slots != nil {
+			slots <- struct{}{}
+		}
+		wg.Add(1)
+		go func(chunk []Result[T]) {
+			defer wg.Done()
+			if slots != nil {
+				defer func() { <-slots }()
+			}
+			runChunk(ctx, chunk, opts, send)
+		}(chunk)
+	}
+	wg.Wait()
+	return results
+}
+
+// runChunk sends the entries of chunk until none is left unprocessed, recording their outcomes in place.
+func runChunk[T any](ctx context.Context, chunk []Result[T], opts Options, send Func[T]) {
+	pending := make([]int, len(chunk))
+	for i := range pending {
+		pending[i] = i
+	}
+	for attempt := 1; ; attempt++ {
+		entries := make([]T, len(pending))
+		for i, p := range pending {
+			entries[i] = chunk[p].Item
+			chunk[p].Attempts = attempt
+		}
+		resp, err := sendRecovered(ctx, entries, send)
+		if err != nil {
+			fail(chunk, pending, err)
+			return
+		}
+		for i, err := range resp.Failed {
+			chunk[pending[i]].Err = err
+		}
+		if len(resp.Retry) == 0 {
+			return
+		}
+		retry := make([]int, 0, len(resp.Retry))
+		for _, i := range resp.Retry {
+			retry = append(retry, pending[i])
+		}
+		pending = retry
+		if attempt >= opts.MaxAttempts {
+			fail(chunk, pending, ErrUnprocessed)
+			return
+		}
+		if err := wait(ctx, delay(opts, attempt, randomDuration)); err != nil {
+			fail(chunk, pending, err)
+			return
+		}
+	}
+}
+
+// wait waits for d, returning ctx's error if it is done first.
+func wait(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// sendRecovered calls send, turning a panic into an error wrapping ErrPanic.
+func sendRecovered[T any](ctx context.Context, entries []T, send Func[T]) (resp Response, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrPanic, r)
+		}
+	}()
+	return send(ctx, entries)
+}
+
+func fail[T any](chunk []Result[T], pending []int, err error) {
+	for _, p := range pending {
+		chunk[p].Err = err
+	}
+}
@@ -0,0 +1,279 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChunk(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []int
+		size  int
+		want  [][]int
+	}{
+		{name: "empty", items: []int{}, size: 10, want: [][]int{}},
+		{name: "partial", items: []int{1, 2, 3}, size: 10, want: [][]int{{1, 2, 3}}},
+		{name: "exact", items: []int{1, 2, 3, 4}, size: 2, want: [][]int{{1, 2}, {3, 4}}},
+		{name: "remainder", items: []int{1, 2, 3, 4, 5}, size: 2, want: [][]int{{1, 2}, {3, 4}, {5}}},
+		{name: "unbounded", items: []int{1, 2, 3}, size: 0, want: [][]int{{1, 2, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Chunk(tt.items, tt.size); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Chunk() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// FuzzChunk checks that every item lands in exactly one chunk, in order, and that no chunk is empty or over size.
+func FuzzChunk(f *testing.F) {
+	for _, seed := range [][2]uint16{{0, 10}, {1, 10}, {10, 10}, {21, 10}, {26, 25}, {100, 1}} {
+		f.Add(seed[0], seed[1])
+	}
+	f.Fuzz(func(t *testing.T, n, size uint16) {
+		items := make([]int, int(n%4096))
+		for i := range items {
+			items[i] = i
+		}
+		limit := int(size%64) + 1
+		chunks := Chunk(items, limit)
+		next := 0
+		for i, chunk := range chunks {
+			if len(chunk) == 0 || len(chunk) > limit {
+				t.Errorf("chunk %d has %d items, limit %d", i, len(chunk), limit)
+			}
+			if i < len(chunks)-1 && len(chunk) != limit {
+				t.Errorf("chunk %d of %d has %d items, want a full chunk of %d", i, len(chunks), len(chunk), limit)
+			}
+			for _, item := range chunk {
+				if item != next {
+					t.Fatalf("chunk %d holds item %d, want %d", i, item, next)
+				}
+				next++
+			}
+		}
+		if next != len(items) {
+			t.Errorf("Chunk() covered %d items, want %d", next, len(items))
+		}
+	})
+}
+
+func TestChunk_appendDoesNotOverwrite(t *testing.T) {
+	items := []int{1, 2, 3, 4}
+	chunks := Chunk(items, 2)
+	_ = append(chunks[0], 99)
+	if items[2] != 3 {
+		t.Errorf("appending to a chunk overwrote the next one: %v", items)
+	}
+}
+
+func TestRun(t *testing.T) {
+	errThrottled := errors.New("throttled")
+	errInvalid := errors.New("invalid entry")
+	tests := []struct {
+		name         string
+		items        []string
+		opts         Options
+		send         func(calls int, chunk []string) (Response, error)
+		wantAttempts []int
+		wantErr      []error
+		wantCalls    int
+	}{
+		{
+			name:  "processed",
+			items: []string{"a", "b", "c"},
+			opts:  Options{Size: 2},
+			send: func(calls int, chunk []string) (Response, error) {
+				return Response{}, nil
+			},
+			wantAttempts: []int{1, 1, 1},
+			wantErr:      []error{nil, nil, nil},
+			wantCalls:    2,
+		},
+		{
+			name:  "unprocessed_then_processed",
+			items: []string{"a", "b", "c"},
+			opts:  Options{Size: 3},
+			send: func(calls int, chunk []string) (Response, error) {
+				if calls == 1 {
+					return Response{Retry: []int{1, 2}}, nil
+				}
+				if calls == 2 {
+					return Response{Retry: []int{1}}, nil
+				}
+				return Response{}, nil
+			},
+			wantAttempts: []int{1, 2, 3},
+			wantErr:      []error{nil, nil, nil},
+			wantCalls:    3,
+		},
+		{
+			name:  "unprocessed_after_max_attempts",
+			items: []string{"a", "b"},
+			opts:  Options{Size: 2, MaxAttempts: 3},
+			send: func(calls int, chunk []string) (Response, error) {
+				for i, entry := range chunk {
+					if entry == "b" {
+						return Response{Retry: []int{i}}, nil
+					}
+				}
+				return Response{}, nil
+			},
+			wantAttempts: []int{1, 3},
+			wantErr:      []error{nil, ErrUnprocessed},
+			wantCalls:    3,
+		},
+		{
+			name:  "chunk_fails",
+			items: []string{"a", "b", "c"},
+			opts:  Options{Size: 2},
+			send: func(calls int, chunk []string) (Response, error) {
+				if chunk[0] == "c" {
+					return Response{}, errThrottled
+				}
+				return Response{}, nil
+			},
+			wantAttempts: []int{1, 1, 1},
+			wantErr:      []error{nil, nil, errThrottled},
+			wantCalls:    2,
+		},
+		{
+			name:  "entry_fails",
+			items: []string{"a", "b"},
+			opts:  Options{Size: 2},
+			send: func(calls int, chunk []string) (Response, error) {
+				return Response{Failed: map[int]error{0: errInvalid}}, nil
+			},
+			wantAttempts: []int{1, 1},
+			wantErr:      []error{errInvalid, nil},
+			wantCalls:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu := sync.Mutex{}
+			calls := 0
+			got := Run(context.TODO(), tt.items, tt.opts, func(ctx context.Context, chunk []string) (Response, error) {
+				mu.Lock()
+				calls++
+				n := calls
+				mu.Unlock()
+				return tt.send(n, chunk)
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("Run() made %d calls, want %d", calls, tt.wantCalls)
+			}
+			for i, res := range got {
+				if res.Item != tt.items[i] {
+					t.Errorf("result %d is for %s, want %s", i, res.Item, tt.items[i])
+				}
+				if res.Attempts != tt.wantAttempts[i] || !errors.Is(res.Err, tt.wantErr[i]) {
+					t.Errorf("result %d = %d attempts, %v, want %d attempts, %v", i, res.Attempts, res.Err, tt.wantAttempts[i], tt.wantErr[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRun_concurrency(t *testing.T) {
+	items := make([]int, 40)
+	var running, peak int32
+	Run(context.TODO(), items, Options{Size: 2, Concurrency: 3}, func(ctx context.Context, chunk []int) (Response, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return Response{}, nil
+	})
+	if peak > 3 {
+		t.Errorf("Run() had %d calls in flight, want at most 3", peak)
+	}
+}
+
+func TestRun_cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	got := Run(ctx, []string{"a"}, Options{Size: 1}, func(ctx context.Context, chunk []string) (Response, error) {
+		calls++
+		cancel()
+		return Response{Retry: []int{0}}, nil
+	})
+	if calls != 1 || !errors.Is(got[0].Err, context.Canceled) {
+		t.Errorf("Run() after cancel = %d calls, %v, want 1 call and context.Canceled", calls, got[0].Err)
+	}
+}
+
+func TestRun_panic(t *testing.T) {
+	got := Run(context.TODO(), []string{"a", "b", "c"}, Options{Size: 2}, func(ctx context.Context, chunk []string) (Response, error) {
+		if chunk[0] == "a" {
+			panic("example panic")
+		}
+		return Response{}, nil
+	})
+	if !errors.Is(got[0].Err, ErrPanic) || !errors.Is(got[1].Err, ErrPanic) || got[2].Err != nil {
+		t.Errorf("Run() errors = %v, %v, %v, want the panicking chunk failed with ErrPanic", got[0].Err, got[1].Err, got[2].Err)
+	}
+}
+
+func TestRun_defaultMaxAttempts(t *testing.T) {
+	calls := 0
+	got := Run(context.TODO(), []string{"a"}, Options{Size: 1, BaseDelay: time.Microsecond}, func(ctx context.Context, chunk []string) (Response, error) {
+		calls++
+		return Response{Retry: []int{0}}, nil
+	})
+	if calls != DefaultMaxAttempts || !errors.Is(got[0].Err, ErrUnprocessed) {
+		t.Errorf("Run() = %d calls, %v, want %d calls and ErrUnprocessed", calls, got[0].Err, DefaultMaxAttempts)
+	}
+}
+
+func TestRun_cancelledDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	got := Run(ctx, []string{"a"}, Options{Size: 1, BaseDelay: time.Hour, MaxDelay: time.Hour}, func(ctx context.Context, chunk []string) (Response, error) {
+		return Response{Retry: []int{0}}, nil
+	})
+	if !errors.Is(got[0].Err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Errorf("Run() = %v after %v, want context.DeadlineExceeded once the context is done", got[0].Err, time.Since(start))
+	}
+}
+
+func Test_delay(t *testing.T) {
+	opts := Options{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	longest := func(n time.Duration) time.Duration { return n - 1 }
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: 10*time.Millisecond - 1},
+		{attempt: 2, want: 20*time.Millisecond - 1},
+		{attempt: 3, want: 40*time.Millisecond - 1},
+		{attempt: 4, want: 50*time.Millisecond - 1},
+		{attempt: 100, want: 50*time.Millisecond - 1},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.attempt), func(t *testing.T) {
+			if got := delay(opts, tt.attempt, longest); got != tt.want {
+				t.Errorf("delay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	for i := 0; i < 100; i++ {
+		if got := delay(opts, 3, randomDuration); got < 0 || got >= 40*time.Millisecond {
+			t.Fatalf("delay() = %v, want within [0, 40ms)", got)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+// Package envelope encrypts personal data before it is written to DynamoDB. Each value is sealed with AES-256-GCM
+// under a data key generated by KMS, and the KMS-encrypted copy of that data key is stored alongside it, so a
+// reader only needs kms:Decrypt on the key to recover the value. Struct fields opt in with the encrypted option
+// on their dynamodbav tag:
+//
+//	EmailAddress string `dynamodbav:"EmailAddress,encrypted"`
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// Prefix starts every encrypted value. The rest is the base64 encoded data key blob and sealed value, separated
+// by a colon.
+const Prefix = "ENC1:"
+
+// DataKeyTTL is how long a generated data key is reused for encryption before a new one is requested.
+const DataKeyTTL = 5 * time.Minute
+
+// maxCachedDataKeys bounds the decrypted data keys kept by an Encrypter.
+const maxCachedDataKeys = 100
+
+// encryptionContext is bound to every data key, so keys generated for other purposes cannot be used here.
+var encryptionContext = map[string]string{"purpose": "dynamodb-pii"}
+
+// KMSAPI is the subset of the KMS client used by an Encrypter.
+type KMSAPI interface {
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+type dataKey struct {
+	plaintext []byte
+	blob      []byte
+	expires   time.Time
+}
+
+// Encrypter encrypts and decrypts attribute values. MarshalMap and UnmarshalMap fall back to plain attributevalue
+// marshalling on a nil Encrypter, so callers can use FromContext without checking whether encryption is enabled.
+type Encrypter struct {
+	kms   KMSAPI
+	keyID string
+	now   func() time.Time
+
+	mu        sync.Mutex
+	current   *dataKey
+	decrypted map[string][]byte
+}
+
+// New returns an Encrypter that generates data keys under the KMS key keyID.
+func New(client KMSAPI, keyID string) *Encrypter {
+	return &Encrypter{kms: client, keyID: keyID, now: time.Now, decrypted: map[string][]byte{}}
+}
+
+// FromEnv returns an Encrypter for the key in PII_KMS_KEY_ID, or nil when it is not set.
+func FromEnv(client KMSAPI) *Encrypter {
+	keyID, ok := os.LookupEnv("PII_KMS_KEY_ID")
+	if !ok || keyID == "" {
+		return nil
+	}
+	return New(client, keyID)
+}
+
+// EncryptedAttributes returns the attribute names of the fields of v's struct type tagged encrypted.
+func EncryptedAttributes(v interface{}) []string {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	names := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		parts := strings.Split(field.Tag.Get("dynamodbav"), ",")
+		for _, option := range parts[1:] {
+			if option != "encrypted" {
+				continue
+			}
+			name := parts[0]
+			if name == "" {
+				name = field.Name
+			}
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// MarshalMap marshals in with attributevalue.MarshalMap and encrypts its string attributes tagged encrypted.
+func (e *Encrypter) MarshalMap(ctx context.Context, in interface{}) (map[string]types.AttributeValue, error) {
+	item, err := attributevalue.MarshalMap(in)
+	if err != nil || e == nil {
+		return item, err
+	}
+	return item, e.EncryptItem(ctx, item, EncryptedAttributes(in)...)
+}
+
+// EncryptItem encrypts the named string attributes of item in place. Missing and empty attributes are skipped.
+// Values are always encrypted, even when they already look encrypted: a plaintext that happens to start with
+// Prefix must not be stored in the clear.
+func (e *Encrypter) EncryptItem(ctx context.Context, item map[string]types.AttributeValue, names ...string) error {
+	for _, name := range names {
+		value, ok := item[name].(*types.AttributeValueMemberS)
+		if !ok || value.Value == "" {
+			continue
+		}
+		encrypted, err := e.Encrypt(ctx, name, value.Value)
+		if err != nil {
+			return err
+		}
+		item[name] = &types.AttributeValueMemberS{Value: encrypted}
+	}
+	return nil
+}
+
+// Encrypt seals plaintext for the attribute name. The name is authenticated with the value, so an encrypted
+// value copied to another attribute fails to decrypt.
+func (e *Encrypter) Encrypt(ctx context.Context, name, plaintext string) (string, error) {
+	key, err := e.dataKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(key.plaintext)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(name))
+	return Prefix + base64.StdEncoding.EncodeToString(key.blob) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// UnmarshalMap decrypts item's encrypted attributes and unmarshals it into out with attributevalue.UnmarshalMap.
+// item itself is left encrypted.
+func (e *Encrypter) UnmarshalMap(ctx context.Context, item map[string]types.AttributeValue, out interface{}) error {
+	decrypted := make(map[string]types.AttributeValue, len(item))
+	for name, value := range item {
+		decrypted[name] = value
+	}
+	if err := e.DecryptItem(ctx, decrypted); err != nil {
+		return err
+	}
+	return attributevalue.UnmarshalMap(decrypted, out)
+}
+
+// DecryptItem decrypts every encrypted string attribute of item in place.
+func (e *Encrypter) DecryptItem(ctx context.Context, item map[string]types.AttributeValue) error {
+	for name, value := range item {
+		s, ok := value.(*types.AttributeValueMemberS)
+		if !ok || !strings.HasPrefix(s.Value, Prefix) {
+			continue
+		}
+		plaintext, err := e.Decrypt(ctx, name, s.Value)
+		if err != nil {
+			return err
+		}
+		item[name] = &types.AttributeValueMemberS{Value: plaintext}
+	}
+	return nil
+}
+
+// Decrypt opens a value Encrypt produced for the attribute name.
+func (e *Encrypter) Decrypt(ctx context.Context, name, value string) (string, error) {
+	if e == nil {
+		return "", fmt.Errorf("unable to decrypt %s, encryption is not configured", name)
+	}
+	parts := strings.Split(strings.TrimPrefix(value, Prefix), ":")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("unable to decrypt %s, value is malformed", name)
+	}
+	blob, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt %s, %w", name, err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt %s, %w", name, err)
+	}
+	key, err := e.decryptDataKey(ctx, blob)
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt data key for %s: %w", name, err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("unable to decrypt %s, value is malformed", name)
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt %s, %w", name, err)
+	}
+	return string(plaintext), nil
+}
+
+// dataKey returns the cached data key, generating a new one once it has expired.
+func (e *Encrypter) dataKey(ctx context.Context) (*dataKey, error) {
+	if e == nil {
+		return nil, fmt.Errorf("encryption is not configured")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.current != nil && e.now().Before(e.current.expires) {
+		return e.current, nil
+	}
+	resp, err := e.kms.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             aws.String(e.keyID),
+		KeySpec:           kmstypes.DataKeySpecAes256,
+		EncryptionContext: encryptionContext,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate data key: %w", err)
+	}
+	e.current = &dataKey{plaintext: resp.Plaintext, blob: resp.CiphertextBlob, expires: e.now().Add(DataKeyTTL)}
+	e.decrypted[string(resp.CiphertextBlob)] = resp.Plaintext
+	return e.current, nil
+}
+
+// decryptDataKey returns the plaintext of the data key blob, asking KMS only for blobs it has not seen.
+func (e *Encrypter) decryptDataKey(ctx context.Context, blob []byte) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if key, ok := e.decrypted[string(blob)]; ok {
+		return key, nil
+	}
+	resp, err := e.kms.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob:    blob,
+		EncryptionContext: encryptionContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(e.decrypted) >= maxCachedDataKeys {
+		e.decrypted = map[string][]byte{}
+	}
+	e.decrypted[string(blob)] = resp.Plaintext
+	return resp.Plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying e. A nil ctx is treated as context.Background.
+func NewContext(ctx context.Context, e *Encrypter) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, contextKey{}, e)
+}
+
+// FromContext returns the Encrypter carried by ctx, or nil when there is none.
+func FromContext(ctx context.Context) *Encrypter {
+	if ctx == nil {
+		return nil
+	}
+	e, _ := ctx.Value(contextKey{}).(*Encrypter)
+	return e
+}
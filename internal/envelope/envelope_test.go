@@ -0,0 +1,152 @@
+package envelope
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type person struct {
+	PK           string `dynamodbav:"PK"`
+	FirstName    string `dynamodbav:"FirstName,encrypted"`
+	EmailAddress string `dynamodbav:"EmailAddress,omitempty,encrypted"`
+	Nickname     string `dynamodbav:",encrypted"`
+	Ignored      string `dynamodbav:"-"`
+}
+
+func TestEncryptedAttributes(t *testing.T) {
+	want := []string{"FirstName", "EmailAddress", "Nickname"}
+	if got := EncryptedAttributes(&person{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("EncryptedAttributes() = %v, want %v", got, want)
+	}
+	if got := EncryptedAttributes("example"); got != nil {
+		t.Errorf("EncryptedAttributes() = %v, want nil", got)
+	}
+}
+
+func TestEncrypter_MarshalMap(t *testing.T) {
+	in := person{PK: "USER#12345", FirstName: "first_example", EmailAddress: "example@example.com", Nickname: "nick"}
+	prefixed := in
+	prefixed.FirstName = Prefix + "first_example"
+	tests := []struct {
+		name          string
+		encrypter     *Encrypter
+		in            person
+		wantEncrypted bool
+	}{
+		{name: "encrypted", encrypter: New(NewLocalKMS(), "alias/example"), in: in, wantEncrypted: true},
+		{name: "prefixed_plaintext", encrypter: New(NewLocalKMS(), "alias/example"), in: prefixed, wantEncrypted: true},
+		{name: "disabled", encrypter: nil, in: in},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := tt.in
+			item, err := tt.encrypter.MarshalMap(context.TODO(), in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pk := item["PK"].(*types.AttributeValueMemberS).Value; pk != in.PK {
+				t.Errorf("MarshalMap() PK = %v, want %v", pk, in.PK)
+			}
+			for _, name := range []string{"FirstName", "EmailAddress", "Nickname"} {
+				value := item[name].(*types.AttributeValueMemberS).Value
+				if strings.HasPrefix(value, Prefix) != tt.wantEncrypted {
+					t.Errorf("MarshalMap() %v = %v, want encrypted %v", name, value, tt.wantEncrypted)
+				}
+			}
+			if first := item["FirstName"].(*types.AttributeValueMemberS).Value; tt.wantEncrypted && first == in.FirstName {
+				t.Errorf("MarshalMap() FirstName = %v, want it encrypted", first)
+			}
+			got := person{}
+			if err := tt.encrypter.UnmarshalMap(context.TODO(), item, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != in {
+				t.Errorf("UnmarshalMap() = %+v, want %+v", got, in)
+			}
+		})
+	}
+}
+
+func TestEncrypter_dataKeyCache(t *testing.T) {
+	local := NewLocalKMS()
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := New(local, "alias/example")
+	e.now = func() time.Time { return now }
+	first, err := e.Encrypt(context.TODO(), "FirstName", "first_example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Encrypt(context.TODO(), "FirstName", "first_example"); err != nil {
+		t.Fatal(err)
+	}
+	if local.GenerateDataKeys != 1 {
+		t.Errorf("GenerateDataKey called %v times within the TTL, want 1", local.GenerateDataKeys)
+	}
+	now = now.Add(DataKeyTTL)
+	if _, err := e.Encrypt(context.TODO(), "FirstName", "first_example"); err != nil {
+		t.Fatal(err)
+	}
+	if local.GenerateDataKeys != 2 {
+		t.Errorf("GenerateDataKey called %v times after the TTL, want 2", local.GenerateDataKeys)
+	}
+
+	// A reader with its own Encrypter asks KMS for each data key once.
+	reader := New(local, "alias/example")
+	for i := 0; i < 2; i++ {
+		got, err := reader.Decrypt(context.TODO(), "FirstName", first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "first_example" {
+			t.Errorf("Decrypt() = %v, want first_example", got)
+		}
+	}
+	if local.Decrypts != 1 {
+		t.Errorf("KMS Decrypt called %v times, want 1", local.Decrypts)
+	}
+}
+
+func TestEncrypter_Decrypt(t *testing.T) {
+	e := New(NewLocalKMS(), "alias/example")
+	encrypted, err := e.Encrypt(context.TODO(), "FirstName", "first_example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		encrypter *Encrypter
+		attribute string
+		value     string
+		wantErr   bool
+	}{
+		{name: "decrypted", encrypter: e, attribute: "FirstName", value: encrypted},
+		{name: "other_attribute", encrypter: e, attribute: "SurName", value: encrypted, wantErr: true},
+		{name: "other_key", encrypter: New(NewLocalKMS(), "alias/example"), attribute: "FirstName", value: encrypted, wantErr: true},
+		{name: "malformed", encrypter: e, attribute: "FirstName", value: Prefix + "example", wantErr: true},
+		{name: "not_configured", encrypter: nil, attribute: "FirstName", value: encrypted, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.encrypter.Decrypt(context.TODO(), tt.attribute, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("PII_KMS_KEY_ID", "")
+	if e := FromEnv(NewLocalKMS()); e != nil {
+		t.Errorf("FromEnv() = %v, want nil without a key", e)
+	}
+	t.Setenv("PII_KMS_KEY_ID", "alias/example")
+	if e := FromEnv(NewLocalKMS()); e == nil || e.keyID != "alias/example" {
+		t.Errorf("FromEnv() = %v, want an Encrypter for alias/example", e)
+	}
+}
@@ -0,0 +1,114 @@
+package fakes
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
+// CognitoThrottle returns the error Cognito returns when a request rate limit is exceeded.
+func CognitoThrottle() error {
+	return &types.TooManyRequestsException{Message: aws.String("fake rate exceeded")}
+}
+
+// Cognito is an in-memory Cognito user pool. Users are created on their first update.
+type Cognito struct {
+	Recorder
+
+	mu         sync.Mutex
+	attributes map[string]map[string]string
+	groups     map[string]map[string]bool
+}
+
+// NewCognito returns an empty Cognito.
+func NewCognito() *Cognito {
+	return &Cognito{attributes: map[string]map[string]string{}, groups: map[string]map[string]bool{}}
+}
+
+// Attribute returns the value of username's attribute name.
+func (c *Cognito) Attribute(username, name string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.attributes[username][name]
+	return value, ok
+}
+
+// InGroup reports whether username has been added to group.
+func (c *Cognito) InGroup(username, group string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.groups[username][group]
+}
+
+// AssertAttribute fails t unless username's attribute name is want.
+func (c *Cognito) AssertAttribute(t testing.TB, username, name, want string) {
+	t.Helper()
+	got, ok := c.Attribute(username, name)
+	if !ok {
+		t.Errorf("user %s has no attribute %s", username, name)
+		return
+	}
+	if got != want {
+		t.Errorf("user %s attribute %s = %s, want %s", username, name, got, want)
+	}
+}
+
+// AssertNoAttribute fails t if username has attribute name.
+func (c *Cognito) AssertNoAttribute(t testing.TB, username, name string) {
+	t.Helper()
+	if got, ok := c.Attribute(username, name); ok {
+		t.Errorf("user %s attribute %s = %s, want it unset", username, name, got)
+	}
+}
+
+// AssertInGroup fails t unless username is in group.
+func (c *Cognito) AssertInGroup(t testing.TB, username, group string) {
+	t.Helper()
+	if !c.InGroup(username, group) {
+		t.Errorf("user %s is not in group %s", username, group)
+	}
+}
+
+// AdminUpdateUserAttributes sets the input's attributes on its user.
+func (c *Cognito) AdminUpdateUserAttributes(
+	ctx context.Context,
+	params *cognitoidentityprovider.AdminUpdateUserAttributesInput,
+	optFns ...func(*cognitoidentityprovider.Options),
+) (*cognitoidentityprovider.AdminUpdateUserAttributesOutput, error) {
+	if _, err := c.record(ctx, "AdminUpdateUserAttributes", params); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	username := aws.ToString(params.Username)
+	if c.attributes[username] == nil {
+		c.attributes[username] = map[string]string{}
+	}
+	for _, attribute := range params.UserAttributes {
+		c.attributes[username][aws.ToString(attribute.Name)] = aws.ToString(attribute.Value)
+	}
+	return &cognitoidentityprovider.AdminUpdateUserAttributesOutput{}, nil
+}
+
+// AdminAddUserToGroup adds the input's user to its group.
+func (c *Cognito) AdminAddUserToGroup(
+	ctx context.Context,
+	params *cognitoidentityprovider.AdminAddUserToGroupInput,
+	optFns ...func(*cognitoidentityprovider.Options),
+) (*cognitoidentityprovider.AdminAddUserToGroupOutput, error) {
+	if _, err := c.record(ctx, "AdminAddUserToGroup", params); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	username := aws.ToString(params.Username)
+	if c.groups[username] == nil {
+		c.groups[username] = map[string]bool{}
+	}
+	c.groups[username][aws.ToString(params.GroupName)] = true
+	return &cognitoidentityprovider.AdminAddUserToGroupOutput{}, nil
+}
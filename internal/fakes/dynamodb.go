@@ -0,0 +1,419 @@
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/keys"
+)
+
+// DynamoDBThrottle returns the error DynamoDB returns when a table's throughput is exceeded.
+func DynamoDBThrottle() error {
+	return &types.ProvisionedThroughputExceededException{Message: aws.String("fake throughput exceeded")}
+}
+
+// DynamoDB is an in-memory DynamoDB holding items keyed on PK and SK.
+type DynamoDB struct {
+	Recorder
+
+	mu          sync.Mutex
+	tables      map[string]map[string]map[string]types.AttributeValue
+	unprocessed map[int]int
+}
+
+// NewDynamoDB returns an empty DynamoDB.
+func NewDynamoDB() *DynamoDB {
+	return &DynamoDB{
+		tables:      map[string]map[string]map[string]types.AttributeValue{},
+		unprocessed: map[int]int{},
+	}
+}
+
+// UnprocessedOn makes the nth call to BatchWriteItem leave its last count requests unprocessed.
+func (d *DynamoDB) UnprocessedOn(n, count int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.unprocessed[n] = count
+}
+
+func itemKey(item map[string]types.AttributeValue) (string, error) {
+	pk, okPK := item[keys.PartitionKey].(*types.AttributeValueMemberS)
+	sk, okSK := item[keys.SortKey].(*types.AttributeValueMemberS)
+	if !okPK || !okSK {
+		return "", fmt.Errorf("item is missing its %s or %s string attribute", keys.PartitionKey, keys.SortKey)
+	}
+	return pk.Value + "\x00" + sk.Value, nil
+}
+
+// Put stores item in table directly, for seeding the fake before a test.
+func (d *DynamoDB) Put(table string, item map[string]types.AttributeValue) error {
+	key, err := itemKey(item)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.store(table, key, item)
+	return nil
+}
+
+// store must be called with d.mu held. A nil item deletes the key.
+func (d *DynamoDB) store(table, key string, item map[string]types.AttributeValue) {
+	if d.tables[table] == nil {
+		d.tables[table] = map[string]map[string]types.AttributeValue{}
+	}
+	if item == nil {
+		delete(d.tables[table], key)
+		return
+	}
+	d.tables[table][key] = copyItem(item)
+}
+
+// load must be called with d.mu held. It returns nil when the item does not exist.
+func (d *DynamoDB) load(table, key string) map[string]types.AttributeValue {
+	item, ok := d.tables[table][key]
+	if !ok {
+		return nil
+	}
+	return copyItem(item)
+}
+
+// Item returns the item in table with the given PK and SK, or nil when there is none.
+func (d *DynamoDB) Item(table, pk, sk string) map[string]types.AttributeValue {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.load(table, pk+"\x00"+sk)
+}
+
+// Items returns every item in table ordered by PK and SK.
+func (d *DynamoDB) Items(table string) []map[string]types.AttributeValue {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	keys := []string{}
+	for key := range d.tables[table] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	items := []map[string]types.AttributeValue{}
+	for _, key := range keys {
+		items = append(items, d.load(table, key))
+	}
+	return items
+}
+
+// AssertItem fails t unless table holds an item with the given PK and SK whose string and number attributes
+// include want.
+func (d *DynamoDB) AssertItem(t testing.TB, table, pk, sk string, want map[string]string) {
+	t.Helper()
+	item := d.Item(table, pk, sk)
+	if item == nil {
+		t.Errorf("item %s/%s does not exist in %s", pk, sk, table)
+		return
+	}
+	for name, value := range want {
+		var got string
+		switch v := item[name].(type) {
+		case *types.AttributeValueMemberS:
+			got = v.Value
+		case *types.AttributeValueMemberN:
+			got = v.Value
+		default:
+			t.Errorf("item %s/%s attribute %s = %v, want %s", pk, sk, name, item[name], value)
+			continue
+		}
+		if got != value {
+			t.Errorf("item %s/%s attribute %s = %s, want %s", pk, sk, name, got, value)
+		}
+	}
+}
+
+// AssertNoItem fails t if table holds an item with the given PK and SK.
+func (d *DynamoDB) AssertNoItem(t testing.TB, table, pk, sk string) {
+	t.Helper()
+	if item := d.Item(table, pk, sk); item != nil {
+		t.Errorf("item %s/%s exists in %s: %v", pk, sk, table, item)
+	}
+}
+
+func conditionalCheckFailed() error {
+	return &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+}
+
+// GetItem returns the item with the input's key.
+func (d *DynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	if _, err := d.record(ctx, "GetItem", params); err != nil {
+		return nil, err
+	}
+	key, err := itemKey(params.Key)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	item := d.load(aws.ToString(params.TableName), key)
+	d.mu.Unlock()
+	item, err = project(aws.ToString(params.ProjectionExpression), params.ExpressionAttributeNames, item)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.GetItemOutput{Item: item}, nil
+}
+
+// PutItem stores the input's item when its condition holds.
+func (d *DynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if _, err := d.record(ctx, "PutItem", params); err != nil {
+		return nil, err
+	}
+	key, err := itemKey(params.Item)
+	if err != nil {
+		return nil, err
+	}
+	table := aws.ToString(params.TableName)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	old := d.load(table, key)
+	ok, err := evaluateCondition(aws.ToString(params.ConditionExpression), params.ExpressionAttributeNames, params.ExpressionAttributeValues, old)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, conditionalCheckFailed()
+	}
+	d.store(table, key, params.Item)
+	output := &dynamodb.PutItemOutput{}
+	if params.ReturnValues == types.ReturnValueAllOld {
+		output.Attributes = old
+	}
+	return output, nil
+}
+
+// UpdateItem applies the input's update expression to the item with its key, creating the item when it does not
+// exist, when its condition holds.
+func (d *DynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	if _, err := d.record(ctx, "UpdateItem", params); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	old, updated, changed, err := d.update(
+		aws.ToString(params.TableName),
+		params.Key,
+		aws.ToString(params.UpdateExpression),
+		aws.ToString(params.ConditionExpression),
+		params.ExpressionAttributeNames,
+		params.ExpressionAttributeValues,
+	)
+	if err != nil {
+		return nil, err
+	}
+	output := &dynamodb.UpdateItemOutput{}
+	switch params.ReturnValues {
+	case types.ReturnValueAllOld:
+		output.Attributes = old
+	case types.ReturnValueAllNew:
+		output.Attributes = updated
+	case types.ReturnValueUpdatedOld, types.ReturnValueUpdatedNew:
+		source := old
+		if params.ReturnValues == types.ReturnValueUpdatedNew {
+			source = updated
+		}
+		output.Attributes = map[string]types.AttributeValue{}
+		for _, name := range changed {
+			if value, ok := source[name]; ok {
+				output.Attributes[name] = value
+			}
+		}
+	}
+	return output, nil
+}
+
+// update must be called with d.mu held.
+func (d *DynamoDB) update(
+	table string,
+	key map[string]types.AttributeValue,
+	update, condition string,
+	names map[string]string,
+	values map[string]types.AttributeValue,
+) (map[string]types.AttributeValue, map[string]types.AttributeValue, []string, error) {
+	k, err := itemKey(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	old := d.load(table, k)
+	ok, err := evaluateCondition(condition, names, values, old)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !ok {
+		return nil, nil, nil, conditionalCheckFailed()
+	}
+	base := old
+	if base == nil {
+		base = copyItem(key)
+	}
+	updated, changed, err := applyUpdate(update, names, values, base)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	d.store(table, k, updated)
+	return old, updated, changed, nil
+}
+
+// BatchWriteItem applies the input's put and delete requests, leaving any requests scripted with UnprocessedOn.
+func (d *DynamoDB) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	n, err := d.record(ctx, "BatchWriteItem", params)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	skip := d.unprocessed[n]
+	output := &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]types.WriteRequest{}}
+	tables := []string{}
+	for table := range params.RequestItems {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		touched := map[string]bool{}
+		for _, request := range params.RequestItems[table] {
+			item := map[string]types.AttributeValue{}
+			switch {
+			case request.PutRequest != nil:
+				item = request.PutRequest.Item
+			case request.DeleteRequest != nil:
+				item = request.DeleteRequest.Key
+			}
+			key, err := itemKey(item)
+			if err != nil {
+				return nil, err
+			}
+			if touched[key] {
+				return nil, fmt.Errorf("ValidationException: Provided list of item keys contains duplicates")
+			}
+			touched[key] = true
+		}
+	}
+	for _, table := range tables {
+		requests := params.RequestItems[table]
+		for i, request := range requests {
+			if i >= len(requests)-skip {
+				output.UnprocessedItems[table] = append(output.UnprocessedItems[table], request)
+				continue
+			}
+			switch {
+			case request.PutRequest != nil:
+				key, err := itemKey(request.PutRequest.Item)
+				if err != nil {
+					return nil, err
+				}
+				d.store(table, key, request.PutRequest.Item)
+			case request.DeleteRequest != nil:
+				key, err := itemKey(request.DeleteRequest.Key)
+				if err != nil {
+					return nil, err
+				}
+				d.store(table, key, nil)
+			}
+		}
+	}
+	if len(output.UnprocessedItems) == 0 {
+		output.UnprocessedItems = nil
+	}
+	return output, nil
+}
+
+// TransactWriteItems applies the input's writes together when every condition holds, and otherwise cancels the
+// transaction with a reason for each item. Like DynamoDB, it rejects a transaction that touches an item twice.
+func (d *DynamoDB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	if _, err := d.record(ctx, "TransactWriteItems", params); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	type check struct {
+		table     string
+		key       map[string]types.AttributeValue
+		condition *string
+		names     map[string]string
+		values    map[string]types.AttributeValue
+	}
+	checks := []check{}
+	for _, item := range params.TransactItems {
+		switch {
+		case item.Put != nil:
+			checks = append(checks, check{aws.ToString(item.Put.TableName), item.Put.Item, item.Put.ConditionExpression, item.Put.ExpressionAttributeNames, item.Put.ExpressionAttributeValues})
+		case item.Update != nil:
+			checks = append(checks, check{aws.ToString(item.Update.TableName), item.Update.Key, item.Update.ConditionExpression, item.Update.ExpressionAttributeNames, item.Update.ExpressionAttributeValues})
+		case item.Delete != nil:
+			checks = append(checks, check{aws.ToString(item.Delete.TableName), item.Delete.Key, item.Delete.ConditionExpression, item.Delete.ExpressionAttributeNames, item.Delete.ExpressionAttributeValues})
+		case item.ConditionCheck != nil:
+			checks = append(checks, check{aws.ToString(item.ConditionCheck.TableName), item.ConditionCheck.Key, item.ConditionCheck.ConditionExpression, item.ConditionCheck.ExpressionAttributeNames, item.ConditionCheck.ExpressionAttributeValues})
+		default:
+			return nil, fmt.Errorf("transact item has no operation")
+		}
+	}
+	touched := map[string]bool{}
+	for _, c := range checks {
+		key, err := itemKey(c.key)
+		if err != nil {
+			return nil, err
+		}
+		if touched[c.table+"|"+key] {
+			return nil, fmt.Errorf("ValidationException: transaction request cannot include multiple operations on one item")
+		}
+		touched[c.table+"|"+key] = true
+	}
+	reasons := make([]types.CancellationReason, len(checks))
+	cancelled := false
+	for i, c := range checks {
+		reasons[i] = types.CancellationReason{Code: aws.String("None")}
+		key, err := itemKey(c.key)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := evaluateCondition(aws.ToString(c.condition), c.names, c.values, d.load(c.table, key))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			cancelled = true
+			reasons[i] = types.CancellationReason{Code: aws.String("ConditionalCheckFailed"), Message: aws.String("The conditional request failed")}
+		}
+	}
+	if cancelled {
+		return nil, &types.TransactionCanceledException{
+			Message:             aws.String("Transaction cancelled, please refer cancellation reasons for specific reasons"),
+			CancellationReasons: reasons,
+		}
+	}
+	for _, item := range params.TransactItems {
+		switch {
+		case item.Put != nil:
+			key, _ := itemKey(item.Put.Item)
+			d.store(aws.ToString(item.Put.TableName), key, item.Put.Item)
+		case item.Update != nil:
+			// The conditions were checked above, so the update is applied unconditionally.
+			_, _, _, err := d.update(
+				aws.ToString(item.Update.TableName),
+				item.Update.Key,
+				aws.ToString(item.Update.UpdateExpression),
+				"",
+				item.Update.ExpressionAttributeNames,
+				item.Update.ExpressionAttributeValues,
+			)
+			if err != nil {
+				return nil, err
+			}
+		case item.Delete != nil:
+			key, _ := itemKey(item.Delete.Key)
+			d.store(aws.ToString(item.Delete.TableName), key, nil)
+		}
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
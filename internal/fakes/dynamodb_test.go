@@ -0,0 +1,202 @@
+package fakes
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func userKey(id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{"PK": s("USER#" + id), "SK": s("USER#MAIDO")}
+}
+
+func TestDynamoDB_UpdateItem(t *testing.T) {
+	db := NewDynamoDB()
+	input := &dynamodb.UpdateItemInput{
+		TableName:                 aws.String("example"),
+		Key:                       userKey("12345"),
+		UpdateExpression:          aws.String("SET #Version = :next, StripeCustomerID = :customer"),
+		ConditionExpression:       aws.String("attribute_not_exists(#Version)"),
+		ExpressionAttributeNames:  map[string]string{"#Version": "Version"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":next": n("1"), ":customer": s("cus_01234")},
+		ReturnValues:              types.ReturnValueAllNew,
+	}
+	resp, err := db.UpdateItem(context.TODO(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Attributes["PK"].(*types.AttributeValueMemberS).Value != "USER#12345" {
+		t.Errorf("UpdateItem() ALL_NEW = %v, want the key included", resp.Attributes)
+	}
+	db.AssertItem(t, "example", "USER#12345", "USER#MAIDO", map[string]string{"Version": "1", "StripeCustomerID": "cus_01234"})
+
+	_, err = db.UpdateItem(context.TODO(), input)
+	var conflict *types.ConditionalCheckFailedException
+	if !errors.As(err, &conflict) {
+		t.Errorf("UpdateItem() error = %v, want ConditionalCheckFailedException", err)
+	}
+
+	input.ConditionExpression = aws.String("#Version = :version")
+	input.ExpressionAttributeValues = map[string]types.AttributeValue{":version": n("1"), ":next": n("2"), ":customer": s("cus_56789")}
+	input.ReturnValues = types.ReturnValueUpdatedOld
+	resp, err = db.UpdateItem(context.TODO(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Attributes["StripeCustomerID"].(*types.AttributeValueMemberS).Value; got != "cus_01234" {
+		t.Errorf("UpdateItem() UPDATED_OLD StripeCustomerID = %v, want cus_01234", got)
+	}
+	if _, ok := resp.Attributes["PK"]; ok {
+		t.Errorf("UpdateItem() UPDATED_OLD = %v, want only updated attributes", resp.Attributes)
+	}
+	db.AssertCalls(t, "UpdateItem", 3)
+}
+
+func TestDynamoDB_GetItem(t *testing.T) {
+	db := NewDynamoDB()
+	item := userKey("12345")
+	item["Version"] = n("2")
+	item["EmailAddress"] = s("example@example.com")
+	if err := db.Put("example", item); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := db.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName:                aws.String("example"),
+		Key:                      userKey("12345"),
+		ProjectionExpression:     aws.String("#Version"),
+		ExpressionAttributeNames: map[string]string{"#Version": "Version"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Item) != 1 || resp.Item["Version"] == nil {
+		t.Errorf("GetItem() = %v, want only Version", resp.Item)
+	}
+	resp, err = db.GetItem(context.TODO(), &dynamodb.GetItemInput{TableName: aws.String("example"), Key: userKey("56789")})
+	if err != nil || resp.Item != nil {
+		t.Errorf("GetItem() = %v, %v, want no item", resp.Item, err)
+	}
+}
+
+func TestDynamoDB_PutItem(t *testing.T) {
+	db := NewDynamoDB()
+	input := &dynamodb.PutItemInput{
+		TableName:           aws.String("example"),
+		Item:                userKey("12345"),
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	}
+	if _, err := db.PutItem(context.TODO(), input); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.PutItem(context.TODO(), input); err == nil {
+		t.Errorf("PutItem() error = nil, want a conditional check failure for an existing item")
+	}
+	if got := len(db.Items("example")); got != 1 {
+		t.Errorf("Items() = %v items, want 1", got)
+	}
+}
+
+func TestDynamoDB_BatchWriteItem(t *testing.T) {
+	db := NewDynamoDB()
+	db.UnprocessedOn(1, 1)
+	db.FailOn("BatchWriteItem", 3, DynamoDBThrottle())
+	input := &dynamodb.BatchWriteItemInput{RequestItems: map[string][]types.WriteRequest{
+		"example": {
+			{PutRequest: &types.PutRequest{Item: userKey("1")}},
+			{PutRequest: &types.PutRequest{Item: userKey("2")}},
+		},
+	}}
+	resp, err := db.BatchWriteItem(context.TODO(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(resp.UnprocessedItems["example"]); got != 1 {
+		t.Fatalf("BatchWriteItem() left %v items unprocessed, want 1", got)
+	}
+	db.AssertItem(t, "example", "USER#1", "USER#MAIDO", nil)
+	db.AssertNoItem(t, "example", "USER#2", "USER#MAIDO")
+
+	resp, err = db.BatchWriteItem(context.TODO(), &dynamodb.BatchWriteItemInput{RequestItems: resp.UnprocessedItems})
+	if err != nil || resp.UnprocessedItems != nil {
+		t.Fatalf("BatchWriteItem() = %v, %v, want everything processed", resp.UnprocessedItems, err)
+	}
+	db.AssertItem(t, "example", "USER#2", "USER#MAIDO", nil)
+
+	_, err = db.BatchWriteItem(context.TODO(), input)
+	var throttle *types.ProvisionedThroughputExceededException
+	if !errors.As(err, &throttle) {
+		t.Errorf("BatchWriteItem() error = %v, want the scripted throttle", err)
+	}
+}
+
+func TestDynamoDB_BatchWriteItem_sameItem(t *testing.T) {
+	db := NewDynamoDB()
+	put := types.WriteRequest{PutRequest: &types.PutRequest{Item: userKey("1")}}
+	_, err := db.BatchWriteItem(context.TODO(), &dynamodb.BatchWriteItemInput{RequestItems: map[string][]types.WriteRequest{
+		"example": {put, put},
+	}})
+	if err == nil {
+		t.Fatal("BatchWriteItem() error = nil, want a validation error")
+	}
+	db.AssertNoItem(t, "example", "USER#1", "USER#MAIDO")
+}
+
+func TestDynamoDB_TransactWriteItems(t *testing.T) {
+	db := NewDynamoDB()
+	if err := db.Put("example", map[string]types.AttributeValue{"PK": s("STRIPE#cus_1"), "SK": s("USER#2"), "CognitoUserID": s("2")}); err != nil {
+		t.Fatal(err)
+	}
+	put := func(pk, sk, user string) types.TransactWriteItem {
+		return types.TransactWriteItem{Put: &types.Put{
+			TableName:                 aws.String("example"),
+			Item:                      map[string]types.AttributeValue{"PK": s(pk), "SK": s(sk), "CognitoUserID": s(user)},
+			ConditionExpression:       aws.String("attribute_not_exists(PK) OR CognitoUserID = :user"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":user": s(user)},
+		}}
+	}
+	update := types.TransactWriteItem{Update: &types.Update{
+		TableName:                 aws.String("example"),
+		Key:                       userKey("1"),
+		UpdateExpression:          aws.String("SET StripeCustomerID = :customer"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":customer": s("cus_1")},
+	}}
+	_, err := db.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{update, put("STRIPE#cus_1", "USER#2", "1")},
+	})
+	var cancelled *types.TransactionCanceledException
+	if !errors.As(err, &cancelled) {
+		t.Fatalf("TransactWriteItems() error = %v, want a cancelled transaction", err)
+	}
+	if codes := []string{aws.ToString(cancelled.CancellationReasons[0].Code), aws.ToString(cancelled.CancellationReasons[1].Code)}; codes[0] != "None" || codes[1] != "ConditionalCheckFailed" {
+		t.Errorf("TransactWriteItems() reasons = %v, want None, ConditionalCheckFailed", codes)
+	}
+	db.AssertNoItem(t, "example", "USER#1", "USER#MAIDO")
+
+	_, err = db.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{update, put("STRIPE#cus_1", "USER#1", "1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AssertItem(t, "example", "USER#1", "USER#MAIDO", map[string]string{"StripeCustomerID": "cus_1"})
+	db.AssertItem(t, "example", "STRIPE#cus_1", "USER#1", map[string]string{"CognitoUserID": "1"})
+}
+
+func TestDynamoDB_TransactWriteItems_sameItem(t *testing.T) {
+	db := NewDynamoDB()
+	put := types.TransactWriteItem{Put: &types.Put{
+		TableName: aws.String("example"),
+		Item:      map[string]types.AttributeValue{"PK": s("STRIPE#cus_1"), "SK": s("USER#1")},
+	}}
+	_, err := db.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{put, put},
+	})
+	if err == nil {
+		t.Fatal("TransactWriteItems() error = nil, want a validation error")
+	}
+	db.AssertNoItem(t, "example", "STRIPE#cus_1", "USER#1")
+}
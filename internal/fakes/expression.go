@@ -0,0 +1,404 @@
+package fakes
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The fake DynamoDB evaluates the subset of the expression language the onboarding lambdas use: top-level
+// attributes only, comparisons, AND, OR, NOT, parentheses, attribute_exists, attribute_not_exists and
+// begins_with in conditions, and SET (with if_not_exists) and REMOVE in updates.
+
+type expression struct {
+	tokens []string
+	pos    int
+	names  map[string]string
+	values map[string]types.AttributeValue
+}
+
+func newExpression(s string, names map[string]string, values map[string]types.AttributeValue) (*expression, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	return &expression{tokens: tokens, names: names, values: values}, nil
+}
+
+func tokenize(s string) ([]string, error) {
+	tokens := []string{}
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.ContainsRune("(),=", c):
+			tokens = append(tokens, string(c))
+			i++
+		case c == '<' || c == '>':
+			if i+1 < len(s) && (s[i+1] == '=' || (c == '<' && s[i+1] == '>')) {
+				tokens = append(tokens, s[i:i+2])
+				i += 2
+			} else {
+				tokens = append(tokens, string(c))
+				i++
+			}
+		case c == '#' || c == ':' || c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || s[j] == '.' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unsupported character %q in expression %q", c, s)
+		}
+	}
+	return tokens, nil
+}
+
+func (e *expression) peek() string {
+	if e.pos < len(e.tokens) {
+		return e.tokens[e.pos]
+	}
+	return ""
+}
+
+func (e *expression) next() string {
+	token := e.peek()
+	e.pos++
+	return token
+}
+
+func (e *expression) expect(token string) error {
+	if got := e.next(); got != token {
+		return fmt.Errorf("expected %q in expression, got %q", token, got)
+	}
+	return nil
+}
+
+func (e *expression) keyword(word string) bool {
+	if strings.EqualFold(e.peek(), word) {
+		e.pos++
+		return true
+	}
+	return false
+}
+
+// path resolves an attribute path token to an attribute name.
+func (e *expression) path(token string) (string, error) {
+	if strings.Contains(token, ".") {
+		return "", fmt.Errorf("nested attribute %q is not supported", token)
+	}
+	if strings.HasPrefix(token, "#") {
+		name, ok := e.names[token]
+		if !ok {
+			return "", fmt.Errorf("expression attribute name %s is not defined", token)
+		}
+		return name, nil
+	}
+	if token == "" || strings.HasPrefix(token, ":") {
+		return "", fmt.Errorf("expected an attribute name, got %q", token)
+	}
+	return token, nil
+}
+
+// operand returns the value of a path or placeholder token in item, or nil when the attribute is missing.
+func (e *expression) operand(token string, item map[string]types.AttributeValue) (types.AttributeValue, error) {
+	if strings.HasPrefix(token, ":") {
+		value, ok := e.values[token]
+		if !ok {
+			return nil, fmt.Errorf("expression attribute value %s is not defined", token)
+		}
+		return value, nil
+	}
+	name, err := e.path(token)
+	if err != nil {
+		return nil, err
+	}
+	return item[name], nil
+}
+
+// evaluateCondition reports whether item, which is nil when it does not exist, satisfies condition.
+func evaluateCondition(condition string, names map[string]string, values map[string]types.AttributeValue, item map[string]types.AttributeValue) (bool, error) {
+	if condition == "" {
+		return true, nil
+	}
+	e, err := newExpression(condition, names, values)
+	if err != nil {
+		return false, err
+	}
+	ok, err := e.or(item)
+	if err != nil {
+		return false, err
+	}
+	if e.pos != len(e.tokens) {
+		return false, fmt.Errorf("unexpected %q in condition %q", e.peek(), condition)
+	}
+	return ok, nil
+}
+
+func (e *expression) or(item map[string]types.AttributeValue) (bool, error) {
+	result, err := e.and(item)
+	if err != nil {
+		return false, err
+	}
+	for e.keyword("OR") {
+		right, err := e.and(item)
+		if err != nil {
+			return false, err
+		}
+		result = result || right
+	}
+	return result, nil
+}
+
+func (e *expression) and(item map[string]types.AttributeValue) (bool, error) {
+	result, err := e.not(item)
+	if err != nil {
+		return false, err
+	}
+	for e.keyword("AND") {
+		right, err := e.not(item)
+		if err != nil {
+			return false, err
+		}
+		result = result && right
+	}
+	return result, nil
+}
+
+func (e *expression) not(item map[string]types.AttributeValue) (bool, error) {
+	if e.keyword("NOT") {
+		result, err := e.not(item)
+		return !result, err
+	}
+	return e.primary(item)
+}
+
+func (e *expression) primary(item map[string]types.AttributeValue) (bool, error) {
+	if e.peek() == "(" {
+		e.next()
+		result, err := e.or(item)
+		if err != nil {
+			return false, err
+		}
+		return result, e.expect(")")
+	}
+	token := e.next()
+	switch strings.ToLower(token) {
+	case "attribute_exists", "attribute_not_exists":
+		if err := e.expect("("); err != nil {
+			return false, err
+		}
+		name, err := e.path(e.next())
+		if err != nil {
+			return false, err
+		}
+		_, exists := item[name]
+		return exists == (strings.ToLower(token) == "attribute_exists"), e.expect(")")
+	case "begins_with":
+		if err := e.expect("("); err != nil {
+			return false, err
+		}
+		value, err := e.operand(e.next(), item)
+		if err != nil {
+			return false, err
+		}
+		if err := e.expect(","); err != nil {
+			return false, err
+		}
+		prefix, err := e.operand(e.next(), item)
+		if err != nil {
+			return false, err
+		}
+		s, ok := value.(*types.AttributeValueMemberS)
+		p, okPrefix := prefix.(*types.AttributeValueMemberS)
+		return ok && okPrefix && strings.HasPrefix(s.Value, p.Value), e.expect(")")
+	}
+	left, err := e.operand(token, item)
+	if err != nil {
+		return false, err
+	}
+	comparator := e.next()
+	right, err := e.operand(e.next(), item)
+	if err != nil {
+		return false, err
+	}
+	return compare(left, comparator, right)
+}
+
+// compare applies comparator to two attribute values. A missing attribute is unequal to everything.
+func compare(left types.AttributeValue, comparator string, right types.AttributeValue) (bool, error) {
+	if left == nil || right == nil {
+		return comparator == "<>", nil
+	}
+	var order int
+	switch l := left.(type) {
+	case *types.AttributeValueMemberS:
+		r, ok := right.(*types.AttributeValueMemberS)
+		if !ok {
+			return comparator == "<>", nil
+		}
+		order = strings.Compare(l.Value, r.Value)
+	case *types.AttributeValueMemberN:
+		r, ok := right.(*types.AttributeValueMemberN)
+		if !ok {
+			return comparator == "<>", nil
+		}
+		lf, okLeft := new(big.Float).SetString(l.Value)
+		rf, okRight := new(big.Float).SetString(r.Value)
+		if !okLeft || !okRight {
+			return false, fmt.Errorf("invalid number %s or %s", l.Value, r.Value)
+		}
+		order = lf.Cmp(rf)
+	case *types.AttributeValueMemberB:
+		r, ok := right.(*types.AttributeValueMemberB)
+		if !ok {
+			return comparator == "<>", nil
+		}
+		order = bytes.Compare(l.Value, r.Value)
+	default:
+		equal := reflect.DeepEqual(left, right)
+		switch comparator {
+		case "=":
+			return equal, nil
+		case "<>":
+			return !equal, nil
+		}
+		return false, fmt.Errorf("comparator %s is not supported for %T", comparator, left)
+	}
+	switch comparator {
+	case "=":
+		return order == 0, nil
+	case "<>":
+		return order != 0, nil
+	case "<":
+		return order < 0, nil
+	case "<=":
+		return order <= 0, nil
+	case ">":
+		return order > 0, nil
+	case ">=":
+		return order >= 0, nil
+	}
+	return false, fmt.Errorf("unsupported comparator %q", comparator)
+}
+
+// applyUpdate applies update to a copy of item and returns it with the names of the attributes it changed.
+func applyUpdate(update string, names map[string]string, values map[string]types.AttributeValue, item map[string]types.AttributeValue) (map[string]types.AttributeValue, []string, error) {
+	e, err := newExpression(update, names, values)
+	if err != nil {
+		return nil, nil, err
+	}
+	updated := copyItem(item)
+	changed := []string{}
+	for e.pos < len(e.tokens) {
+		switch {
+		case e.keyword("SET"):
+			for {
+				name, err := e.path(e.next())
+				if err != nil {
+					return nil, nil, err
+				}
+				if err := e.expect("="); err != nil {
+					return nil, nil, err
+				}
+				value, err := e.setValue(item)
+				if err != nil {
+					return nil, nil, err
+				}
+				updated[name] = value
+				changed = append(changed, name)
+				if e.peek() != "," {
+					break
+				}
+				e.next()
+			}
+		case e.keyword("REMOVE"):
+			for {
+				name, err := e.path(e.next())
+				if err != nil {
+					return nil, nil, err
+				}
+				delete(updated, name)
+				changed = append(changed, name)
+				if e.peek() != "," {
+					break
+				}
+				e.next()
+			}
+		default:
+			return nil, nil, fmt.Errorf("unsupported update clause %q in %q", e.peek(), update)
+		}
+	}
+	return updated, changed, nil
+}
+
+// setValue reads the right hand side of a SET action, evaluated against the item before the update.
+func (e *expression) setValue(item map[string]types.AttributeValue) (types.AttributeValue, error) {
+	token := e.next()
+	if strings.EqualFold(token, "if_not_exists") {
+		if err := e.expect("("); err != nil {
+			return nil, err
+		}
+		name, err := e.path(e.next())
+		if err != nil {
+			return nil, err
+		}
+		if err := e.expect(","); err != nil {
+			return nil, err
+		}
+		fallback, err := e.operand(e.next(), item)
+		if err != nil {
+			return nil, err
+		}
+		if err := e.expect(")"); err != nil {
+			return nil, err
+		}
+		if existing, ok := item[name]; ok {
+			return existing, nil
+		}
+		return fallback, nil
+	}
+	value, err := e.operand(token, item)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, fmt.Errorf("attribute %s in update does not exist", token)
+	}
+	return value, nil
+}
+
+// project returns the attributes of item named in projection.
+func project(projection string, names map[string]string, item map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	if projection == "" || item == nil {
+		return item, nil
+	}
+	e := &expression{names: names}
+	projected := map[string]types.AttributeValue{}
+	for _, token := range strings.Split(projection, ",") {
+		name, err := e.path(strings.TrimSpace(token))
+		if err != nil {
+			return nil, err
+		}
+		if value, ok := item[name]; ok {
+			projected[name] = value
+		}
+	}
+	return projected, nil
+}
+
+func copyItem(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	copied := make(map[string]types.AttributeValue, len(item))
+	for name, value := range item {
+		copied[name] = value
+	}
+	return copied
+}
@@ -0,0 +1,134 @@
+package fakes
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func s(value string) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: value}
+}
+
+func n(value string) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: value}
+}
+
+func TestEvaluateCondition(t *testing.T) {
+	item := map[string]types.AttributeValue{
+		"PK":                     s("USER#12345"),
+		"OnboardingStatus":       s("FAILED"),
+		"OnboardingResumeStatus": s("STRIPE_CREATED"),
+		"Version":                n("3"),
+	}
+	values := map[string]types.AttributeValue{
+		":from":    s("STRIPE_CREATED"),
+		":failed":  s("FAILED"),
+		":version": n("3.0"),
+		":prefix":  s("USER#"),
+	}
+	names := map[string]string{"#Version": "Version"}
+	tests := []struct {
+		name      string
+		condition string
+		item      map[string]types.AttributeValue
+		want      bool
+		wantErr   bool
+	}{
+		{name: "empty", condition: "", item: item, want: true},
+		{name: "not_exists_missing_item", condition: "attribute_not_exists(PK)", item: nil, want: true},
+		{name: "not_exists_existing_item", condition: "attribute_not_exists(PK)", item: item, want: false},
+		{name: "exists_with_name", condition: "attribute_exists(#Version)", item: item, want: true},
+		{name: "number_equal", condition: "#Version = :version", item: item, want: true},
+		{name: "number_greater", condition: "#Version > :version", item: item, want: false},
+		{
+			name:      "or_and_parentheses",
+			condition: "OnboardingStatus = :from OR (OnboardingStatus = :failed AND OnboardingResumeStatus = :from)",
+			item:      item,
+			want:      true,
+		},
+		{name: "not", condition: "NOT OnboardingStatus = :failed", item: item, want: false},
+		{name: "missing_not_equal", condition: "Missing <> :from", item: item, want: true},
+		{name: "begins_with", condition: "begins_with(PK, :prefix)", item: item, want: true},
+		{name: "undefined_value", condition: "PK = :undefined", item: item, wantErr: true},
+		{name: "undefined_name", condition: "#Undefined = :from", item: item, wantErr: true},
+		{name: "trailing_tokens", condition: "PK = :prefix :from", item: item, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evaluateCondition(tt.condition, names, values, tt.item)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("evaluateCondition() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("evaluateCondition() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyUpdate(t *testing.T) {
+	item := map[string]types.AttributeValue{
+		"PK":                     s("USER#12345"),
+		"OnboardingStatus":       s("PENDING"),
+		"OnboardingResumeStatus": s("PENDING"),
+	}
+	values := map[string]types.AttributeValue{
+		":to":      s("STRIPE_CREATED"),
+		":pending": s("PENDING"),
+		":version": n("1"),
+	}
+	names := map[string]string{"#Version": "Version"}
+	tests := []struct {
+		name        string
+		update      string
+		want        map[string]types.AttributeValue
+		wantChanged []string
+		wantErr     bool
+	}{
+		{
+			name:   "set_and_remove",
+			update: "SET OnboardingStatus = :to, #Version = :version REMOVE OnboardingResumeStatus",
+			want: map[string]types.AttributeValue{
+				"PK":               s("USER#12345"),
+				"OnboardingStatus": s("STRIPE_CREATED"),
+				"Version":          n("1"),
+			},
+			wantChanged: []string{"OnboardingStatus", "Version", "OnboardingResumeStatus"},
+		},
+		{
+			name:   "if_not_exists",
+			update: "SET OnboardingStatus = if_not_exists(OnboardingStatus, :to), Started = if_not_exists(Started, :pending)",
+			want: map[string]types.AttributeValue{
+				"PK":                     s("USER#12345"),
+				"OnboardingStatus":       s("PENDING"),
+				"OnboardingResumeStatus": s("PENDING"),
+				"Started":                s("PENDING"),
+			},
+			wantChanged: []string{"OnboardingStatus", "Started"},
+		},
+		{name: "unsupported_clause", update: "ADD Counter :version", wantErr: true},
+		{name: "nested_path", update: "SET Address.City = :to", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed, err := applyUpdate(tt.update, names, values, item)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyUpdate() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(changed, tt.wantChanged) {
+				t.Errorf("applyUpdate() changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if len(item) != 3 {
+				t.Errorf("applyUpdate() modified its input: %v", item)
+			}
+		})
+	}
+}
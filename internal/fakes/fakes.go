@@ -0,0 +1,145 @@
+// Package fakes provides stateful in-memory implementations of the Stripe, DynamoDB, Cognito and SQS clients used
+// by the onboarding lambdas. Each fake records its calls, can be scripted to fail, and has assertions for tests:
+//
+//	db := fakes.NewDynamoDB()
+//	db.FailOn("BatchWriteItem", 2, fakes.DynamoDBThrottle())
+//	...
+//	db.AssertCalls(t, "BatchWriteItem", 2)
+//	db.AssertItem(t, "example", "USER#12345", "USER#MAIDO", map[string]string{"StripeCustomerID": "cus_fake_0001"})
+package fakes
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Call is a single recorded call to a fake.
+type Call struct {
+	Operation string
+	Input     interface{}
+}
+
+type scriptedFailure struct {
+	operation string
+	call      int
+	match     func(input interface{}) bool
+	err       error
+}
+
+// Recorder records the calls made to a fake and returns the failures scripted for them. It is embedded in every
+// fake and is safe for concurrent use.
+type Recorder struct {
+	mu        sync.Mutex
+	calls     []Call
+	counts    map[string]int
+	failures  []scriptedFailure
+	latencies map[string]time.Duration
+}
+
+// SetLatency makes every call to operation take at least d, as a call over the network would. An empty operation
+// sets the latency of every operation without one of its own.
+func (r *Recorder) SetLatency(operation string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.latencies == nil {
+		r.latencies = map[string]time.Duration{}
+	}
+	r.latencies[operation] = d
+}
+
+// FailOn makes the nth call (counting from 1) to operation return err instead of doing anything. An n of 0 fails
+// every call.
+func (r *Recorder) FailOn(operation string, n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, scriptedFailure{operation: operation, call: n, err: err})
+}
+
+// FailWhen makes every call to operation whose input satisfies match return err instead of doing anything.
+func (r *Recorder) FailWhen(operation string, match func(input interface{}) bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, scriptedFailure{operation: operation, match: match, err: err})
+}
+
+// record stores a call, waits for its latency, and returns its call number and any failure scripted for it. Like
+// the real clients, it returns ctx's error when ctx is done before the call completes.
+func (r *Recorder) record(ctx context.Context, operation string, input interface{}) (int, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	n, latency, err := r.recordLocked(operation, input)
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return n, ctxErr
+	}
+	return n, err
+}
+
+// recordLocked stores a call and returns its call number, latency and scripted failure.
+func (r *Recorder) recordLocked(operation string, input interface{}) (int, time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	latency, ok := r.latencies[operation]
+	if !ok {
+		latency = r.latencies[""]
+	}
+	if r.counts == nil {
+		r.counts = map[string]int{}
+	}
+	r.counts[operation]++
+	n := r.counts[operation]
+	r.calls = append(r.calls, Call{Operation: operation, Input: input})
+	for _, failure := range r.failures {
+		if failure.operation != operation {
+			continue
+		}
+		if failure.match != nil {
+			if failure.match(input) {
+				return n, latency, failure.err
+			}
+			continue
+		}
+		if failure.call == 0 || failure.call == n {
+			return n, latency, failure.err
+		}
+	}
+	return n, latency, nil
+}
+
+// Calls returns the calls made to operation, or every call when operation is empty.
+func (r *Recorder) Calls(operation string) []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	calls := []Call{}
+	for _, call := range r.calls {
+		if operation == "" || call.Operation == operation {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// CallCount returns the number of calls made to operation.
+func (r *Recorder) CallCount(operation string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[operation]
+}
+
+// AssertCalls fails t unless operation was called want times.
+func (r *Recorder) AssertCalls(t testing.TB, operation string, want int) {
+	t.Helper()
+	if got := r.CallCount(operation); got != want {
+		t.Errorf("%s called %d times, want %d", operation, got, want)
+	}
+}
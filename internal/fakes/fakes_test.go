@@ -0,0 +1,243 @@
+package fakes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stripe/stripe-go/v72"
+)
+
+func TestRecorder(t *testing.T) {
+	r := &Recorder{}
+	example := fmt.Errorf("example error")
+	r.FailOn("Get", 2, example)
+	r.FailWhen("Put", func(input interface{}) bool { return input == "bad" }, example)
+	tests := []struct {
+		operation string
+		input     interface{}
+		wantErr   bool
+	}{
+		{operation: "Get", input: "a"},
+		{operation: "Get", input: "b", wantErr: true},
+		{operation: "Get", input: "c"},
+		{operation: "Put", input: "good"},
+		{operation: "Put", input: "bad", wantErr: true},
+	}
+	for _, tt := range tests {
+		if _, err := r.record(context.TODO(), tt.operation, tt.input); (err != nil) != tt.wantErr {
+			t.Errorf("record(%v, %v) error = %v, wantErr %v", tt.operation, tt.input, err, tt.wantErr)
+		}
+	}
+	r.AssertCalls(t, "Get", 3)
+	if got := len(r.Calls("")); got != 5 {
+		t.Errorf("Calls() = %v, want 5", got)
+	}
+	if got := r.Calls("Put")[1].Input; got != "bad" {
+		t.Errorf("Calls(Put)[1] = %v, want bad", got)
+	}
+}
+
+func TestCognito(t *testing.T) {
+	c := NewCognito()
+	c.FailOn("AdminAddUserToGroup", 1, CognitoThrottle())
+	_, err := c.AdminUpdateUserAttributes(context.TODO(), &cognitoidentityprovider.AdminUpdateUserAttributesInput{
+		Username:       aws.String("12345"),
+		UserAttributes: []cognitotypes.AttributeType{{Name: aws.String("custom:stripe_customer_id"), Value: aws.String("cus_01234")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.AssertAttribute(t, "12345", "custom:stripe_customer_id", "cus_01234")
+	c.AssertNoAttribute(t, "12345", "custom:plan")
+	group := &cognitoidentityprovider.AdminAddUserToGroupInput{Username: aws.String("12345"), GroupName: aws.String("customers")}
+	var throttle *cognitotypes.TooManyRequestsException
+	if _, err := c.AdminAddUserToGroup(context.TODO(), group); !errors.As(err, &throttle) {
+		t.Errorf("AdminAddUserToGroup() error = %v, want the scripted throttle", err)
+	}
+	if c.InGroup("12345", "customers") {
+		t.Errorf("InGroup() = true after a failed call")
+	}
+	if _, err := c.AdminAddUserToGroup(context.TODO(), group); err != nil {
+		t.Fatal(err)
+	}
+	c.AssertInGroup(t, "12345", "customers")
+}
+
+func TestSQS_DeleteMessageBatch(t *testing.T) {
+	q := NewSQS()
+	q.FailDelete("2", "ReceiptHandleIsInvalid")
+	resp, err := q.DeleteMessageBatch(context.TODO(), &sqs.DeleteMessageBatchInput{Entries: []sqstypes.DeleteMessageBatchRequestEntry{
+		{Id: aws.String("1"), ReceiptHandle: aws.String("handle-1")},
+		{Id: aws.String("2"), ReceiptHandle: aws.String("handle-2")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Successful) != 1 || len(resp.Failed) != 1 || aws.ToString(resp.Failed[0].Code) != "ReceiptHandleIsInvalid" {
+		t.Errorf("DeleteMessageBatch() = %+v, want one success and one scripted failure", resp)
+	}
+	q.AssertDeleted(t, "1")
+	q.AssertNotDeleted(t, "2")
+}
+
+func TestSQS_Acknowledge(t *testing.T) {
+	q := NewSQS()
+	first := q.Send("first")
+	q.Send("second")
+	q.FailDelete(first, "ReceiptHandleIsInvalid")
+	messages := q.Receive(10)
+	q.Acknowledge(messages[0])
+	q.AssertDeleted(t, first)
+	if queued := q.Queued(); len(queued) != 1 || queued[0].ID == first {
+		t.Errorf("Queued() = %+v, want only the second message", queued)
+	}
+	q.AssertCalls(t, "DeleteMessageBatch", 0)
+}
+
+func TestSQS_SendMessage(t *testing.T) {
+	q := NewSQS()
+	for _, body := range []string{"first", "second"} {
+		if _, err := q.SendMessage(context.TODO(), &sqs.SendMessageInput{QueueUrl: aws.String("emails"), MessageBody: aws.String(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := q.SentTo("emails"); !reflect.DeepEqual(got, []string{"first", "second"}) {
+		t.Errorf("SentTo() = %v, want both bodies in order", got)
+	}
+	if queued := q.Queued(); len(queued) != 0 {
+		t.Errorf("Queued() = %+v, want sent messages kept off the queue", queued)
+	}
+	q.AssertCalls(t, "SendMessage", 2)
+}
+
+func TestSQS_ChangeMessageVisibilityBatch(t *testing.T) {
+	q := NewSQS()
+	q.Send("first")
+	q.Send("second")
+	received := q.Receive(2)
+	if _, err := q.DeleteMessageBatch(context.TODO(), &sqs.DeleteMessageBatchInput{Entries: []sqstypes.DeleteMessageBatchRequestEntry{
+		{Id: aws.String(received[1].ID), ReceiptHandle: aws.String(received[1].ReceiptHandle)},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := q.ChangeMessageVisibilityBatch(context.TODO(), &sqs.ChangeMessageVisibilityBatchInput{Entries: []sqstypes.ChangeMessageVisibilityBatchRequestEntry{
+		{Id: aws.String(received[0].ID), ReceiptHandle: aws.String(received[0].ReceiptHandle)},
+		{Id: aws.String(received[1].ID), ReceiptHandle: aws.String(received[1].ReceiptHandle)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Successful) != 1 || len(resp.Failed) != 1 || aws.ToString(resp.Failed[0].Id) != received[1].ID {
+		t.Errorf("ChangeMessageVisibilityBatch() = %+v, want the deleted message to fail", resp)
+	}
+	if got := q.Released(); !reflect.DeepEqual(got, []string{received[0].ID}) {
+		t.Errorf("Released() = %v, want %v", got, received[0].ID)
+	}
+}
+
+func TestStripe(t *testing.T) {
+	s := NewStripe()
+	s.Customers.FailWhen("New", func(input interface{}) bool {
+		return *input.(*stripe.CustomerParams).Email == "declined@example.com"
+	}, StripeRateLimit())
+	customer, err := s.Customers.New(&stripe.CustomerParams{Email: stripe.String("example@example.com"), Coupon: stripe.String("coupon_01234")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if customer.ID != "cus_fake_0001" || customer.Discount.Coupon.ID != "coupon_01234" {
+		t.Errorf("Customers.New() = %+v", customer)
+	}
+	var stripeErr *stripe.Error
+	if _, err := s.Customers.New(&stripe.CustomerParams{Email: stripe.String("declined@example.com")}); !errors.As(err, &stripeErr) || stripeErr.HTTPStatusCode != 429 {
+		t.Errorf("Customers.New() error = %v, want the scripted rate limit", err)
+	}
+	s.Customers.AssertCreated(t, 1)
+
+	subscription, err := s.Subscriptions.New(&stripe.SubscriptionParams{Customer: stripe.String(customer.ID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subscription.ID != "sub_fake_0001" || subscription.Status != stripe.SubscriptionStatusIncomplete {
+		t.Errorf("Subscriptions.New() = %+v", subscription)
+	}
+
+	s.PromotionCodes.Add(&stripe.PromotionCode{Code: "MAIDO", Active: true})
+	s.PromotionCodes.Add(&stripe.PromotionCode{Code: "OTHER", Active: true})
+	iter := s.PromotionCodes.List(&stripe.PromotionCodeListParams{Code: stripe.String("MAIDO"), Active: stripe.Bool(true)})
+	codes := []string{}
+	for iter.Next() {
+		codes = append(codes, iter.PromotionCode().Code)
+	}
+	if iter.Err() != nil || len(codes) != 1 || codes[0] != "MAIDO" {
+		t.Errorf("PromotionCodes.List() = %v, %v, want MAIDO", codes, iter.Err())
+	}
+	s.PromotionCodes.FailOn("List", 2, StripeRateLimit())
+	iter = s.PromotionCodes.List(&stripe.PromotionCodeListParams{Code: stripe.String("MAIDO")})
+	if iter.Next() || iter.Err() == nil {
+		t.Errorf("PromotionCodes.List() error = %v, want the scripted rate limit", iter.Err())
+	}
+}
+
+func TestSQS_Receive(t *testing.T) {
+	q := NewSQS()
+	q.MaxReceiveCount = 2
+	acknowledged := q.Send(`{"cognitoUserID": "1"}`)
+	poisoned := q.Send(`{"cognitoUserID": "2"}`)
+	for i := 0; i < 2; i++ {
+		messages := q.Receive(10)
+		if len(messages) != 2 || messages[0].ReceiveCount != i+1 {
+			t.Fatalf("Receive() = %+v, want both messages received %d times", messages, i+1)
+		}
+		if i == 1 {
+			_, err := q.DeleteMessageBatch(context.TODO(), &sqs.DeleteMessageBatchInput{Entries: []sqstypes.DeleteMessageBatchRequestEntry{
+				{Id: aws.String(messages[0].ID), ReceiptHandle: aws.String(messages[0].ReceiptHandle)},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if messages := q.Receive(10); len(messages) != 0 {
+		t.Errorf("Receive() = %+v, want the undeleted message dead-lettered", messages)
+	}
+	q.AssertDeleted(t, acknowledged)
+	if deadLetters := q.DeadLetters(); len(deadLetters) != 1 || deadLetters[0].ID != poisoned {
+		t.Errorf("DeadLetters() = %+v, want %s", deadLetters, poisoned)
+	}
+	if queued := q.Queued(); len(queued) != 0 {
+		t.Errorf("Queued() = %+v, want an empty queue", queued)
+	}
+}
+
+func TestRecorder_SetLatency(t *testing.T) {
+	r := &Recorder{}
+	r.SetLatency("", time.Millisecond)
+	r.SetLatency("Fast", 0)
+	start := time.Now()
+	if _, err := r.record(context.TODO(), "Fast", nil); err != nil {
+		t.Fatal(err)
+	}
+	fast := time.Since(start)
+	start = time.Now()
+	if _, err := r.record(context.TODO(), "Slow", nil); err != nil {
+		t.Fatal(err)
+	}
+	if slow := time.Since(start); slow < time.Millisecond || fast >= time.Millisecond {
+		t.Errorf("record() took %v for Fast and %v for Slow, want under and over 1ms", fast, slow)
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond)
+	defer cancel()
+	r.SetLatency("Slow", time.Minute)
+	if _, err := r.record(ctx, "Slow", nil); err != context.DeadlineExceeded {
+		t.Errorf("record() error = %v, want %v once the context is done", err, context.DeadlineExceeded)
+	}
+}
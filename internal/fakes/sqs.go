@@ -0,0 +1,271 @@
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// DefaultMaxReceiveCount is the number of receives after which an undeleted message is moved to the dead-letter
+// queue, matching the redrive policy of the onboarding queues.
+const DefaultMaxReceiveCount = 3
+
+// Message is a message on the fake queue.
+type Message struct {
+	ID            string
+	Body          string
+	ReceiptHandle string
+	ReceiveCount  int
+}
+
+// SQS is an in-memory SQS queue with a dead-letter queue. Messages added with Send are handed out by Receive until
+// they are deleted, or until they have been received MaxReceiveCount times, when they are moved to the
+// dead-letter queue as SQS's redrive policy does.
+type SQS struct {
+	Recorder
+	MaxReceiveCount int
+
+	mu          sync.Mutex
+	sent        int
+	receipts    int
+	messages    []*Message
+	deadLetters []Message
+	deleted     map[string]string
+	failDelete  map[string]string
+	released    map[string]int
+	sentTo      map[string][]string
+}
+
+// NewSQS returns an empty SQS with nothing deleted.
+func NewSQS() *SQS {
+	return &SQS{
+		MaxReceiveCount: DefaultMaxReceiveCount,
+		deleted:         map[string]string{},
+		failDelete:      map[string]string{},
+		released:        map[string]int{},
+		sentTo:          map[string][]string{},
+	}
+}
+
+// Send adds a message with body to the queue and returns its ID.
+func (s *SQS) Send(body string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent++
+	message := &Message{ID: fmt.Sprintf("msg-%04d", s.sent), Body: body}
+	s.messages = append(s.messages, message)
+	return message.ID
+}
+
+// Receive returns up to max messages from the queue, each with a new receipt handle. Messages already received
+// MaxReceiveCount times are moved to the dead-letter queue instead of being returned.
+func (s *SQS) Receive(max int) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	received := []Message{}
+	queued := []*Message{}
+	for _, message := range s.messages {
+		if message.ReceiveCount >= s.MaxReceiveCount {
+			s.deadLetters = append(s.deadLetters, *message)
+			continue
+		}
+		queued = append(queued, message)
+		if len(received) == max {
+			continue
+		}
+		s.receipts++
+		message.ReceiveCount++
+		message.ReceiptHandle = fmt.Sprintf("%s-receipt-%d", message.ID, s.receipts)
+		received = append(received, *message)
+	}
+	s.messages = queued
+	return received
+}
+
+// Queued returns the messages still on the queue.
+func (s *SQS) Queued() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	messages := []Message{}
+	for _, message := range s.messages {
+		messages = append(messages, *message)
+	}
+	return messages
+}
+
+// DeadLetters returns the messages moved to the dead-letter queue.
+func (s *SQS) DeadLetters() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message{}, s.deadLetters...)
+}
+
+// FailDelete makes deletes of the entry with id fail with code, as SQS reports in a batch's Failed list.
+func (s *SQS) FailDelete(id, code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failDelete[id] = code
+}
+
+// Deleted returns the IDs of the deleted entries in order.
+func (s *SQS) Deleted() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := []string{}
+	for id := range s.deleted {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// AssertDeleted fails t unless every entry in ids was deleted.
+func (s *SQS) AssertDeleted(t testing.TB, ids ...string) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		if _, ok := s.deleted[id]; !ok {
+			t.Errorf("message %s was not deleted", id)
+		}
+	}
+}
+
+// AssertNotDeleted fails t if any entry in ids was deleted.
+func (s *SQS) AssertNotDeleted(t testing.TB, ids ...string) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		if _, ok := s.deleted[id]; ok {
+			t.Errorf("message %s was deleted", id)
+		}
+	}
+}
+
+// DeleteMessageBatch deletes the input's entries, reporting those scripted with FailDelete as failed. An entry whose
+// receipt handle belongs to a queued message removes that message from the queue.
+func (s *SQS) DeleteMessageBatch(ctx context.Context, params *sqs.DeleteMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error) {
+	if _, err := s.record(ctx, "DeleteMessageBatch", params); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	output := &sqs.DeleteMessageBatchOutput{}
+	for _, entry := range params.Entries {
+		id := aws.ToString(entry.Id)
+		if code, ok := s.failDelete[id]; ok {
+			output.Failed = append(output.Failed, types.BatchResultErrorEntry{
+				Id:      entry.Id,
+				Code:    aws.String(code),
+				Message: aws.String("fake delete failure"),
+			})
+			continue
+		}
+		s.deleted[id] = aws.ToString(entry.ReceiptHandle)
+		s.remove(aws.ToString(entry.ReceiptHandle))
+		output.Successful = append(output.Successful, types.DeleteMessageBatchResultEntry{Id: entry.Id})
+	}
+	return output, nil
+}
+
+// Acknowledge deletes messages as the Lambda event source does after an invocation that returned without error.
+// The deletes are not recorded and ignore FailDelete, which script the handler's own calls.
+func (s *SQS) Acknowledge(messages ...Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, message := range messages {
+		s.deleted[message.ID] = message.ReceiptHandle
+		s.remove(message.ReceiptHandle)
+	}
+}
+
+// ChangeMessageVisibilityBatch records the entries made visible again with a VisibilityTimeout of 0. The fake
+// queue has no visibility timeout, so this does not change what Receive returns. Entries whose receipt handle
+// does not belong to a queued message fail with ReceiptHandleIsInvalid, as they do for a deleted message.
+func (s *SQS) ChangeMessageVisibilityBatch(
+	ctx context.Context,
+	params *sqs.ChangeMessageVisibilityBatchInput,
+	optFns ...func(*sqs.Options),
+) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	if _, err := s.record(ctx, "ChangeMessageVisibilityBatch", params); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	output := &sqs.ChangeMessageVisibilityBatchOutput{}
+	for _, entry := range params.Entries {
+		if !s.queued(aws.ToString(entry.ReceiptHandle)) {
+			output.Failed = append(output.Failed, types.BatchResultErrorEntry{
+				Id:          entry.Id,
+				Code:        aws.String("ReceiptHandleIsInvalid"),
+				Message:     aws.String("fake receipt handle is not queued"),
+				SenderFault: true,
+			})
+			continue
+		}
+		if entry.VisibilityTimeout == 0 {
+			s.released[aws.ToString(entry.Id)]++
+		}
+		output.Successful = append(output.Successful, types.ChangeMessageVisibilityBatchResultEntry{Id: entry.Id})
+	}
+	return output, nil
+}
+
+// Released returns the IDs of the entries made visible again, in order.
+func (s *SQS) Released() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := []string{}
+	for id := range s.released {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// SendMessage records the body of a message sent to another queue, such as the welcome email queue. Messages are
+// not added to this queue, which only Send fills.
+func (s *SQS) SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	if _, err := s.record(ctx, "SendMessage", params); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queueURL := aws.ToString(params.QueueUrl)
+	s.sentTo[queueURL] = append(s.sentTo[queueURL], aws.ToString(params.MessageBody))
+	return &sqs.SendMessageOutput{MessageId: aws.String(fmt.Sprintf("sent-%04d", len(s.sentTo[queueURL])))}, nil
+}
+
+// SentTo returns the bodies of the messages sent to queueURL in order.
+func (s *SQS) SentTo(queueURL string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.sentTo[queueURL]...)
+}
+
+// queued reports whether receiptHandle is the latest receipt handle of a queued message.
+func (s *SQS) queued(receiptHandle string) bool {
+	for _, message := range s.messages {
+		if message.ReceiptHandle != "" && message.ReceiptHandle == receiptHandle {
+			return true
+		}
+	}
+	return false
+}
+
+// remove takes the message last received with receiptHandle off the queue.
+func (s *SQS) remove(receiptHandle string) {
+	for i, message := range s.messages {
+		if message.ReceiptHandle != "" && message.ReceiptHandle == receiptHandle {
+			s.messages = append(s.messages[:i], s.messages[i+1:]...)
+			return
+		}
+	}
+}
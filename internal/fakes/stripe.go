@@ -0,0 +1,155 @@
+package fakes
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/form"
+	"github.com/stripe/stripe-go/v72/promotioncode"
+)
+
+// StripeRateLimit returns the error Stripe returns when too many requests are made.
+func StripeRateLimit() error {
+	return &stripe.Error{
+		Type:           stripe.ErrorTypeInvalidRequest,
+		Code:           stripe.ErrorCodeRateLimit,
+		HTTPStatusCode: http.StatusTooManyRequests,
+		Msg:            "fake rate limit",
+	}
+}
+
+// Stripe groups the fake Stripe resources used by the onboarding lambdas, mirroring the fields of client.API.
+type Stripe struct {
+	Customers      *StripeCustomers
+	Subscriptions  *StripeSubscriptions
+	PromotionCodes *StripePromotionCodes
+}
+
+// NewStripe returns a Stripe with no customers, subscriptions or promotion codes.
+func NewStripe() *Stripe {
+	return &Stripe{
+		Customers:      &StripeCustomers{},
+		Subscriptions:  &StripeSubscriptions{Status: stripe.SubscriptionStatusIncomplete},
+		PromotionCodes: &StripePromotionCodes{},
+	}
+}
+
+// StripeCustomers creates customers with sequential IDs.
+type StripeCustomers struct {
+	Recorder
+
+	mu        sync.Mutex
+	customers []*stripe.Customer
+}
+
+// New creates a customer from params.
+func (c *StripeCustomers) New(params *stripe.CustomerParams) (*stripe.Customer, error) {
+	if _, err := c.record(params.Context, "New", params); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	customer := &stripe.Customer{ID: fmt.Sprintf("cus_fake_%04d", len(c.customers)+1)}
+	if params.Email != nil {
+		customer.Email = *params.Email
+	}
+	if params.Name != nil {
+		customer.Name = *params.Name
+	}
+	if params.Coupon != nil {
+		customer.Discount = &stripe.Discount{Coupon: &stripe.Coupon{ID: *params.Coupon}}
+	}
+	if params.PromotionCode != nil {
+		customer.Discount = &stripe.Discount{PromotionCode: &stripe.PromotionCode{ID: *params.PromotionCode}}
+	}
+	c.customers = append(c.customers, customer)
+	return customer, nil
+}
+
+// Created returns the customers created so far.
+func (c *StripeCustomers) Created() []*stripe.Customer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*stripe.Customer{}, c.customers...)
+}
+
+// AssertCreated fails t unless want customers were created.
+func (c *StripeCustomers) AssertCreated(t testing.TB, want int) {
+	t.Helper()
+	if got := len(c.Created()); got != want {
+		t.Errorf("%d Stripe customers created, want %d", got, want)
+	}
+}
+
+// StripeSubscriptions creates subscriptions with sequential IDs in Status.
+type StripeSubscriptions struct {
+	Recorder
+	Status stripe.SubscriptionStatus
+
+	mu            sync.Mutex
+	subscriptions []*stripe.Subscription
+}
+
+// New creates a subscription from params.
+func (s *StripeSubscriptions) New(params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	if _, err := s.record(params.Context, "New", params); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscription := &stripe.Subscription{ID: fmt.Sprintf("sub_fake_%04d", len(s.subscriptions)+1), Status: s.Status}
+	if params.Customer != nil {
+		subscription.Customer = &stripe.Customer{ID: *params.Customer}
+	}
+	s.subscriptions = append(s.subscriptions, subscription)
+	return subscription, nil
+}
+
+// Created returns the subscriptions created so far.
+func (s *StripeSubscriptions) Created() []*stripe.Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*stripe.Subscription{}, s.subscriptions...)
+}
+
+// StripePromotionCodes lists the promotion codes added with Add, filtered by code.
+type StripePromotionCodes struct {
+	Recorder
+
+	mu    sync.Mutex
+	codes []*stripe.PromotionCode
+}
+
+// Add makes promotionCode available to List.
+func (p *StripePromotionCodes) Add(promotionCode *stripe.PromotionCode) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.codes = append(p.codes, promotionCode)
+}
+
+// List returns the promotion codes matching params.Code and params.Active. A scripted failure is returned by the
+// iterator, as Stripe's own iterator does.
+func (p *StripePromotionCodes) List(params *stripe.PromotionCodeListParams) *promotioncode.Iter {
+	_, err := p.record(params.Context, "List", params)
+	p.mu.Lock()
+	matches := []interface{}{}
+	for _, code := range p.codes {
+		if params.Code != nil && code.Code != *params.Code {
+			continue
+		}
+		if params.Active != nil && code.Active != *params.Active {
+			continue
+		}
+		matches = append(matches, code)
+	}
+	p.mu.Unlock()
+	return &promotioncode.Iter{Iter: stripe.GetIter(params, func(*stripe.Params, *form.Values) ([]interface{}, stripe.ListContainer, error) {
+		if err != nil {
+			return nil, &stripe.PromotionCodeList{}, err
+		}
+		return matches, &stripe.PromotionCodeList{}, nil
+	})}
+}
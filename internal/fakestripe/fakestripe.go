@@ -0,0 +1,366 @@
+// Package fakestripe is an in-memory stand-in for the parts of the Stripe HTTP API the lambdas use: customers,
+// subscriptions, setup intents and promotion codes. It speaks Stripe's form-encoded requests and JSON responses,
+// so the real stripe-go client can be pointed at it with STRIPE_API_BASE_URL:
+//
+//	server := httptest.NewServer(fakestripe.New())
+//	backends, _ := stripeclient.Backends(server.URL)
+//	sc := client.New("sk_test_fake", backends)
+package fakestripe
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server serves the fake Stripe API. It is safe for concurrent use.
+type Server struct {
+	now func() time.Time
+
+	mu             sync.Mutex
+	ids            map[string]int
+	customers      map[string]map[string]interface{}
+	subscriptions  map[string]map[string]interface{}
+	setupIntents   map[string]map[string]interface{}
+	promotionCodes []map[string]interface{}
+	coupons        map[string]bool
+	idempotent     map[string]response
+}
+
+type response struct {
+	status int
+	body   []byte
+}
+
+// New returns a Server with no objects.
+func New() *Server {
+	return &Server{
+		now:           time.Now,
+		ids:           map[string]int{},
+		customers:     map[string]map[string]interface{}{},
+		subscriptions: map[string]map[string]interface{}{},
+		setupIntents:  map[string]map[string]interface{}{},
+		coupons:       map[string]bool{},
+		idempotent:    map[string]response{},
+	}
+}
+
+// AddPromotionCode makes an active promotion code for couponID available under code.
+func (s *Server) AddPromotionCode(code, couponID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.coupons[couponID] = true
+	s.promotionCodes = append(s.promotionCodes, map[string]interface{}{
+		"id":              s.newID("promo"),
+		"object":          "promotion_code",
+		"active":          true,
+		"code":            code,
+		"coupon":          map[string]interface{}{"id": couponID, "object": "coupon", "valid": true},
+		"max_redemptions": 0,
+		"times_redeemed":  0,
+		"expires_at":      0,
+	})
+}
+
+// promotionCode returns the promotion code with id, or nil. s.mu must be held.
+func (s *Server) promotionCode(id string) map[string]interface{} {
+	for _, promotionCode := range s.promotionCodes {
+		if promotionCode["id"] == id {
+			return promotionCode
+		}
+	}
+	return nil
+}
+
+// Customers returns the customers created so far, ordered by ID.
+func (s *Server) Customers() []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sorted(s.customers)
+}
+
+// Subscriptions returns the subscriptions created so far, ordered by ID.
+func (s *Server) Subscriptions() []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sorted(s.subscriptions)
+}
+
+// SetupIntents returns the setup intents created so far, ordered by ID.
+func (s *Server) SetupIntents() []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sorted(s.setupIntents)
+}
+
+func sorted(objects map[string]map[string]interface{}) []map[string]interface{} {
+	ids := make([]string, 0, len(objects))
+	for id := range objects {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	result := make([]map[string]interface{}, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, objects[id])
+	}
+	return result
+}
+
+// newID returns the next ID with prefix. s.mu must be held.
+func (s *Server) newID(prefix string) string {
+	s.ids[prefix]++
+	return fmt.Sprintf("%s_fake_%04d", prefix, s.ids[prefix])
+}
+
+// apiError is the error body Stripe returns.
+type apiError struct {
+	status  int
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+func invalidRequest(status int, code, param, format string, args ...interface{}) *apiError {
+	return &apiError{status: status, Type: "invalid_request_error", Code: code, Param: param, Message: fmt.Sprintf(format, args...)}
+}
+
+func missing(param, id string) *apiError {
+	return invalidRequest(http.StatusNotFound, "resource_missing", param, "No such %s: '%s'", param, id)
+}
+
+// ServeHTTP routes POST and GET requests under /v1 to the fake resources.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") || strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ") == "" {
+		writeJSON(w, response{status: http.StatusUnauthorized, body: errorBody(&apiError{
+			status:  http.StatusUnauthorized,
+			Type:    "invalid_request_error",
+			Message: "You did not provide an API key.",
+		})})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, response{status: http.StatusBadRequest, body: errorBody(invalidRequest(http.StatusBadRequest, "", "", "Invalid request body: %v", err))})
+		return
+	}
+	key := r.Header.Get("Idempotency-Key")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Method == http.MethodPost && key != "" {
+		if replay, ok := s.idempotent[key]; ok {
+			writeJSON(w, replay)
+			return
+		}
+	}
+	object, err := s.route(r.Method, strings.Split(strings.Trim(r.URL.Path, "/"), "/"), r.Form)
+	res := response{status: http.StatusOK}
+	if err != nil {
+		res.status = err.status
+		res.body = errorBody(err)
+	} else {
+		res.body, _ = json.Marshal(object)
+	}
+	if r.Method == http.MethodPost && key != "" {
+		s.idempotent[key] = res
+	}
+	writeJSON(w, res)
+}
+
+func errorBody(err *apiError) []byte {
+	body, _ := json.Marshal(map[string]*apiError{"error": err})
+	return body
+}
+
+func writeJSON(w http.ResponseWriter, res response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(res.status)
+	_, _ = w.Write(res.body)
+}
+
+// route dispatches a request for path, split on "/". s.mu must be held.
+func (s *Server) route(method string, path []string, form url.Values) (interface{}, *apiError) {
+	if len(path) < 2 || path[0] != "v1" {
+		return nil, invalidRequest(http.StatusNotFound, "", "", "Unrecognized request URL (%s: /%s).", method, strings.Join(path, "/"))
+	}
+	resource, id := path[1], ""
+	if len(path) == 3 {
+		id = path[2]
+	}
+	switch {
+	case resource == "customers" && method == http.MethodPost && id == "":
+		return s.createCustomer(form)
+	case resource == "customers" && method == http.MethodGet && id != "":
+		return retrieve(s.customers, "customer", id)
+	case resource == "subscriptions" && method == http.MethodPost && id == "":
+		return s.createSubscription(form)
+	case resource == "subscriptions" && method == http.MethodGet && id != "":
+		return retrieve(s.subscriptions, "subscription", id)
+	case resource == "setup_intents" && method == http.MethodPost && id == "":
+		return s.createSetupIntent(form)
+	case resource == "setup_intents" && method == http.MethodGet && id != "":
+		return retrieve(s.setupIntents, "setup_intent", id)
+	case resource == "promotion_codes" && method == http.MethodGet && id == "":
+		return s.listPromotionCodes(form), nil
+	}
+	return nil, invalidRequest(http.StatusNotFound, "", "", "Unrecognized request URL (%s: /%s).", method, strings.Join(path, "/"))
+}
+
+func retrieve(objects map[string]map[string]interface{}, param, id string) (interface{}, *apiError) {
+	object, ok := objects[id]
+	if !ok {
+		return nil, missing(param, id)
+	}
+	return object, nil
+}
+
+// metadata collects the metadata[key] form values.
+func metadata(form url.Values) map[string]string {
+	values := map[string]string{}
+	for name := range form {
+		if strings.HasPrefix(name, "metadata[") && strings.HasSuffix(name, "]") {
+			values[strings.TrimSuffix(strings.TrimPrefix(name, "metadata["), "]")] = form.Get(name)
+		}
+	}
+	return values
+}
+
+func (s *Server) createCustomer(form url.Values) (interface{}, *apiError) {
+	customer := map[string]interface{}{
+		"id":       s.newID("cus"),
+		"object":   "customer",
+		"created":  s.now().Unix(),
+		"email":    form.Get("email"),
+		"name":     form.Get("name"),
+		"metadata": metadata(form),
+		"livemode": false,
+	}
+	if coupon := form.Get("coupon"); coupon != "" {
+		if !s.coupons[coupon] {
+			return nil, missing("coupon", coupon)
+		}
+		customer["discount"] = map[string]interface{}{
+			"object": "discount",
+			"coupon": map[string]interface{}{"id": coupon, "object": "coupon", "valid": true},
+		}
+	}
+	if id := form.Get("promotion_code"); id != "" {
+		promotionCode := s.promotionCode(id)
+		if promotionCode == nil {
+			return nil, missing("promotion_code", id)
+		}
+		customer["discount"] = map[string]interface{}{
+			"object":         "discount",
+			"coupon":         promotionCode["coupon"],
+			"promotion_code": id,
+		}
+		promotionCode["times_redeemed"] = promotionCode["times_redeemed"].(int) + 1
+	}
+	s.customers[customer["id"].(string)] = customer
+	return customer, nil
+}
+
+// createSubscription creates a subscription that is trialing when a trial is requested, incomplete when payment
+// is deferred with default_incomplete, and active otherwise.
+func (s *Server) createSubscription(form url.Values) (interface{}, *apiError) {
+	customerID := form.Get("customer")
+	if customerID == "" {
+		return nil, invalidRequest(http.StatusBadRequest, "parameter_missing", "customer", "Missing required param: customer.")
+	}
+	if _, ok := s.customers[customerID]; !ok {
+		return nil, missing("customer", customerID)
+	}
+	items := []interface{}{}
+	for i := 0; form.Get(fmt.Sprintf("items[%d][price]", i)) != ""; i++ {
+		items = append(items, map[string]interface{}{
+			"id":       s.newID("si"),
+			"object":   "subscription_item",
+			"price":    map[string]interface{}{"id": form.Get(fmt.Sprintf("items[%d][price]", i)), "object": "price"},
+			"quantity": 1,
+		})
+	}
+	if len(items) == 0 {
+		return nil, invalidRequest(http.StatusBadRequest, "parameter_missing", "items", "Missing required param: items.")
+	}
+	now := s.now()
+	subscription := map[string]interface{}{
+		"id":       s.newID("sub"),
+		"object":   "subscription",
+		"created":  now.Unix(),
+		"customer": customerID,
+		"items":    map[string]interface{}{"object": "list", "data": items, "has_more": false},
+		"metadata": metadata(form),
+		"status":   "active",
+	}
+	if days := form.Get("trial_period_days"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return nil, invalidRequest(http.StatusBadRequest, "parameter_invalid_integer", "trial_period_days", "Invalid integer: %s", days)
+		}
+		if n > 0 {
+			subscription["status"] = "trialing"
+			subscription["trial_start"] = now.Unix()
+			subscription["trial_end"] = now.AddDate(0, 0, n).Unix()
+		}
+	}
+	if subscription["status"] == "active" && form.Get("payment_behavior") == "default_incomplete" {
+		subscription["status"] = "incomplete"
+	}
+	s.subscriptions[subscription["id"].(string)] = subscription
+	return subscription, nil
+}
+
+func (s *Server) createSetupIntent(form url.Values) (interface{}, *apiError) {
+	customerID := form.Get("customer")
+	if customerID != "" {
+		if _, ok := s.customers[customerID]; !ok {
+			return nil, missing("customer", customerID)
+		}
+	}
+	usage := form.Get("usage")
+	if usage == "" {
+		usage = "off_session"
+	}
+	paymentMethodTypes := []string{}
+	for i := 0; form.Get(fmt.Sprintf("payment_method_types[%d]", i)) != ""; i++ {
+		paymentMethodTypes = append(paymentMethodTypes, form.Get(fmt.Sprintf("payment_method_types[%d]", i)))
+	}
+	if len(paymentMethodTypes) == 0 {
+		paymentMethodTypes = []string{"card"}
+	}
+	id := s.newID("seti")
+	setupIntent := map[string]interface{}{
+		"id":                   id,
+		"object":               "setup_intent",
+		"created":              s.now().Unix(),
+		"client_secret":        id + "_secret_fake",
+		"metadata":             metadata(form),
+		"payment_method_types": paymentMethodTypes,
+		"status":               "requires_payment_method",
+		"usage":                usage,
+	}
+	if customerID != "" {
+		setupIntent["customer"] = customerID
+	}
+	s.setupIntents[id] = setupIntent
+	return setupIntent, nil
+}
+
+func (s *Server) listPromotionCodes(form url.Values) interface{} {
+	data := []interface{}{}
+	for _, promotionCode := range s.promotionCodes {
+		if code := form.Get("code"); code != "" && promotionCode["code"] != code {
+			continue
+		}
+		if active := form.Get("active"); active != "" && strconv.FormatBool(promotionCode["active"].(bool)) != active {
+			continue
+		}
+		data = append(data, promotionCode)
+	}
+	return map[string]interface{}{"object": "list", "url": "/v1/promotion_codes", "has_more": false, "data": data}
+}
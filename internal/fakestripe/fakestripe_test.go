@@ -0,0 +1,212 @@
+package fakestripe
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/seanturner026/maido-lambdas/internal/stripeclient"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/client"
+)
+
+func newClient(t *testing.T, server *Server) *client.API {
+	t.Helper()
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	backends, err := stripeclient.Backends(httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.New("sk_test_fake", backends)
+}
+
+func TestServer_customersAndSubscriptions(t *testing.T) {
+	server := New()
+	server.AddPromotionCode("MAIDO", "coupon_maido")
+	sc := newClient(t, server)
+
+	iter := sc.PromotionCodes.List(&stripe.PromotionCodeListParams{Code: stripe.String("MAIDO"), Active: stripe.Bool(true)})
+	if !iter.Next() {
+		t.Fatalf("PromotionCodes.List() found nothing, error = %v", iter.Err())
+	}
+	promotionCode := iter.PromotionCode()
+	if promotionCode.Coupon.ID != "coupon_maido" || !promotionCode.Coupon.Valid {
+		t.Errorf("PromotionCodes.List() = %+v", promotionCode)
+	}
+
+	customer, err := sc.Customers.New(&stripe.CustomerParams{
+		Email:         stripe.String("example@example.com"),
+		Name:          stripe.String("first_example sur_example"),
+		PromotionCode: stripe.String(promotionCode.ID),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if customer.ID != "cus_fake_0001" || customer.Email != "example@example.com" || customer.Discount.Coupon.ID != "coupon_maido" ||
+		customer.Discount.PromotionCode.ID != promotionCode.ID {
+		t.Errorf("Customers.New() = %+v", customer)
+	}
+	retrieved, err := sc.Customers.Get(customer.ID, nil)
+	if err != nil || retrieved.Name != "first_example sur_example" {
+		t.Errorf("Customers.Get() = %+v, %v", retrieved, err)
+	}
+
+	subscription, err := sc.Subscriptions.New(&stripe.SubscriptionParams{
+		Customer:        stripe.String(customer.ID),
+		Items:           []*stripe.SubscriptionItemsParams{{Price: stripe.String("price_01234")}},
+		PaymentBehavior: stripe.String("default_incomplete"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subscription.Status != stripe.SubscriptionStatusIncomplete || subscription.Customer.ID != customer.ID || subscription.Items.Data[0].Price.ID != "price_01234" {
+		t.Errorf("Subscriptions.New() = %+v", subscription)
+	}
+	subscription, err = sc.Subscriptions.New(&stripe.SubscriptionParams{
+		Customer:        stripe.String(customer.ID),
+		Items:           []*stripe.SubscriptionItemsParams{{Price: stripe.String("price_01234")}},
+		TrialPeriodDays: stripe.Int64(14),
+	})
+	if err != nil || subscription.Status != stripe.SubscriptionStatusTrialing || subscription.TrialEnd == 0 {
+		t.Errorf("Subscriptions.New() with a trial = %+v, %v", subscription, err)
+	}
+	if got := len(server.Subscriptions()); got != 2 {
+		t.Errorf("Subscriptions() = %v subscriptions, want 2", got)
+	}
+}
+
+func TestServer_setupIntents(t *testing.T) {
+	server := New()
+	sc := newClient(t, server)
+	customer, err := sc.Customers.New(&stripe.CustomerParams{Email: stripe.String("example@example.com")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupIntent, err := sc.SetupIntents.New(&stripe.SetupIntentParams{
+		Customer:           stripe.String(customer.ID),
+		PaymentMethodTypes: stripe.StringSlice([]string{"card", "sepa_debit"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if setupIntent.Customer.ID != customer.ID || setupIntent.Status != stripe.SetupIntentStatusRequiresPaymentMethod || setupIntent.ClientSecret == "" {
+		t.Errorf("SetupIntents.New() = %+v", setupIntent)
+	}
+	if len(setupIntent.PaymentMethodTypes) != 2 || setupIntent.PaymentMethodTypes[1] != "sepa_debit" {
+		t.Errorf("SetupIntents.New() payment method types = %v", setupIntent.PaymentMethodTypes)
+	}
+	retrieved, err := sc.SetupIntents.Get(setupIntent.ID, nil)
+	if err != nil || retrieved.ID != setupIntent.ID {
+		t.Errorf("SetupIntents.Get() = %+v, %v", retrieved, err)
+	}
+}
+
+func TestServer_errors(t *testing.T) {
+	server := New()
+	sc := newClient(t, server)
+	tests := []struct {
+		name       string
+		call       func() error
+		wantStatus int
+		wantCode   stripe.ErrorCode
+	}{
+		{
+			name: "unknown_coupon",
+			call: func() error {
+				_, err := sc.Customers.New(&stripe.CustomerParams{Coupon: stripe.String("coupon_missing")})
+				return err
+			},
+			wantStatus: http.StatusNotFound,
+			wantCode:   stripe.ErrorCodeResourceMissing,
+		},
+		{
+			name: "unknown_promotion_code",
+			call: func() error {
+				_, err := sc.Customers.New(&stripe.CustomerParams{PromotionCode: stripe.String("promo_missing")})
+				return err
+			},
+			wantStatus: http.StatusNotFound,
+			wantCode:   stripe.ErrorCodeResourceMissing,
+		},
+		{
+			name: "unknown_customer",
+			call: func() error {
+				_, err := sc.Subscriptions.New(&stripe.SubscriptionParams{
+					Customer: stripe.String("cus_missing"),
+					Items:    []*stripe.SubscriptionItemsParams{{Price: stripe.String("price_01234")}},
+				})
+				return err
+			},
+			wantStatus: http.StatusNotFound,
+			wantCode:   stripe.ErrorCodeResourceMissing,
+		},
+		{
+			name: "missing_items",
+			call: func() error {
+				customer, err := sc.Customers.New(&stripe.CustomerParams{})
+				if err != nil {
+					return err
+				}
+				_, err = sc.Subscriptions.New(&stripe.SubscriptionParams{Customer: stripe.String(customer.ID)})
+				return err
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   stripe.ErrorCodeParameterMissing,
+		},
+		{
+			name: "unsupported_endpoint",
+			call: func() error {
+				_, err := sc.Charges.New(&stripe.ChargeParams{})
+				return err
+			},
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stripeErr *stripe.Error
+			if err := tt.call(); !errors.As(err, &stripeErr) {
+				t.Fatalf("error = %v, want a *stripe.Error", err)
+			}
+			if stripeErr.HTTPStatusCode != tt.wantStatus || stripeErr.Code != tt.wantCode {
+				t.Errorf("error = %d %s, want %d %s", stripeErr.HTTPStatusCode, stripeErr.Code, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+	if got := len(server.Customers()); got != 1 {
+		t.Errorf("Customers() = %v customers, want only the one created before missing_items", got)
+	}
+}
+
+func TestServer_idempotency(t *testing.T) {
+	server := New()
+	sc := newClient(t, server)
+	params := &stripe.CustomerParams{Email: stripe.String("example@example.com")}
+	params.SetIdempotencyKey("onboarding-12345")
+	first, err := sc.Customers.New(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := sc.Customers.New(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != second.ID || len(server.Customers()) != 1 {
+		t.Errorf("Customers.New() replayed as %s after %s, with %d customers", second.ID, first.ID, len(server.Customers()))
+	}
+}
+
+func TestServer_unauthenticated(t *testing.T) {
+	httpServer := httptest.NewServer(New())
+	defer httpServer.Close()
+	resp, err := http.Post(httpServer.URL+"/v1/customers", "application/x-www-form-urlencoded", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("POST /v1/customers without a key = %v, want 401", resp.StatusCode)
+	}
+}
@@ -0,0 +1,167 @@
+// Package golden compares values against JSON fixtures checked in under testdata, so that changes to generated
+// requests show up as readable diffs in review. Regenerate the fixtures of a package after an intended change with:
+//
+//	go test ./internal/onboarding -update
+package golden
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with the values the tests produce")
+
+// Path returns the golden file of the running test: testdata/<test name>.golden.json, with one directory per
+// subtest.
+func Path(t testing.TB) string {
+	return filepath.Join("testdata", filepath.FromSlash(t.Name())+".golden.json")
+}
+
+// Assert fails t unless got, marshalled with Marshal, matches the test's golden file. With -update the file is
+// written instead.
+func Assert(t testing.TB, got interface{}) {
+	t.Helper()
+	AssertFile(t, Path(t), got)
+}
+
+// AssertFile is Assert with the golden file at path.
+func AssertFile(t testing.TB, path string, got interface{}) {
+	t.Helper()
+	gotJSON, err := Marshal(got)
+	if err != nil {
+		t.Fatalf("unable to marshal golden value, %v", err)
+	}
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, gotJSON, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	wantJSON, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read golden file, run with -update to create it: %v", err)
+	}
+	if !bytes.Equal(gotJSON, wantJSON) {
+		t.Errorf("%s differs, run with -update if the change is intended:\n%s", path, diff(string(wantJSON), string(gotJSON)))
+	}
+}
+
+// Marshal returns v as indented JSON. DynamoDB attribute values are written in DynamoDB's own JSON form, such as
+// {"S": "USER#12345"}, and fields left at their zero value are omitted.
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(simplify(reflect.ValueOf(v))); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// simplify converts v into maps, slices and scalars that encoding/json writes readably.
+func simplify(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		if av, ok := v.Interface().(types.AttributeValue); ok {
+			return attributeValue(av)
+		}
+		return simplify(v.Elem())
+	case reflect.Struct:
+		fields := map[string]interface{}{}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" || v.Field(i).IsZero() {
+				continue
+			}
+			fields[field.Name] = simplify(v.Field(i))
+		}
+		return fields
+	case reflect.Map:
+		entries := map[string]interface{}{}
+		iter := v.MapRange()
+		for iter.Next() {
+			entries[fmt.Sprint(iter.Key().Interface())] = simplify(iter.Value())
+		}
+		return entries
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+		elements := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			elements = append(elements, simplify(v.Index(i)))
+		}
+		return elements
+	}
+	return v.Interface()
+}
+
+func attributeValue(av types.AttributeValue) interface{} {
+	switch av := av.(type) {
+	case *types.AttributeValueMemberS:
+		return map[string]interface{}{"S": av.Value}
+	case *types.AttributeValueMemberN:
+		return map[string]interface{}{"N": av.Value}
+	case *types.AttributeValueMemberB:
+		return map[string]interface{}{"B": av.Value}
+	case *types.AttributeValueMemberBOOL:
+		return map[string]interface{}{"BOOL": av.Value}
+	case *types.AttributeValueMemberNULL:
+		return map[string]interface{}{"NULL": av.Value}
+	case *types.AttributeValueMemberSS:
+		return map[string]interface{}{"SS": av.Value}
+	case *types.AttributeValueMemberNS:
+		return map[string]interface{}{"NS": av.Value}
+	case *types.AttributeValueMemberBS:
+		return map[string]interface{}{"BS": av.Value}
+	case *types.AttributeValueMemberL:
+		return map[string]interface{}{"L": simplify(reflect.ValueOf(av.Value))}
+	case *types.AttributeValueMemberM:
+		return map[string]interface{}{"M": simplify(reflect.ValueOf(av.Value))}
+	}
+	return fmt.Sprintf("%T", av)
+}
+
+// diff returns the lines around the first difference between want and got.
+func diff(want, got string) string {
+	wantLines := strings.Split(want, "\n")
+	gotLines := strings.Split(got, "\n")
+	line := 0
+	for line < len(wantLines) && line < len(gotLines) && wantLines[line] == gotLines[line] {
+		line++
+	}
+	var b strings.Builder
+	start := line - 3
+	if start < 0 {
+		start = 0
+	}
+	for i := start; i < line; i++ {
+		fmt.Fprintf(&b, "  %s\n", wantLines[i])
+	}
+	for i := line; i < line+3 && i < len(wantLines); i++ {
+		fmt.Fprintf(&b, "- %s\n", wantLines[i])
+	}
+	for i := line; i < line+3 && i < len(gotLines); i++ {
+		fmt.Fprintf(&b, "+ %s\n", gotLines[i])
+	}
+	return fmt.Sprintf("first difference at line %d:\n%s", line+1, b.String())
+}
@@ -0,0 +1,121 @@
+package golden
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{
+			name: "attribute_values",
+			value: map[string]types.AttributeValue{
+				"PK":      &types.AttributeValueMemberS{Value: "USER#12345"},
+				"Version": &types.AttributeValueMemberN{Value: "1"},
+				"Tags":    &types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberBOOL{Value: true}}},
+			},
+			want: `{
+  "PK": {
+    "S": "USER#12345"
+  },
+  "Tags": {
+    "L": [
+      {
+        "BOOL": true
+      }
+    ]
+  },
+  "Version": {
+    "N": "1"
+  }
+}
+`,
+		},
+		{
+			name: "zero_fields_omitted",
+			value: &dynamodb.UpdateItemInput{
+				TableName:        aws.String("example"),
+				UpdateExpression: aws.String("SET #Status = :status"),
+			},
+			want: `{
+  "TableName": "example",
+  "UpdateExpression": "SET #Status = :status"
+}
+`,
+		},
+		{
+			name:  "nil",
+			value: nil,
+			want:  "null\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Marshal(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Marshal() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// failureRecorder records failures instead of failing the test it wraps.
+type failureRecorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *failureRecorder) Errorf(format string, args ...interface{}) { r.failed = true }
+
+func (r *failureRecorder) Fatalf(format string, args ...interface{}) { r.failed = true }
+
+func TestAssertFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "example.golden.json")
+	value := map[string]string{"QueueUrl": "example_queue_url"}
+
+	*update = true
+	AssertFile(t, path, value)
+	*update = false
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("AssertFile() with -update did not write %s, %v", path, err)
+	}
+	if !strings.Contains(string(written), `"QueueUrl": "example_queue_url"`) {
+		t.Errorf("AssertFile() wrote %s", written)
+	}
+
+	AssertFile(t, path, value)
+	recorder := &failureRecorder{TB: t}
+	AssertFile(recorder, path, map[string]string{"QueueUrl": "other_queue_url"})
+	if !recorder.failed {
+		t.Error("AssertFile() passed for a value that differs from the golden file")
+	}
+}
+
+func TestPath(t *testing.T) {
+	t.Run("subtest", func(t *testing.T) {
+		if got, want := Path(t), filepath.Join("testdata", "TestPath", "subtest.golden.json"); got != want {
+			t.Errorf("Path() = %v, want %v", got, want)
+		}
+	})
+}
+
+func Test_diff(t *testing.T) {
+	got := diff("a\nb\nc\n", "a\nx\nc\n")
+	if !strings.Contains(got, "line 2") || !strings.Contains(got, "- b") || !strings.Contains(got, "+ x") {
+		t.Errorf("diff() = %s", got)
+	}
+}
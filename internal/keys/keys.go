@@ -0,0 +1,150 @@
+// Package keys describes how the onboarding lambdas key their items in the single DynamoDB table. Every item has
+// a PK and SK built from a type prefix and an ID, and user items also carry GSI1PK so that the user can be found
+// by their Stripe customer ID through the GSI1 index.
+package keys
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Attribute and index names shared by every schema.
+const (
+	PartitionKey = "PK"
+	SortKey      = "SK"
+	GSI1PK       = "GSI1PK"
+	GSI1Index    = "GSI1"
+)
+
+// Schema holds the prefixes and fixed sort keys used to build item keys.
+type Schema struct {
+	UserPrefix           string
+	UserSortKey          string
+	ReferralPrefix       string
+	ReferralOwnerSortKey string
+	StripePrefix         string
+	RunPrefix            string
+	RunSortKey           string
+	RunMessagePrefix     string
+}
+
+// Default returns the schema the onboarding table was created with.
+func Default() Schema {
+	return Schema{
+		UserPrefix:           "USER#",
+		UserSortKey:          "USER#MAIDO",
+		ReferralPrefix:       "REFERRAL#",
+		ReferralOwnerSortKey: "OWNER",
+		StripePrefix:         "STRIPE#",
+		RunPrefix:            "RUN#",
+		RunSortKey:           "RUN#MAIDO",
+		RunMessagePrefix:     "MESSAGE#",
+	}
+}
+
+// FromEnv returns the default schema with any of DYNAMODB_USER_KEY_PREFIX, DYNAMODB_USER_SORT_KEY,
+// DYNAMODB_REFERRAL_KEY_PREFIX, DYNAMODB_REFERRAL_OWNER_SORT_KEY, DYNAMODB_STRIPE_KEY_PREFIX,
+// DYNAMODB_RUN_KEY_PREFIX, DYNAMODB_RUN_SORT_KEY and DYNAMODB_RUN_MESSAGE_KEY_PREFIX that are set applied over it.
+func FromEnv() (Schema, error) {
+	schema := Default()
+	for name, value := range map[string]*string{
+		"DYNAMODB_USER_KEY_PREFIX":         &schema.UserPrefix,
+		"DYNAMODB_USER_SORT_KEY":           &schema.UserSortKey,
+		"DYNAMODB_REFERRAL_KEY_PREFIX":     &schema.ReferralPrefix,
+		"DYNAMODB_REFERRAL_OWNER_SORT_KEY": &schema.ReferralOwnerSortKey,
+		"DYNAMODB_STRIPE_KEY_PREFIX":       &schema.StripePrefix,
+		"DYNAMODB_RUN_KEY_PREFIX":          &schema.RunPrefix,
+		"DYNAMODB_RUN_SORT_KEY":            &schema.RunSortKey,
+		"DYNAMODB_RUN_MESSAGE_KEY_PREFIX":  &schema.RunMessagePrefix,
+	} {
+		if v, ok := os.LookupEnv(name); ok {
+			if v == "" {
+				return Default(), fmt.Errorf("environment variable %s is empty", name)
+			}
+			*value = v
+		}
+	}
+	prefixes := []string{schema.UserPrefix, schema.ReferralPrefix, schema.StripePrefix, schema.RunPrefix}
+	for i := range prefixes {
+		for j := i + 1; j < len(prefixes); j++ {
+			if prefixes[i] == prefixes[j] {
+				return Default(), fmt.Errorf("DynamoDB key prefixes must be distinct, got %s", strings.Join(prefixes, ", "))
+			}
+		}
+	}
+	return schema, nil
+}
+
+// UserPK returns the partition key of cognitoUserID's items.
+func (s Schema) UserPK(cognitoUserID string) string {
+	return s.UserPrefix + cognitoUserID
+}
+
+// UserKey returns the primary key of cognitoUserID's user item.
+func (s Schema) UserKey(cognitoUserID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		PartitionKey: &types.AttributeValueMemberS{Value: s.UserPK(cognitoUserID)},
+		SortKey:      &types.AttributeValueMemberS{Value: s.UserSortKey},
+	}
+}
+
+// ReferralPK returns the partition key shared by every referee of referralCode.
+func (s Schema) ReferralPK(referralCode string) string {
+	return s.ReferralPrefix + referralCode
+}
+
+// ReferralOwnerKey returns the primary key of the item naming the user referralCode belongs to. It shares the
+// partition of the code's referrals.
+func (s Schema) ReferralOwnerKey(referralCode string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		PartitionKey: &types.AttributeValueMemberS{Value: s.ReferralPK(referralCode)},
+		SortKey:      &types.AttributeValueMemberS{Value: s.ReferralOwnerSortKey},
+	}
+}
+
+// StripePK returns the GSI1PK of the user item belonging to stripeCustomerID.
+func (s Schema) StripePK(stripeCustomerID string) string {
+	return s.StripePrefix + stripeCustomerID
+}
+
+// RunPK returns the partition key of the items describing the invocation requestID.
+func (s Schema) RunPK(requestID string) string {
+	return s.RunPrefix + requestID
+}
+
+// RunMessageSK returns the sort key of the item describing what happened to messageID during a run. It shares
+// the partition of the run's summary item.
+func (s Schema) RunMessageSK(messageID string) string {
+	return s.RunMessagePrefix + messageID
+}
+
+// CognitoUserID returns the user an item with the given PK and SK belongs to. User items carry the user in
+// their PK and referral items in their SK.
+func (s Schema) CognitoUserID(pk, sk string) (string, bool) {
+	for _, key := range []string{pk, sk} {
+		if strings.HasPrefix(key, s.UserPrefix) && key != s.UserSortKey {
+			return strings.TrimPrefix(key, s.UserPrefix), true
+		}
+	}
+	return "", false
+}
+
+// StripeCustomerQuery returns a query for the user item belonging to stripeCustomerID.
+func (s Schema) StripeCustomerQuery(tableName, stripeCustomerID string) *dynamodb.QueryInput {
+	return &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(GSI1Index),
+		KeyConditionExpression: aws.String("#GSI1PK = :stripe"),
+		ExpressionAttributeNames: map[string]string{
+			"#GSI1PK": GSI1PK,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":stripe": &types.AttributeValueMemberS{Value: s.StripePK(stripeCustomerID)},
+		},
+	}
+}
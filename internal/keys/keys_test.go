@@ -0,0 +1,120 @@
+package keys
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    Schema
+		wantErr bool
+	}{
+		{name: "default", want: Default()},
+		{
+			name: "overridden",
+			env:  map[string]string{"DYNAMODB_USER_KEY_PREFIX": "U#", "DYNAMODB_USER_SORT_KEY": "PROFILE"},
+			want: Schema{UserPrefix: "U#", UserSortKey: "PROFILE", ReferralPrefix: "REFERRAL#", ReferralOwnerSortKey: "OWNER", StripePrefix: "STRIPE#", RunPrefix: "RUN#", RunSortKey: "RUN#MAIDO", RunMessagePrefix: "MESSAGE#"},
+		},
+		{
+			name:    "empty",
+			env:     map[string]string{"DYNAMODB_STRIPE_KEY_PREFIX": ""},
+			want:    Default(),
+			wantErr: true,
+		},
+		{
+			name:    "duplicate_prefix",
+			env:     map[string]string{"DYNAMODB_STRIPE_KEY_PREFIX": "USER#"},
+			want:    Default(),
+			wantErr: true,
+		},
+		{
+			name:    "duplicate_run_prefix",
+			env:     map[string]string{"DYNAMODB_RUN_KEY_PREFIX": "REFERRAL#"},
+			want:    Default(),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			got, err := FromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("FromEnv() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchema_UserKey(t *testing.T) {
+	want := map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "USER#12345"},
+		"SK": &types.AttributeValueMemberS{Value: "USER#MAIDO"},
+	}
+	if got := Default().UserKey("12345"); !reflect.DeepEqual(got, want) {
+		t.Errorf("UserKey() = %v, want %v", got, want)
+	}
+}
+
+func TestSchema_ReferralOwnerKey(t *testing.T) {
+	want := map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "REFERRAL#FRIEND42"},
+		"SK": &types.AttributeValueMemberS{Value: "OWNER"},
+	}
+	if got := Default().ReferralOwnerKey("FRIEND42"); !reflect.DeepEqual(got, want) {
+		t.Errorf("ReferralOwnerKey() = %v, want %v", got, want)
+	}
+}
+
+func TestSchema_CognitoUserID(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema Schema
+		pk     string
+		sk     string
+		want   string
+		wantOK bool
+	}{
+		{name: "user_item", schema: Default(), pk: "USER#12345", sk: "USER#MAIDO", want: "12345", wantOK: true},
+		{name: "referral_item", schema: Default(), pk: "REFERRAL#MAIDO-ABC", sk: "USER#12345", want: "12345", wantOK: true},
+		{name: "run_item", schema: Default(), pk: "RUN#request", sk: "RUN#MAIDO"},
+		{name: "run_message_item", schema: Default(), pk: "RUN#request", sk: "MESSAGE#message-1"},
+		{name: "referral_owner_item", schema: Default(), pk: "REFERRAL#MAIDO-ABC", sk: "OWNER"},
+		{
+			name:   "custom_prefix",
+			schema: Schema{UserPrefix: "U#", UserSortKey: "PROFILE", ReferralPrefix: "R#", StripePrefix: "S#"},
+			pk:     "U#12345",
+			sk:     "PROFILE",
+			want:   "12345",
+			wantOK: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.schema.CognitoUserID(tt.pk, tt.sk)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("CognitoUserID() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSchema_StripeCustomerQuery(t *testing.T) {
+	got := Default().StripeCustomerQuery("example", "cus_01234")
+	if aws.ToString(got.IndexName) != GSI1Index {
+		t.Errorf("StripeCustomerQuery() IndexName = %v, want %v", aws.ToString(got.IndexName), GSI1Index)
+	}
+	if value := got.ExpressionAttributeValues[":stripe"].(*types.AttributeValueMemberS).Value; value != "STRIPE#cus_01234" {
+		t.Errorf("StripeCustomerQuery() :stripe = %v, want STRIPE#cus_01234", value)
+	}
+}
@@ -0,0 +1,124 @@
+// Package loadgen generates synthetic SQS events for the onboarding pipeline. Events are deterministic for a
+// given seed, so benchmark runs and load tests can be repeated exactly:
+//
+//	event := loadgen.SQSEvent(10000, loadgen.DefaultOptions())
+package loadgen
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// MaxBatchSize is the most messages SQS delivers to a lambda in one event.
+const MaxBatchSize = 10000
+
+// Options shapes the generated messages. Rates are the fraction of messages, between 0 and 1, given the option.
+type Options struct {
+	Seed                 int64
+	PromoCodeRate        float64
+	ReferralRate         float64
+	SkipSubscriptionRate float64
+	// PromoCodes are drawn from when a message carries a promotion code.
+	PromoCodes []string
+	// QueueARN is the source queue of the generated messages.
+	QueueARN string
+	// SentAt is the sent timestamp of the first message; later messages are sent a millisecond apart.
+	SentAt time.Time
+}
+
+// DefaultOptions returns an illustrative mix of sign-ups: most without a code, a few with a promotion or referral
+// code, and a handful skipping the subscription. The rates are not measured from real traffic; set them from the
+// queue's own mix when a load test needs to match it.
+func DefaultOptions() Options {
+	return Options{
+		Seed:                 1,
+		PromoCodeRate:        0.1,
+		ReferralRate:         0.05,
+		SkipSubscriptionRate: 0.02,
+		PromoCodes:           []string{"MAIDO", "WELCOME10"},
+		QueueARN:             "arn:aws:sqs:us-east-2:123456789012:onboarding",
+		SentAt:               time.Date(2021, time.December, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+var (
+	firstNames = []string{"Aiko", "Ben", "Chloe", "Daniel", "Emi", "Felix", "Grace", "Hiro", "Isla", "Jack", "Kenji", "Lucy"}
+	surNames   = []string{"Anderson", "Brown", "Campbell", "Davies", "Evans", "Fujita", "Green", "Hayashi", "Ito", "Jones"}
+)
+
+// messageBody is the JSON body the sign-up flow puts on the onboarding queue.
+type messageBody struct {
+	CognitoUserID    string `json:"cognitoUserID"`
+	Email            string `json:"email"`
+	FirstName        string `json:"firstName"`
+	SurName          string `json:"surName"`
+	PromoCode        string `json:"promoCode,omitempty"`
+	ReferralCode     string `json:"referralCode,omitempty"`
+	SkipSubscription bool   `json:"skipSubscription,omitempty"`
+	CorrelationID    string `json:"correlationId"`
+}
+
+// uuid returns a random version 4 UUID drawn from r.
+func uuid(r *rand.Rand) string {
+	b := make([]byte, 16)
+	r.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// SQSEvent returns an event of n messages generated from opts.
+func SQSEvent(n int, opts Options) events.SQSEvent {
+	r := rand.New(rand.NewSource(opts.Seed))
+	event := events.SQSEvent{Records: make([]events.SQSMessage, 0, n)}
+	for i := 0; i < n; i++ {
+		event.Records = append(event.Records, message(r, i, opts))
+	}
+	return event
+}
+
+func message(r *rand.Rand, i int, opts Options) events.SQSMessage {
+	firstName := firstNames[r.Intn(len(firstNames))]
+	surName := surNames[r.Intn(len(surNames))]
+	body := messageBody{
+		CognitoUserID: uuid(r),
+		Email:         fmt.Sprintf("%s.%s.%d@example.com", firstName, surName, i),
+		FirstName:     firstName,
+		SurName:       surName,
+		CorrelationID: uuid(r),
+	}
+	if len(opts.PromoCodes) > 0 && r.Float64() < opts.PromoCodeRate {
+		body.PromoCode = opts.PromoCodes[r.Intn(len(opts.PromoCodes))]
+	}
+	if r.Float64() < opts.ReferralRate {
+		body.ReferralCode = fmt.Sprintf("REF%06d", r.Intn(1000000))
+	}
+	body.SkipSubscription = r.Float64() < opts.SkipSubscriptionRate
+	// messageBody only holds strings and a bool, so it always marshals.
+	raw, _ := json.Marshal(body)
+	sum := md5.Sum(raw)
+	sentAt := opts.SentAt.Add(time.Duration(i) * time.Millisecond)
+	return events.SQSMessage{
+		MessageId:     uuid(r),
+		ReceiptHandle: "AQEB" + hex.EncodeToString([]byte(uuid(r))),
+		Body:          string(raw),
+		Md5OfBody:     hex.EncodeToString(sum[:]),
+		Attributes: map[string]string{
+			"ApproximateReceiveCount":          "1",
+			"SentTimestamp":                    strconv.FormatInt(sentAt.UnixNano()/int64(time.Millisecond), 10),
+			"SenderId":                         "AIDAIENQZJOLO23YVJ4VO",
+			"ApproximateFirstReceiveTimestamp": strconv.FormatInt(sentAt.Add(10*time.Millisecond).UnixNano()/int64(time.Millisecond), 10),
+		},
+		MessageAttributes: map[string]events.SQSMessageAttribute{},
+		EventSource:       "aws:sqs",
+		EventSourceARN:    opts.QueueARN,
+		AWSRegion:         "us-east-2",
+	}
+}
@@ -0,0 +1,63 @@
+package loadgen
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSQSEvent(t *testing.T) {
+	opts := DefaultOptions()
+	event := SQSEvent(1000, opts)
+	if len(event.Records) != 1000 {
+		t.Fatalf("SQSEvent() = %v records, want 1000", len(event.Records))
+	}
+	if again := SQSEvent(1000, opts); !reflect.DeepEqual(event, again) {
+		t.Error("SQSEvent() differs between runs with the same seed")
+	}
+	messageIDs := map[string]bool{}
+	userIDs := map[string]bool{}
+	promoCodes, referrals := 0, 0
+	for _, record := range event.Records {
+		body := messageBody{}
+		if err := json.Unmarshal([]byte(record.Body), &body); err != nil {
+			t.Fatalf("record %s body = %s, %v", record.MessageId, record.Body, err)
+		}
+		sum := md5.Sum([]byte(record.Body))
+		if record.Md5OfBody != hex.EncodeToString(sum[:]) {
+			t.Errorf("record %s md5OfBody = %v, want the MD5 of its body", record.MessageId, record.Md5OfBody)
+		}
+		if body.CognitoUserID == "" || body.Email == "" || body.CorrelationID == "" {
+			t.Errorf("record %s body = %+v, want a user ID, email and correlation ID", record.MessageId, body)
+		}
+		messageIDs[record.MessageId] = true
+		userIDs[body.CognitoUserID] = true
+		if body.PromoCode != "" {
+			promoCodes++
+		}
+		if body.ReferralCode != "" {
+			referrals++
+		}
+	}
+	if len(messageIDs) != 1000 || len(userIDs) != 1000 {
+		t.Errorf("SQSEvent() = %v message IDs and %v user IDs, want 1000 unique", len(messageIDs), len(userIDs))
+	}
+	// The rates are drawn at random, so only check they are in the right neighbourhood.
+	if promoCodes < 50 || promoCodes > 150 {
+		t.Errorf("SQSEvent() = %v promotion codes at rate %v", promoCodes, opts.PromoCodeRate)
+	}
+	if referrals < 20 || referrals > 80 {
+		t.Errorf("SQSEvent() = %v referral codes at rate %v", referrals, opts.ReferralRate)
+	}
+}
+
+func TestSQSEvent_seed(t *testing.T) {
+	opts := DefaultOptions()
+	first := SQSEvent(10, opts)
+	opts.Seed = 2
+	if second := SQSEvent(10, opts); first.Records[0].Body == second.Records[0].Body {
+		t.Error("SQSEvent() is the same for different seeds")
+	}
+}
@@ -0,0 +1,161 @@
+// Package logging layers correlation fields and PII redaction on top of logrus. Configure installs hooks on a
+// logger so that every entry logged with a context carries the Lambda request ID, SQS message ID and
+// correlation ID, and so that configured PII fields are hashed or redacted before the entry is written.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	log "github.com/sirupsen/logrus"
+)
+
+// Field names added to entries logged with a context.
+const (
+	FieldRequestID     = "aws_request_id"
+	FieldMessageID     = "sqs_message_id"
+	FieldCorrelationID = "correlation_id"
+)
+
+// Mode controls how PII fields are rewritten.
+type Mode string
+
+// Supported PII modes.
+const (
+	ModeHash   Mode = "hash"
+	ModeRedact Mode = "redact"
+)
+
+// defaultPIIFields are rewritten when LOG_PII_FIELDS is not set.
+var defaultPIIFields = []string{"cognito_user_id", "cognito_user_ids", "email", "first_name", "sur_name", "name"}
+
+// Config controls the redaction hook.
+type Config struct {
+	// Fields are the entry fields treated as PII. Matching is case-insensitive.
+	Fields []string
+	// Mode is ModeHash or ModeRedact.
+	Mode Mode
+	// Salt is mixed into hashes so that low-entropy values such as emails cannot be looked up in a
+	// precomputed table.
+	Salt string
+	// Debug disables redaction. It is refused when Environment is production.
+	Debug bool
+	// Environment is the deployment environment, for example production.
+	Environment string
+}
+
+// ConfigFromEnv reads LOG_PII_FIELDS (comma separated), LOG_PII_MODE, LOG_PII_HASH_SALT, LOG_PII_DEBUG and
+// ENVIRONMENT.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Fields:      defaultPIIFields,
+		Mode:        ModeHash,
+		Salt:        os.Getenv("LOG_PII_HASH_SALT"),
+		Debug:       os.Getenv("LOG_PII_DEBUG") == "true",
+		Environment: os.Getenv("ENVIRONMENT"),
+	}
+	if fields, ok := os.LookupEnv("LOG_PII_FIELDS"); ok {
+		cfg.Fields = []string{}
+		for _, field := range strings.Split(fields, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				cfg.Fields = append(cfg.Fields, field)
+			}
+		}
+	}
+	if mode, ok := os.LookupEnv("LOG_PII_MODE"); ok && mode != "" {
+		cfg.Mode = Mode(mode)
+	}
+	return cfg
+}
+
+func isProduction(environment string) bool {
+	switch strings.ToLower(environment) {
+	case "prod", "production":
+		return true
+	}
+	return false
+}
+
+// Configure installs the correlation and redaction hooks on logger. When cfg asks for debug output in
+// production the override is refused: redaction stays enabled and an error is returned for the caller to log.
+func Configure(logger *log.Logger, cfg Config) error {
+	logger.AddHook(correlationHook{})
+	var err error
+	switch cfg.Mode {
+	case ModeHash, ModeRedact:
+	default:
+		err = fmt.Errorf("unknown PII log mode %q, falling back to %s", cfg.Mode, ModeRedact)
+		cfg.Mode = ModeRedact
+	}
+	if cfg.Debug && isProduction(cfg.Environment) {
+		err = fmt.Errorf("PII debug logging is not allowed in %s, redaction remains enabled", cfg.Environment)
+		cfg.Debug = false
+	}
+	if !cfg.Debug {
+		logger.AddHook(newRedactionHook(cfg))
+	}
+	return err
+}
+
+type contextKey struct{}
+
+type correlation struct {
+	MessageID     string
+	CorrelationID string
+}
+
+// WithMessage returns a copy of ctx whose entries carry messageID and correlationID. An empty correlationID
+// falls back to messageID so that every message can be followed through the logs.
+func WithMessage(ctx context.Context, messageID, correlationID string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if correlationID == "" {
+		correlationID = messageID
+	}
+	return context.WithValue(ctx, contextKey{}, correlation{MessageID: messageID, CorrelationID: correlationID})
+}
+
+// CorrelationFromContext returns the message and correlation IDs stored by WithMessage.
+func CorrelationFromContext(ctx context.Context) (messageID, correlationID string) {
+	if ctx == nil {
+		return "", ""
+	}
+	c, _ := ctx.Value(contextKey{}).(correlation)
+	return c.MessageID, c.CorrelationID
+}
+
+// FromContext returns an entry of the standard logger bound to ctx.
+func FromContext(ctx context.Context) *log.Entry {
+	if ctx == nil {
+		return log.NewEntry(log.StandardLogger())
+	}
+	return log.WithContext(ctx)
+}
+
+type correlationHook struct{}
+
+func (correlationHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (correlationHook) Fire(entry *log.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	if lc, ok := lambdacontext.FromContext(entry.Context); ok && lc.AwsRequestID != "" {
+		entry.Data[FieldRequestID] = lc.AwsRequestID
+	}
+	if c, ok := entry.Context.Value(contextKey{}).(correlation); ok {
+		if c.MessageID != "" {
+			entry.Data[FieldMessageID] = c.MessageID
+		}
+		if c.CorrelationID != "" {
+			entry.Data[FieldCorrelationID] = c.CorrelationID
+		}
+	}
+	return nil
+}
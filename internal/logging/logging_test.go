@@ -0,0 +1,189 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	log "github.com/sirupsen/logrus"
+)
+
+func newTestLogger(t *testing.T, cfg Config) (*log.Logger, *bytes.Buffer, error) {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	logger := log.New()
+	logger.SetOutput(buf)
+	logger.SetFormatter(&log.JSONFormatter{})
+	err := Configure(logger, cfg)
+	return logger, buf, err
+}
+
+func decode(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	t.Helper()
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("unable to decode log entry %q: %v", buf.String(), err)
+	}
+	return entry
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("LOG_PII_FIELDS", "email, name,,")
+	t.Setenv("LOG_PII_MODE", "redact")
+	t.Setenv("LOG_PII_HASH_SALT", "pepper")
+	t.Setenv("LOG_PII_DEBUG", "true")
+	t.Setenv("ENVIRONMENT", "staging")
+	want := Config{
+		Fields:      []string{"email", "name"},
+		Mode:        ModeRedact,
+		Salt:        "pepper",
+		Debug:       true,
+		Environment: "staging",
+	}
+	if got := ConfigFromEnv(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ConfigFromEnv() = %v, want %v", got, want)
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		fields  log.Fields
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name:   "hash",
+			cfg:    Config{Fields: []string{"email", "cognito_user_ids"}, Mode: ModeHash, Salt: "pepper"},
+			fields: log.Fields{"email": "example@example.com", "cognito_user_ids": []string{"12345"}, "stripe_customer_id": "cus_01234"},
+			want: map[string]interface{}{
+				"email":              Hash("example@example.com", []byte("pepper")),
+				"cognito_user_ids":   []interface{}{Hash("12345", []byte("pepper"))},
+				"stripe_customer_id": "cus_01234",
+			},
+		},
+		{
+			name:   "redact_case_insensitive",
+			cfg:    Config{Fields: []string{"EMAIL"}, Mode: ModeRedact},
+			fields: log.Fields{"email": "example@example.com"},
+			want:   map[string]interface{}{"email": "[REDACTED]"},
+		},
+		{
+			name:   "debug_outside_production",
+			cfg:    Config{Fields: []string{"email"}, Mode: ModeHash, Debug: true, Environment: "staging"},
+			fields: log.Fields{"email": "example@example.com"},
+			want:   map[string]interface{}{"email": "example@example.com"},
+		},
+		{
+			name:    "debug_refused_in_production",
+			cfg:     Config{Fields: []string{"email"}, Mode: ModeRedact, Debug: true, Environment: "production"},
+			fields:  log.Fields{"email": "example@example.com"},
+			want:    map[string]interface{}{"email": "[REDACTED]"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown_mode_redacts",
+			cfg:     Config{Fields: []string{"email"}, Mode: "mask"},
+			fields:  log.Fields{"email": "example@example.com"},
+			want:    map[string]interface{}{"email": "[REDACTED]"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, buf, err := newTestLogger(t, tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Configure() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			logger.WithFields(tt.fields).Info("example")
+			entry := decode(t, buf)
+			for key, want := range tt.want {
+				if !reflect.DeepEqual(entry[key], want) {
+					t.Errorf("%s = %v, want %v", key, entry[key], want)
+				}
+			}
+		})
+	}
+}
+
+type report struct {
+	Email string `json:"email"`
+	Plan  string `json:"plan"`
+}
+
+func (r report) Redact(rewrite func(field, value string) string) interface{} {
+	r.Email = rewrite("email", r.Email)
+	r.Plan = rewrite("plan", r.Plan)
+	return r
+}
+
+func TestRedactionNested(t *testing.T) {
+	logger, buf, err := newTestLogger(t, Config{Fields: []string{"email"}, Mode: ModeRedact})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.WithField("report", report{Email: "example@example.com", Plan: "pro"}).Info("example")
+	want := map[string]interface{}{"email": "[REDACTED]", "plan": "pro"}
+	if got := decode(t, buf)["report"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("report = %v, want %v", got, want)
+	}
+}
+
+func TestRedactionLeavesCallerFieldsUntouched(t *testing.T) {
+	logger, _, err := newTestLogger(t, Config{Fields: []string{"email"}, Mode: ModeRedact})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := log.Fields{"email": "example@example.com"}
+	logger.WithFields(fields).Info("example")
+	if fields["email"] != "example@example.com" {
+		t.Errorf("caller fields were modified: %v", fields)
+	}
+}
+
+func TestCorrelation(t *testing.T) {
+	logger, buf, err := newTestLogger(t, Config{Mode: ModeHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "request-1"})
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		correlationID string
+		want          map[string]interface{}
+	}{
+		{
+			name:          "explicit_correlation_id",
+			ctx:           WithMessage(ctx, "message-1", "correlation-1"),
+			correlationID: "correlation-1",
+			want:          map[string]interface{}{FieldRequestID: "request-1", FieldMessageID: "message-1", FieldCorrelationID: "correlation-1"},
+		},
+		{
+			name: "correlation_id_defaults_to_message_id",
+			ctx:  WithMessage(ctx, "message-1", ""),
+			want: map[string]interface{}{FieldRequestID: "request-1", FieldMessageID: "message-1", FieldCorrelationID: "message-1"},
+		},
+		{
+			name: "request_only",
+			ctx:  ctx,
+			want: map[string]interface{}{FieldRequestID: "request-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			logger.WithContext(tt.ctx).Info("example")
+			entry := decode(t, buf)
+			for _, key := range []string{FieldRequestID, FieldMessageID, FieldCorrelationID} {
+				if entry[key] != tt.want[key] {
+					t.Errorf("%s = %v, want %v", key, entry[key], tt.want[key])
+				}
+			}
+		})
+	}
+}
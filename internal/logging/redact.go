@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const redacted = "[REDACTED]"
+
+// Redactor is implemented by values that carry PII below the top level of an entry, such as a report holding
+// a list of users. The redaction hook passes Redact a rewrite function that hashes or redacts value when field
+// is configured as PII and returns it unchanged otherwise. Redact must return a copy rather than modify the
+// receiver.
+type Redactor interface {
+	Redact(rewrite func(field, value string) string) interface{}
+}
+
+type redactionHook struct {
+	fields map[string]bool
+	mode   Mode
+	salt   []byte
+}
+
+func newRedactionHook(cfg Config) redactionHook {
+	fields := map[string]bool{}
+	for _, field := range cfg.Fields {
+		fields[strings.ToLower(field)] = true
+	}
+	return redactionHook{fields: fields, mode: cfg.Mode, salt: []byte(cfg.Salt)}
+}
+
+func (h redactionHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire rewrites PII fields in place. logrus hands hooks a copy of the entry's fields, so the caller's
+// log.Fields are left untouched.
+func (h redactionHook) Fire(entry *log.Entry) error {
+	for key, value := range entry.Data {
+		if h.fields[strings.ToLower(key)] {
+			entry.Data[key] = h.rewrite(value)
+		} else if r, ok := value.(Redactor); ok {
+			entry.Data[key] = r.Redact(h.rewriteField)
+		}
+	}
+	return nil
+}
+
+func (h redactionHook) rewriteField(field, value string) string {
+	if !h.fields[strings.ToLower(field)] {
+		return value
+	}
+	return h.rewriteString(value)
+}
+
+func (h redactionHook) rewrite(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []string:
+		rewritten := make([]string, len(v))
+		for i, s := range v {
+			rewritten[i] = h.rewriteString(s)
+		}
+		return rewritten
+	case string:
+		return h.rewriteString(v)
+	default:
+		return h.rewriteString(fmt.Sprint(v))
+	}
+}
+
+func (h redactionHook) rewriteString(value string) string {
+	if value == "" {
+		return ""
+	}
+	if h.mode == ModeRedact {
+		return redacted
+	}
+	return Hash(value, h.salt)
+}
+
+// Hash returns the truncated HMAC-SHA256 of value keyed with salt, prefixed with "sha256:". The same value and
+// salt always produce the same hash, so redacted logs can still be searched for a known user.
+func Hash(value string, salt []byte) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(value))
+	return "sha256:" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
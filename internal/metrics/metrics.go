@@ -0,0 +1,188 @@
+// Package metrics records CloudWatch metrics in Embedded Metric Format (EMF). A Logger aggregates values
+// for the lifetime of a Lambda invocation and writes a single EMF document to its sink on Flush, which the
+// Lambda runtime forwards to CloudWatch Logs where the metrics are extracted.
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Unit is a CloudWatch metric unit.
+type Unit string
+
+// Units used by the onboarding lambdas.
+const (
+	UnitCount        Unit = "Count"
+	UnitMilliseconds Unit = "Milliseconds"
+)
+
+// maxValuesPerMetric is the number of values EMF accepts for a single metric in one document.
+const maxValuesPerMetric = 100
+
+// Dimension is a CloudWatch dimension attached to every metric a Logger emits.
+type Dimension struct {
+	Name  string
+	Value string
+}
+
+type metric struct {
+	unit    Unit
+	counter bool
+	values  []float64
+}
+
+// Logger aggregates metrics and writes them as EMF. All methods are safe for concurrent use and are no-ops on
+// a nil Logger, so callers can use FromContext without checking whether metrics are enabled.
+type Logger struct {
+	mu         sync.Mutex
+	sink       io.Writer
+	namespace  string
+	dimensions []Dimension
+	metrics    map[string]*metric
+	now        func() time.Time
+}
+
+// New returns a Logger that writes to sink under namespace with the given dimensions.
+func New(sink io.Writer, namespace string, dimensions ...Dimension) *Logger {
+	return &Logger{
+		sink:       sink,
+		namespace:  namespace,
+		dimensions: dimensions,
+		metrics:    map[string]*metric{},
+		now:        time.Now,
+	}
+}
+
+// Count adds n to the counter name.
+func (l *Logger) Count(name string, n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	m, ok := l.metrics[name]
+	if !ok {
+		m = &metric{unit: UnitCount, counter: true, values: []float64{0}}
+		l.metrics[name] = m
+	}
+	m.values[0] += float64(n)
+}
+
+// Duration records d in milliseconds as a sample of name.
+func (l *Logger) Duration(name string, d time.Duration) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	m, ok := l.metrics[name]
+	if !ok {
+		m = &metric{unit: UnitMilliseconds}
+		l.metrics[name] = m
+	}
+	m.values = append(m.values, float64(d.Microseconds())/1000)
+}
+
+// Time starts a timer for name and returns a function that records the elapsed time when called.
+func (l *Logger) Time(name string) func() {
+	if l == nil {
+		return func() {}
+	}
+	start := l.now()
+	return func() {
+		l.Duration(name, l.now().Sub(start))
+	}
+}
+
+type metricDefinition struct {
+	Name string `json:"Name"`
+	Unit Unit   `json:"Unit"`
+}
+
+type metricDirective struct {
+	Namespace  string             `json:"Namespace"`
+	Dimensions [][]string         `json:"Dimensions"`
+	Metrics    []metricDefinition `json:"Metrics"`
+}
+
+type metadata struct {
+	Timestamp         int64             `json:"Timestamp"`
+	CloudWatchMetrics []metricDirective `json:"CloudWatchMetrics"`
+}
+
+// Flush writes the recorded metrics as one EMF document and resets the Logger. Nothing is written when no
+// metrics have been recorded.
+func (l *Logger) Flush() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.metrics) == 0 {
+		return nil
+	}
+	document := map[string]interface{}{}
+	dimensionNames := []string{}
+	for _, dimension := range l.dimensions {
+		dimensionNames = append(dimensionNames, dimension.Name)
+		document[dimension.Name] = dimension.Value
+	}
+	names := make([]string, 0, len(l.metrics))
+	for name := range l.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	definitions := []metricDefinition{}
+	for _, name := range names {
+		m := l.metrics[name]
+		definitions = append(definitions, metricDefinition{Name: name, Unit: m.unit})
+		values := m.values
+		if len(values) > maxValuesPerMetric {
+			values = values[:maxValuesPerMetric]
+		}
+		if len(values) == 1 {
+			document[name] = values[0]
+		} else {
+			document[name] = values
+		}
+	}
+	document["_aws"] = metadata{
+		Timestamp: l.now().UnixNano() / int64(time.Millisecond),
+		CloudWatchMetrics: []metricDirective{{
+			Namespace:  l.namespace,
+			Dimensions: [][]string{dimensionNames},
+			Metrics:    definitions,
+		}},
+	}
+	payload, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	l.metrics = map[string]*metric{}
+	_, err = l.sink.Write(append(payload, '\n'))
+	return err
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying l. A nil ctx is treated as context.Background.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the Logger carried by ctx, or nil when there is none.
+func FromContext(ctx context.Context) *Logger {
+	if ctx == nil {
+		return nil
+	}
+	l, _ := ctx.Value(contextKey{}).(*Logger)
+	return l
+}
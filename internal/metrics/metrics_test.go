@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLogger_Flush(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(buf, "Maido/Onboarding", Dimension{Name: "Environment", Value: "test"}, Dimension{Name: "Function", Value: "stripe_onboarding"})
+	l.now = func() time.Time { return time.Unix(1640995200, 0) }
+	l.Count("StripeCustomersCreated", 2)
+	l.Count("StripeCustomersCreated", 1)
+	l.Duration("StripeLatency", 1500*time.Microsecond)
+	l.Duration("StripeLatency", 2*time.Millisecond)
+	if err := l.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	want := `{"Environment":"test","Function":"stripe_onboarding","StripeCustomersCreated":3,"StripeLatency":[1.5,2],` +
+		`"_aws":{"Timestamp":1640995200000,"CloudWatchMetrics":[{"Namespace":"Maido/Onboarding","Dimensions":[["Environment","Function"]],` +
+		`"Metrics":[{"Name":"StripeCustomersCreated","Unit":"Count"},{"Name":"StripeLatency","Unit":"Milliseconds"}]}]}}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Flush() = %v, want %v", got, want)
+	}
+	buf.Reset()
+	if err := l.Flush(); err != nil || buf.Len() != 0 {
+		t.Errorf("Flush() after reset wrote %q, error = %v", buf.String(), err)
+	}
+}
+
+func TestLogger_FlushTruncatesValues(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(buf, "Maido/Onboarding")
+	for i := 0; i < maxValuesPerMetric+10; i++ {
+		l.Duration("Latency", time.Millisecond)
+	}
+	if err := l.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	document := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(document["Latency"].([]interface{})); got != maxValuesPerMetric {
+		t.Errorf("Flush() wrote %v values, want %v", got, maxValuesPerMetric)
+	}
+}
+
+func TestLogger_Concurrent(t *testing.T) {
+	sink := &MemorySink{}
+	l := New(sink, "Maido/Onboarding")
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Count("Items", 1)
+		}()
+	}
+	wg.Wait()
+	if err := l.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if got := sink.Sum("Items"); got != 50 {
+		t.Errorf("Sum() = %v, want 50", got)
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	var l *Logger
+	l.Count("Items", 1)
+	l.Duration("Latency", time.Second)
+	l.Time("Latency")()
+	if err := l.Flush(); err != nil {
+		t.Errorf("Flush() error = %v", err)
+	}
+	if got := FromContext(context.Background()); got != nil {
+		t.Errorf("FromContext() = %v, want nil", got)
+	}
+}
+
+func TestMemorySink_Values(t *testing.T) {
+	sink := &MemorySink{}
+	l := New(sink, "Maido/Onboarding")
+	ctx := NewContext(context.Background(), l)
+	FromContext(ctx).Count("Items", 2)
+	FromContext(ctx).Duration("Latency", time.Millisecond)
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	FromContext(ctx).Count("Items", 3)
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.Values("Items"); !reflect.DeepEqual(got, []float64{2, 3}) {
+		t.Errorf("Values() = %v, want [2 3]", got)
+	}
+	if got := sink.Sum("Latency"); got != 1 {
+		t.Errorf("Sum() = %v, want 1", got)
+	}
+	if got := sink.Values("Missing"); len(got) != 0 {
+		t.Errorf("Values() = %v, want none", got)
+	}
+}
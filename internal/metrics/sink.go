@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// MemorySink is an io.Writer that keeps every EMF document written to it so tests can assert on the metrics
+// a function emitted.
+type MemorySink struct {
+	mu        sync.Mutex
+	Documents []map[string]interface{}
+}
+
+// Write decodes each newline separated EMF document in p.
+func (s *MemorySink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, line := range bytes.Split(bytes.TrimSpace(p), []byte("\n")) {
+		document := map[string]interface{}{}
+		err := json.Unmarshal(line, &document)
+		if err != nil {
+			return 0, fmt.Errorf("unable to decode EMF document: %w", err)
+		}
+		s.Documents = append(s.Documents, document)
+	}
+	return len(p), nil
+}
+
+// Sum returns the total of every value recorded for name across all documents.
+func (s *MemorySink) Sum(name string) float64 {
+	total := 0.0
+	for _, value := range s.Values(name) {
+		total += value
+	}
+	return total
+}
+
+// Values returns every value recorded for name across all documents in the order they were written.
+func (s *MemorySink) Values(name string) []float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := []float64{}
+	for _, document := range s.Documents {
+		switch v := document[name].(type) {
+		case float64:
+			values = append(values, v)
+		case []interface{}:
+			for _, value := range v {
+				if f, ok := value.(float64); ok {
+					values = append(values, f)
+				}
+			}
+		}
+	}
+	return values
+}
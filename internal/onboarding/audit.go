@@ -0,0 +1,105 @@
+package onboarding
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/audit"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+	"github.com/seanturner026/maido-lambdas/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// Audited actions.
+const (
+	auditStripeCustomerCreated     = "stripe.customer.created"
+	auditStripeSubscriptionCreated = "stripe.subscription.created"
+	auditDynamoDBItemWritten       = "dynamodb.item.written"
+	auditOnboardingStatusUpdated   = "dynamodb.status.updated"
+	auditCognitoAttributesUpdated  = "cognito.attributes.updated"
+	auditCognitoGroupAdded         = "cognito.group.added"
+)
+
+func newAuditRecorder(db audit.PutItemAPI) (*audit.Recorder, error) {
+	tableName, err := audit.TableNameFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return audit.New(db, tableName, keySchema, audit.ActorFromLambda("stripe_onboarding")), nil
+}
+
+// recordAudit writes change for cognitoUserID. The change has already happened by the time it is audited, so a
+// failed write is logged and counted rather than failing the message.
+func recordAudit(ctx context.Context, cognitoUserID string, change audit.Change) {
+	err := audit.FromContext(ctx).Record(ctx, cognitoUserID, change)
+	if err != nil {
+		metrics.FromContext(ctx).Count(metricAuditWritesFailed, 1)
+		logging.FromContext(ctx).WithFields(log.Fields{"cognito_user_id": cognitoUserID, "error": err}).Error("Unable to write audit record")
+	}
+}
+
+// auditedItem holds the attributes of a written item that are recorded in the audit trail. Names and email
+// addresses are left out so the trail does not become another copy of the user's personal data.
+type auditedItem struct {
+	PK                 string `dynamodbav:"PK"`
+	SK                 string `dynamodbav:"SK"`
+	StripeCustomerID   string `dynamodbav:"StripeCustomerID"`
+	SubscriptionID     string `dynamodbav:"SubscriptionID"`
+	SubscriptionStatus string `dynamodbav:"SubscriptionStatus"`
+	PromoCode          string `dynamodbav:"PromoCode"`
+	RewardStatus       string `dynamodbav:"RewardStatus"`
+}
+
+func (i auditedItem) values() map[string]string {
+	values := map[string]string{}
+	for name, value := range map[string]string{
+		"StripeCustomerID":   i.StripeCustomerID,
+		"SubscriptionID":     i.SubscriptionID,
+		"SubscriptionStatus": i.SubscriptionStatus,
+		"PromoCode":          i.PromoCode,
+		"RewardStatus":       i.RewardStatus,
+	} {
+		if value != "" {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// auditWrittenItems records a write for each put request in written.
+func auditWrittenItems(ctx context.Context, tableName string, written []dynamodbtypes.WriteRequest) {
+	if audit.FromContext(ctx) == nil {
+		return
+	}
+	for _, request := range written {
+		if request.PutRequest == nil {
+			continue
+		}
+		item := auditedItem{}
+		if err := attributevalue.UnmarshalMap(request.PutRequest.Item, &item); err != nil {
+			logging.FromContext(ctx).WithFields(log.Fields{"error": err}).Error("Unable to read written item for audit record")
+			continue
+		}
+		cognitoUserID, ok := keySchema.CognitoUserID(item.PK, item.SK)
+		if !ok {
+			continue
+		}
+		recordAudit(ctx, cognitoUserID, audit.Change{
+			Action:  auditDynamoDBItemWritten,
+			Targets: map[string]string{"TableName": tableName, "PK": item.PK, "SK": item.SK},
+			After:   item.values(),
+		})
+	}
+}
+
+func attributesAfter(attributes []types.AttributeType) map[string]string {
+	after := map[string]string{}
+	for _, attribute := range attributes {
+		if attribute.Name != nil && attribute.Value != nil {
+			after[*attribute.Name] = *attribute.Value
+		}
+	}
+	return after
+}
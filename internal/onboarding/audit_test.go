@@ -0,0 +1,174 @@
+package onboarding
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/audit"
+	"github.com/seanturner026/maido-lambdas/internal/fakes"
+	"github.com/stripe/stripe-go/v72"
+)
+
+type mockAuditPutItem struct {
+	mu      sync.Mutex
+	Records []audit.Record
+}
+
+func (m *mockAuditPutItem) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	record := audit.Record{}
+	if err := attributevalue.UnmarshalMap(params.Item, &record); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Records = append(m.Records, record)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *mockAuditPutItem) Actions() []string {
+	actions := []string{}
+	for _, record := range m.Records {
+		actions = append(actions, record.Action)
+	}
+	return actions
+}
+
+func withAuditRecorder() (context.Context, *mockAuditPutItem) {
+	db := &mockAuditPutItem{}
+	recorder := audit.New(db, "audit", keySchema, audit.Actor{Function: "stripe_onboarding", Version: "$LATEST"})
+	return audit.NewContext(context.TODO(), recorder), db
+}
+
+func Test_createCustomers_audit(t *testing.T) {
+	ctx, db := withAuditRecorder()
+	wg := &sync.WaitGroup{}
+	ch := make(chan resultStripe, 1)
+	wg.Add(2)
+	event := &createCustomerEvent{CognitoUserID: "12345", SQSMessageID: "message-1", CorrelationID: "correlation-1"}
+	createCustomers(ctx, wg, ch, mockStripeCustomer{Response: &stripe.Customer{ID: "cus_01234"}}, nil, event)
+	createSubscriptions(
+		ctx,
+		wg,
+		ch,
+		&mockStripeSubscription{Response: &stripe.Subscription{ID: "sub_01234", Status: stripe.SubscriptionStatusIncomplete}},
+		subscriptionConfig{PriceID: "price_01234"},
+		event,
+	)
+	want := []string{auditStripeCustomerCreated, auditStripeSubscriptionCreated}
+	if got := db.Actions(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("createCustomers() and createSubscriptions() audited %v, want %v", got, want)
+	}
+	subscription := db.Records[1]
+	if subscription.PK != "USER#12345" || subscription.Targets["StripeSubscriptionID"] != "sub_01234" ||
+		subscription.Targets["StripeCustomerID"] != "cus_01234" || subscription.After["PriceID"] != "price_01234" ||
+		subscription.CorrelationID != "correlation-1" {
+		t.Errorf("createSubscriptions() record = %+v", subscription)
+	}
+}
+
+func Test_writeStripeIDUserAttribute_audit(t *testing.T) {
+	os.Setenv("USER_POOL_ID", "pool")
+	defer os.Unsetenv("USER_POOL_ID")
+	ctx, db := withAuditRecorder()
+	wg := &sync.WaitGroup{}
+	ch := make(chan resultCognito, 1)
+	wg.Add(1)
+	writeStripeIDUserAttribute(
+		ctx,
+		wg,
+		ch,
+		&mockAdminUpdateUserAttributes{Response: nil},
+		cognitoConfig{},
+		createCustomerEvent{CognitoUserID: "12345", StripeCustomerID: "cus_01234"},
+	)
+	if err := addUserToGroup(ctx, &mockAdminUpdateUserAttributes{}, "pool", "customers", &createCustomerEvent{CognitoUserID: "12345"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{auditCognitoAttributesUpdated, auditCognitoGroupAdded}
+	if got := db.Actions(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("writeStripeIDUserAttribute() and addUserToGroup() audited %v, want %v", got, want)
+	}
+	if got := db.Records[0].After["custom:stripe_customer_id"]; got != "cus_01234" {
+		t.Errorf("writeStripeIDUserAttribute() attributes after = %v", db.Records[0].After)
+	}
+}
+
+func Test_batchWriteItems_audit(t *testing.T) {
+	const tableName = "mockTable"
+	first := types.WriteRequest{PutRequest: &types.PutRequest{Item: map[string]types.AttributeValue{
+		"PK":               &types.AttributeValueMemberS{Value: "USER#1234"},
+		"SK":               &types.AttributeValueMemberS{Value: "USER#MAIDO"},
+		"StripeCustomerID": &types.AttributeValueMemberS{Value: "cus_01234"},
+		"EmailAddress":     &types.AttributeValueMemberS{Value: "example@example.com"},
+	}}}
+	second := types.WriteRequest{PutRequest: &types.PutRequest{Item: map[string]types.AttributeValue{
+		"PK":           &types.AttributeValueMemberS{Value: "REFERRAL#FRIEND"},
+		"SK":           &types.AttributeValueMemberS{Value: "USER#1234"},
+		"RewardStatus": &types.AttributeValueMemberS{Value: "PENDING"},
+	}}}
+	db := fakes.NewDynamoDB()
+	db.UnprocessedOn(1, 1)
+	ctx, auditDB := withAuditRecorder()
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	batchWriteItems(ctx, wg, make(chan resultDB, 3), db, []types.WriteRequest{first, second}, tableName, 0)
+	if len(auditDB.Records) != 2 {
+		t.Fatalf("batchWriteItems() audited %v records, want 2", len(auditDB.Records))
+	}
+	want := []map[string]string{
+		{"StripeCustomerID": "cus_01234"},
+		{"RewardStatus": "PENDING"},
+	}
+	for i, record := range auditDB.Records {
+		if record.PK != "USER#1234" || record.Action != auditDynamoDBItemWritten || !reflect.DeepEqual(record.After, want[i]) {
+			t.Errorf("batchWriteItems() record %d = %+v", i, record)
+		}
+	}
+}
+
+func Test_advanceOnboarding_audit(t *testing.T) {
+	ctx, auditDB := withAuditRecorder()
+	db := fakes.NewDynamoDB()
+	if err := db.Put("example", map[string]types.AttributeValue{
+		"PK":               &types.AttributeValueMemberS{Value: "USER#12345"},
+		"SK":               &types.AttributeValueMemberS{Value: "USER#MAIDO"},
+		"OnboardingStatus": &types.AttributeValueMemberS{Value: onboardingStatusPending},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	event := createCustomerEvent{
+		CognitoUserID:      "12345",
+		OnboardingStatus:   onboardingStatusPending,
+		StripeCustomerID:   "cus_01234",
+		SubscriptionID:     "sub_01234",
+		SubscriptionStatus: "incomplete",
+	}
+	advanceOnboarding(ctx, wg, make(chan resultStatus, 1), db, "example", event, onboardingStatusStripeCreated, onboardingStatusSubscriptionCreated)
+	want := []audit.Record{
+		{
+			Before: map[string]string{"OnboardingStatus": onboardingStatusPending},
+			After:  map[string]string{"OnboardingStatus": onboardingStatusStripeCreated, "StripeCustomerID": "cus_01234", "GSI1PK": "STRIPE#cus_01234"},
+		},
+		{
+			Before: map[string]string{"OnboardingStatus": onboardingStatusStripeCreated},
+			After:  map[string]string{"OnboardingStatus": onboardingStatusSubscriptionCreated, "SubscriptionID": "sub_01234", "SubscriptionStatus": "incomplete"},
+		},
+	}
+	if len(auditDB.Records) != len(want) {
+		t.Fatalf("advanceOnboarding() audited %d records, want %d", len(auditDB.Records), len(want))
+	}
+	for i, record := range auditDB.Records {
+		if record.Action != auditOnboardingStatusUpdated || record.PK != "USER#12345" ||
+			!reflect.DeepEqual(record.Before, want[i].Before) || !reflect.DeepEqual(record.After, want[i].After) {
+			t.Errorf("advanceOnboarding() record %d = %+v", i, record)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/seanturner026/maido-lambdas/internal/loadgen"
+	log "github.com/sirupsen/logrus"
+	"github.com/stripe/stripe-go/v72"
+)
+
+// Latencies of the fakes in BenchmarkHandler. The values are illustrative, not measured, and only give the fakes
+// some delay to overlap.
+const (
+	benchAWSLatency    = time.Millisecond
+	benchStripeLatency = 2 * time.Millisecond
+)
+
+// newBenchServices returns fakes that answer after the bench latencies, knowing the promotion codes
+// loadgen draws from.
+func newBenchServices(opts loadgen.Options) *fakeServices {
+	f := newFakeServices()
+	f.db.SetLatency("", benchAWSLatency)
+	f.cognito.SetLatency("", benchAWSLatency)
+	f.queue.SetLatency("", benchAWSLatency)
+	f.stripe.Customers.SetLatency("", benchStripeLatency)
+	f.stripe.Subscriptions.SetLatency("", benchStripeLatency)
+	f.stripe.PromotionCodes.SetLatency("", benchStripeLatency)
+	for _, code := range opts.PromoCodes {
+		f.stripe.PromotionCodes.Add(&stripe.PromotionCode{
+			Code:   code,
+			Active: true,
+			Coupon: &stripe.Coupon{ID: "coupon_" + code, Valid: true},
+		})
+	}
+	return f
+}
+
+// peakGoroutines samples runtime.NumGoroutine until the returned function is called, which returns the highest
+// count seen.
+func peakGoroutines() func() int {
+	done := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	peak := runtime.NumGoroutine()
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(100 * time.Microsecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if n := runtime.NumGoroutine(); n > peak {
+					peak = n
+				}
+			}
+		}
+	}()
+	return func() int {
+		close(done)
+		wg.Wait()
+		return peak
+	}
+}
+
+// BenchmarkHandler runs whole batches through the pipeline against fakes with network latency, for each batch size
+// and ONBOARDING_MAX_CONCURRENCY, where 0 is unbounded:
+//
+//	go test ./internal/onboarding -run xxx -bench Handler -benchtime 3x
+func BenchmarkHandler(b *testing.B) {
+	b.Setenv("DYNAMODB_TABLE_NAME", scenarioTableName)
+	b.Setenv("SQS_QUEUE_URL", "example_queue_url")
+	b.Setenv("USER_POOL_ID", "example_pool")
+	b.Setenv("STRIPE_SUBSCRIPTION_PRICE_ID", "price_01234")
+	for _, name := range []string{"DYNAMODB_WRITE_MODE", "COGNITO_GROUP_NAME", "COGNITO_USER_ATTRIBUTES", "RUN_SUMMARY_ENABLED"} {
+		b.Setenv(name, "")
+	}
+	// Logging every message would dominate the run.
+	logOutput := log.StandardLogger().Out
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logOutput)
+	opts := loadgen.DefaultOptions()
+	for _, records := range []int{100, 1000, loadgen.MaxBatchSize} {
+		event := loadgen.SQSEvent(records, opts)
+		for _, concurrency := range []int{0, 50, 200} {
+			b.Run(fmt.Sprintf("records=%d/concurrency=%d", records, concurrency), func(b *testing.B) {
+				b.Setenv("ONBOARDING_MAX_CONCURRENCY", strconv.Itoa(concurrency))
+				b.ReportAllocs()
+				peak := 0
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					h := newBenchServices(opts).handler()
+					stopSampling := peakGoroutines()
+					b.StartTimer()
+					summary, err := h.Run(context.TODO(), event)
+					b.StopTimer()
+					if n := stopSampling(); n > peak {
+						peak = n
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+					for _, message := range summary.Messages {
+						if !message.Acknowledged {
+							b.Fatalf("message %s was not acknowledged: %s", message.MessageID, message.Error)
+						}
+					}
+					b.StartTimer()
+				}
+				b.ReportMetric(float64(records*b.N)/b.Elapsed().Seconds(), "records/s")
+				b.ReportMetric(float64(peak), "peak-goroutines")
+			})
+		}
+	}
+}
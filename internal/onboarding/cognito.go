@@ -0,0 +1,232 @@
+package onboarding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/seanturner026/maido-lambdas/internal/audit"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+	"github.com/seanturner026/maido-lambdas/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+type resultCognito struct {
+	Message string
+	UserID  string
+	Error   error
+}
+
+type awsCognitoIdentityProviderAPI interface {
+	AdminUpdateUserAttributes(
+		ctx context.Context,
+		params *cognitoidentityprovider.AdminUpdateUserAttributesInput,
+		optFns ...func(*cognitoidentityprovider.Options),
+	) (*cognitoidentityprovider.AdminUpdateUserAttributesOutput, error)
+	AdminAddUserToGroup(
+		ctx context.Context,
+		params *cognitoidentityprovider.AdminAddUserToGroupInput,
+		optFns ...func(*cognitoidentityprovider.Options),
+	) (*cognitoidentityprovider.AdminAddUserToGroupOutput, error)
+}
+
+type attributeTemplate struct {
+	Name  string
+	Value *template.Template
+}
+
+// cognitoConfig holds the optional Cognito settings: attributes applied on top of custom:stripe_customer_id, and
+// the group the group stage adds users to.
+type cognitoConfig struct {
+	Attributes []attributeTemplate
+	GroupName  string
+}
+
+// cognitoConfigFromEnv reads COGNITO_USER_ATTRIBUTES, a JSON object of attribute name to value, and
+// COGNITO_GROUP_NAME. Values are text/template strings executed against the customer event, so
+// {"custom:customer_type": "residential", "custom:plan": "{{.SubscriptionStatus}}"} sets one static and one
+// per-user attribute.
+func cognitoConfigFromEnv() (cognitoConfig, error) {
+	cfg := cognitoConfig{GroupName: os.Getenv("COGNITO_GROUP_NAME")}
+	attributesJSON, ok := os.LookupEnv("COGNITO_USER_ATTRIBUTES")
+	if !ok || attributesJSON == "" {
+		return cfg, nil
+	}
+	attributes := map[string]string{}
+	err := json.Unmarshal([]byte(attributesJSON), &attributes)
+	if err != nil {
+		return cognitoConfig{}, fmt.Errorf("environment variable COGNITO_USER_ATTRIBUTES is not a JSON object of strings: %w", err)
+	}
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, err := template.New(name).Option("missingkey=error").Parse(attributes[name])
+		if err != nil {
+			return cognitoConfig{}, fmt.Errorf("unable to parse COGNITO_USER_ATTRIBUTES value for %s: %w", name, err)
+		}
+		cfg.Attributes = append(cfg.Attributes, attributeTemplate{Name: name, Value: value})
+	}
+	return cfg, nil
+}
+
+func generateUserAttributes(cfg cognitoConfig, event createCustomerEvent) ([]types.AttributeType, error) {
+	attributes := []types.AttributeType{{
+		Name:  aws.String("custom:stripe_customer_id"),
+		Value: aws.String(event.StripeCustomerID),
+	}}
+	for _, attribute := range cfg.Attributes {
+		value := &bytes.Buffer{}
+		err := attribute.Value.Execute(value, event)
+		if err != nil {
+			return attributes, fmt.Errorf("unable to render user attribute %s: %w", attribute.Name, err)
+		}
+		attributes = append(attributes, types.AttributeType{
+			Name:  aws.String(attribute.Name),
+			Value: aws.String(value.String()),
+		})
+	}
+	return attributes, nil
+}
+
+func writeStripeIDUserAttribute(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	ch chan resultCognito,
+	cognito awsCognitoIdentityProviderAPI,
+	cfg cognitoConfig,
+	event createCustomerEvent,
+) {
+	defer wg.Done()
+	ctx = logging.WithMessage(ctx, event.SQSMessageID, event.CorrelationID)
+	defer recoverWorker(ctx, func(err error) {
+		ch <- resultCognito{Error: err, UserID: event.CognitoUserID, Message: "Unable to add user attribute"}
+	})
+	userPoolID, ok := os.LookupEnv("USER_POOL_ID")
+	if !ok {
+		ch <- resultCognito{Error: fmt.Errorf("environment variable USER_POOL_ID is not set"), UserID: event.CognitoUserID, Message: "Unable to add user attribute"}
+		return
+	}
+	attributes, err := generateUserAttributes(cfg, event)
+	if err != nil {
+		ch <- resultCognito{Error: err, UserID: event.CognitoUserID, Message: "Unable to add user attribute"}
+		return
+	}
+	input := &cognitoidentityprovider.AdminUpdateUserAttributesInput{
+		UserAttributes: attributes,
+		UserPoolId:     aws.String(userPoolID),
+		Username:       aws.String(event.CognitoUserID),
+	}
+	_, err = cognito.AdminUpdateUserAttributes(ctx, input)
+	if err != nil {
+		metrics.FromContext(ctx).Count(metricCognitoUpdatesFailed, 1)
+		ch <- resultCognito{Error: err, UserID: event.CognitoUserID, Message: "Unable to add user attribute"}
+		return
+	}
+	recordAudit(ctx, event.CognitoUserID, audit.Change{
+		Action:  auditCognitoAttributesUpdated,
+		Targets: map[string]string{"UserPoolID": userPoolID, "CognitoUserID": event.CognitoUserID},
+		After:   attributesAfter(attributes),
+	})
+}
+
+// addUserToGroup adds the event's user to groupName.
+func addUserToGroup(ctx context.Context, cognito awsCognitoIdentityProviderAPI, userPoolID, groupName string, event *createCustomerEvent) error {
+	_, err := cognito.AdminAddUserToGroup(ctx, &cognitoidentityprovider.AdminAddUserToGroupInput{
+		GroupName:  aws.String(groupName),
+		UserPoolId: aws.String(userPoolID),
+		Username:   aws.String(event.CognitoUserID),
+	})
+	if err != nil {
+		metrics.FromContext(ctx).Count(metricCognitoUpdatesFailed, 1)
+		logging.FromContext(ctx).WithFields(log.Fields{"cognito_user_id": event.CognitoUserID, "group": groupName, "error": err}).
+			Error("Unable to add user to group")
+		return err
+	}
+	recordAudit(ctx, event.CognitoUserID, audit.Change{
+		Action:  auditCognitoGroupAdded,
+		Targets: map[string]string{"UserPoolID": userPoolID, "CognitoUserID": event.CognitoUserID},
+		After:   map[string]string{"GroupName": groupName},
+	})
+	return nil
+}
+
+// cognitoStage sets each event's Stripe customer ID and COGNITO_USER_ATTRIBUTES on its Cognito user.
+type cognitoStage struct {
+	workers limiter
+	cognito awsCognitoIdentityProviderAPI
+	cfg     cognitoConfig
+}
+
+func newCognitoStage(cfg pipelineConfig) (Stage, error) {
+	return cognitoStage{workers: cfg.workers, cognito: cfg.handler.Cognito, cfg: cfg.cognito}, nil
+}
+
+func (s cognitoStage) Name() string {
+	return stageCognito
+}
+
+func (s cognitoStage) Status() string {
+	return onboardingStatusCognitoSynced
+}
+
+func (s cognitoStage) Run(ctx context.Context, events []*createCustomerEvent) stageResult {
+	wg := &sync.WaitGroup{}
+	wg.Add(len(events))
+	ch := make(chan resultCognito, len(events))
+	for _, event := range events {
+		event := *event
+		s.workers.Go(func() { writeStripeIDUserAttribute(ctx, wg, ch, s.cognito, s.cfg, event) })
+	}
+	wg.Wait()
+	close(ch)
+	failed := map[string]error{}
+	for res := range ch {
+		failed[res.UserID] = res.Error
+		logging.FromContext(ctx).WithFields(log.Fields{"cognito_user_id": res.UserID, "error": res.Error}).Error(res.Message)
+	}
+	return stageResult{Failed: failuresByUser(events, failed)}
+}
+
+// groupStage adds each event's Cognito user to COGNITO_GROUP_NAME.
+type groupStage struct {
+	workers    limiter
+	cognito    awsCognitoIdentityProviderAPI
+	userPoolID string
+	groupName  string
+}
+
+func newGroupStage(cfg pipelineConfig) (Stage, error) {
+	if cfg.cognito.GroupName == "" {
+		return nil, nil
+	}
+	userPoolID, ok := os.LookupEnv("USER_POOL_ID")
+	if !ok {
+		return nil, fmt.Errorf("environment variable USER_POOL_ID is not set")
+	}
+	return groupStage{workers: cfg.workers, cognito: cfg.handler.Cognito, userPoolID: userPoolID, groupName: cfg.cognito.GroupName}, nil
+}
+
+func (s groupStage) Name() string {
+	return stageGroup
+}
+
+func (s groupStage) Status() string {
+	return onboardingStatusGroupAssigned
+}
+
+func (s groupStage) Run(ctx context.Context, events []*createCustomerEvent) stageResult {
+	return stageResult{Failed: forEachEvent(ctx, s.workers, events, func(ctx context.Context, event *createCustomerEvent) error {
+		return addUserToGroup(ctx, s.cognito, s.userPoolID, s.groupName, event)
+	})}
+}
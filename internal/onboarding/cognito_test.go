@@ -0,0 +1,207 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
+type mockAdminUpdateUserAttributes struct {
+	Response      *cognitoidentityprovider.AdminUpdateUserAttributesOutput
+	Error         error
+	GroupError    error
+	Input         *cognitoidentityprovider.AdminUpdateUserAttributesInput
+	GroupInput    *cognitoidentityprovider.AdminAddUserToGroupInput
+	groupRequests int
+}
+
+func (m *mockAdminUpdateUserAttributes) AdminUpdateUserAttributes(
+	ctx context.Context,
+	params *cognitoidentityprovider.AdminUpdateUserAttributesInput,
+	optFns ...func(*cognitoidentityprovider.Options),
+) (*cognitoidentityprovider.AdminUpdateUserAttributesOutput, error) {
+	m.Input = params
+	return m.Response, m.Error
+}
+
+func (m *mockAdminUpdateUserAttributes) AdminAddUserToGroup(
+	ctx context.Context,
+	params *cognitoidentityprovider.AdminAddUserToGroupInput,
+	optFns ...func(*cognitoidentityprovider.Options),
+) (*cognitoidentityprovider.AdminAddUserToGroupOutput, error) {
+	m.GroupInput = params
+	m.groupRequests++
+	return &cognitoidentityprovider.AdminAddUserToGroupOutput{}, m.GroupError
+}
+
+func Test_writeStripeIDUserAttribute(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	ctx := context.TODO()
+	type args struct {
+		ctx        context.Context
+		wg         *sync.WaitGroup
+		ch         chan resultCognito
+		cognito    awsCognitoIdentityProviderAPI
+		userPoolID string
+		event      createCustomerEvent
+	}
+	tests := []struct {
+		name string
+		args args
+	}{
+		{
+			name: "",
+			args: args{
+				ctx: ctx,
+				wg:  wg,
+				ch:  make(chan resultCognito),
+				cognito: &mockAdminUpdateUserAttributes{
+					Response: &cognitoidentityprovider.AdminUpdateUserAttributesOutput{},
+				},
+				userPoolID: "example_user_pool_id",
+				event: createCustomerEvent{
+					PK:               "",
+					SK:               "",
+					StripeCustomerID: "01234",
+					FirstName:        "first_example",
+					SurName:          "sur_example",
+					EmailAddress:     "example@example.com",
+				},
+			},
+		},
+	}
+	_ = os.Setenv("USER_POOL_ID", "example")
+	wg.Add(len(tests))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeStripeIDUserAttribute(tt.args.ctx, tt.args.wg, tt.args.ch, tt.args.cognito, cognitoConfig{}, tt.args.event)
+		})
+	}
+	wg.Wait()
+}
+
+func Test_cognitoConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name          string
+		env           map[string]string
+		wantNames     []string
+		wantGroupName string
+		wantErr       bool
+	}{
+		{
+			name:      "unset",
+			env:       map[string]string{},
+			wantNames: nil,
+		},
+		{
+			name: "attributes_and_group",
+			env: map[string]string{
+				"COGNITO_USER_ATTRIBUTES": `{"custom:plan": "{{.SubscriptionStatus}}", "custom:customer_type": "residential"}`,
+				"COGNITO_GROUP_NAME":      "customers",
+			},
+			wantNames:     []string{"custom:customer_type", "custom:plan"},
+			wantGroupName: "customers",
+		},
+		{
+			name: "invalid_json",
+			env: map[string]string{
+				"COGNITO_USER_ATTRIBUTES": `["custom:plan"]`,
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid_template",
+			env: map[string]string{
+				"COGNITO_USER_ATTRIBUTES": `{"custom:plan": "{{.SubscriptionStatus"}`,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		os.Unsetenv("COGNITO_USER_ATTRIBUTES")
+		os.Unsetenv("COGNITO_GROUP_NAME")
+		for k, v := range tt.env {
+			os.Setenv(k, v)
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cognitoConfigFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("cognitoConfigFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			var gotNames []string
+			for _, attribute := range got.Attributes {
+				gotNames = append(gotNames, attribute.Name)
+			}
+			if !reflect.DeepEqual(gotNames, tt.wantNames) {
+				t.Errorf("cognitoConfigFromEnv() names = %v, want %v", gotNames, tt.wantNames)
+			}
+			if got.GroupName != tt.wantGroupName {
+				t.Errorf("cognitoConfigFromEnv() group = %v, want %v", got.GroupName, tt.wantGroupName)
+			}
+		})
+	}
+	os.Unsetenv("COGNITO_USER_ATTRIBUTES")
+	os.Unsetenv("COGNITO_GROUP_NAME")
+}
+
+func Test_generateUserAttributes(t *testing.T) {
+	os.Setenv("COGNITO_USER_ATTRIBUTES", `{"custom:plan": "{{.SubscriptionStatus}}", "custom:onboarding_status": "COMPLETE"}`)
+	defer os.Unsetenv("COGNITO_USER_ATTRIBUTES")
+	cfg, err := cognitoConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := generateUserAttributes(cfg, createCustomerEvent{StripeCustomerID: "cus_01234", SubscriptionStatus: "trialing"})
+	if err != nil {
+		t.Fatalf("generateUserAttributes() error = %v", err)
+	}
+	want := []types.AttributeType{
+		{Name: aws.String("custom:stripe_customer_id"), Value: aws.String("cus_01234")},
+		{Name: aws.String("custom:onboarding_status"), Value: aws.String("COMPLETE")},
+		{Name: aws.String("custom:plan"), Value: aws.String("trialing")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("generateUserAttributes() = %v, want %v", got, want)
+	}
+}
+
+func Test_addUserToGroup(t *testing.T) {
+	tests := []struct {
+		name    string
+		cognito *mockAdminUpdateUserAttributes
+		wantErr bool
+	}{
+		{
+			name:    "added_to_group",
+			cognito: &mockAdminUpdateUserAttributes{},
+		},
+		{
+			name:    "group_failed",
+			cognito: &mockAdminUpdateUserAttributes{GroupError: fmt.Errorf("example error")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := addUserToGroup(context.TODO(), tt.cognito, "example", "customers", &createCustomerEvent{CognitoUserID: "56789"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("addUserToGroup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.cognito.groupRequests != 1 || aws.ToString(tt.cognito.GroupInput.GroupName) != "customers" ||
+				aws.ToString(tt.cognito.GroupInput.Username) != "56789" {
+				t.Errorf("addUserToGroup() requests = %v, last %+v", tt.cognito.groupRequests, tt.cognito.GroupInput)
+			}
+			if tt.cognito.Input != nil {
+				t.Errorf("addUserToGroup() updated attributes %+v", tt.cognito.Input)
+			}
+		})
+	}
+}
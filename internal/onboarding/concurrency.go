@@ -0,0 +1,65 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strconv"
+
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+	"github.com/seanturner026/maido-lambdas/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// concurrencyFromEnv reads ONBOARDING_MAX_CONCURRENCY, the most workers a stage runs at once. Unset or 0 runs a
+// worker for every request at once.
+func concurrencyFromEnv() (int, error) {
+	value, ok := os.LookupEnv("ONBOARDING_MAX_CONCURRENCY")
+	if !ok || value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("environment variable ONBOARDING_MAX_CONCURRENCY must be a non-negative integer, got %q", value)
+	}
+	return n, nil
+}
+
+// limiter bounds how many of a stage's workers run at once. A nil limiter does not bound them.
+type limiter chan struct{}
+
+func newLimiter(n int) limiter {
+	if n <= 0 {
+		return nil
+	}
+	return make(limiter, n)
+}
+
+// Go runs worker in a new goroutine, first waiting until fewer than the limit are running. Workers send their
+// results to buffered channels, so waiting here cannot block a running worker.
+func (l limiter) Go(worker func()) {
+	if l == nil {
+		go worker()
+		return
+	}
+	l <- struct{}{}
+	go func() {
+		defer func() { <-l }()
+		worker()
+	}()
+}
+
+// recoverWorker recovers a panic in the worker that defers it and passes it to report as an error, so that a
+// panic fails the worker's messages instead of the whole invocation. Workers defer it after wg.Done, so that it
+// reports before they are done.
+func recoverWorker(ctx context.Context, report func(err error)) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	err := fmt.Errorf("worker panicked: %v", r)
+	metrics.FromContext(ctx).Count(metricWorkerPanics, 1)
+	logging.FromContext(ctx).WithFields(log.Fields{"error": err, "stack": string(debug.Stack())}).Error("Recovered from a panic in a worker")
+	report(err)
+}
@@ -0,0 +1,99 @@
+package onboarding
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_concurrencyFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{name: "default", value: "", want: 0},
+		{name: "unbounded", value: "0", want: 0},
+		{name: "bounded", value: "50", want: 50},
+		{name: "negative", value: "-1", wantErr: true},
+		{name: "not_a_number", value: "example", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ONBOARDING_MAX_CONCURRENCY", tt.value)
+			got, err := concurrencyFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("concurrencyFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("concurrencyFromEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_limiter_Go(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int
+		workers int
+		wantMax int32
+	}{
+		{name: "unbounded", limit: 0, workers: 20, wantMax: 20},
+		{name: "bounded", limit: 3, workers: 20, wantMax: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workers := newLimiter(tt.limit)
+			wg := &sync.WaitGroup{}
+			wg.Add(tt.workers)
+			release := make(chan struct{})
+			var running, peak int32
+			count := tt.workers
+			go func() {
+				for i := 0; i < count; i++ {
+					workers.Go(func() {
+						defer wg.Done()
+						n := atomic.AddInt32(&running, 1)
+						for {
+							p := atomic.LoadInt32(&peak)
+							if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+								break
+							}
+						}
+						<-release
+						atomic.AddInt32(&running, -1)
+					})
+				}
+			}()
+			deadline := time.Now().Add(time.Second)
+			for atomic.LoadInt32(&peak) < tt.wantMax && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			close(release)
+			wg.Wait()
+			if peak != tt.wantMax {
+				t.Errorf("peak running workers = %v, want %v", peak, tt.wantMax)
+			}
+		})
+	}
+}
+
+func Test_recoverWorker(t *testing.T) {
+	ch := make(chan error, 1)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer recoverWorker(context.TODO(), func(err error) { ch <- err })
+		panic("example panic")
+	}()
+	wg.Wait()
+	close(ch)
+	if err := <-ch; err == nil || err.Error() != "worker panicked: example panic" {
+		t.Errorf("recoverWorker() reported %v, want the panic as an error", err)
+	}
+}
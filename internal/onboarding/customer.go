@@ -0,0 +1,208 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/audit"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+	"github.com/seanturner026/maido-lambdas/internal/metrics"
+	"github.com/seanturner026/maido-lambdas/internal/tracing"
+	log "github.com/sirupsen/logrus"
+	"github.com/stripe/stripe-go/v72"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+type createCustomerEvent struct {
+	PK                    string `dynamodbav:"PK"`
+	SK                    string `dynamodbav:"SK"`
+	GSI1PK                string `dynamodbav:"GSI1PK,omitempty"             json:"-"`
+	StripeCustomerID      string `dynamodbav:"StripeCustomerID"`
+	SubscriptionID        string `dynamodbav:"SubscriptionID,omitempty"`
+	SubscriptionStatus    string `dynamodbav:"SubscriptionStatus,omitempty"`
+	PromoCode             string `dynamodbav:"PromoCode,omitempty"          json:"promoCode"`
+	ReferralCode          string `dynamodbav:"ReferralCode,omitempty"       json:"referralCode"`
+	ReferrerCognitoUserID string `dynamodbav:"-" json:"-"`
+	SQSMessageID          string `dynamodbav:"-"`
+	SQSReceiptHandle      string `dynamodbav:"-"`
+	CognitoUserID         string `dynamodbav:"-"                            json:"cognitoUserID"`
+	FirstName             string `dynamodbav:"FirstName,encrypted"          json:"firstName"`
+	SurName               string `dynamodbav:"SurName,encrypted"            json:"surName"`
+	EmailAddress          string `dynamodbav:"EmailAddress,encrypted"       json:"email"`
+	SkipSubscription      bool   `dynamodbav:"-"                            json:"skipSubscription"`
+	CorrelationID         string `dynamodbav:"-"                            json:"correlationId"`
+	OnboardingStatus      string `dynamodbav:"OnboardingStatus,omitempty"   json:"-"`
+	Version               int    `dynamodbav:"-"                            json:"-"`
+}
+
+type resultStripe struct {
+	Message                 string
+	Event                   createCustomerEvent
+	PutRequestInput         map[string]types.AttributeValue
+	ReferralPutRequestInput map[string]types.AttributeValue
+	Error                   error
+}
+
+type stripeCustomerCreateAPI interface {
+	New(params *stripe.CustomerParams) (*stripe.Customer, error)
+}
+
+func createCustomers(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	ch chan resultStripe,
+	apiStripe stripeCustomerCreateAPI,
+	apiPromotionCode stripePromotionCodeListAPI,
+	event *createCustomerEvent,
+) {
+	defer wg.Done()
+	defer recoverWorker(logging.WithMessage(ctx, event.SQSMessageID, event.CorrelationID), func(err error) {
+		ch <- resultStripe{Message: "Unable to create Stripe customer", Event: *event, Error: err}
+	})
+	m := metrics.FromContext(ctx)
+	ctx, span := tracing.Start(ctx, "message", semconv.MessagingMessageIDKey.String(event.SQSMessageID))
+	defer span.End()
+	ctx = logging.WithMessage(ctx, event.SQSMessageID, event.CorrelationID)
+	promotionCodeID, couponID := "", ""
+	if event.PromoCode != "" {
+		_, spanPromotionCode := tracing.Start(ctx, "stripe.PromotionCodes.List")
+		promotionCode, err := lookupPromotionCode(ctx, apiPromotionCode, event.PromoCode)
+		tracing.End(spanPromotionCode, err)
+		if err != nil {
+			ch <- resultStripe{Message: "Unable to look up promotion code", Event: *event, Error: err}
+			return
+		}
+		if promotionCode == nil {
+			logging.FromContext(ctx).WithFields(log.Fields{"cognito_user_id": event.CognitoUserID, "promo_code": event.PromoCode}).
+				Warn("Promotion code is not redeemable, creating customer without it")
+			event.PromoCode = ""
+		} else {
+			promotionCodeID, couponID = promotionCode.ID, promotionCode.Coupon.ID
+		}
+	}
+	_, spanCustomer := tracing.Start(ctx, "stripe.Customers.New")
+	stripeCustomerID, err := createCustomer(ctx, apiStripe, event.CognitoUserID, event.EmailAddress, fmt.Sprintf("%s %s", event.FirstName, event.SurName), promotionCodeID)
+	tracing.End(spanCustomer, err)
+	if err != nil {
+		m.Count(metricStripeCustomersFailed, 1)
+		ch <- resultStripe{Message: "Unable to create Stripe customer", Event: *event, Error: err}
+		return
+	}
+	m.Count(metricStripeCustomersCreated, 1)
+	event.StripeCustomerID = stripeCustomerID
+	customerAfter := map[string]string{"StripeCustomerID": stripeCustomerID}
+	if promotionCodeID != "" {
+		customerAfter["PromotionCodeID"] = promotionCodeID
+		customerAfter["CouponID"] = couponID
+	}
+	recordAudit(ctx, event.CognitoUserID, audit.Change{
+		Action:  auditStripeCustomerCreated,
+		Targets: map[string]string{"StripeCustomerID": stripeCustomerID},
+		After:   customerAfter,
+	})
+	logging.FromContext(ctx).WithFields(log.Fields{"cognito_user_id": event.CognitoUserID, "stripe_customer_id": stripeCustomerID}).Info("Created stripe customer ID")
+	ch <- resultStripe{Event: *event}
+}
+
+// idempotencyKey is the Stripe idempotency key of the call stage makes for cognitoUserID. Messages are delivered
+// at least once and released when an invocation runs out of time, so a stage can run again after Stripe made
+// its call; the key makes Stripe return what it created the first time instead of creating it again.
+func idempotencyKey(stage, cognitoUserID string) string {
+	return "onboarding-" + stage + "-" + cognitoUserID
+}
+
+// createCustomer creates cognitoUserID's Stripe customer, giving up when ctx is done. A promotionCodeID applies
+// the promotion code, so that Stripe counts the redemption against it.
+func createCustomer(
+	ctx context.Context,
+	api stripeCustomerCreateAPI,
+	cognitoUserID, customerEmail, customerName, promotionCodeID string,
+) (string, error) {
+	params := &stripe.CustomerParams{
+		Params: stripe.Params{Context: ctx},
+		Email:  stripe.String(customerEmail),
+		InvoiceSettings: &stripe.CustomerInvoiceSettingsParams{
+			CustomFields:         []*stripe.CustomerInvoiceCustomFieldParams{},
+			DefaultPaymentMethod: new(string),
+		},
+		Name:      stripe.String(customerName),
+		Source:    &stripe.SourceParams{},
+		Tax:       &stripe.CustomerTaxParams{},
+		TaxExempt: stripe.String("none"),
+	}
+	if promotionCodeID != "" {
+		params.PromotionCode = stripe.String(promotionCodeID)
+	}
+	params.SetIdempotencyKey(idempotencyKey(stageStripe, cognitoUserID))
+	customer, err := api.New(params)
+	if err != nil {
+		return "", err
+	}
+	customerID := customer.ID
+	return customerID, nil
+}
+
+// newResultStripe prepares the DynamoDB writes for an event whose Stripe customer exists.
+func newResultStripe(ctx context.Context, event createCustomerEvent) resultStripe {
+	putRequestInput, err := generatePutRequestInput(ctx, event)
+	if err != nil {
+		return resultStripe{
+			Message: "Unable to generate DynamoDB input",
+			Event:   event,
+			Error:   err,
+		}
+	}
+	result := resultStripe{PutRequestInput: putRequestInput, Event: event}
+	if event.ReferralCode != "" {
+		result.ReferralPutRequestInput, err = generateReferralPutRequestInput(event, time.Now())
+		if err != nil {
+			return resultStripe{
+				Message: "Unable to generate DynamoDB referral input",
+				Event:   event,
+				Error:   err,
+			}
+		}
+	}
+	return result
+}
+
+// stripeStage creates the Stripe customer of each event.
+type stripeStage struct {
+	workers limiter
+	stripe  StripeAPI
+}
+
+func newStripeStage(cfg pipelineConfig) (Stage, error) {
+	return stripeStage{workers: cfg.workers, stripe: cfg.handler.Stripe}, nil
+}
+
+func (s stripeStage) Name() string {
+	return stageStripe
+}
+
+func (s stripeStage) Status() string {
+	return onboardingStatusStripeCreated
+}
+
+func (s stripeStage) Run(ctx context.Context, events []*createCustomerEvent) stageResult {
+	wg := &sync.WaitGroup{}
+	wg.Add(len(events))
+	ch := make(chan resultStripe, len(events))
+	for _, event := range events {
+		event := event
+		s.workers.Go(func() { createCustomers(ctx, wg, ch, s.stripe.Customers, s.stripe.PromotionCodes, event) })
+	}
+	wg.Wait()
+	close(ch)
+	result := stageResult{Failed: map[string]error{}}
+	for res := range ch {
+		if res.Error != nil {
+			result.Failed[res.Event.SQSMessageID] = res.Error
+			logging.FromContext(ctx).WithFields(log.Fields{"cognito_user_id": res.Event.CognitoUserID, "promo_code": res.Event.PromoCode, "error": res.Error}).Error(res.Message)
+		}
+	}
+	return result
+}
@@ -0,0 +1,147 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+type mockStripeCustomer struct {
+	Response *stripe.Customer
+	Error    error
+}
+
+func (m mockStripeCustomer) New(params *stripe.CustomerParams) (*stripe.Customer, error) {
+	return m.Response, m.Error
+}
+
+func Test_createCustomers(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	type args struct {
+		wg               *sync.WaitGroup
+		ch               chan resultStripe
+		apiStripe        stripeCustomerCreateAPI
+		apiPromotionCode stripePromotionCodeListAPI
+		event            *createCustomerEvent
+	}
+	tests := []struct {
+		name string
+		args args
+	}{
+		{
+			name: "",
+			args: args{
+				wg: wg,
+				ch: make(chan resultStripe, 1),
+				apiStripe: mockStripeCustomer{
+					Response: &stripe.Customer{
+						ID: "01234567890",
+					},
+				},
+				event: &createCustomerEvent{
+					CognitoUserID: "01234567890",
+					FirstName:     "first",
+					SurName:       "last",
+					EmailAddress:  "example@example.com",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.args.wg.Add(1)
+			go createCustomers(context.TODO(), tt.args.wg, tt.args.ch, tt.args.apiStripe, tt.args.apiPromotionCode, tt.args.event)
+		})
+	}
+	wg.Wait()
+}
+
+// panickingStripeCustomer panics instead of creating a customer.
+type panickingStripeCustomer struct{}
+
+func (panickingStripeCustomer) New(params *stripe.CustomerParams) (*stripe.Customer, error) {
+	panic("example panic")
+}
+
+func Test_createCustomers_panic(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	ch := make(chan resultStripe, 1)
+	event := &createCustomerEvent{SQSMessageID: "message-1", CognitoUserID: "01234567890"}
+	go createCustomers(context.TODO(), wg, ch, panickingStripeCustomer{}, nil, event)
+	wg.Wait()
+	close(ch)
+	res, ok := <-ch
+	if !ok || res.Error == nil || res.Event.SQSMessageID != "message-1" {
+		t.Errorf("createCustomers() sent %+v, want the panic as the message's error", res)
+	}
+}
+
+func Test_createCustomer(t *testing.T) {
+	type args struct {
+		api             mockStripeCustomer
+		customerEmail   string
+		customerName    string
+		promotionCodeID string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "",
+			args: args{
+				api: mockStripeCustomer{
+					Response: &stripe.Customer{
+						ID: "01234567890",
+					},
+					Error: nil,
+				},
+				customerEmail: "foo.bar@gmail.com",
+				customerName:  "Boo Far",
+			},
+			want:    "01234567890",
+			wantErr: false,
+		},
+		{
+			name: "",
+			args: args{
+				api: mockStripeCustomer{
+					Response: nil,
+					Error:    fmt.Errorf("example error"),
+				},
+				customerEmail: "",
+				customerName:  "",
+			},
+			want:    "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := createCustomer(context.TODO(), tt.args.api, "01234567890", tt.args.customerEmail, tt.args.customerName, tt.args.promotionCodeID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("createCustomer() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("createCustomer() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_createCustomer_idempotencyKey(t *testing.T) {
+	api := &mockStripeCustomerParams{}
+	if _, err := createCustomer(context.TODO(), api, "01234567890", "foo.bar@gmail.com", "Boo Far", ""); err != nil {
+		t.Fatal(err)
+	}
+	if key := api.Params.IdempotencyKey; key == nil || *key != "onboarding-stripe-01234567890" {
+		t.Errorf("createCustomer() idempotency key = %v, want onboarding-stripe-01234567890", stripe.StringValue(key))
+	}
+}
@@ -0,0 +1,43 @@
+package onboarding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// defaultDeadlineMargin leaves time after the stages stop to record statuses and delete or release messages.
+const defaultDeadlineMargin = 3 * time.Second
+
+// deadlineMarginFromEnv reads ONBOARDING_DEADLINE_MARGIN, how long before the Lambda times out the stages stop.
+func deadlineMarginFromEnv() (time.Duration, error) {
+	value, ok := os.LookupEnv("ONBOARDING_DEADLINE_MARGIN")
+	if !ok || value == "" {
+		return defaultDeadlineMargin, nil
+	}
+	margin, err := time.ParseDuration(value)
+	if err != nil || margin < 0 {
+		return 0, fmt.Errorf("environment variable ONBOARDING_DEADLINE_MARGIN must be a non-negative duration, got %q", value)
+	}
+	return margin, nil
+}
+
+// withDeadlineMargin returns a context that is done margin before ctx's deadline. A ctx without a deadline, as
+// when running outside Lambda, is only given a cancel function.
+func withDeadlineMargin(ctx context.Context, margin time.Duration) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, deadline.Add(-margin))
+}
+
+// unfinished reports whether err is ctx running out, rather than the message failing on its own. Unfinished
+// messages are released for another invocation to pick up straight away. That invocation runs the cut-short stage
+// again even when its Stripe call went through, which is safe because the calls carry idempotency keys: Stripe
+// returns what it created the first time instead of creating it again.
+func unfinished(ctx context.Context, err error) bool {
+	return ctx.Err() != nil && errors.Is(err, ctx.Err())
+}
@@ -0,0 +1,77 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_deadlineMarginFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "default", value: "", want: defaultDeadlineMargin},
+		{name: "none", value: "0s", want: 0},
+		{name: "set", value: "1500ms", want: 1500 * time.Millisecond},
+		{name: "negative", value: "-1s", wantErr: true},
+		{name: "not_a_duration", value: "5", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ONBOARDING_DEADLINE_MARGIN", tt.value)
+			got, err := deadlineMarginFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("deadlineMarginFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("deadlineMarginFromEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_withDeadlineMargin(t *testing.T) {
+	deadline := time.Now().Add(time.Hour)
+	ctx, cancel := context.WithDeadline(context.TODO(), deadline)
+	defer cancel()
+	work, cancelWork := withDeadlineMargin(ctx, 3*time.Second)
+	defer cancelWork()
+	if got, ok := work.Deadline(); !ok || !got.Equal(deadline.Add(-3*time.Second)) {
+		t.Errorf("withDeadlineMargin() deadline = %v, want %v", got, deadline.Add(-3*time.Second))
+	}
+
+	work, cancelWork = withDeadlineMargin(context.TODO(), 3*time.Second)
+	if _, ok := work.Deadline(); ok {
+		t.Error("withDeadlineMargin() set a deadline on a context without one")
+	}
+	cancelWork()
+	if work.Err() == nil {
+		t.Error("withDeadlineMargin() cancel did not cancel the context")
+	}
+}
+
+func Test_unfinished(t *testing.T) {
+	done, cancel := context.WithCancel(context.TODO())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{name: "cut_short", ctx: done, err: fmt.Errorf("example: %w", context.Canceled), want: true},
+		{name: "failed_after_deadline", ctx: done, err: fmt.Errorf("example error"), want: false},
+		{name: "own_timeout", ctx: context.TODO(), err: context.DeadlineExceeded, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unfinished(tt.ctx, tt.err); got != tt.want {
+				t.Errorf("unfinished() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
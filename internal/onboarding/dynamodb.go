@@ -0,0 +1,305 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/awsbatch"
+	"github.com/seanturner026/maido-lambdas/internal/batch"
+	"github.com/seanturner026/maido-lambdas/internal/envelope"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+	"github.com/seanturner026/maido-lambdas/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxBatchWriteItemAttempts bounds how often puts DynamoDB leaves unprocessed are sent. Puts still unprocessed
+// after the last attempt fail their messages, which SQS delivers again.
+const maxBatchWriteItemAttempts = 5
+
+// generatePutRequests collects the puts of the successful results, along with every successful result.
+func generatePutRequests(chanStripe chan resultStripe) ([]types.WriteRequest, items, string, error) {
+	tableName, ok := os.LookupEnv("DYNAMODB_TABLE_NAME")
+	if !ok {
+		return []types.WriteRequest{}, items{}, "", fmt.Errorf("environment variable DYNAMODB_TABLE_NAME is not set")
+	}
+	writeRequests := []types.WriteRequest{}
+	items := &items{}
+	for res := range chanStripe {
+		if res.Error != nil {
+			log.WithFields(log.Fields{"error": res.Error}).Error(res.Message)
+			continue // TODO handle better
+		}
+		items.Items = append(items.Items, res.Event)
+		// Results without a put input have their user item written with UpdateItem instead.
+		if res.PutRequestInput != nil {
+			writeRequests = append(writeRequests, types.WriteRequest{PutRequest: &types.PutRequest{Item: res.PutRequestInput}})
+		}
+		if res.ReferralPutRequestInput != nil {
+			writeRequests = append(writeRequests, types.WriteRequest{PutRequest: &types.PutRequest{Item: res.ReferralPutRequestInput}})
+		}
+	}
+	return writeRequests, *items, tableName, nil
+}
+
+// generatePutRequestInput marshals item for a put, encrypting its personal data when encryption is enabled.
+func generatePutRequestInput(ctx context.Context, item createCustomerEvent) (map[string]types.AttributeValue, error) {
+	item.PK = keySchema.UserPK(item.CognitoUserID)
+	item.SK = keySchema.UserSortKey
+	item.GSI1PK = keySchema.StripePK(item.StripeCustomerID)
+	// The put replaces the whole item, so it carries the status the earlier stages left it in. The move to
+	// PERSISTED is a separate conditional write once the put has succeeded.
+	putItemInput, err := envelope.FromContext(ctx).MarshalMap(ctx, item)
+	if err != nil {
+		return map[string]types.AttributeValue{}, err
+	}
+	return putItemInput, err
+}
+
+func extractCognitoUserIDSFromWriteRequests(writeRequests []types.WriteRequest) ([]string, error) {
+	type cognitoUser struct {
+		PK string `dynamodbav:"PK"`
+		SK string `dynamodbav:"SK"`
+	}
+	cognitoUserIDS := []string{}
+	for _, item := range writeRequests {
+		id := &cognitoUser{}
+		err := attributevalue.UnmarshalMap(item.PutRequest.Item, id)
+		if err != nil {
+			return cognitoUserIDS, err
+		}
+		// Referral items are keyed on the referral code and carry the user in their sort key.
+		cognitoUserID, ok := keySchema.CognitoUserID(id.PK, id.SK)
+		if !ok {
+			return cognitoUserIDS, fmt.Errorf("unable to find a Cognito user ID in item %s/%s", id.PK, id.SK)
+		}
+		cognitoUserIDS = append(cognitoUserIDS, cognitoUserID)
+	}
+	return cognitoUserIDS, nil
+}
+
+type resultDB struct {
+	Message string
+	UserIDS []string
+	Retries int
+	Error   error
+}
+
+type awsDynamoDBAPI = awsbatch.BatchWriteItemAPI
+
+// resends counts the calls batchWriteItems made beyond one per batch, the number of times a batch was sent again.
+func resends(results []batch.Result[types.WriteRequest]) int {
+	total := 0
+	for _, chunk := range batch.Chunk(results, awsbatch.MaxBatchWriteItemRequests) {
+		attempts := 0
+		for _, res := range chunk {
+			if res.Attempts > attempts {
+				attempts = res.Attempts
+			}
+		}
+		if attempts > 1 {
+			total += attempts - 1
+		}
+	}
+	return total
+}
+
+// batchWriteItems writes writeRequests in batches of up to awsbatch.MaxBatchWriteItemRequests, at most concurrency at once,
+// and sends a result for each distinct failure and one for the retries of unprocessed puts.
+func batchWriteItems(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	ch chan resultDB,
+	db awsDynamoDBAPI,
+	writeRequests []types.WriteRequest,
+	tableName string,
+	concurrency int,
+) {
+	defer wg.Done()
+	defer recoverWorker(ctx, func(err error) {
+		userIDs, _ := extractCognitoUserIDSFromWriteRequests(writeRequests)
+		ch <- resultDB{Error: err, UserIDS: userIDs, Message: "Error writing Stripe Customer IDs to dynamodb"}
+	})
+	m := metrics.FromContext(ctx)
+	opts := batch.Options{Concurrency: concurrency, MaxAttempts: maxBatchWriteItemAttempts}
+	results := awsbatch.WriteItems(ctx, db, tableName, writeRequests, opts, func(chunk, unprocessed []types.WriteRequest) {
+		m.Count(metricDynamoDBItemsWritten, len(chunk)-len(unprocessed))
+		auditWrittenItems(ctx, tableName, awsbatch.Written(chunk, unprocessed))
+	})
+	resent, failed := 0, 0
+	failures := map[string]*resultDB{}
+	order := []string{}
+	for _, res := range results {
+		resent += res.Attempts - 1
+		if res.Err == nil {
+			continue
+		}
+		failed++
+		cognitoUserIDs, err := extractCognitoUserIDSFromWriteRequests([]types.WriteRequest{res.Item})
+		if err != nil {
+			log.Error("Error batch writing and extracting cognito user IDs from input object")
+		}
+		failure, ok := failures[res.Err.Error()]
+		if !ok {
+			failure = &resultDB{Error: res.Err, Message: "Error writing Stripe Customer IDs to dynamodb"}
+			failures[res.Err.Error()] = failure
+			order = append(order, res.Err.Error())
+		}
+		failure.UserIDS = append(failure.UserIDS, cognitoUserIDs...)
+	}
+	if failed > 0 {
+		m.Count(metricDynamoDBItemsFailed, failed)
+	}
+	if resent > 0 {
+		m.Count(metricDynamoDBUnprocessedRetried, resent)
+	}
+	for _, key := range order {
+		ch <- *failures[key]
+	}
+	// Successful writes only report back when they needed retries, so the invocation summary can count them.
+	if retries := resends(results); retries > 0 {
+		ch <- resultDB{Retries: retries}
+	}
+}
+
+// dynamoDBStage writes each event's user item, and its referral when it has one, as DYNAMODB_WRITE_MODE chooses.
+type dynamoDBStage struct {
+	workers     limiter
+	concurrency int
+	db          DynamoDBAPI
+	tableName   string
+	writeMode   string
+}
+
+func newDynamoDBStage(cfg pipelineConfig) (Stage, error) {
+	return dynamoDBStage{
+		workers:     cfg.workers,
+		concurrency: cfg.concurrency,
+		db:          cfg.handler.DynamoDB,
+		tableName:   cfg.tableName,
+		writeMode:   cfg.writeMode,
+	}, nil
+}
+
+func (s dynamoDBStage) Name() string {
+	return stageDynamoDB
+}
+
+func (s dynamoDBStage) Status() string {
+	return onboardingStatusPersisted
+}
+
+// resolveReferrers looks up the referrer of each event with a referral code, returning the users whose lookup
+// failed.
+func (s dynamoDBStage) resolveReferrers(ctx context.Context, events []*createCustomerEvent) map[string]error {
+	referred := []*createCustomerEvent{}
+	for _, event := range events {
+		if event.ReferralCode != "" {
+			referred = append(referred, event)
+		}
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(len(referred))
+	ch := make(chan resultDB, len(referred))
+	for _, event := range referred {
+		event := event
+		s.workers.Go(func() { resolveReferrer(ctx, wg, ch, s.db, s.tableName, event) })
+	}
+	wg.Wait()
+	close(ch)
+	failed := map[string]error{}
+	for res := range ch {
+		for _, userID := range res.UserIDS {
+			failed[userID] = res.Error
+		}
+		logging.FromContext(ctx).WithFields(log.Fields{"cognito_user_ids": res.UserIDS, "error": res.Error}).Error(res.Message)
+	}
+	return failed
+}
+
+func (s dynamoDBStage) Run(ctx context.Context, events []*createCustomerEvent) stageResult {
+	failed := s.resolveReferrers(ctx, events)
+	results := make(chan resultStripe, len(events))
+	// Only the first event of a user is written: duplicate messages carry the same user, a batch may not put an
+	// item twice, and failuresByUser reports the outcome of the kept event for every message of the user.
+	seen := map[string]bool{}
+	for _, event := range events {
+		if _, ok := failed[event.CognitoUserID]; ok || seen[event.CognitoUserID] {
+			continue
+		}
+		seen[event.CognitoUserID] = true
+		res := newResultStripe(ctx, *event)
+		if res.Error != nil {
+			failed[event.CognitoUserID] = res.Error
+			logging.FromContext(ctx).WithFields(log.Fields{
+				"cognito_user_id":    event.CognitoUserID,
+				"stripe_customer_id": event.StripeCustomerID,
+				"referral_code":      event.ReferralCode,
+				"error":              res.Error,
+			}).Error(res.Message)
+			continue
+		}
+		switch s.writeMode {
+		case writeModeUpdate:
+			res.PutRequestInput = nil
+		case writeModeTransaction:
+			res.PutRequestInput = nil
+			res.ReferralPutRequestInput = nil
+		}
+		results <- res
+	}
+	close(results)
+	puts, written, _, err := generatePutRequests(results)
+	if err != nil {
+		for _, event := range events {
+			failed[event.CognitoUserID] = err
+		}
+		return stageResult{Failed: failuresByUser(events, failed)}
+	}
+	userUpdates := []createCustomerEvent{}
+	transactions := [][]createCustomerEvent{}
+	switch s.writeMode {
+	case writeModeUpdate:
+		userUpdates = written.Items
+	case writeModeTransaction:
+		transactions = generateTransactionBatches(written.Items)
+	}
+	requestCount := len(userUpdates) + len(transactions)
+	if len(puts) > 0 {
+		requestCount++
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(requestCount)
+	// The batch writes send a result for each distinct failure, so the buffer leaves room for one per put.
+	ch := make(chan resultDB, requestCount+len(puts))
+	if len(puts) > 0 {
+		// The batches bound their own concurrency, so waiting for them does not take a worker.
+		go batchWriteItems(ctx, wg, ch, s.db, puts, s.tableName, s.concurrency)
+	}
+	for _, item := range userUpdates {
+		item := item
+		s.workers.Go(func() { updateUserItem(ctx, wg, ch, s.db, s.tableName, item) })
+	}
+	for _, transaction := range transactions {
+		transaction := transaction
+		s.workers.Go(func() { transactWriteItems(ctx, wg, ch, s.db, s.tableName, transaction) })
+	}
+	wg.Wait()
+	close(ch)
+	result := stageResult{}
+	for res := range ch {
+		result.Retries += res.Retries
+		if res.Error == nil {
+			continue
+		}
+		for _, userID := range res.UserIDS {
+			failed[userID] = res.Error
+		}
+		logging.FromContext(ctx).WithFields(log.Fields{"cognito_user_ids": res.UserIDS, "error": res.Error}).Error(res.Message)
+	}
+	result.Failed = failuresByUser(events, failed)
+	return result
+}
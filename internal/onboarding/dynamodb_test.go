@@ -0,0 +1,513 @@
+package onboarding
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/awsbatch"
+	"github.com/seanturner026/maido-lambdas/internal/envelope"
+	"github.com/seanturner026/maido-lambdas/internal/fakes"
+	"github.com/seanturner026/maido-lambdas/internal/golden"
+	log "github.com/sirupsen/logrus"
+)
+
+func Test_generatePutRequests(t *testing.T) {
+	const tableName = "example_table_name"
+	customerEvent := &createCustomerEvent{
+		PK:               "USER#56789",
+		SK:               "USER#MAIDO",
+		StripeCustomerID: "01234",
+		SQSMessageID:     "123456789",
+		SQSReceiptHandle: "23456789",
+		CognitoUserID:    "56789",
+		FirstName:        "first_example",
+		SurName:          "sur_example",
+		EmailAddress:     "example@example.com",
+	}
+	type args struct {
+		chanStripe chan resultStripe
+	}
+	putRequestInput := map[string]types.AttributeValue{
+		"PK":               &types.AttributeValueMemberS{Value: "USER#56789"},
+		"SK":               &types.AttributeValueMemberS{Value: "USER#MAIDO"},
+		"FirstName":        &types.AttributeValueMemberS{Value: "first_example"},
+		"SurName":          &types.AttributeValueMemberS{Value: "sur_example"},
+		"EmailAddress":     &types.AttributeValueMemberS{Value: "example@example.com"},
+		"StripeCustomerID": &types.AttributeValueMemberS{Value: "01234"},
+	}
+	chanInput := &resultStripe{
+		Message:         "example",
+		Event:           *customerEvent,
+		PutRequestInput: putRequestInput,
+		Error:           nil,
+	}
+	zeroItemsChanStripe := make(chan resultStripe)
+	oneItemsChanStripe := make(chan resultStripe, 1)
+	oneItemsChanStripe <- *chanInput
+	twoItemsChanStripe := make(chan resultStripe, 2)
+	twoItemsChanStripe <- *chanInput
+	twoItemsChanStripe <- *chanInput
+	twentySixItemsChanStripe := make(chan resultStripe, 26)
+	for i := 0; i < 26; i++ {
+		twentySixItemsChanStripe <- *chanInput
+	}
+	close(zeroItemsChanStripe)
+	close(oneItemsChanStripe)
+	close(twoItemsChanStripe)
+	close(twentySixItemsChanStripe)
+	tests := []struct {
+		name    string
+		args    args
+		want1   items
+		want2   string
+		wantErr bool
+	}{
+		{
+			name: "0_items",
+			args: args{
+				chanStripe: zeroItemsChanStripe,
+			},
+			want1:   items{},
+			want2:   tableName,
+			wantErr: false,
+		},
+		{
+			name: "1_item",
+			args: args{
+				chanStripe: oneItemsChanStripe,
+			},
+			want1: items{
+				Items: []createCustomerEvent{*customerEvent},
+			},
+			want2:   tableName,
+			wantErr: false,
+		},
+		{
+			name: "2_items",
+			args: args{
+				chanStripe: twoItemsChanStripe,
+			},
+			want1: items{
+				Items: []createCustomerEvent{*customerEvent, *customerEvent},
+			},
+			want2:   tableName,
+			wantErr: false,
+		},
+		{
+			name: "26_items",
+			args: args{
+				chanStripe: twentySixItemsChanStripe,
+			},
+			want1: items{
+				Items: []createCustomerEvent{
+					*customerEvent, *customerEvent, *customerEvent, *customerEvent, *customerEvent,
+					*customerEvent, *customerEvent, *customerEvent, *customerEvent, *customerEvent,
+					*customerEvent, *customerEvent, *customerEvent, *customerEvent, *customerEvent,
+					*customerEvent, *customerEvent, *customerEvent, *customerEvent, *customerEvent,
+					*customerEvent, *customerEvent, *customerEvent, *customerEvent, *customerEvent,
+					*customerEvent,
+				},
+			},
+			want2:   tableName,
+			wantErr: false,
+		},
+		{
+			name: "no_environment_variable",
+			args: args{
+				chanStripe: zeroItemsChanStripe,
+			},
+			want1:   items{},
+			want2:   "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		if tt.name == "no_environment_variable" {
+			os.Unsetenv("DYNAMODB_TABLE_NAME")
+		} else {
+			err := os.Setenv("DYNAMODB_TABLE_NAME", tableName)
+			if err != nil {
+				t.Fatal("error setting DYNAMODB_TABLE_NAME environment variable")
+			}
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			got, got1, got2, err := generatePutRequests(tt.args.chanStripe)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("generatePutRequests() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				golden.Assert(t, got)
+			}
+			if !reflect.DeepEqual(got1, tt.want1) {
+				t.Errorf("generatePutRequests() got1 = %v, want %v", got1, tt.want1)
+			}
+			if got2 != tt.want2 {
+				t.Errorf("generatePutRequests() got2 = %v, want %v", got2, tt.want2)
+			}
+		})
+	}
+}
+
+// Fuzz_batchWriteItems builds one Stripe result per byte of results, where the byte's bits mark the result as
+// failed (1), written with UpdateItem instead of a put (4), or carrying a referral (8). It checks that every
+// successful result is returned, that every put is written in exactly one BatchWriteItem call, and that no call is
+// empty or over the DynamoDB limit.
+func Fuzz_batchWriteItems(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0, 0, 0})
+	f.Add([]byte{1, 8, 8, 2, 4, 0, 8})
+	f.Add(bytes.Repeat([]byte{8}, 13))
+	f.Add(bytes.Repeat([]byte{0, 1, 8}, 30))
+	os.Setenv("DYNAMODB_TABLE_NAME", "example_table_name")
+	logOutput := log.StandardLogger().Out
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logOutput)
+	f.Fuzz(func(t *testing.T, results []byte) {
+		chanStripe := make(chan resultStripe, len(results))
+		wantPuts := map[string]bool{}
+		wantItems := []string{}
+		for i, flags := range results {
+			userID := strconv.Itoa(i)
+			res := resultStripe{Event: createCustomerEvent{CognitoUserID: userID}}
+			if flags&1 != 0 {
+				res.Message = "example"
+				res.Error = fmt.Errorf("example error")
+				chanStripe <- res
+				continue
+			}
+			wantItems = append(wantItems, userID)
+			if flags&4 == 0 {
+				res.PutRequestInput = map[string]types.AttributeValue{
+					"PK": &types.AttributeValueMemberS{Value: "USER#" + userID},
+					"SK": &types.AttributeValueMemberS{Value: "USER#MAIDO"},
+				}
+			}
+			if flags&8 != 0 {
+				res.ReferralPutRequestInput = map[string]types.AttributeValue{
+					"PK": &types.AttributeValueMemberS{Value: "REFERRAL#" + userID},
+					"SK": &types.AttributeValueMemberS{Value: "USER#" + userID},
+				}
+			}
+			for _, item := range []map[string]types.AttributeValue{res.PutRequestInput, res.ReferralPutRequestInput} {
+				if item != nil {
+					wantPuts[item["PK"].(*types.AttributeValueMemberS).Value] = true
+				}
+			}
+			chanStripe <- res
+		}
+		close(chanStripe)
+		puts, gotItems, tableName, err := generatePutRequests(chanStripe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(gotItems.Items) != len(wantItems) {
+			t.Fatalf("generatePutRequests() items = %v, want %v", len(gotItems.Items), len(wantItems))
+		}
+		for i, item := range gotItems.Items {
+			if item.CognitoUserID != wantItems[i] {
+				t.Errorf("item %d = %s, want %s", i, item.CognitoUserID, wantItems[i])
+			}
+		}
+		db := fakes.NewDynamoDB()
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		ch := make(chan resultDB, len(puts)+1)
+		batchWriteItems(context.TODO(), wg, ch, db, puts, tableName, 4)
+		close(ch)
+		for res := range ch {
+			if res.Error != nil {
+				t.Errorf("batchWriteItems() error = %v", res.Error)
+			}
+		}
+		seen := map[string]int{}
+		calls := db.Calls("BatchWriteItem")
+		for i, call := range calls {
+			input := call.Input.(*dynamodb.BatchWriteItemInput)
+			writes := input.RequestItems[tableName]
+			if len(input.RequestItems) != 1 || len(writes) == 0 || len(writes) > awsbatch.MaxBatchWriteItemRequests {
+				t.Errorf("call %d has %d tables and %d writes", i, len(input.RequestItems), len(writes))
+			}
+			for _, write := range writes {
+				seen[write.PutRequest.Item["PK"].(*types.AttributeValueMemberS).Value]++
+			}
+		}
+		for pk := range wantPuts {
+			if seen[pk] != 1 {
+				t.Errorf("put %s is in %d calls, want 1", pk, seen[pk])
+			}
+		}
+		if len(seen) != len(wantPuts) {
+			t.Errorf("batchWriteItems() wrote %v items, want %v", len(seen), len(wantPuts))
+		}
+		if want := (len(wantPuts) + awsbatch.MaxBatchWriteItemRequests - 1) / awsbatch.MaxBatchWriteItemRequests; len(calls) != want {
+			t.Errorf("batchWriteItems() made %v calls for %v puts, want %v", len(calls), len(wantPuts), want)
+		}
+	})
+}
+
+func Test_generatePutRequests_referrals(t *testing.T) {
+	const tableName = "example_table_name"
+	userItem := map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "USER#56789"},
+	}
+	referralItem := map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "REFERRAL#FRIEND42"},
+	}
+	chanStripe := make(chan resultStripe, 14)
+	chanStripe <- resultStripe{Message: "example", Error: fmt.Errorf("example error")}
+	for i := 0; i < 13; i++ {
+		chanStripe <- resultStripe{PutRequestInput: userItem, ReferralPutRequestInput: referralItem}
+	}
+	close(chanStripe)
+	err := os.Setenv("DYNAMODB_TABLE_NAME", tableName)
+	if err != nil {
+		t.Fatal("error setting DYNAMODB_TABLE_NAME environment variable")
+	}
+	got, gotItems, _, err := generatePutRequests(chanStripe)
+	if err != nil {
+		t.Fatalf("generatePutRequests() error = %v", err)
+	}
+	if len(gotItems.Items) != 13 {
+		t.Errorf("generatePutRequests() items = %v, want 13", len(gotItems.Items))
+	}
+	if len(got) != 26 {
+		t.Fatalf("generatePutRequests() = %v puts, want 26", len(got))
+	}
+	if !reflect.DeepEqual(got[1].PutRequest.Item, referralItem) {
+		t.Errorf("generatePutRequests() second write = %v, want referral item", got[1].PutRequest.Item)
+	}
+}
+
+func Test_generatePutRequestInput(t *testing.T) {
+	type args struct {
+		item createCustomerEvent
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    map[string]types.AttributeValue
+		wantErr bool
+	}{
+		{
+			name: "",
+			args: args{
+				item: createCustomerEvent{
+					PK:               "",
+					SK:               "",
+					StripeCustomerID: "01234",
+					CognitoUserID:    "56789",
+					FirstName:        "first_example",
+					SurName:          "sur_example",
+					EmailAddress:     "example@example.com",
+					OnboardingStatus: onboardingStatusStripeCreated,
+				},
+			},
+			want: map[string]types.AttributeValue{
+				"PK":               &types.AttributeValueMemberS{Value: "USER#56789"},
+				"SK":               &types.AttributeValueMemberS{Value: "USER#MAIDO"},
+				"GSI1PK":           &types.AttributeValueMemberS{Value: "STRIPE#01234"},
+				"FirstName":        &types.AttributeValueMemberS{Value: "first_example"},
+				"SurName":          &types.AttributeValueMemberS{Value: "sur_example"},
+				"EmailAddress":     &types.AttributeValueMemberS{Value: "example@example.com"},
+				"StripeCustomerID": &types.AttributeValueMemberS{Value: "01234"},
+				"OnboardingStatus": &types.AttributeValueMemberS{Value: "STRIPE_CREATED"},
+			},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := generatePutRequestInput(context.TODO(), tt.args.item)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("generatePutRequestInput() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("generatePutRequestInput() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_generatePutRequestInput_encrypted(t *testing.T) {
+	e := envelope.New(envelope.NewLocalKMS(), "alias/example")
+	ctx := envelope.NewContext(context.TODO(), e)
+	item := createCustomerEvent{
+		StripeCustomerID: "01234",
+		CognitoUserID:    "56789",
+		FirstName:        "first_example",
+		SurName:          "sur_example",
+		EmailAddress:     "example@example.com",
+	}
+	got, err := generatePutRequestInput(ctx, item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"FirstName", "SurName", "EmailAddress"} {
+		if value := got[name].(*types.AttributeValueMemberS).Value; !strings.HasPrefix(value, envelope.Prefix) {
+			t.Errorf("generatePutRequestInput() %v = %v, want it encrypted", name, value)
+		}
+	}
+	if value := got["StripeCustomerID"].(*types.AttributeValueMemberS).Value; value != "01234" {
+		t.Errorf("generatePutRequestInput() StripeCustomerID = %v, want 01234", value)
+	}
+	decrypted := createCustomerEvent{}
+	if err := e.UnmarshalMap(ctx, got, &decrypted); err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.EmailAddress != item.EmailAddress {
+		t.Errorf("UnmarshalMap() EmailAddress = %v, want %v", decrypted.EmailAddress, item.EmailAddress)
+	}
+}
+
+func Test_extractCognitoUserIDSFromWriteRequests(t *testing.T) {
+	put := func(pk, sk string) types.WriteRequest {
+		item := map[string]types.AttributeValue{"PK": &types.AttributeValueMemberS{Value: pk}}
+		if sk != "" {
+			item["SK"] = &types.AttributeValueMemberS{Value: sk}
+		}
+		return types.WriteRequest{PutRequest: &types.PutRequest{Item: item}}
+	}
+	tests := []struct {
+		name          string
+		writeRequests []types.WriteRequest
+		want          []string
+		wantErr       bool
+	}{
+		{
+			name:          "",
+			writeRequests: []types.WriteRequest{put("USER#12345", ""), put("USER#67890", "")},
+			want:          []string{"12345", "67890"},
+			wantErr:       false,
+		},
+		{
+			name:          "referral_item",
+			writeRequests: []types.WriteRequest{put("USER#12345", "USER#MAIDO"), put("REFERRAL#FRIEND42", "USER#12345")},
+			want:          []string{"12345", "12345"},
+			wantErr:       false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractCognitoUserIDSFromWriteRequests(tt.writeRequests)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("extractCognitoUserIDSFromWriteRequests() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractCognitoUserIDSFromWriteRequests() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// numberedPuts returns n puts of distinct user items.
+func numberedPuts(n int) []types.WriteRequest {
+	puts := []types.WriteRequest{}
+	for i := 1; i <= n; i++ {
+		puts = append(puts, types.WriteRequest{PutRequest: &types.PutRequest{Item: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%02d", i)},
+			"SK": &types.AttributeValueMemberS{Value: "USER#MAIDO"},
+		}}})
+	}
+	return puts
+}
+
+func Test_batchWriteItems(t *testing.T) {
+	const tableName = "mockTable"
+	tests := []struct {
+		name        string
+		puts        int
+		inject      func(db *fakes.DynamoDB)
+		wantRetries int
+		wantFailed  []string
+	}{
+		{name: "1_item", puts: 1},
+		{name: "2_items", puts: 2},
+		{name: "26_items", puts: 26},
+		{
+			name:        "unprocessed_retried",
+			puts:        3,
+			inject:      func(db *fakes.DynamoDB) { db.UnprocessedOn(1, 2) },
+			wantRetries: 1,
+		},
+		{
+			name: "unprocessed_after_max_attempts",
+			puts: 2,
+			inject: func(db *fakes.DynamoDB) {
+				for n := 1; n <= maxBatchWriteItemAttempts; n++ {
+					db.UnprocessedOn(n, 1)
+				}
+			},
+			wantRetries: maxBatchWriteItemAttempts - 1,
+			wantFailed:  []string{"02"},
+		},
+		{
+			name:       "batch_fails",
+			puts:       26,
+			inject:     func(db *fakes.DynamoDB) { db.FailOn("BatchWriteItem", 2, fakes.DynamoDBThrottle()) },
+			wantFailed: []string{"26"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakes.NewDynamoDB()
+			if tt.inject != nil {
+				tt.inject(db)
+			}
+			wg := &sync.WaitGroup{}
+			wg.Add(1)
+			ch := make(chan resultDB, tt.puts+1)
+			// One batch at a time keeps the order of the calls, and so the golden file, stable.
+			batchWriteItems(context.TODO(), wg, ch, db, numberedPuts(tt.puts), tableName, 1)
+			close(ch)
+			retries := 0
+			failed := []string{}
+			for res := range ch {
+				retries += res.Retries
+				if res.Error != nil {
+					failed = append(failed, res.UserIDS...)
+				}
+			}
+			if retries != tt.wantRetries {
+				t.Errorf("batchWriteItems() retries = %v, want %v", retries, tt.wantRetries)
+			}
+			if len(failed) != len(tt.wantFailed) || (len(failed) > 0 && !reflect.DeepEqual(failed, tt.wantFailed)) {
+				t.Errorf("batchWriteItems() failed users = %v, want %v", failed, tt.wantFailed)
+			}
+			inputs := []interface{}{}
+			for _, call := range db.Calls("BatchWriteItem") {
+				inputs = append(inputs, call.Input)
+			}
+			golden.Assert(t, inputs)
+		})
+	}
+}
+
+func Test_dynamoDBStage_batchDuplicateUser(t *testing.T) {
+	t.Setenv("DYNAMODB_TABLE_NAME", "example")
+	db := fakes.NewDynamoDB()
+	stage := dynamoDBStage{workers: newLimiter(0), concurrency: 1, db: db, tableName: "example", writeMode: writeModeBatch}
+	result := stage.Run(context.TODO(), []*createCustomerEvent{
+		{SQSMessageID: "message-1", CognitoUserID: "1", StripeCustomerID: "cus_1"},
+		{SQSMessageID: "message-2", CognitoUserID: "1", StripeCustomerID: "cus_1"},
+		{SQSMessageID: "message-3", CognitoUserID: "2", StripeCustomerID: "cus_2"},
+	})
+	if len(result.Failed) != 0 {
+		t.Fatalf("Run() failed = %v, want none", result.Failed)
+	}
+	db.AssertItem(t, "example", "USER#1", "USER#MAIDO", map[string]string{"StripeCustomerID": "cus_1"})
+	db.AssertItem(t, "example", "USER#2", "USER#MAIDO", map[string]string{"StripeCustomerID": "cus_2"})
+	db.AssertCalls(t, "BatchWriteItem", 1)
+}
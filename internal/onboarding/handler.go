@@ -0,0 +1,311 @@
+// Package onboarding is the stripe_onboarding pipeline. It is run by the cmd/stripe_onboarding lambda and by
+// cmd/onboard_local, which replays events through it on a developer machine.
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/seanturner026/maido-lambdas/internal/audit"
+	"github.com/seanturner026/maido-lambdas/internal/awsbatch"
+	"github.com/seanturner026/maido-lambdas/internal/batch"
+	"github.com/seanturner026/maido-lambdas/internal/envelope"
+	"github.com/seanturner026/maido-lambdas/internal/keys"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+	"github.com/seanturner026/maido-lambdas/internal/metrics"
+	"github.com/seanturner026/maido-lambdas/internal/tracing"
+	log "github.com/sirupsen/logrus"
+	"github.com/stripe/stripe-go/v72/client"
+)
+
+// DynamoDBAPI is every DynamoDB operation the handler makes.
+type DynamoDBAPI interface {
+	awsDynamoDBAPI
+	awsDynamoDBUserItemAPI
+	awsDynamoDBPutItemAPI
+	awsDynamoDBTransactAPI
+}
+
+// CognitoAPI is every Cognito operation the handler makes.
+type CognitoAPI interface {
+	awsCognitoIdentityProviderAPI
+}
+
+// SQSAPI is every SQS operation the handler makes.
+type SQSAPI interface {
+	awsSQSAPI
+	awsSQSChangeMessageVisibilityAPI
+	awsSQSSendMessageAPI
+}
+
+// StripeAPI holds the Stripe resources the handler uses, mirroring the fields of client.API so that fakes can
+// stand in for them.
+type StripeAPI struct {
+	Customers      stripeCustomerCreateAPI
+	Subscriptions  stripeSubscriptionCreateAPI
+	PromotionCodes stripePromotionCodeListAPI
+}
+
+// NewStripeAPI returns the resources of sc that the handler uses.
+func NewStripeAPI(sc *client.API) StripeAPI {
+	return StripeAPI{Customers: sc.Customers, Subscriptions: sc.Subscriptions, PromotionCodes: sc.PromotionCodes}
+}
+
+// Handler onboards the customers in batches of SQS messages: it runs each message through the stages named in
+// ONBOARDING_STAGES, such as creating its Stripe customer and writing it to DynamoDB and Cognito, and deletes the
+// messages that completed them all. The stages stop ONBOARDING_DEADLINE_MARGIN before the Lambda times out, and
+// the messages they did not get to are released for another invocation. A panic in a worker fails only the
+// messages it was handling.
+type Handler struct {
+	Cognito  CognitoAPI
+	DynamoDB DynamoDBAPI
+	SQS      SQSAPI
+	Stripe   StripeAPI
+	// Encrypter encrypts personal data before it is written to DynamoDB. It is nil when encryption is disabled.
+	Encrypter *envelope.Encrypter
+	// Metrics receives the EMF metrics document written at the end of each invocation. It defaults to stdout.
+	Metrics io.Writer
+	// FlushTraces is called at the end of each invocation when it is set.
+	FlushTraces func(context.Context) error
+}
+
+// New returns a Handler with AWS clients built from cfg, the resources of the Stripe client sc, and personal data
+// encryption configured from the environment.
+func New(cfg aws.Config, sc *client.API) *Handler {
+	return &Handler{
+		Cognito:   cognitoidentityprovider.NewFromConfig(cfg),
+		DynamoDB:  dynamodb.NewFromConfig(cfg),
+		SQS:       sqs.NewFromConfig(cfg),
+		Stripe:    NewStripeAPI(sc),
+		Encrypter: envelope.FromEnv(kms.NewFromConfig(cfg)),
+	}
+}
+
+// keySchema is the key schema items are written with.
+var keySchema = keys.Default()
+
+// UseKeySchema sets the key schema items are written with. It is called once at start-up, before any events are
+// handled.
+func UseKeySchema(schema keys.Schema) {
+	keySchema = schema
+}
+
+const correlationIDAttribute = "correlationId"
+
+type items struct {
+	Items []createCustomerEvent
+}
+
+func unmarshalCreateCustomerEvents(event events.SQSEvent) ([]*createCustomerEvent, error) {
+	return awsbatch.Unmarshal(event, func(item *createCustomerEvent, record events.SQSMessage) {
+		item.SQSMessageID = record.MessageId
+		item.SQSReceiptHandle = record.ReceiptHandle
+		item.CorrelationID = correlationID(record, item.CorrelationID)
+	})
+}
+
+// correlationID prefers the correlationId message attribute set by the producer, then the value carried in the
+// message body, and finally the SQS message ID.
+func correlationID(record events.SQSMessage, fromBody string) string {
+	if attribute, ok := record.MessageAttributes[correlationIDAttribute]; ok && attribute.StringValue != nil && *attribute.StringValue != "" {
+		return *attribute.StringValue
+	}
+	if fromBody != "" {
+		return fromBody
+	}
+	return record.MessageId
+}
+
+func (h *Handler) onboardCustomer(ctx context.Context, event events.SQSEvent) (*Summary, error) {
+	requestID := ""
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		requestID = lc.AwsRequestID
+	}
+	summary := newSummary(requestID, time.Now(), len(event.Records))
+	defer func() { summary.finish(time.Now()) }()
+	m := metrics.FromContext(ctx)
+	stopTimer := m.Time(metricUnmarshalLatency)
+	_, span := tracing.Start(ctx, "unmarshal")
+	customerEvents, err := unmarshalCreateCustomerEvents(event)
+	tracing.End(span, err)
+	stopTimer()
+	if err != nil {
+		return summary, err
+	}
+	summary.addMessages(customerEvents)
+	subscription, err := subscriptionConfigFromEnv()
+	if err != nil {
+		return summary, err
+	}
+	cognitoCfg, err := cognitoConfigFromEnv()
+	if err != nil {
+		return summary, err
+	}
+	writeMode, err := writeModeFromEnv()
+	if err != nil {
+		return summary, err
+	}
+	concurrency, err := concurrencyFromEnv()
+	if err != nil {
+		return summary, err
+	}
+	stages, err := stagesFromEnv()
+	if err != nil {
+		return summary, err
+	}
+	margin, err := deadlineMarginFromEnv()
+	if err != nil {
+		return summary, err
+	}
+	// The stages run in work, leaving the rest of ctx to record statuses and delete or release messages.
+	work, cancel := withDeadlineMargin(ctx, margin)
+	defer cancel()
+	workers := newLimiter(concurrency)
+	tableName, ok := os.LookupEnv("DYNAMODB_TABLE_NAME")
+	if !ok {
+		return summary, fmt.Errorf("environment variable DYNAMODB_TABLE_NAME is not set")
+	}
+	p, err := newPipeline(pipelineConfig{
+		handler:      h,
+		workers:      workers,
+		concurrency:  concurrency,
+		tableName:    tableName,
+		writeMode:    writeMode,
+		subscription: subscription,
+		cognito:      cognitoCfg,
+	}, summary, stages)
+	if err != nil {
+		return summary, err
+	}
+	requestCount := len(customerEvents)
+	wg := &sync.WaitGroup{}
+	wg.Add(requestCount)
+	chanStatus := make(chan resultStatus, requestCount)
+	stopStage := summary.time(stageStatus)
+	ctxStatus, span := tracing.Start(work, "status")
+	for _, customerEvent := range customerEvents {
+		customerEvent := customerEvent
+		workers.Go(func() { startOnboarding(ctxStatus, wg, chanStatus, h.DynamoDB, tableName, customerEvent) })
+	}
+	wg.Wait()
+	span.End()
+	stopStage()
+	close(chanStatus)
+	failedStatus := recordStatusResults(ctx, summary, chanStatus, requestCount)
+	started := []*createCustomerEvent{}
+	unfinishedEvents := []*createCustomerEvent{}
+	for _, customerEvent := range customerEvents {
+		switch {
+		case !failedStatus[customerEvent.SQSMessageID]:
+			started = append(started, customerEvent)
+		case work.Err() != nil:
+			// Status updates that failed once work ran out were most likely cut short by it.
+			unfinishedEvents = append(unfinishedEvents, customerEvent)
+		}
+	}
+	// Messages that failed a stage stay on the queue so that a retry resumes from the recorded status.
+	completedEvents, unfinishedStages := p.run(ctx, work, started)
+	unfinishedEvents = append(unfinishedEvents, unfinishedStages...)
+	completed := items{}
+	for _, item := range completedEvents {
+		completed.Items = append(completed.Items, *item)
+	}
+	entries, queueURL, err := generateDeleteMessageBatchRequestEntries(completed)
+	if err != nil {
+		return summary, err
+	}
+	wg.Add(1)
+	// Each failed batch sends a result, as do the entries that failed on their own.
+	chanSQS := make(chan resultSQS, len(batch.Chunk(entries, awsbatch.MaxDeleteMessageBatchEntries))+1)
+	stopTimer = m.Time(metricSQSLatency)
+	stopStage = summary.time(stageSQS)
+	ctxSQS, span := tracing.Start(ctx, "sqs")
+	go batchDeleteMessages(ctxSQS, wg, chanSQS, h.SQS, queueURL, entries, concurrency)
+	wg.Wait()
+	span.End()
+	stopStage()
+	stopTimer()
+	close(chanSQS)
+	summary.acknowledge(completed)
+	for ch := range chanSQS {
+		summary.recordSQS(ch)
+		if ch.Error != nil {
+			logging.FromContext(ctx).WithFields(log.Fields{"failed_delete_messages": ch.FailedDeleteMessages, "error": ch.Error}).Error(ch.Message)
+		}
+	}
+	// Released messages repeat the stage they were cut short in, relying on the Stripe idempotency keys.
+	if len(unfinishedEvents) > 0 {
+		summary.release(releaseMessages(ctx, h.SQS, queueURL, generateReleaseMessageBatchRequestEntries(unfinishedEvents), concurrency))
+	}
+	return summary, nil
+}
+
+// Handle is the Lambda handler. Every message that was not acknowledged is reported as a batch item failure, so
+// the event source mapping must have ReportBatchItemFailures enabled in its function response types. Without it
+// the nil error deletes the whole batch, including the failed messages and the ones released for redelivery.
+func (h *Handler) Handle(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	summary, err := h.Run(ctx, event)
+	if err != nil {
+		return events.SQSEventResponse{}, err
+	}
+	return events.SQSEventResponse{BatchItemFailures: batchItemFailures(event, summary)}, nil
+}
+
+// batchItemFailures returns the messages of event that the summary does not report as acknowledged.
+func batchItemFailures(event events.SQSEvent, summary *Summary) []events.SQSBatchItemFailure {
+	failures := []events.SQSBatchItemFailure{}
+	for _, record := range event.Records {
+		if message, ok := summary.byMessage[record.MessageId]; ok && message.Acknowledged {
+			continue
+		}
+		failures = append(failures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+	}
+	return failures
+}
+
+// Run handles event and returns the summary of what happened to each of its messages.
+func (h *Handler) Run(ctx context.Context, event events.SQSEvent) (summary *Summary, err error) {
+	logging.FromContext(ctx).Info(fmt.Sprintf("Handling %v events", len(event.Records)))
+	m := newMetricsLogger(h.Metrics)
+	defer func() {
+		if err := m.Flush(); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("Unable to flush metrics")
+		}
+	}()
+	ctx = metrics.NewContext(ctx, m)
+	recorder, auditErr := newAuditRecorder(h.DynamoDB)
+	if auditErr != nil {
+		logging.FromContext(ctx).WithFields(log.Fields{"error": auditErr}).Error("Audit records are disabled")
+	}
+	ctx = audit.NewContext(ctx, recorder)
+	ctx = envelope.NewContext(ctx, h.Encrypter)
+	ctx, span := tracing.StartFromSQSEvent(ctx, "stripe_onboarding", event)
+	defer func() {
+		tracing.End(span, err)
+		if h.FlushTraces == nil {
+			return
+		}
+		if err := h.FlushTraces(ctx); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("Unable to flush traces")
+		}
+	}()
+	summary, err = h.onboardCustomer(ctx, event)
+	logSummary(ctx, summary)
+	if runSummaryEnabled() {
+		if err := writeSummary(ctx, h.DynamoDB, summary); err != nil {
+			logging.FromContext(ctx).WithFields(log.Fields{"error": err}).Error("Unable to write invocation summary")
+		}
+	}
+	return summary, err
+}
@@ -0,0 +1,213 @@
+package onboarding
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/seanturner026/maido-lambdas/internal/fakes"
+)
+
+// The fakes must satisfy the interfaces the handler's dependencies are held behind.
+var (
+	_ DynamoDBAPI                 = (*fakes.DynamoDB)(nil)
+	_ awsDynamoDBUpdateItemAPI    = (*fakes.DynamoDB)(nil)
+	_ CognitoAPI                  = (*fakes.Cognito)(nil)
+	_ SQSAPI                      = (*fakes.SQS)(nil)
+	_ stripeCustomerCreateAPI     = (*fakes.StripeCustomers)(nil)
+	_ stripeSubscriptionCreateAPI = (*fakes.StripeSubscriptions)(nil)
+	_ stripePromotionCodeListAPI  = (*fakes.StripePromotionCodes)(nil)
+)
+
+func Test_unmarshalCreateCustomerEvents(t *testing.T) {
+	type args struct {
+		event events.SQSEvent
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []*createCustomerEvent
+		wantErr bool
+	}{
+		{
+			name: "",
+			args: args{
+				event: events.SQSEvent{
+					Records: []events.SQSMessage{{
+						MessageId:     "123456789",
+						ReceiptHandle: "23456789",
+						Body:          "{\"cognitoUserID\": \"56789\", \"email\": \"example@example.com\", \"firstName\": \"first_example\", \"surName\": \"sur_example\"}",
+					}},
+				},
+			},
+			want: []*createCustomerEvent{{
+				PK:               "",
+				SK:               "",
+				StripeCustomerID: "",
+				SQSMessageID:     "123456789",
+				SQSReceiptHandle: "23456789",
+				CognitoUserID:    "56789",
+				FirstName:        "first_example",
+				SurName:          "sur_example",
+				EmailAddress:     "example@example.com",
+				CorrelationID:    "123456789",
+			}},
+			wantErr: false,
+		},
+		{
+			name: "correlation_id_attribute",
+			args: args{
+				event: events.SQSEvent{
+					Records: []events.SQSMessage{{
+						MessageId:     "123456789",
+						ReceiptHandle: "23456789",
+						Body:          "{\"cognitoUserID\": \"56789\", \"correlationId\": \"body-correlation\"}",
+						MessageAttributes: map[string]events.SQSMessageAttribute{
+							"correlationId": {StringValue: aws.String("attribute-correlation"), DataType: "String"},
+						},
+					}},
+				},
+			},
+			want: []*createCustomerEvent{{
+				SQSMessageID:     "123456789",
+				SQSReceiptHandle: "23456789",
+				CognitoUserID:    "56789",
+				CorrelationID:    "attribute-correlation",
+			}},
+			wantErr: false,
+		},
+		{
+			name: "correlation_id_body",
+			args: args{
+				event: events.SQSEvent{
+					Records: []events.SQSMessage{{
+						MessageId:     "123456789",
+						ReceiptHandle: "23456789",
+						Body:          "{\"cognitoUserID\": \"56789\", \"correlationId\": \"body-correlation\"}",
+					}},
+				},
+			},
+			want: []*createCustomerEvent{{
+				SQSMessageID:     "123456789",
+				SQSReceiptHandle: "23456789",
+				CognitoUserID:    "56789",
+				CorrelationID:    "body-correlation",
+			}},
+			wantErr: false,
+		},
+		{
+			name: "",
+			args: args{
+				event: events.SQSEvent{
+					Records: []events.SQSMessage{{
+						MessageId:     "123456789",
+						ReceiptHandle: "23456789",
+						Body:          "",
+					}},
+				},
+			},
+			want:    []*createCustomerEvent{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unmarshalCreateCustomerEvents(tt.args.event)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unmarshalCreateCustomerEvents() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unmarshalCreateCustomerEvents() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Fuzz_unmarshalCreateCustomerEvents checks that every record of an event either becomes one event carrying its
+// SQS identifiers or fails the whole batch, whatever its body holds.
+func Fuzz_unmarshalCreateCustomerEvents(f *testing.F) {
+	f.Add(`{"cognitoUserID": "12345", "email": "example@example.com", "firstName": "first", "surName": "last"}`, `{"cognitoUserID": "67890", "correlationId": "example"}`)
+	f.Add(`{"promoCode": "MAIDO", "referralCode": "FRIEND42", "skipSubscription": true}`, `{}`)
+	f.Add(`not json`, `{"cognitoUserID": 12345}`)
+	f.Fuzz(func(t *testing.T, firstBody, secondBody string) {
+		event := events.SQSEvent{Records: []events.SQSMessage{
+			{MessageId: "message-1", ReceiptHandle: "receipt-1", Body: firstBody},
+			{MessageId: "message-2", ReceiptHandle: "receipt-2", Body: secondBody},
+		}}
+		wantErr := false
+		for _, record := range event.Records {
+			if json.Unmarshal([]byte(record.Body), &createCustomerEvent{}) != nil {
+				wantErr = true
+			}
+		}
+		got, err := unmarshalCreateCustomerEvents(event)
+		if (err != nil) != wantErr {
+			t.Fatalf("unmarshalCreateCustomerEvents() error = %v, wantErr %v", err, wantErr)
+		}
+		if err != nil {
+			return
+		}
+		if len(got) != len(event.Records) {
+			t.Fatalf("unmarshalCreateCustomerEvents() = %v events, want %v", len(got), len(event.Records))
+		}
+		for i, record := range event.Records {
+			if got[i].SQSMessageID != record.MessageId || got[i].SQSReceiptHandle != record.ReceiptHandle {
+				t.Errorf("event %d has message %s and receipt %s, want %s and %s", i, got[i].SQSMessageID, got[i].SQSReceiptHandle, record.MessageId, record.ReceiptHandle)
+			}
+			if got[i].CorrelationID == "" {
+				t.Errorf("event %d has no correlation ID", i)
+			}
+		}
+	})
+}
+
+func Test_handler(t *testing.T) {
+	type args struct {
+		ctx   context.Context
+		event events.SQSEvent
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "",
+			args: args{
+				ctx: nil,
+				event: events.SQSEvent{
+					Records: []events.SQSMessage{},
+				},
+			},
+			wantErr: false,
+		},
+	}
+	os.Setenv("SQS_QUEUE_URL", "example")
+	os.Setenv("DYNAMODB_TABLE_NAME", "example")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := (&Handler{}).Handle(tt.args.ctx, tt.args.event); (err != nil) != tt.wantErr {
+				t.Errorf("handler() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_batchItemFailures(t *testing.T) {
+	summary := newTestSummary()
+	summary.acknowledge(items{Items: []createCustomerEvent{{SQSMessageID: "message-1"}, {SQSMessageID: "message-3"}}})
+	summary.release([]string{"message-4"})
+	event := events.SQSEvent{}
+	for _, messageID := range []string{"message-1", "message-2", "message-3", "message-4", "message-5"} {
+		event.Records = append(event.Records, events.SQSMessage{MessageId: messageID})
+	}
+	want := []events.SQSBatchItemFailure{{ItemIdentifier: "message-2"}, {ItemIdentifier: "message-4"}, {ItemIdentifier: "message-5"}}
+	if got := batchItemFailures(event, summary); !reflect.DeepEqual(got, want) {
+		t.Errorf("batchItemFailures() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,58 @@
+package onboarding
+
+import (
+	"io"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/seanturner026/maido-lambdas/internal/metrics"
+)
+
+const metricsNamespace = "Maido/Onboarding"
+
+// Metric names emitted once per invocation.
+const (
+	metricStripeCustomersCreated     = "StripeCustomersCreated"
+	metricStripeCustomersFailed      = "StripeCustomersFailed"
+	metricStripeSubscriptionsCreated = "StripeSubscriptionsCreated"
+	metricStripeSubscriptionsFailed  = "StripeSubscriptionsFailed"
+	metricDynamoDBItemsWritten       = "DynamoDBItemsWritten"
+	metricDynamoDBItemsFailed        = "DynamoDBItemsFailed"
+	metricDynamoDBUnprocessedRetried = "DynamoDBUnprocessedRetried"
+	metricDynamoDBVersionConflicts   = "DynamoDBVersionConflicts"
+	metricCognitoUpdatesFailed       = "CognitoUpdatesFailed"
+	metricSQSDeletesFailed           = "SQSDeletesFailed"
+	metricWelcomeEmailsFailed        = "WelcomeEmailsFailed"
+	metricSQSMessagesReleased        = "SQSMessagesReleased"
+	metricWorkerPanics               = "WorkerPanics"
+	metricAuditWritesFailed          = "AuditWritesFailed"
+	metricUnmarshalLatency           = "UnmarshalLatency"
+	metricStripeLatency              = "StripeLatency"
+	metricSubscriptionLatency        = "SubscriptionLatency"
+	metricPersistLatency             = "PersistLatency"
+	metricCognitoLatency             = "CognitoLatency"
+	metricGroupLatency               = "GroupLatency"
+	metricWelcomeEmailLatency        = "WelcomeEmailLatency"
+	metricSQSLatency                 = "SQSLatency"
+)
+
+// newMetricsLogger returns a logger that writes to sink, or to stdout when sink is nil.
+func newMetricsLogger(sink io.Writer) *metrics.Logger {
+	if sink == nil {
+		sink = os.Stdout
+	}
+	environment, ok := os.LookupEnv("ENVIRONMENT")
+	if !ok {
+		environment = "unknown"
+	}
+	functionName := lambdacontext.FunctionName
+	if functionName == "" {
+		functionName = "stripe_onboarding"
+	}
+	return metrics.New(
+		sink,
+		metricsNamespace,
+		metrics.Dimension{Name: "Environment", Value: environment},
+		metrics.Dimension{Name: "Function", Value: functionName},
+	)
+}
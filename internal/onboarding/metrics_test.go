@@ -0,0 +1,101 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/seanturner026/maido-lambdas/internal/fakes"
+	"github.com/seanturner026/maido-lambdas/internal/metrics"
+	"github.com/stripe/stripe-go/v72"
+)
+
+func Test_handler_metrics(t *testing.T) {
+	sink := &metrics.MemorySink{}
+	os.Setenv("ENVIRONMENT", "test")
+	defer os.Unsetenv("ENVIRONMENT")
+	os.Setenv("SQS_QUEUE_URL", "example")
+	os.Setenv("DYNAMODB_TABLE_NAME", "example")
+	if _, err := (&Handler{Metrics: sink}).Handle(context.TODO(), events.SQSEvent{Records: []events.SQSMessage{}}); err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if len(sink.Documents) != 1 {
+		t.Fatalf("handler() wrote %v EMF documents, want 1", len(sink.Documents))
+	}
+	document := sink.Documents[0]
+	if document["Environment"] != "test" || document["Function"] != "stripe_onboarding" {
+		t.Errorf("handler() dimensions = %v/%v", document["Environment"], document["Function"])
+	}
+	for _, name := range []string{metricUnmarshalLatency, metricStripeLatency, metricPersistLatency, metricSQSLatency} {
+		if len(sink.Values(name)) != 1 {
+			t.Errorf("handler() %s values = %v, want 1 sample", name, sink.Values(name))
+		}
+	}
+}
+
+func Test_createCustomers_metrics(t *testing.T) {
+	sink := &metrics.MemorySink{}
+	m := metrics.New(sink, metricsNamespace)
+	ctx := metrics.NewContext(context.TODO(), m)
+	wg := &sync.WaitGroup{}
+	ch := make(chan resultStripe, 3)
+	wg.Add(3)
+	go createCustomers(ctx, wg, ch, mockStripeCustomer{Response: &stripe.Customer{ID: "cus_01234"}}, nil, &createCustomerEvent{})
+	go createCustomers(ctx, wg, ch, mockStripeCustomer{Response: &stripe.Customer{ID: "cus_56789"}}, nil, &createCustomerEvent{})
+	go createCustomers(ctx, wg, ch, mockStripeCustomer{Error: fmt.Errorf("example error")}, nil, &createCustomerEvent{})
+	wg.Wait()
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.Sum(metricStripeCustomersCreated); got != 2 {
+		t.Errorf("%s = %v, want 2", metricStripeCustomersCreated, got)
+	}
+	if got := sink.Sum(metricStripeCustomersFailed); got != 1 {
+		t.Errorf("%s = %v, want 1", metricStripeCustomersFailed, got)
+	}
+}
+
+func Test_batchWriteItems_metrics(t *testing.T) {
+	const tableName = "mockTable"
+	db := fakes.NewDynamoDB()
+	db.UnprocessedOn(1, 1)
+	sink := &metrics.MemorySink{}
+	m := metrics.New(sink, metricsNamespace)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	batchWriteItems(metrics.NewContext(context.TODO(), m), wg, make(chan resultDB, 4), db, numberedPuts(3), tableName, 0)
+	wg.Wait()
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.Sum(metricDynamoDBItemsWritten); got != 3 {
+		t.Errorf("%s = %v, want 3", metricDynamoDBItemsWritten, got)
+	}
+	if got := sink.Sum(metricDynamoDBUnprocessedRetried); got != 1 {
+		t.Errorf("%s = %v, want 1", metricDynamoDBUnprocessedRetried, got)
+	}
+	db.AssertCalls(t, "BatchWriteItem", 2)
+}
+
+func Test_batchDeleteMessages_metrics(t *testing.T) {
+	sink := &metrics.MemorySink{}
+	m := metrics.New(sink, metricsNamespace)
+	queue := fakes.NewSQS()
+	queue.FailDelete("12345", "ReceiptHandleIsInvalid")
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	entries := []sqstypes.DeleteMessageBatchRequestEntry{{Id: aws.String("12345"), ReceiptHandle: aws.String("67890")}}
+	batchDeleteMessages(metrics.NewContext(context.TODO(), m), wg, make(chan resultSQS, 2), queue, "example_queue_url", entries, 0)
+	wg.Wait()
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.Sum(metricSQSDeletesFailed); got != 1 {
+		t.Errorf("%s = %v, want 1", metricSQSDeletesFailed, got)
+	}
+}
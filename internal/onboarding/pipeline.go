@@ -0,0 +1,289 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+	"github.com/seanturner026/maido-lambdas/internal/metrics"
+	"github.com/seanturner026/maido-lambdas/internal/tracing"
+	log "github.com/sirupsen/logrus"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+// Stage is one step of onboarding a customer. The pipeline runs its stages in order and passes each one only the
+// events that every earlier stage completed.
+type Stage interface {
+	// Name identifies the stage in ONBOARDING_STAGES, the invocation summary and traces.
+	Name() string
+	// Status is recorded on the user item once the user has completed the stage. Users that already have it, from
+	// an earlier delivery of their message, are not passed to the stage again.
+	Status() string
+	// Run processes events and reports the ones that failed. The events are shared with later stages, so
+	// whatever Run sets on them is passed on.
+	Run(ctx context.Context, events []*createCustomerEvent) stageResult
+}
+
+type stageResult struct {
+	// Failed holds the error of each failed event by SQS message ID.
+	Failed map[string]error
+	// Retries counts the requests the stage repeated before it succeeded or gave up.
+	Retries int
+}
+
+// stageDefinition describes a stage that ONBOARDING_STAGES can name.
+type stageDefinition struct {
+	// requires are the stages that must run before this one.
+	requires []string
+	// status is the Status of the stage. It is ranked even when the stage drops out, so users past it are not
+	// sent back through the stages before it.
+	status string
+	// latencyMetric times the stage.
+	latencyMetric string
+	// new returns the stage, or nil when its configuration leaves it with nothing to do.
+	new func(cfg pipelineConfig) (Stage, error)
+}
+
+// stageDefinitions are the stages the pipeline can run, by name.
+var stageDefinitions = map[string]stageDefinition{
+	stageStripe: {
+		status:        onboardingStatusStripeCreated,
+		latencyMetric: metricStripeLatency,
+		new:           newStripeStage,
+	},
+	stageSubscription: {
+		requires:      []string{stageStripe},
+		status:        onboardingStatusSubscriptionCreated,
+		latencyMetric: metricSubscriptionLatency,
+		new:           newSubscriptionStage,
+	},
+	stageDynamoDB: {
+		requires:      []string{stageStripe},
+		status:        onboardingStatusPersisted,
+		latencyMetric: metricPersistLatency,
+		new:           newDynamoDBStage,
+	},
+	stageCognito: {
+		requires:      []string{stageStripe},
+		status:        onboardingStatusCognitoSynced,
+		latencyMetric: metricCognitoLatency,
+		new:           newCognitoStage,
+	},
+	stageGroup: {
+		status:        onboardingStatusGroupAssigned,
+		latencyMetric: metricGroupLatency,
+		new:           newGroupStage,
+	},
+	stageWelcomeEmail: {
+		status:        onboardingStatusWelcomeEmailSent,
+		latencyMetric: metricWelcomeEmailLatency,
+		new:           newWelcomeEmailStage,
+	},
+}
+
+// defaultStages run when ONBOARDING_STAGES is not set. The subscription and group stages drop out unless they
+// are configured.
+var defaultStages = []string{stageStripe, stageSubscription, stageDynamoDB, stageCognito, stageGroup}
+
+// stagesFromEnv reads ONBOARDING_STAGES, a comma separated list of the stages to run in order, such as
+// "stripe,dynamodb,cognito,welcome_email". Each stage must come after the stages it requires.
+func stagesFromEnv() ([]string, error) {
+	value, ok := os.LookupEnv("ONBOARDING_STAGES")
+	if !ok || value == "" {
+		return defaultStages, nil
+	}
+	names := []string{}
+	seen := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		definition, ok := stageDefinitions[name]
+		if !ok {
+			return nil, fmt.Errorf("environment variable ONBOARDING_STAGES names unknown stage %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("environment variable ONBOARDING_STAGES names stage %q more than once", name)
+		}
+		for _, required := range definition.requires {
+			if !seen[required] {
+				return nil, fmt.Errorf("environment variable ONBOARDING_STAGES must name stage %q before %q", required, name)
+			}
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// pipelineConfig is what the stages are built from for an invocation.
+type pipelineConfig struct {
+	handler      *Handler
+	workers      limiter
+	concurrency  int
+	tableName    string
+	writeMode    string
+	subscription *subscriptionConfig
+	cognito      cognitoConfig
+}
+
+type pipelineStage struct {
+	Stage
+	latencyMetric string
+}
+
+// pipeline runs a batch of events through its stages, recording each user's progress on their user item.
+type pipeline struct {
+	stages    []pipelineStage
+	order     statusOrder
+	summary   *Summary
+	workers   limiter
+	db        awsDynamoDBUpdateItemAPI
+	tableName string
+}
+
+// newPipeline builds the named stages. Statuses are ranked in the order the stages are named, including stages
+// that drop out, so a stage should only be removed or moved once no messages are waiting to resume from it.
+func newPipeline(cfg pipelineConfig, summary *Summary, names []string) (*pipeline, error) {
+	p := &pipeline{summary: summary, workers: cfg.workers, db: cfg.handler.DynamoDB, tableName: cfg.tableName}
+	statuses := []string{}
+	for _, name := range names {
+		definition, ok := stageDefinitions[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown onboarding stage %q", name)
+		}
+		statuses = append(statuses, definition.status)
+		stage, err := definition.new(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if stage == nil {
+			continue
+		}
+		p.stages = append(p.stages, pipelineStage{Stage: stage, latencyMetric: definition.latencyMetric})
+	}
+	p.order = newStatusOrder(statuses...)
+	return p, nil
+}
+
+// run passes events through every stage and returns the events that completed them all, and the events that
+// work ran out before they could. After each stage the users that completed it move on to its status and the ones
+// that failed are marked FAILED, so that SQS delivering their messages again retries them from the stage that
+// failed. The stages run in work, which is done a margin before ctx, so that there is time left in ctx to record
+// the statuses. Unfinished users keep the status they last reached.
+func (p *pipeline) run(ctx, work context.Context, events []*createCustomerEvent) ([]*createCustomerEvent, []*createCustomerEvent) {
+	m := metrics.FromContext(ctx)
+	unfinishedEvents := []*createCustomerEvent{}
+	for _, stage := range p.stages {
+		if err := work.Err(); err != nil {
+			logging.FromContext(ctx).WithFields(log.Fields{"stage": stage.Name(), "messages": len(events), "error": err}).
+				Warn("Stopping onboarding before the invocation times out")
+			p.summary.recordUnfinished(stage.Name(), events, err)
+			return nil, append(unfinishedEvents, events...)
+		}
+		pending := []*createCustomerEvent{}
+		for _, event := range events {
+			if !p.order.reached(*event, stage.Status()) {
+				pending = append(pending, event)
+			}
+		}
+		stopTimer := m.Time(stage.latencyMetric)
+		stopStage := p.summary.time(stage.Name())
+		ctxStage, span := tracing.Start(work, stage.Name())
+		result := runStage(ctxStage, stage, pending)
+		span.End()
+		stopStage()
+		stopTimer()
+		p.summary.recordStage(stage.Name(), len(pending), result)
+		updates := []statusUpdate{}
+		for _, event := range pending {
+			err, failed := result.Failed[event.SQSMessageID]
+			switch {
+			case failed && unfinished(work, err):
+				unfinishedEvents = append(unfinishedEvents, event)
+			case failed:
+				updates = append(updates, statusUpdate{Event: *event, Statuses: []string{onboardingStatusFailed}})
+			default:
+				updates = append(updates, statusUpdate{Event: *event, Statuses: []string{stage.Status()}})
+			}
+		}
+		failedStatus := updateOnboardingStatuses(ctx, p.summary, p.workers, p.db, p.tableName, updates)
+		completed := []*createCustomerEvent{}
+		for _, event := range events {
+			if _, failed := result.Failed[event.SQSMessageID]; failed || failedStatus[event.SQSMessageID] {
+				continue
+			}
+			if !p.order.reached(*event, stage.Status()) {
+				event.OnboardingStatus = stage.Status()
+			}
+			completed = append(completed, event)
+		}
+		events = completed
+	}
+	return events, unfinishedEvents
+}
+
+// runStage runs stage, failing every event when it panics outside of its workers.
+func runStage(ctx context.Context, stage Stage, events []*createCustomerEvent) (result stageResult) {
+	defer recoverWorker(ctx, func(err error) {
+		result = stageResult{Failed: map[string]error{}}
+		for _, event := range events {
+			result.Failed[event.SQSMessageID] = err
+		}
+	})
+	return stage.Run(ctx, events)
+}
+
+// forEachEvent calls process for each event, as workers allows, with the event's message in the context it is
+// given for logging and tracing. It returns the errors process returned by SQS message ID.
+func forEachEvent(
+	ctx context.Context,
+	workers limiter,
+	events []*createCustomerEvent,
+	process func(ctx context.Context, event *createCustomerEvent) error,
+) map[string]error {
+	mu := sync.Mutex{}
+	failed := map[string]error{}
+	wg := &sync.WaitGroup{}
+	wg.Add(len(events))
+	for _, event := range events {
+		event := event
+		workers.Go(func() {
+			defer wg.Done()
+			ctx, span := tracing.Start(ctx, "message", semconv.MessagingMessageIDKey.String(event.SQSMessageID))
+			ctx = logging.WithMessage(ctx, event.SQSMessageID, event.CorrelationID)
+			err := processRecovered(ctx, event, process)
+			tracing.End(span, err)
+			if err == nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			failed[event.SQSMessageID] = err
+		})
+	}
+	wg.Wait()
+	return failed
+}
+
+// processRecovered calls process, returning a panic as its error.
+func processRecovered(
+	ctx context.Context,
+	event *createCustomerEvent,
+	process func(ctx context.Context, event *createCustomerEvent) error,
+) (err error) {
+	defer recoverWorker(ctx, func(panicErr error) { err = panicErr })
+	return process(ctx, event)
+}
+
+// failuresByUser returns the errors of the users in failed as errors of each of their events' messages.
+func failuresByUser(events []*createCustomerEvent, failed map[string]error) map[string]error {
+	byMessage := map[string]error{}
+	for _, event := range events {
+		if err, ok := failed[event.CognitoUserID]; ok {
+			byMessage[event.SQSMessageID] = err
+		}
+	}
+	return byMessage
+}
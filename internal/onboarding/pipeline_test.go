@@ -0,0 +1,401 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/fakes"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+)
+
+// recordingStage records the users it is given and fails the ones in fail. A stage with cancel calls it before
+// failing them, as if it ran out of time.
+type recordingStage struct {
+	name   string
+	status string
+	fail   map[string]bool
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	users []string
+}
+
+func (s *recordingStage) Name() string {
+	return s.name
+}
+
+func (s *recordingStage) Status() string {
+	return s.status
+}
+
+func (s *recordingStage) Run(ctx context.Context, events []*createCustomerEvent) stageResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	result := stageResult{Failed: map[string]error{}}
+	for _, event := range events {
+		s.users = append(s.users, event.CognitoUserID)
+		if !s.fail[event.CognitoUserID] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			result.Failed[event.SQSMessageID] = fmt.Errorf("%s failed: %w", s.name, err)
+		} else {
+			result.Failed[event.SQSMessageID] = fmt.Errorf("%s failed", s.name)
+		}
+	}
+	return result
+}
+
+// panickingStage panics outside of any worker.
+type panickingStage struct{}
+
+func (panickingStage) Name() string {
+	return "panicking"
+}
+
+func (panickingStage) Status() string {
+	return "PANICKING_DONE"
+}
+
+func (panickingStage) Run(ctx context.Context, events []*createCustomerEvent) stageResult {
+	panic("example panic")
+}
+
+// pendingUsers puts an item for each user at PENDING and returns their events.
+func pendingUsers(t *testing.T, db *fakes.DynamoDB, tableName string, n int) []*createCustomerEvent {
+	t.Helper()
+	events := []*createCustomerEvent{}
+	for i := 1; i <= n; i++ {
+		event := &createCustomerEvent{
+			SQSMessageID:     fmt.Sprintf("message-%d", i),
+			CognitoUserID:    fmt.Sprintf("user-%d", i),
+			OnboardingStatus: onboardingStatusPending,
+		}
+		item := keySchema.UserKey(event.CognitoUserID)
+		item["OnboardingStatus"] = &types.AttributeValueMemberS{Value: onboardingStatusPending}
+		if err := db.Put(tableName, item); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func Test_stagesFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "default", value: "", want: defaultStages},
+		{name: "reordered", value: "stripe, welcome_email,cognito,dynamodb", want: []string{stageStripe, stageWelcomeEmail, stageCognito, stageDynamoDB}},
+		{name: "without_stripe_dependents", value: "group,welcome_email", want: []string{stageGroup, stageWelcomeEmail}},
+		{name: "unknown", value: "stripe,fax", wantErr: true},
+		{name: "duplicate", value: "stripe,dynamodb,stripe", wantErr: true},
+		{name: "before_requirement", value: "dynamodb,stripe", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ONBOARDING_STAGES", tt.value)
+			got, err := stagesFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("stagesFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stagesFromEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newPipeline(t *testing.T) {
+	t.Setenv("USER_POOL_ID", "example")
+	t.Setenv("WELCOME_EMAIL_QUEUE_URL", "example")
+	tests := []struct {
+		name       string
+		cfg        pipelineConfig
+		names      []string
+		wantStages []string
+		wantErr    bool
+	}{
+		{
+			name:       "unconfigured_stages_dropped",
+			names:      defaultStages,
+			wantStages: []string{stageStripe, stageDynamoDB, stageCognito},
+		},
+		{
+			name: "configured",
+			cfg: pipelineConfig{
+				subscription: &subscriptionConfig{PriceID: "price_01234"},
+				cognito:      cognitoConfig{GroupName: "customers"},
+			},
+			names:      append(append([]string{}, defaultStages...), stageWelcomeEmail),
+			wantStages: []string{stageStripe, stageSubscription, stageDynamoDB, stageCognito, stageGroup, stageWelcomeEmail},
+		},
+		{
+			name:    "unknown",
+			names:   []string{"fax"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.handler = &Handler{}
+			p, err := newPipeline(tt.cfg, newTestSummary(), tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newPipeline() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := []string{}
+			for _, stage := range p.stages {
+				got = append(got, stage.Name())
+				if want := stageDefinitions[stage.Name()].status; stage.Status() != want {
+					t.Errorf("newPipeline() stage %s status = %v, want %v", stage.Name(), stage.Status(), want)
+				}
+				if !p.order.reached(createCustomerEvent{OnboardingStatus: stage.Status()}, stage.Status()) ||
+					p.order.reached(createCustomerEvent{OnboardingStatus: onboardingStatusPending}, stage.Status()) {
+					t.Errorf("newPipeline() did not rank stage %s", stage.Name())
+				}
+			}
+			if !reflect.DeepEqual(got, tt.wantStages) {
+				t.Errorf("newPipeline() stages = %v, want %v", got, tt.wantStages)
+			}
+			for i := 1; i < len(tt.names); i++ {
+				before, after := stageDefinitions[tt.names[i-1]].status, stageDefinitions[tt.names[i]].status
+				if !p.order.reached(createCustomerEvent{OnboardingStatus: after}, before) || p.order.reached(createCustomerEvent{OnboardingStatus: before}, after) {
+					t.Errorf("newPipeline() did not rank %s before %s", before, after)
+				}
+			}
+		})
+	}
+}
+
+func Test_newPipeline_missingConfiguration(t *testing.T) {
+	t.Setenv("USER_POOL_ID", "example")
+	if _, err := newPipeline(pipelineConfig{handler: &Handler{}}, newTestSummary(), []string{stageWelcomeEmail}); err == nil {
+		t.Error("newPipeline() error = nil, want WELCOME_EMAIL_QUEUE_URL to be required")
+	}
+}
+
+func Test_pipeline_run(t *testing.T) {
+	const tableName = "example"
+	first := &recordingStage{name: "first", status: "FIRST_DONE", fail: map[string]bool{"user-2": true}}
+	second := &recordingStage{name: "second", status: "SECOND_DONE", fail: map[string]bool{"user-3": true}}
+	db := fakes.NewDynamoDB()
+	events := []*createCustomerEvent{}
+	for i, status := range []string{onboardingStatusPending, onboardingStatusPending, onboardingStatusPending, "FIRST_DONE"} {
+		event := &createCustomerEvent{
+			SQSMessageID:     fmt.Sprintf("message-%d", i+1),
+			CognitoUserID:    fmt.Sprintf("user-%d", i+1),
+			OnboardingStatus: status,
+		}
+		item := keySchema.UserKey(event.CognitoUserID)
+		item["OnboardingStatus"] = &types.AttributeValueMemberS{Value: status}
+		if err := db.Put(tableName, item); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	summary := newTestSummary()
+	p := &pipeline{
+		stages:    []pipelineStage{{Stage: first}, {Stage: second}},
+		order:     newStatusOrder(first.Status(), second.Status()),
+		summary:   summary,
+		db:        db,
+		tableName: tableName,
+	}
+	completed, unfinishedEvents := p.run(context.TODO(), context.TODO(), events)
+
+	if want := []string{"user-1", "user-2", "user-3"}; !reflect.DeepEqual(sorted(first.users), want) {
+		t.Errorf("first stage ran for %v, want %v", first.users, want)
+	}
+	// user-2 failed the first stage and user-4 had already completed it on an earlier delivery.
+	if want := []string{"user-1", "user-3", "user-4"}; !reflect.DeepEqual(sorted(second.users), want) {
+		t.Errorf("second stage ran for %v, want %v", second.users, want)
+	}
+	got := []string{}
+	for _, event := range completed {
+		got = append(got, event.CognitoUserID)
+		if event.OnboardingStatus != "SECOND_DONE" {
+			t.Errorf("completed %s has status %s, want SECOND_DONE", event.CognitoUserID, event.OnboardingStatus)
+		}
+	}
+	if want := []string{"user-1", "user-4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("run() = %v, want %v", got, want)
+	}
+	if len(unfinishedEvents) != 0 {
+		t.Errorf("run() unfinished = %v, want none", unfinishedEvents)
+	}
+	db.AssertItem(t, tableName, "USER#user-1", "USER#MAIDO", map[string]string{"OnboardingStatus": "SECOND_DONE"})
+	db.AssertItem(t, tableName, "USER#user-2", "USER#MAIDO", map[string]string{
+		"OnboardingStatus":       onboardingStatusFailed,
+		"OnboardingResumeStatus": onboardingStatusPending,
+	})
+	db.AssertItem(t, tableName, "USER#user-3", "USER#MAIDO", map[string]string{
+		"OnboardingStatus":       onboardingStatusFailed,
+		"OnboardingResumeStatus": "FIRST_DONE",
+	})
+	db.AssertItem(t, tableName, "USER#user-4", "USER#MAIDO", map[string]string{"OnboardingStatus": "SECOND_DONE"})
+	wantStages := map[string]StageSummary{
+		"first":     {Succeeded: 2, Failed: 1},
+		"second":    {Succeeded: 2, Failed: 1},
+		stageStatus: {Succeeded: 6},
+	}
+	for name, want := range wantStages {
+		stage := *summary.Stages[name]
+		stage.DurationMs = 0
+		if stage != want {
+			t.Errorf("stage %s = %+v, want %+v", name, stage, want)
+		}
+	}
+	if message := summary.Messages[2]; message.FailedStage != "second" || message.Error != "second failed" {
+		t.Errorf("message-3 summary = %+v, want failed at the second stage", *message)
+	}
+}
+
+func Test_pipeline_run_statusFails(t *testing.T) {
+	stage := &recordingStage{name: "only", status: "ONLY_DONE"}
+	events := []*createCustomerEvent{{SQSMessageID: "message-1", CognitoUserID: "user-1", OnboardingStatus: onboardingStatusPending}}
+	p := &pipeline{
+		stages:    []pipelineStage{{Stage: stage}},
+		order:     newStatusOrder(stage.Status()),
+		summary:   newTestSummary(),
+		db:        fakes.NewDynamoDB(),
+		tableName: "example",
+	}
+	// The user item does not exist, so the conditional status update fails.
+	if completed, _ := p.run(context.TODO(), context.TODO(), events); len(completed) != 0 {
+		t.Errorf("run() = %v, want the event whose status was not recorded left out", completed)
+	}
+	if events[0].OnboardingStatus != onboardingStatusPending {
+		t.Errorf("event status = %v, want it left at %v", events[0].OnboardingStatus, onboardingStatusPending)
+	}
+}
+
+func Test_pipeline_run_deadline(t *testing.T) {
+	const tableName = "example"
+	work, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	first := &recordingStage{name: "first", status: "FIRST_DONE", fail: map[string]bool{"user-2": true}, cancel: cancel}
+	second := &recordingStage{name: "second", status: "SECOND_DONE"}
+	db := fakes.NewDynamoDB()
+	events := pendingUsers(t, db, tableName, 3)
+	summary := newTestSummary()
+	p := &pipeline{
+		stages:    []pipelineStage{{Stage: first}, {Stage: second}},
+		order:     newStatusOrder(first.Status(), second.Status()),
+		summary:   summary,
+		db:        db,
+		tableName: tableName,
+	}
+	completed, unfinishedEvents := p.run(context.TODO(), work, events)
+
+	if len(completed) != 0 || len(second.users) != 0 {
+		t.Errorf("run() completed %v and ran the second stage for %v, want neither once work is done", completed, second.users)
+	}
+	got := []string{}
+	for _, event := range unfinishedEvents {
+		got = append(got, event.CognitoUserID)
+	}
+	if want := []string{"user-1", "user-2", "user-3"}; !reflect.DeepEqual(sorted(got), want) {
+		t.Errorf("run() unfinished = %v, want %v", got, want)
+	}
+	// user-2 was cut short rather than failing, so it keeps the status it reached instead of FAILED.
+	db.AssertItem(t, tableName, "USER#user-1", "USER#MAIDO", map[string]string{"OnboardingStatus": "FIRST_DONE"})
+	db.AssertItem(t, tableName, "USER#user-2", "USER#MAIDO", map[string]string{"OnboardingStatus": onboardingStatusPending})
+	for i, wantStage := range []string{"second", "first", "second"} {
+		if message := summary.Messages[i]; message.FailedStage != wantStage {
+			t.Errorf("message-%d failed at %q, want %q", i+1, message.FailedStage, wantStage)
+		}
+	}
+}
+
+func Test_pipeline_run_stagePanics(t *testing.T) {
+	const tableName = "example"
+	db := fakes.NewDynamoDB()
+	events := pendingUsers(t, db, tableName, 2)
+	p := &pipeline{
+		stages:    []pipelineStage{{Stage: panickingStage{}}},
+		order:     newStatusOrder(panickingStage{}.Status()),
+		summary:   newTestSummary(),
+		db:        db,
+		tableName: tableName,
+	}
+	completed, unfinishedEvents := p.run(context.TODO(), context.TODO(), events)
+	if len(completed) != 0 || len(unfinishedEvents) != 0 {
+		t.Errorf("run() = %v, %v, want every event failed", completed, unfinishedEvents)
+	}
+	db.AssertItem(t, tableName, "USER#user-1", "USER#MAIDO", map[string]string{
+		"OnboardingStatus":       onboardingStatusFailed,
+		"OnboardingResumeStatus": onboardingStatusPending,
+	})
+}
+
+func Test_forEachEvent(t *testing.T) {
+	events := []*createCustomerEvent{}
+	for i := 0; i < 20; i++ {
+		events = append(events, &createCustomerEvent{SQSMessageID: fmt.Sprintf("message-%02d", i), CorrelationID: fmt.Sprintf("correlation-%02d", i)})
+	}
+	workers := newLimiter(3)
+	mu := sync.Mutex{}
+	running, peak := 0, 0
+	failed := forEachEvent(context.TODO(), workers, events, func(ctx context.Context, event *createCustomerEvent) error {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if _, got := logging.CorrelationFromContext(ctx); got != event.CorrelationID {
+			return fmt.Errorf("logger correlation ID = %v, want %v", got, event.CorrelationID)
+		}
+		switch event.SQSMessageID {
+		case "message-07":
+			return fmt.Errorf("example error")
+		case "message-11":
+			panic("example panic")
+		}
+		return nil
+	})
+	if len(failed) != 2 || failed["message-07"] == nil || failed["message-07"].Error() != "example error" || failed["message-11"] == nil {
+		t.Errorf("forEachEvent() = %v, want message-07 and the panicking message-11 failed", failed)
+	}
+	if peak > 3 {
+		t.Errorf("forEachEvent() ran %v at once, want at most 3", peak)
+	}
+}
+
+func Test_failuresByUser(t *testing.T) {
+	events := []*createCustomerEvent{
+		{SQSMessageID: "message-1", CognitoUserID: "user-1"},
+		{SQSMessageID: "message-2", CognitoUserID: "user-2"},
+		{SQSMessageID: "message-3", CognitoUserID: "user-1"},
+	}
+	err := fmt.Errorf("example error")
+	got := failuresByUser(events, map[string]error{"user-1": err})
+	if want := map[string]error{"message-1": err, "message-3": err}; !reflect.DeepEqual(got, want) {
+		t.Errorf("failuresByUser() = %v, want %v", got, want)
+	}
+}
+
+func sorted(values []string) []string {
+	values = append([]string{}, values...)
+	sort.Strings(values)
+	return values
+}
@@ -0,0 +1,47 @@
+package onboarding
+
+import (
+	"context"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/promotioncode"
+)
+
+type stripePromotionCodeListAPI interface {
+	List(params *stripe.PromotionCodeListParams) *promotioncode.Iter
+}
+
+// lookupPromotionCode returns the active promotion code matching code, or nil when Stripe has no redeemable
+// promotion code by that name. An error is only returned when Stripe could not be queried.
+func lookupPromotionCode(ctx context.Context, api stripePromotionCodeListAPI, code string) (*stripe.PromotionCode, error) {
+	params := &stripe.PromotionCodeListParams{
+		ListParams: stripe.ListParams{Context: ctx},
+		Active:     stripe.Bool(true),
+		Code:       stripe.String(code),
+	}
+	iter := api.List(params)
+	for iter.Next() {
+		promotionCode := iter.PromotionCode()
+		if isRedeemable(promotionCode, time.Now()) {
+			return promotionCode, nil
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func isRedeemable(promotionCode *stripe.PromotionCode, now time.Time) bool {
+	if !promotionCode.Active || promotionCode.Coupon == nil || !promotionCode.Coupon.Valid {
+		return false
+	}
+	if promotionCode.ExpiresAt != 0 && promotionCode.ExpiresAt <= now.Unix() {
+		return false
+	}
+	if promotionCode.MaxRedemptions != 0 && promotionCode.TimesRedeemed >= promotionCode.MaxRedemptions {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,194 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/form"
+	"github.com/stripe/stripe-go/v72/promotioncode"
+)
+
+type mockStripePromotionCode struct {
+	Response []*stripe.PromotionCode
+	Error    error
+	Params   *stripe.PromotionCodeListParams
+}
+
+func (m *mockStripePromotionCode) List(params *stripe.PromotionCodeListParams) *promotioncode.Iter {
+	m.Params = params
+	return &promotioncode.Iter{Iter: stripe.GetIter(params, func(*stripe.Params, *form.Values) ([]interface{}, stripe.ListContainer, error) {
+		list := &stripe.PromotionCodeList{}
+		ret := make([]interface{}, len(m.Response))
+		for i, v := range m.Response {
+			ret[i] = v
+		}
+		return ret, list, m.Error
+	})}
+}
+
+type mockStripeCustomerParams struct {
+	Params *stripe.CustomerParams
+}
+
+func (m *mockStripeCustomerParams) New(params *stripe.CustomerParams) (*stripe.Customer, error) {
+	m.Params = params
+	return &stripe.Customer{ID: "cus_01234"}, nil
+}
+
+func Test_isRedeemable(t *testing.T) {
+	now := time.Unix(1640995200, 0)
+	validCoupon := &stripe.Coupon{ID: "coupon_01234", Valid: true}
+	tests := []struct {
+		name          string
+		promotionCode *stripe.PromotionCode
+		want          bool
+	}{
+		{
+			name:          "active",
+			promotionCode: &stripe.PromotionCode{Active: true, Coupon: validCoupon},
+			want:          true,
+		},
+		{
+			name:          "inactive",
+			promotionCode: &stripe.PromotionCode{Active: false, Coupon: validCoupon},
+			want:          false,
+		},
+		{
+			name:          "invalid_coupon",
+			promotionCode: &stripe.PromotionCode{Active: true, Coupon: &stripe.Coupon{Valid: false}},
+			want:          false,
+		},
+		{
+			name:          "expired",
+			promotionCode: &stripe.PromotionCode{Active: true, Coupon: validCoupon, ExpiresAt: now.Unix() - 1},
+			want:          false,
+		},
+		{
+			name:          "fully_redeemed",
+			promotionCode: &stripe.PromotionCode{Active: true, Coupon: validCoupon, MaxRedemptions: 5, TimesRedeemed: 5},
+			want:          false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRedeemable(tt.promotionCode, now); got != tt.want {
+				t.Errorf("isRedeemable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_lookupPromotionCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		api     *mockStripePromotionCode
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "found",
+			api: &mockStripePromotionCode{Response: []*stripe.PromotionCode{{
+				ID:     "promo_01234",
+				Active: true,
+				Coupon: &stripe.Coupon{ID: "coupon_01234", Valid: true},
+			}}},
+			want:    "promo_01234",
+			wantErr: false,
+		},
+		{
+			name:    "not_found",
+			api:     &mockStripePromotionCode{Response: []*stripe.PromotionCode{}},
+			want:    "",
+			wantErr: false,
+		},
+		{
+			name:    "error",
+			api:     &mockStripePromotionCode{Error: fmt.Errorf("example error")},
+			want:    "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.TODO(), struct{}{}, "example")
+			got, err := lookupPromotionCode(ctx, tt.api, "WELCOME10")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("lookupPromotionCode() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if *tt.api.Params.Code != "WELCOME10" || !*tt.api.Params.Active || tt.api.Params.Context != ctx {
+				t.Errorf("lookupPromotionCode() params = %v", tt.api.Params)
+			}
+			gotID := ""
+			if got != nil {
+				gotID = got.ID
+			}
+			if gotID != tt.want {
+				t.Errorf("lookupPromotionCode() = %v, want %v", gotID, tt.want)
+			}
+		})
+	}
+}
+
+func Test_createCustomers_promoCode(t *testing.T) {
+	tests := []struct {
+		name          string
+		api           *mockStripePromotionCode
+		wantPromotion *string
+		wantPromoCode string
+		wantErr       bool
+	}{
+		{
+			name: "applied",
+			api: &mockStripePromotionCode{Response: []*stripe.PromotionCode{{
+				ID:     "promo_01234",
+				Active: true,
+				Coupon: &stripe.Coupon{ID: "coupon_01234", Valid: true},
+			}}},
+			wantPromotion: stripe.String("promo_01234"),
+			wantPromoCode: "WELCOME10",
+		},
+		{
+			name:          "not_redeemable",
+			api:           &mockStripePromotionCode{Response: []*stripe.PromotionCode{}},
+			wantPromotion: nil,
+			wantPromoCode: "",
+		},
+		{
+			name:    "lookup_error",
+			api:     &mockStripePromotionCode{Error: fmt.Errorf("example error")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiStripe := &mockStripeCustomerParams{}
+			wg := &sync.WaitGroup{}
+			ch := make(chan resultStripe, 1)
+			wg.Add(1)
+			createCustomers(context.TODO(), wg, ch, apiStripe, tt.api, &createCustomerEvent{CognitoUserID: "56789", PromoCode: "WELCOME10"})
+			wg.Wait()
+			res := <-ch
+			if (res.Error != nil) != tt.wantErr {
+				t.Fatalf("createCustomers() error = %v, wantErr %v", res.Error, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (apiStripe.Params.PromotionCode == nil) != (tt.wantPromotion == nil) ||
+				(tt.wantPromotion != nil && *apiStripe.Params.PromotionCode != *tt.wantPromotion) {
+				t.Errorf("createCustomers() promotion code = %v, want %v", apiStripe.Params.PromotionCode, tt.wantPromotion)
+			}
+			if apiStripe.Params.Coupon != nil {
+				t.Errorf("createCustomers() coupon = %v, want the promotion code instead", *apiStripe.Params.Coupon)
+			}
+			if res.Event.PromoCode != tt.wantPromoCode {
+				t.Errorf("createCustomers() promo code = %v, want %v", res.Event.PromoCode, tt.wantPromoCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+const referralRewardPending = "PENDING"
+
+// referralItem links the owner of a referral code (the referrer) to the customer who signed up with it (the
+// referee). Items share the REFERRAL#<code> partition so that every referee of a code can be queried when
+// rewards are issued.
+type referralItem struct {
+	PK                      string `dynamodbav:"PK"`
+	SK                      string `dynamodbav:"SK"`
+	ReferralCode            string `dynamodbav:"ReferralCode"`
+	ReferrerCognitoUserID   string `dynamodbav:"ReferrerCognitoUserID"`
+	RefereeCognitoUserID    string `dynamodbav:"RefereeCognitoUserID"`
+	RefereeStripeCustomerID string `dynamodbav:"RefereeStripeCustomerID"`
+	RewardStatus            string `dynamodbav:"RewardStatus"`
+	CreatedAt               string `dynamodbav:"CreatedAt"`
+}
+
+// referralOwnerItem names the user a referral code belongs to. It is written when the code is issued, under the
+// code's partition and keys.Schema.ReferralOwnerSortKey.
+type referralOwnerItem struct {
+	CognitoUserID string `dynamodbav:"CognitoUserID"`
+}
+
+func generateReferralPutRequestInput(event createCustomerEvent, now time.Time) (map[string]types.AttributeValue, error) {
+	if event.ReferrerCognitoUserID == "" {
+		return map[string]types.AttributeValue{}, fmt.Errorf("referral code %s has no referrer", event.ReferralCode)
+	}
+	if event.ReferrerCognitoUserID == event.CognitoUserID {
+		return map[string]types.AttributeValue{}, fmt.Errorf("referral code %s belongs to the referee", event.ReferralCode)
+	}
+	item := referralItem{
+		PK:                      keySchema.ReferralPK(event.ReferralCode),
+		SK:                      keySchema.UserPK(event.CognitoUserID),
+		ReferralCode:            event.ReferralCode,
+		ReferrerCognitoUserID:   event.ReferrerCognitoUserID,
+		RefereeCognitoUserID:    event.CognitoUserID,
+		RefereeStripeCustomerID: event.StripeCustomerID,
+		RewardStatus:            referralRewardPending,
+		CreatedAt:               now.UTC().Format(time.RFC3339),
+	}
+	putItemInput, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return map[string]types.AttributeValue{}, err
+	}
+	return putItemInput, nil
+}
+
+// lookupReferrer returns the user referralCode belongs to, or "" when no user owns it.
+func lookupReferrer(ctx context.Context, db awsDynamoDBUserItemAPI, tableName, referralCode string) (string, error) {
+	resp, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		Key:                      keySchema.ReferralOwnerKey(referralCode),
+		TableName:                aws.String(tableName),
+		ProjectionExpression:     aws.String("#CognitoUserID"),
+		ExpressionAttributeNames: map[string]string{"#CognitoUserID": "CognitoUserID"},
+	})
+	if err != nil {
+		return "", err
+	}
+	owner := referralOwnerItem{}
+	err = attributevalue.UnmarshalMap(resp.Item, &owner)
+	return owner.CognitoUserID, err
+}
+
+// resolveReferrer records who referred the event's user. A code nobody owns, or one used by its own owner, is
+// dropped so that the user is onboarded without a referral.
+func resolveReferrer(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	ch chan resultDB,
+	db awsDynamoDBUserItemAPI,
+	tableName string,
+	event *createCustomerEvent,
+) {
+	defer wg.Done()
+	ctx = logging.WithMessage(ctx, event.SQSMessageID, event.CorrelationID)
+	defer recoverWorker(ctx, func(err error) {
+		ch <- resultDB{Error: err, UserIDS: []string{event.CognitoUserID}, Message: "Unable to look up referral code owner"}
+	})
+	referrer, err := lookupReferrer(ctx, db, tableName, event.ReferralCode)
+	if err != nil {
+		ch <- resultDB{Error: err, UserIDS: []string{event.CognitoUserID}, Message: "Unable to look up referral code owner"}
+		return
+	}
+	fields := log.Fields{"cognito_user_id": event.CognitoUserID, "referral_code": event.ReferralCode}
+	switch referrer {
+	case "":
+		logging.FromContext(ctx).WithFields(fields).Warn("Referral code has no owner, onboarding without a referral")
+		event.ReferralCode = ""
+	case event.CognitoUserID:
+		logging.FromContext(ctx).WithFields(fields).Warn("Referral code belongs to the user who used it, onboarding without a referral")
+		event.ReferralCode = ""
+	default:
+		event.ReferrerCognitoUserID = referrer
+	}
+}
@@ -0,0 +1,152 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/fakes"
+)
+
+func Test_generateReferralPutRequestInput(t *testing.T) {
+	type args struct {
+		event createCustomerEvent
+		now   time.Time
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    map[string]types.AttributeValue
+		wantErr bool
+	}{
+		{
+			name: "",
+			args: args{
+				event: createCustomerEvent{
+					StripeCustomerID:      "cus_01234",
+					CognitoUserID:         "56789",
+					ReferralCode:          "FRIEND42",
+					ReferrerCognitoUserID: "12345",
+				},
+				now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+			want: map[string]types.AttributeValue{
+				"PK":                      &types.AttributeValueMemberS{Value: "REFERRAL#FRIEND42"},
+				"SK":                      &types.AttributeValueMemberS{Value: "USER#56789"},
+				"ReferralCode":            &types.AttributeValueMemberS{Value: "FRIEND42"},
+				"ReferrerCognitoUserID":   &types.AttributeValueMemberS{Value: "12345"},
+				"RefereeCognitoUserID":    &types.AttributeValueMemberS{Value: "56789"},
+				"RefereeStripeCustomerID": &types.AttributeValueMemberS{Value: "cus_01234"},
+				"RewardStatus":            &types.AttributeValueMemberS{Value: "PENDING"},
+				"CreatedAt":               &types.AttributeValueMemberS{Value: "2022-01-01T00:00:00Z"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "no_referrer",
+			args: args{
+				event: createCustomerEvent{CognitoUserID: "56789", ReferralCode: "FRIEND42"},
+				now:   time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+			want:    map[string]types.AttributeValue{},
+			wantErr: true,
+		},
+		{
+			name: "self_referral",
+			args: args{
+				event: createCustomerEvent{CognitoUserID: "56789", ReferralCode: "FRIEND42", ReferrerCognitoUserID: "56789"},
+				now:   time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+			want:    map[string]types.AttributeValue{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := generateReferralPutRequestInput(tt.args.event, tt.args.now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("generateReferralPutRequestInput() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("generateReferralPutRequestInput() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newResultStripe_referralCode(t *testing.T) {
+	tests := []struct {
+		name         string
+		referralCode string
+		wantReferral bool
+	}{
+		{name: "with_referral", referralCode: "FRIEND42", wantReferral: true},
+		{name: "without_referral", referralCode: "", wantReferral: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := newResultStripe(context.TODO(), createCustomerEvent{
+				CognitoUserID:         "56789",
+				StripeCustomerID:      "cus_01234",
+				ReferralCode:          tt.referralCode,
+				ReferrerCognitoUserID: "12345",
+			})
+			if res.Error != nil {
+				t.Fatalf("newResultStripe() error = %v", res.Error)
+			}
+			if (res.ReferralPutRequestInput != nil) != tt.wantReferral {
+				t.Errorf("newResultStripe() referral = %v, want %v", res.ReferralPutRequestInput, tt.wantReferral)
+			}
+		})
+	}
+}
+
+func Test_resolveReferrer(t *testing.T) {
+	const tableName = "example_table_name"
+	tests := []struct {
+		name         string
+		owner        string
+		fail         bool
+		wantCode     string
+		wantReferrer string
+		wantErr      bool
+	}{
+		{name: "owned", owner: "12345", wantCode: "FRIEND42", wantReferrer: "12345"},
+		{name: "no_owner", wantCode: ""},
+		{name: "self_referral", owner: "56789", wantCode: ""},
+		{name: "lookup_error", owner: "12345", fail: true, wantCode: "FRIEND42", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakes.NewDynamoDB()
+			if tt.owner != "" {
+				owner := keySchema.ReferralOwnerKey("FRIEND42")
+				owner["CognitoUserID"] = &types.AttributeValueMemberS{Value: tt.owner}
+				if err := db.Put(tableName, owner); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.fail {
+				db.FailOn("GetItem", 1, fmt.Errorf("example error"))
+			}
+			event := &createCustomerEvent{CognitoUserID: "56789", ReferralCode: "FRIEND42"}
+			wg := &sync.WaitGroup{}
+			wg.Add(1)
+			ch := make(chan resultDB, 1)
+			resolveReferrer(context.TODO(), wg, ch, db, tableName, event)
+			close(ch)
+			res, gotResult := <-ch
+			if gotResult != tt.wantErr || (gotResult && res.Error == nil) {
+				t.Fatalf("resolveReferrer() result = %+v, wantErr %v", res, tt.wantErr)
+			}
+			if event.ReferralCode != tt.wantCode || event.ReferrerCognitoUserID != tt.wantReferrer {
+				t.Errorf("resolveReferrer() code = %q, referrer = %q, want %q and %q", event.ReferralCode, event.ReferrerCognitoUserID, tt.wantCode, tt.wantReferrer)
+			}
+		})
+	}
+}
@@ -0,0 +1,544 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/fakes"
+	"github.com/seanturner026/maido-lambdas/internal/fakestripe"
+	"github.com/seanturner026/maido-lambdas/internal/stripeclient"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/client"
+)
+
+const scenarioTableName = "onboarding"
+
+// outcome is what finally happened to a message delivered by runScenario.
+type outcome string
+
+const (
+	// outcomeAcknowledged messages were deleted on their first delivery.
+	outcomeAcknowledged outcome = "acknowledged"
+	// outcomeRetried messages were left on the queue at least once and deleted on a later delivery.
+	outcomeRetried outcome = "retried"
+	// outcomeQuarantined messages were never deleted and ended up on the dead-letter queue.
+	outcomeQuarantined outcome = "quarantined"
+)
+
+// fakeServices are the fakes the handler runs against in a scenario.
+type fakeServices struct {
+	db      *fakes.DynamoDB
+	cognito *fakes.Cognito
+	queue   *fakes.SQS
+	stripe  *fakes.Stripe
+}
+
+// newFakeServices returns fakes with no state.
+func newFakeServices() *fakeServices {
+	return &fakeServices{
+		db:      fakes.NewDynamoDB(),
+		cognito: fakes.NewCognito(),
+		queue:   fakes.NewSQS(),
+		stripe:  fakes.NewStripe(),
+	}
+}
+
+// handler returns a Handler that runs against the fakes.
+func (f *fakeServices) handler() *Handler {
+	return &Handler{
+		Cognito:  f.cognito,
+		DynamoDB: f.db,
+		SQS:      f.queue,
+		Stripe:   StripeAPI{Customers: f.stripe.Customers, Subscriptions: f.stripe.Subscriptions, PromotionCodes: f.stripe.PromotionCodes},
+		Metrics:  io.Discard,
+	}
+}
+
+func scenarioEmail(userID string) string {
+	return fmt.Sprintf("user%s@example.com", userID)
+}
+
+// forUser matches the inputs of the fakes that belong to userID, so that faults can be injected for one user
+// whatever order the handler's goroutines run in.
+func forUser(userID string) func(input interface{}) bool {
+	return func(input interface{}) bool {
+		switch input := input.(type) {
+		case *stripe.CustomerParams:
+			return aws.ToString(input.Email) == scenarioEmail(userID)
+		case *cognitoidentityprovider.AdminUpdateUserAttributesInput:
+			return aws.ToString(input.Username) == userID
+		case *cognitoidentityprovider.AdminAddUserToGroupInput:
+			return aws.ToString(input.Username) == userID
+		case *dynamodb.UpdateItemInput:
+			pk, ok := input.Key["PK"].(*types.AttributeValueMemberS)
+			return ok && pk.Value == keySchema.UserPK(userID)
+		}
+		return false
+	}
+}
+
+// userItemWrite matches the versioned write of userID's user item, leaving its status updates alone.
+func userItemWrite(userID string) func(input interface{}) bool {
+	matchUser := forUser(userID)
+	return func(input interface{}) bool {
+		update, ok := input.(*dynamodb.UpdateItemInput)
+		return ok && matchUser(input) && strings.Contains(aws.ToString(update.UpdateExpression), ":nextVersion")
+	}
+}
+
+// once limits match to the first input it matches.
+func once(match func(input interface{}) bool) func(input interface{}) bool {
+	mu := sync.Mutex{}
+	matched := false
+	return func(input interface{}) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if matched || !match(input) {
+			return false
+		}
+		matched = true
+		return true
+	}
+}
+
+// runScenario sends a message for each of userIDs and delivers the queue to the handler, as the SQS event source
+// does, until every message has been deleted or moved to the dead-letter queue. Like the event source, it deletes
+// the messages the handler did not report as batch item failures. It returns each user's outcome.
+func runScenario(t *testing.T, f *fakeServices, h *Handler, userIDs []string) map[string]outcome {
+	t.Helper()
+	users := map[string]string{}
+	for _, userID := range userIDs {
+		messageID := f.queue.Send(fmt.Sprintf(
+			`{"cognitoUserID": %q, "email": %q, "firstName": "first_example", "surName": "sur_example"}`,
+			userID,
+			scenarioEmail(userID),
+		))
+		users[messageID] = userID
+	}
+	deliveries := map[string]int{}
+	for delivery := 0; delivery <= f.queue.MaxReceiveCount; delivery++ {
+		messages := f.queue.Receive(10)
+		if len(messages) == 0 {
+			break
+		}
+		event := events.SQSEvent{}
+		for _, message := range messages {
+			deliveries[message.ID]++
+			event.Records = append(event.Records, events.SQSMessage{
+				MessageId:     message.ID,
+				ReceiptHandle: message.ReceiptHandle,
+				Body:          message.Body,
+			})
+		}
+		resp, err := h.Handle(context.TODO(), event)
+		if err != nil {
+			t.Fatalf("handler() error = %v", err)
+		}
+		// The event source deletes every message the handler did not report as a batch item failure.
+		failures := map[string]bool{}
+		for _, failure := range resp.BatchItemFailures {
+			failures[failure.ItemIdentifier] = true
+		}
+		acknowledged := []fakes.Message{}
+		for _, message := range messages {
+			if !failures[message.ID] {
+				acknowledged = append(acknowledged, message)
+			}
+		}
+		f.queue.Acknowledge(acknowledged...)
+	}
+	if queued := f.queue.Queued(); len(queued) > 0 {
+		t.Fatalf("%d messages were still queued after every delivery", len(queued))
+	}
+	outcomes := map[string]outcome{}
+	for _, messageID := range f.queue.Deleted() {
+		if deliveries[messageID] == 1 {
+			outcomes[users[messageID]] = outcomeAcknowledged
+		} else {
+			outcomes[users[messageID]] = outcomeRetried
+		}
+	}
+	for _, message := range f.queue.DeadLetters() {
+		outcomes[users[message.ID]] = outcomeQuarantined
+	}
+	return outcomes
+}
+
+func Test_handler_scenarios(t *testing.T) {
+	sqsOutage := fmt.Errorf("fake SQS outage")
+	tests := []struct {
+		name   string
+		env    map[string]string
+		inject func(f *fakeServices)
+		want   map[string]outcome
+		check  func(t *testing.T, f *fakeServices)
+	}{
+		{
+			name: "all_acknowledged",
+			want: map[string]outcome{"1": outcomeAcknowledged, "2": outcomeAcknowledged, "3": outcomeAcknowledged},
+			check: func(t *testing.T, f *fakeServices) {
+				f.stripe.Customers.AssertCreated(t, 3)
+				f.db.AssertItem(t, scenarioTableName, "USER#2", "USER#MAIDO", map[string]string{
+					"OnboardingStatus": onboardingStatusCognitoSynced,
+					"Version":          "1",
+				})
+				f.cognito.AssertAttribute(t, "2", "custom:stripe_customer_id", stringAttribute(f.db, "2", "StripeCustomerID"))
+			},
+		},
+		{
+			name: "stripe_rate_limited_once",
+			inject: func(f *fakeServices) {
+				f.stripe.Customers.FailWhen("New", once(forUser("2")), fakes.StripeRateLimit())
+			},
+			want: map[string]outcome{"1": outcomeAcknowledged, "2": outcomeRetried, "3": outcomeAcknowledged},
+			check: func(t *testing.T, f *fakeServices) {
+				f.stripe.Customers.AssertCreated(t, 3)
+			},
+		},
+		{
+			name: "stripe_always_fails",
+			inject: func(f *fakeServices) {
+				f.stripe.Customers.FailWhen("New", forUser("2"), fakes.StripeRateLimit())
+			},
+			want: map[string]outcome{"1": outcomeAcknowledged, "2": outcomeQuarantined, "3": outcomeAcknowledged},
+			check: func(t *testing.T, f *fakeServices) {
+				f.stripe.Customers.AssertCreated(t, 2)
+				f.db.AssertItem(t, scenarioTableName, "USER#2", "USER#MAIDO", map[string]string{
+					"OnboardingStatus":       onboardingStatusFailed,
+					"OnboardingResumeStatus": onboardingStatusPending,
+				})
+				f.cognito.AssertNoAttribute(t, "2", "custom:stripe_customer_id")
+			},
+		},
+		{
+			name: "user_item_write_throttled_once",
+			inject: func(f *fakeServices) {
+				f.db.FailWhen("UpdateItem", once(userItemWrite("2")), fakes.DynamoDBThrottle())
+			},
+			want: map[string]outcome{"1": outcomeAcknowledged, "2": outcomeRetried, "3": outcomeAcknowledged},
+			check: func(t *testing.T, f *fakeServices) {
+				// The retry resumes after the Stripe customer was recorded rather than creating another.
+				f.stripe.Customers.AssertCreated(t, 3)
+				f.db.AssertItem(t, scenarioTableName, "USER#2", "USER#MAIDO", map[string]string{
+					"OnboardingStatus": onboardingStatusCognitoSynced,
+				})
+			},
+		},
+		{
+			name: "batch_write_throttled_once",
+			env:  map[string]string{"DYNAMODB_WRITE_MODE": writeModeBatch},
+			inject: func(f *fakeServices) {
+				f.db.FailOn("BatchWriteItem", 1, fakes.DynamoDBThrottle())
+			},
+			want: map[string]outcome{"1": outcomeRetried, "2": outcomeRetried, "3": outcomeRetried},
+			check: func(t *testing.T, f *fakeServices) {
+				f.stripe.Customers.AssertCreated(t, 3)
+				f.db.AssertCalls(t, "BatchWriteItem", 2)
+			},
+		},
+		{
+			name: "cognito_fails_after_dynamodb",
+			inject: func(f *fakeServices) {
+				f.cognito.FailWhen("AdminUpdateUserAttributes", forUser("2"), fakes.CognitoThrottle())
+			},
+			want: map[string]outcome{"1": outcomeAcknowledged, "2": outcomeQuarantined, "3": outcomeAcknowledged},
+			check: func(t *testing.T, f *fakeServices) {
+				// The user item was written before Cognito failed, and the retries did not write it again.
+				f.stripe.Customers.AssertCreated(t, 3)
+				f.db.AssertItem(t, scenarioTableName, "USER#2", "USER#MAIDO", map[string]string{
+					"OnboardingStatus":       onboardingStatusFailed,
+					"OnboardingResumeStatus": onboardingStatusPersisted,
+					"Version":                "1",
+				})
+				f.cognito.AssertNoAttribute(t, "2", "custom:stripe_customer_id")
+			},
+		},
+		{
+			name: "cognito_group_throttled_once",
+			env:  map[string]string{"COGNITO_GROUP_NAME": "customers"},
+			inject: func(f *fakeServices) {
+				f.cognito.FailWhen("AdminAddUserToGroup", once(forUser("3")), fakes.CognitoThrottle())
+			},
+			want: map[string]outcome{"1": outcomeAcknowledged, "2": outcomeAcknowledged, "3": outcomeRetried},
+			check: func(t *testing.T, f *fakeServices) {
+				f.cognito.AssertInGroup(t, "3", "customers")
+			},
+		},
+		{
+			name: "welcome_email_before_cognito",
+			env: map[string]string{
+				"ONBOARDING_STAGES":       "stripe,dynamodb,welcome_email,cognito",
+				"WELCOME_EMAIL_QUEUE_URL": "example_welcome_queue_url",
+			},
+			inject: func(f *fakeServices) {
+				f.cognito.FailWhen("AdminUpdateUserAttributes", once(forUser("2")), fakes.CognitoThrottle())
+			},
+			want: map[string]outcome{"1": outcomeAcknowledged, "2": outcomeRetried, "3": outcomeAcknowledged},
+			check: func(t *testing.T, f *fakeServices) {
+				// User 2 resumed from WELCOME_EMAIL_SENT, so its email was not sent again.
+				if sent := f.queue.SentTo("example_welcome_queue_url"); len(sent) != 3 {
+					t.Errorf("sent %d welcome emails, want 3", len(sent))
+				}
+				f.db.AssertItem(t, scenarioTableName, "USER#2", "USER#MAIDO", map[string]string{
+					"OnboardingStatus": onboardingStatusCognitoSynced,
+				})
+			},
+		},
+		{
+			name: "welcome_email_fails_once",
+			env: map[string]string{
+				"ONBOARDING_STAGES":       "stripe,dynamodb,cognito,welcome_email",
+				"WELCOME_EMAIL_QUEUE_URL": "example_welcome_queue_url",
+			},
+			inject: func(f *fakeServices) {
+				f.queue.FailOn("SendMessage", 1, sqsOutage)
+			},
+			check: func(t *testing.T, f *fakeServices) {
+				// Whichever user's email failed was retried from COGNITO_SYNCED, sending only its email again.
+				f.stripe.Customers.AssertCreated(t, 3)
+				f.cognito.AssertCalls(t, "AdminUpdateUserAttributes", 3)
+				f.queue.AssertCalls(t, "SendMessage", 4)
+				if sent := f.queue.SentTo("example_welcome_queue_url"); len(sent) != 3 {
+					t.Errorf("sent %d welcome emails, want 3", len(sent))
+				}
+			},
+		},
+		{
+			name: "delete_batch_fails_once",
+			inject: func(f *fakeServices) {
+				f.queue.FailOn("DeleteMessageBatch", 1, sqsOutage)
+			},
+			want: map[string]outcome{"1": outcomeRetried, "2": outcomeRetried, "3": outcomeRetried},
+			check: func(t *testing.T, f *fakeServices) {
+				// Onboarding had completed, so the redelivered messages are only deleted.
+				f.stripe.Customers.AssertCreated(t, 3)
+				f.cognito.AssertCalls(t, "AdminUpdateUserAttributes", 3)
+			},
+		},
+		{
+			name: "delete_entry_always_fails",
+			inject: func(f *fakeServices) {
+				f.queue.FailDelete("msg-0001", "ReceiptHandleIsInvalid")
+			},
+			want: map[string]outcome{"1": outcomeQuarantined, "2": outcomeAcknowledged, "3": outcomeAcknowledged},
+			check: func(t *testing.T, f *fakeServices) {
+				f.stripe.Customers.AssertCreated(t, 3)
+				f.db.AssertItem(t, scenarioTableName, "USER#1", "USER#MAIDO", map[string]string{
+					"OnboardingStatus": onboardingStatusCognitoSynced,
+				})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DYNAMODB_TABLE_NAME", scenarioTableName)
+			t.Setenv("SQS_QUEUE_URL", "example_queue_url")
+			t.Setenv("USER_POOL_ID", "example_pool")
+			for _, name := range []string{"DYNAMODB_WRITE_MODE", "COGNITO_GROUP_NAME", "COGNITO_USER_ATTRIBUTES", "STRIPE_SUBSCRIPTION_PRICE_ID", "RUN_SUMMARY_ENABLED", "ONBOARDING_STAGES", "WELCOME_EMAIL_QUEUE_URL", "ONBOARDING_DEADLINE_MARGIN"} {
+				t.Setenv(name, tt.env[name])
+			}
+			f := newFakeServices()
+			if tt.inject != nil {
+				tt.inject(f)
+			}
+			got := runScenario(t, f, f.handler(), []string{"1", "2", "3"})
+			for userID, want := range tt.want {
+				if got[userID] != want {
+					t.Errorf("user %s was %v, want %v", userID, got[userID], want)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, f)
+			}
+		})
+	}
+}
+
+// Test_handler_deadline delivers a batch to an invocation that runs out of time while Stripe is slow, then
+// delivers it again to one that does not.
+func Test_handler_deadline(t *testing.T) {
+	t.Setenv("DYNAMODB_TABLE_NAME", scenarioTableName)
+	t.Setenv("SQS_QUEUE_URL", "example_queue_url")
+	t.Setenv("USER_POOL_ID", "example_pool")
+	t.Setenv("ONBOARDING_DEADLINE_MARGIN", "1s")
+	for _, name := range []string{"DYNAMODB_WRITE_MODE", "COGNITO_GROUP_NAME", "COGNITO_USER_ATTRIBUTES", "STRIPE_SUBSCRIPTION_PRICE_ID", "RUN_SUMMARY_ENABLED", "ONBOARDING_STAGES"} {
+		t.Setenv(name, "")
+	}
+	f := newFakeServices()
+	h := f.handler()
+	for _, userID := range []string{"1", "2", "3"} {
+		f.queue.Send(fmt.Sprintf(`{"cognitoUserID": %q, "email": %q}`, userID, scenarioEmail(userID)))
+	}
+	deliver := func(ctx context.Context) *Summary {
+		t.Helper()
+		event := events.SQSEvent{}
+		for _, message := range f.queue.Receive(10) {
+			event.Records = append(event.Records, events.SQSMessage{MessageId: message.ID, ReceiptHandle: message.ReceiptHandle, Body: message.Body})
+		}
+		summary, err := h.Run(ctx, event)
+		if err != nil {
+			t.Fatalf("handler() error = %v", err)
+		}
+		return summary
+	}
+
+	f.stripe.Customers.SetLatency("New", time.Minute)
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second+50*time.Millisecond)
+	defer cancel()
+	summary := deliver(ctx)
+	for _, message := range summary.Messages {
+		if !message.Released || message.Acknowledged || message.FailedStage != stageStripe {
+			t.Errorf("message %s = %+v, want released after running out of time at the Stripe stage", message.MessageID, *message)
+		}
+	}
+	if released := f.queue.Released(); len(released) != 3 {
+		t.Errorf("released %v, want every message", released)
+	}
+	// The users were cut short rather than failing, so none of them is FAILED.
+	f.db.AssertItem(t, scenarioTableName, "USER#2", "USER#MAIDO", map[string]string{"OnboardingStatus": onboardingStatusPending})
+
+	f.stripe.Customers.SetLatency("New", 0)
+	deliver(context.TODO())
+	f.queue.AssertDeleted(t, "msg-0001", "msg-0002", "msg-0003")
+	f.db.AssertItem(t, scenarioTableName, "USER#2", "USER#MAIDO", map[string]string{"OnboardingStatus": onboardingStatusCognitoSynced})
+}
+
+// Test_handler_deadline_idempotent runs out of time after Stripe created the customers but before its responses
+// arrived. The released messages create the customers again on the next delivery, and the idempotency keys make
+// Stripe return the customers it already created rather than creating more.
+func Test_handler_deadline_idempotent(t *testing.T) {
+	t.Setenv("DYNAMODB_TABLE_NAME", scenarioTableName)
+	t.Setenv("SQS_QUEUE_URL", "example_queue_url")
+	t.Setenv("USER_POOL_ID", "example_pool")
+	t.Setenv("ONBOARDING_DEADLINE_MARGIN", "1s")
+	for _, name := range []string{"DYNAMODB_WRITE_MODE", "COGNITO_GROUP_NAME", "COGNITO_USER_ATTRIBUTES", "STRIPE_SUBSCRIPTION_PRICE_ID", "RUN_SUMMARY_ENABLED", "ONBOARDING_STAGES"} {
+		t.Setenv(name, "")
+	}
+	f := newFakeServices()
+	server := fakestripe.New()
+	var slow int32 = 1
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, r)
+		if r.URL.Path == "/v1/customers" && atomic.LoadInt32(&slow) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+		for name, values := range recorder.Header() {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(recorder.Code)
+		_, _ = w.Write(recorder.Body.Bytes())
+	}))
+	defer httpServer.Close()
+	backends, err := stripeclient.Backends(httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := f.handler()
+	h.Stripe = NewStripeAPI(client.New("sk_test_fake", backends))
+	for _, userID := range []string{"1", "2", "3"} {
+		f.queue.Send(fmt.Sprintf(`{"cognitoUserID": %q, "email": %q}`, userID, scenarioEmail(userID)))
+	}
+	deliver := func(ctx context.Context) *Summary {
+		t.Helper()
+		event := events.SQSEvent{}
+		for _, message := range f.queue.Receive(10) {
+			event.Records = append(event.Records, events.SQSMessage{MessageId: message.ID, ReceiptHandle: message.ReceiptHandle, Body: message.Body})
+		}
+		summary, err := h.Run(ctx, event)
+		if err != nil {
+			t.Fatalf("handler() error = %v", err)
+		}
+		return summary
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second+100*time.Millisecond)
+	defer cancel()
+	for _, message := range deliver(ctx).Messages {
+		if !message.Released || message.FailedStage != stageStripe {
+			t.Errorf("message %s = %+v, want released after running out of time at the Stripe stage", message.MessageID, *message)
+		}
+	}
+	if customers := server.Customers(); len(customers) != 3 {
+		t.Fatalf("fake Stripe has %d customers after the first delivery, want 3", len(customers))
+	}
+
+	atomic.StoreInt32(&slow, 0)
+	deliver(context.TODO())
+	f.queue.AssertDeleted(t, "msg-0001", "msg-0002", "msg-0003")
+	customers := server.Customers()
+	if len(customers) != 3 {
+		t.Fatalf("fake Stripe has %d customers after the second delivery, want 3", len(customers))
+	}
+	ids := map[string]bool{}
+	for _, customer := range customers {
+		ids[fmt.Sprint(customer["id"])] = true
+	}
+	for _, userID := range []string{"1", "2", "3"} {
+		if id := stringAttribute(f.db, userID, "StripeCustomerID"); !ids[id] {
+			t.Errorf("user %s has Stripe customer %q, want one created on the first delivery", userID, id)
+		}
+	}
+}
+
+// Test_handler_stripeHTTP runs the handler through the real stripe-go client against the fake Stripe API.
+func Test_handler_stripeHTTP(t *testing.T) {
+	t.Setenv("DYNAMODB_TABLE_NAME", scenarioTableName)
+	t.Setenv("SQS_QUEUE_URL", "example_queue_url")
+	t.Setenv("USER_POOL_ID", "example_pool")
+	t.Setenv("STRIPE_SUBSCRIPTION_PRICE_ID", "price_01234")
+	for _, name := range []string{"DYNAMODB_WRITE_MODE", "COGNITO_GROUP_NAME", "COGNITO_USER_ATTRIBUTES", "RUN_SUMMARY_ENABLED", "ONBOARDING_STAGES"} {
+		t.Setenv(name, "")
+	}
+	f := newFakeServices()
+	server := fakestripe.New()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	backends, err := stripeclient.Backends(httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := f.handler()
+	h.Stripe = NewStripeAPI(client.New("sk_test_fake", backends))
+
+	got := runScenario(t, f, h, []string{"1", "2"})
+	for _, userID := range []string{"1", "2"} {
+		if got[userID] != outcomeAcknowledged {
+			t.Errorf("user %s was %v, want %v", userID, got[userID], outcomeAcknowledged)
+		}
+	}
+	if customers, subscriptions := server.Customers(), server.Subscriptions(); len(customers) != 2 || len(subscriptions) != 2 {
+		t.Fatalf("fake Stripe has %d customers and %d subscriptions, want 2 of each", len(customers), len(subscriptions))
+	}
+	customerID := stringAttribute(f.db, "1", "StripeCustomerID")
+	f.db.AssertItem(t, scenarioTableName, "USER#1", "USER#MAIDO", map[string]string{
+		"OnboardingStatus":   onboardingStatusCognitoSynced,
+		"SubscriptionStatus": string(stripe.SubscriptionStatusIncomplete),
+	})
+	f.cognito.AssertAttribute(t, "1", "custom:stripe_customer_id", customerID)
+}
+
+// stringAttribute returns the string attribute name of userID's user item.
+func stringAttribute(db *fakes.DynamoDB, userID, name string) string {
+	value, ok := db.Item(scenarioTableName, keySchema.UserPK(userID), keySchema.UserSortKey)[name].(*types.AttributeValueMemberS)
+	if !ok {
+		return ""
+	}
+	return value.Value
+}
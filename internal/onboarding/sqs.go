@@ -0,0 +1,129 @@
+package onboarding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/seanturner026/maido-lambdas/internal/awsbatch"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+	"github.com/seanturner026/maido-lambdas/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// generateDeleteMessageBatchRequestEntries returns the delete entries of items and the queue to delete them from.
+func generateDeleteMessageBatchRequestEntries(items items) ([]types.DeleteMessageBatchRequestEntry, string, error) {
+	queueURL, ok := os.LookupEnv("SQS_QUEUE_URL")
+	if !ok {
+		return []types.DeleteMessageBatchRequestEntry{}, "", fmt.Errorf("environment variable SQS_QUEUE_URL is not set")
+	}
+	entries := []types.DeleteMessageBatchRequestEntry{}
+	for _, item := range items.Items {
+		entries = append(entries, awsbatch.DeleteEntry(item.SQSMessageID, item.SQSReceiptHandle))
+	}
+	return entries, queueURL, nil
+}
+
+type resultSQS struct {
+	Message              string
+	MessageIDS           []string
+	FailedDeleteMessages []string
+	Error                error
+}
+
+type awsSQSAPI = awsbatch.DeleteMessageBatchAPI
+
+type awsSQSChangeMessageVisibilityAPI = awsbatch.ChangeMessageVisibilityBatchAPI
+
+// batchDeleteMessages deletes entries from queueURL in batches of up to awsbatch.MaxDeleteMessageBatchEntries, at most
+// concurrency at once, and sends a result for each failed batch and one for the entries that failed on their own.
+func batchDeleteMessages(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	ch chan resultSQS,
+	queue awsSQSAPI,
+	queueURL string,
+	entries []types.DeleteMessageBatchRequestEntry,
+	concurrency int,
+) {
+	defer wg.Done()
+	defer recoverWorker(ctx, func(err error) {
+		messageIDs := []string{}
+		for _, entry := range entries {
+			messageIDs = append(messageIDs, aws.ToString(entry.Id))
+		}
+		ch <- resultSQS{Error: err, MessageIDS: messageIDs, Message: "Unable to delete message batch"}
+	})
+	results := awsbatch.DeleteMessages(ctx, queue, queueURL, entries, concurrency)
+	failures := map[string]*resultSQS{}
+	order := []string{}
+	outstanding := []types.BatchResultErrorEntry{}
+	for _, res := range results {
+		if res.Err == nil {
+			continue
+		}
+		var failure awsbatch.EntryFailure
+		if errors.As(res.Err, &failure) {
+			outstanding = append(outstanding, failure.Entry)
+			continue
+		}
+		result, ok := failures[res.Err.Error()]
+		if !ok {
+			result = &resultSQS{Error: res.Err, Message: "Unable to delete message batch"}
+			failures[res.Err.Error()] = result
+			order = append(order, res.Err.Error())
+		}
+		result.MessageIDS = append(result.MessageIDS, aws.ToString(res.Item.Id))
+	}
+	for _, key := range order {
+		metrics.FromContext(ctx).Count(metricSQSDeletesFailed, len(failures[key].MessageIDS))
+		ch <- *failures[key]
+	}
+	if len(outstanding) > 0 {
+		metrics.FromContext(ctx).Count(metricSQSDeletesFailed, len(outstanding))
+		ch <- resultSQS{FailedDeleteMessages: awsbatch.FailedEntries(outstanding), Message: "Messages failed to batch delete"}
+	}
+}
+
+// generateReleaseMessageBatchRequestEntries returns entries that make the messages of events visible again at once.
+func generateReleaseMessageBatchRequestEntries(events []*createCustomerEvent) []types.ChangeMessageVisibilityBatchRequestEntry {
+	entries := []types.ChangeMessageVisibilityBatchRequestEntry{}
+	for _, event := range events {
+		entries = append(entries, types.ChangeMessageVisibilityBatchRequestEntry{
+			Id:                aws.String(event.SQSMessageID),
+			ReceiptHandle:     aws.String(event.SQSReceiptHandle),
+			VisibilityTimeout: 0,
+		})
+	}
+	return entries
+}
+
+// releaseMessages makes entries visible on queueURL again, so that the messages an invocation ran out of time for
+// are delivered to another invocation instead of waiting out their visibility timeout. It returns the IDs of the
+// messages it released; the rest are delivered again once their timeout ends.
+func releaseMessages(
+	ctx context.Context,
+	queue awsSQSChangeMessageVisibilityAPI,
+	queueURL string,
+	entries []types.ChangeMessageVisibilityBatchRequestEntry,
+	concurrency int,
+) []string {
+	results := awsbatch.ReleaseMessages(ctx, queue, queueURL, entries, concurrency)
+	released := []string{}
+	for _, res := range results {
+		if res.Err != nil {
+			logging.FromContext(ctx).WithFields(log.Fields{"message_id": aws.ToString(res.Item.Id), "error": res.Err}).
+				Warn("Unable to release message, it will be delivered again once its visibility timeout ends")
+			continue
+		}
+		released = append(released, aws.ToString(res.Item.Id))
+	}
+	if len(released) > 0 {
+		metrics.FromContext(ctx).Count(metricSQSMessagesReleased, len(released))
+	}
+	return released
+}
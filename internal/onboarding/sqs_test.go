@@ -0,0 +1,257 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/seanturner026/maido-lambdas/internal/awsbatch"
+	"github.com/seanturner026/maido-lambdas/internal/fakes"
+	"github.com/seanturner026/maido-lambdas/internal/golden"
+)
+
+// numberedEvents returns n events with distinct message IDs and receipt handles.
+func numberedEvents(n int) []createCustomerEvent {
+	events := []createCustomerEvent{}
+	for i := 1; i <= n; i++ {
+		events = append(events, createCustomerEvent{
+			SQSMessageID:     fmt.Sprintf("message-%02d", i),
+			SQSReceiptHandle: fmt.Sprintf("receipt-%02d", i),
+		})
+	}
+	return events
+}
+
+func Test_generateDeleteMessageBatchRequestEntries(t *testing.T) {
+	const sqsQueueURL = "example_queue_url"
+	customerEvent := createCustomerEvent{
+		SQSMessageID:     "12345",
+		SQSReceiptHandle: "67890",
+	}
+	type args struct {
+		items items
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "1_item",
+			args: args{
+				items: items{
+					Items: []createCustomerEvent{customerEvent},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "11_items",
+			args: args{
+				items: items{
+					Items: []createCustomerEvent{
+						customerEvent, customerEvent, customerEvent, customerEvent, customerEvent,
+						customerEvent, customerEvent, customerEvent, customerEvent, customerEvent,
+						customerEvent,
+					},
+				},
+			},
+			wantErr: false,
+		},
+	}
+	os.Setenv("SQS_QUEUE_URL", sqsQueueURL)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, queueURL, err := generateDeleteMessageBatchRequestEntries(tt.args.items)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("generateDeleteMessageBatchRequestEntries() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if queueURL != sqsQueueURL {
+				t.Errorf("generateDeleteMessageBatchRequestEntries() queue = %v, want %v", queueURL, sqsQueueURL)
+			}
+			golden.Assert(t, got)
+		})
+	}
+}
+
+// Fuzz_batchDeleteMessages checks that every message is deleted in exactly one DeleteMessageBatch call and that
+// no call is empty or over the SQS limit.
+func Fuzz_batchDeleteMessages(f *testing.F) {
+	for _, n := range []uint16{0, 1, 9, 10, 11, 20, 21, 100} {
+		f.Add(n)
+	}
+	os.Setenv("SQS_QUEUE_URL", "example_queue_url")
+	f.Fuzz(func(t *testing.T, n uint16) {
+		events := numberedEvents(int(n % 2048))
+		entries, queueURL, err := generateDeleteMessageBatchRequestEntries(items{Items: events})
+		if err != nil {
+			t.Fatal(err)
+		}
+		queue := fakes.NewSQS()
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		ch := make(chan resultSQS, len(entries)+1)
+		batchDeleteMessages(context.TODO(), wg, ch, queue, queueURL, entries, 4)
+		close(ch)
+		for res := range ch {
+			t.Errorf("batchDeleteMessages() result = %+v", res)
+		}
+		seen := map[string]int{}
+		calls := queue.Calls("DeleteMessageBatch")
+		for i, call := range calls {
+			input := call.Input.(*sqs.DeleteMessageBatchInput)
+			if len(input.Entries) == 0 || len(input.Entries) > awsbatch.MaxDeleteMessageBatchEntries {
+				t.Errorf("call %d has %d entries", i, len(input.Entries))
+			}
+			if aws.ToString(input.QueueUrl) != "example_queue_url" {
+				t.Errorf("call %d has queue %s", i, aws.ToString(input.QueueUrl))
+			}
+			for _, entry := range input.Entries {
+				seen[aws.ToString(entry.Id)]++
+			}
+		}
+		for _, event := range events {
+			if seen[event.SQSMessageID] != 1 {
+				t.Errorf("message %s is in %d calls, want 1", event.SQSMessageID, seen[event.SQSMessageID])
+			}
+		}
+		if want := (len(events) + awsbatch.MaxDeleteMessageBatchEntries - 1) / awsbatch.MaxDeleteMessageBatchEntries; len(calls) != want {
+			t.Errorf("batchDeleteMessages() made %v calls for %v messages, want %v", len(calls), len(events), want)
+		}
+	})
+}
+
+func Test_batchDeleteMessages(t *testing.T) {
+	tests := []struct {
+		name           string
+		messages       int
+		inject         func(queue *fakes.SQS)
+		wantMessageIDs []string
+		wantFailed     int
+	}{
+		{name: "1_entry", messages: 1},
+		{name: "2_entries", messages: 2},
+		{name: "21_entries", messages: 21},
+		{
+			name:       "entry_fails",
+			messages:   3,
+			inject:     func(queue *fakes.SQS) { queue.FailDelete("message-02", "ReceiptHandleIsInvalid") },
+			wantFailed: 1,
+		},
+		{
+			name:           "batch_fails",
+			messages:       11,
+			inject:         func(queue *fakes.SQS) { queue.FailOn("DeleteMessageBatch", 2, fmt.Errorf("example error")) },
+			wantMessageIDs: []string{"message-11"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := fakes.NewSQS()
+			if tt.inject != nil {
+				tt.inject(queue)
+			}
+			entries, _, err := generateDeleteMessageBatchRequestEntries(items{Items: numberedEvents(tt.messages)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wg := &sync.WaitGroup{}
+			wg.Add(1)
+			ch := make(chan resultSQS, tt.messages+1)
+			// One batch at a time keeps the order of the calls, and so the golden file, stable.
+			batchDeleteMessages(context.TODO(), wg, ch, queue, "example_queue_url", entries, 1)
+			close(ch)
+			messageIDs := []string{}
+			failed := 0
+			for res := range ch {
+				messageIDs = append(messageIDs, res.MessageIDS...)
+				failed += len(res.FailedDeleteMessages)
+			}
+			if len(messageIDs) != len(tt.wantMessageIDs) || (len(messageIDs) > 0 && !reflect.DeepEqual(messageIDs, tt.wantMessageIDs)) {
+				t.Errorf("batchDeleteMessages() failed batches held %v, want %v", messageIDs, tt.wantMessageIDs)
+			}
+			if failed != tt.wantFailed {
+				t.Errorf("batchDeleteMessages() failed entries = %v, want %v", failed, tt.wantFailed)
+			}
+			inputs := []interface{}{}
+			for _, call := range queue.Calls("DeleteMessageBatch") {
+				inputs = append(inputs, call.Input)
+			}
+			golden.Assert(t, inputs)
+		})
+	}
+}
+
+func Test_generateReleaseMessageBatchRequestEntries(t *testing.T) {
+	events := []*createCustomerEvent{{SQSMessageID: "message-01", SQSReceiptHandle: "receipt-01"}}
+	want := []types.ChangeMessageVisibilityBatchRequestEntry{{
+		Id:                aws.String("message-01"),
+		ReceiptHandle:     aws.String("receipt-01"),
+		VisibilityTimeout: 0,
+	}}
+	if got := generateReleaseMessageBatchRequestEntries(events); !reflect.DeepEqual(got, want) {
+		t.Errorf("generateReleaseMessageBatchRequestEntries() = %v, want %v", got, want)
+	}
+}
+
+func Test_releaseMessages(t *testing.T) {
+	tests := []struct {
+		name         string
+		inject       func(queue *fakes.SQS, received []fakes.Message)
+		wantReleased []string
+	}{
+		{
+			name:         "all_released",
+			wantReleased: []string{"msg-0001", "msg-0002", "msg-0003", "msg-0004", "msg-0005", "msg-0006", "msg-0007", "msg-0008", "msg-0009", "msg-0010", "msg-0011", "msg-0012"},
+		},
+		{
+			name: "deleted_entry_fails",
+			inject: func(queue *fakes.SQS, received []fakes.Message) {
+				_, err := queue.DeleteMessageBatch(context.TODO(), &sqs.DeleteMessageBatchInput{Entries: []types.DeleteMessageBatchRequestEntry{
+					awsbatch.DeleteEntry(received[2].ID, received[2].ReceiptHandle),
+				}})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantReleased: []string{"msg-0001", "msg-0002", "msg-0004", "msg-0005", "msg-0006", "msg-0007", "msg-0008", "msg-0009", "msg-0010", "msg-0011", "msg-0012"},
+		},
+		{
+			name: "batch_fails",
+			inject: func(queue *fakes.SQS, received []fakes.Message) {
+				queue.FailOn("ChangeMessageVisibilityBatch", 2, fmt.Errorf("example error"))
+			},
+			wantReleased: []string{"msg-0001", "msg-0002", "msg-0003", "msg-0004", "msg-0005", "msg-0006", "msg-0007", "msg-0008", "msg-0009", "msg-0010"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := fakes.NewSQS()
+			for i := 0; i < 12; i++ {
+				queue.Send("example")
+			}
+			received := queue.Receive(12)
+			if tt.inject != nil {
+				tt.inject(queue, received)
+			}
+			events := []*createCustomerEvent{}
+			for _, message := range received {
+				events = append(events, &createCustomerEvent{SQSMessageID: message.ID, SQSReceiptHandle: message.ReceiptHandle})
+			}
+			got := releaseMessages(context.TODO(), queue, "example_queue_url", generateReleaseMessageBatchRequestEntries(events), 1)
+			if !reflect.DeepEqual(got, tt.wantReleased) {
+				t.Errorf("releaseMessages() = %v, want %v", got, tt.wantReleased)
+			}
+			if released := queue.Released(); !reflect.DeepEqual(released, tt.wantReleased) {
+				t.Errorf("queue released %v, want %v", released, tt.wantReleased)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package onboarding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/audit"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+	"github.com/seanturner026/maido-lambdas/internal/tracing"
+	log "github.com/sirupsen/logrus"
+)
+
+// Values of the OnboardingStatus attribute on the USER#<id>/USER#MAIDO item. Each stage records its own status
+// once a user has completed it.
+const (
+	onboardingStatusPending             = "PENDING"
+	onboardingStatusStripeCreated       = "STRIPE_CREATED"
+	onboardingStatusSubscriptionCreated = "SUBSCRIPTION_CREATED"
+	onboardingStatusPersisted           = "PERSISTED"
+	onboardingStatusCognitoSynced       = "COGNITO_SYNCED"
+	onboardingStatusGroupAssigned       = "GROUP_ASSIGNED"
+	onboardingStatusWelcomeEmailSent    = "WELCOME_EMAIL_SENT"
+	onboardingStatusFailed              = "FAILED"
+)
+
+// statusOrder ranks the statuses a user passes through: PENDING, then the status of each stage in the order the
+// pipeline runs them. FAILED is not ranked: a failed item keeps the status it failed from in
+// OnboardingResumeStatus and a retry continues from there.
+type statusOrder map[string]int
+
+func newStatusOrder(statuses ...string) statusOrder {
+	order := statusOrder{onboardingStatusPending: 1}
+	for i, status := range statuses {
+		order[status] = i + 2
+	}
+	return order
+}
+
+// reached reports whether the event's user has already completed status in an earlier run.
+func (o statusOrder) reached(event createCustomerEvent, status string) bool {
+	return o[event.OnboardingStatus] >= o[status]
+}
+
+// onboardingState is the progress recorded on the user item by an earlier run.
+type onboardingState struct {
+	OnboardingStatus       string `dynamodbav:"OnboardingStatus"`
+	OnboardingResumeStatus string `dynamodbav:"OnboardingResumeStatus"`
+	StripeCustomerID       string `dynamodbav:"StripeCustomerID"`
+	SubscriptionID         string `dynamodbav:"SubscriptionID"`
+	SubscriptionStatus     string `dynamodbav:"SubscriptionStatus"`
+	Version                int    `dynamodbav:"Version"`
+}
+
+// status returns the status to continue from, looking through FAILED to the status the failure happened at.
+func (s onboardingState) status() string {
+	if s.OnboardingStatus != onboardingStatusFailed {
+		return s.OnboardingStatus
+	}
+	if s.OnboardingResumeStatus == "" {
+		return onboardingStatusPending
+	}
+	return s.OnboardingResumeStatus
+}
+
+type resultStatus struct {
+	Message   string
+	MessageID string
+	UserID    string
+	Error     error
+}
+
+type awsDynamoDBUpdateItemAPI interface {
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
+// startOnboarding marks the event's user PENDING unless an earlier run already recorded a status, and loads
+// that run's progress into event so completed stages can be skipped.
+func startOnboarding(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	ch chan resultStatus,
+	db awsDynamoDBUpdateItemAPI,
+	tableName string,
+	event *createCustomerEvent,
+) {
+	defer wg.Done()
+	defer recoverWorker(ctx, func(err error) {
+		ch <- resultStatus{Error: err, MessageID: event.SQSMessageID, UserID: event.CognitoUserID, Message: "Unable to start onboarding"}
+	})
+	resp, err := db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:              keySchema.UserKey(event.CognitoUserID),
+		TableName:        aws.String(tableName),
+		UpdateExpression: aws.String("SET OnboardingStatus = if_not_exists(OnboardingStatus, :pending)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: onboardingStatusPending},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if err != nil {
+		ch <- resultStatus{Error: err, MessageID: event.SQSMessageID, UserID: event.CognitoUserID, Message: "Unable to start onboarding"}
+		return
+	}
+	state := onboardingState{}
+	err = attributevalue.UnmarshalMap(resp.Attributes, &state)
+	if err != nil {
+		ch <- resultStatus{Error: err, MessageID: event.SQSMessageID, UserID: event.CognitoUserID, Message: "Unable to read onboarding status"}
+		return
+	}
+	event.OnboardingStatus = state.status()
+	event.Version = state.Version
+	// The Stripe IDs are only recorded once they exist, so a user that has not reached their stages has none.
+	event.StripeCustomerID = state.StripeCustomerID
+	event.SubscriptionID = state.SubscriptionID
+	event.SubscriptionStatus = state.SubscriptionStatus
+}
+
+// generateStatusUpdateInput moves the user item from one status to another. The condition accepts the item
+// either at from or FAILED while resuming from from, so a retry of a failed stage can move it on.
+func generateStatusUpdateInput(tableName string, event createCustomerEvent, from, to string, now time.Time) *dynamodb.UpdateItemInput {
+	set := []string{"OnboardingStatus = :to", "OnboardingStatusUpdatedAt = :now"}
+	remove := " REMOVE OnboardingResumeStatus"
+	values := map[string]types.AttributeValue{
+		":to":     &types.AttributeValueMemberS{Value: to},
+		":from":   &types.AttributeValueMemberS{Value: from},
+		":failed": &types.AttributeValueMemberS{Value: onboardingStatusFailed},
+		":now":    &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+	}
+	switch to {
+	case onboardingStatusFailed:
+		set = append(set, "OnboardingResumeStatus = :from")
+		remove = ""
+	case onboardingStatusStripeCreated:
+		// Keep the Stripe customer with the status so a retry after a failed write does not create a second one.
+		set = append(set, "StripeCustomerID = :customer", "GSI1PK = :stripe")
+		values[":customer"] = &types.AttributeValueMemberS{Value: event.StripeCustomerID}
+		values[":stripe"] = &types.AttributeValueMemberS{Value: keySchema.StripePK(event.StripeCustomerID)}
+	case onboardingStatusSubscriptionCreated:
+		// Events that skip their subscription complete the stage without one.
+		if event.SubscriptionID != "" {
+			set = append(set, "SubscriptionID = :subscription", "SubscriptionStatus = :subscriptionStatus")
+			values[":subscription"] = &types.AttributeValueMemberS{Value: event.SubscriptionID}
+			values[":subscriptionStatus"] = &types.AttributeValueMemberS{Value: event.SubscriptionStatus}
+		}
+	}
+	return &dynamodb.UpdateItemInput{
+		Key:                       keySchema.UserKey(event.CognitoUserID),
+		TableName:                 aws.String(tableName),
+		UpdateExpression:          aws.String("SET " + strings.Join(set, ", ") + remove),
+		ConditionExpression:       aws.String("OnboardingStatus = :from OR (OnboardingStatus = :failed AND OnboardingResumeStatus = :from)"),
+		ExpressionAttributeValues: values,
+	}
+}
+
+// auditStatusUpdate records the attributes a successful generateStatusUpdateInput wrote to the event's user item.
+func auditStatusUpdate(ctx context.Context, tableName string, event createCustomerEvent, from, to string) {
+	after := map[string]string{"OnboardingStatus": to}
+	switch to {
+	case onboardingStatusFailed:
+		after["OnboardingResumeStatus"] = from
+	case onboardingStatusStripeCreated:
+		after["StripeCustomerID"] = event.StripeCustomerID
+		after["GSI1PK"] = keySchema.StripePK(event.StripeCustomerID)
+	case onboardingStatusSubscriptionCreated:
+		if event.SubscriptionID != "" {
+			after["SubscriptionID"] = event.SubscriptionID
+			after["SubscriptionStatus"] = event.SubscriptionStatus
+		}
+	}
+	recordAudit(ctx, event.CognitoUserID, audit.Change{
+		Action:  auditOnboardingStatusUpdated,
+		Targets: map[string]string{"TableName": tableName, "PK": keySchema.UserPK(event.CognitoUserID), "SK": keySchema.UserSortKey},
+		Before:  map[string]string{"OnboardingStatus": from},
+		After:   after,
+	})
+}
+
+// advanceOnboarding applies statuses to the event's user in order, each conditional on the one before, and
+// stops at the first write that fails.
+func advanceOnboarding(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	ch chan resultStatus,
+	db awsDynamoDBUpdateItemAPI,
+	tableName string,
+	event createCustomerEvent,
+	statuses ...string,
+) {
+	defer wg.Done()
+	defer recoverWorker(ctx, func(err error) {
+		ch <- resultStatus{Error: err, MessageID: event.SQSMessageID, UserID: event.CognitoUserID, Message: "Unable to move onboarding status"}
+	})
+	from := event.OnboardingStatus
+	for _, to := range statuses {
+		_, err := db.UpdateItem(ctx, generateStatusUpdateInput(tableName, event, from, to, time.Now()))
+		if err != nil {
+			var conflict *types.ConditionalCheckFailedException
+			if errors.As(err, &conflict) {
+				err = fmt.Errorf("onboarding status is no longer %s, another run may have handled this user: %w", from, err)
+			}
+			ch <- resultStatus{
+				Error:     err,
+				MessageID: event.SQSMessageID,
+				UserID:    event.CognitoUserID,
+				Message:   fmt.Sprintf("Unable to move onboarding status from %s to %s", from, to),
+			}
+			return
+		}
+		auditStatusUpdate(ctx, tableName, event, from, to)
+		from = to
+	}
+}
+
+type statusUpdate struct {
+	Event    createCustomerEvent
+	Statuses []string
+}
+
+// updateOnboardingStatuses applies updates concurrently, as workers allows, and returns the message IDs whose update failed.
+func updateOnboardingStatuses(
+	ctx context.Context,
+	summary *Summary,
+	workers limiter,
+	db awsDynamoDBUpdateItemAPI,
+	tableName string,
+	updates []statusUpdate,
+) map[string]bool {
+	wg := &sync.WaitGroup{}
+	wg.Add(len(updates))
+	ch := make(chan resultStatus, len(updates))
+	stopStage := summary.time(stageStatus)
+	ctx, span := tracing.Start(ctx, "status")
+	for _, update := range updates {
+		update := update
+		workers.Go(func() { advanceOnboarding(ctx, wg, ch, db, tableName, update.Event, update.Statuses...) })
+	}
+	wg.Wait()
+	span.End()
+	stopStage()
+	close(ch)
+	return recordStatusResults(ctx, summary, ch, len(updates))
+}
+
+// recordStatusResults logs and records the failed status updates in ch out of attempted, and returns their
+// message IDs.
+func recordStatusResults(ctx context.Context, summary *Summary, ch chan resultStatus, attempted int) map[string]bool {
+	failed := map[string]bool{}
+	for res := range ch {
+		summary.recordStatus(res)
+		failed[res.MessageID] = true
+		logging.FromContext(ctx).WithFields(log.Fields{"cognito_user_id": res.UserID, "error": res.Error}).Error(res.Message)
+	}
+	summary.addSucceeded(stageStatus, attempted-len(failed))
+	return failed
+}
@@ -0,0 +1,242 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type mockUpdateItem struct {
+	mu       sync.Mutex
+	Response *dynamodb.UpdateItemOutput
+	// Errors are returned by successive calls; calls past the end succeed.
+	Errors []error
+	Inputs []*dynamodb.UpdateItemInput
+}
+
+func (m *mockUpdateItem) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Inputs = append(m.Inputs, params)
+	var err error
+	if len(m.Inputs) <= len(m.Errors) {
+		err = m.Errors[len(m.Inputs)-1]
+	}
+	if m.Response == nil {
+		return &dynamodb.UpdateItemOutput{}, err
+	}
+	return m.Response, err
+}
+
+func Test_statusOrder_reached(t *testing.T) {
+	order := newStatusOrder(onboardingStatusStripeCreated, onboardingStatusCognitoSynced, onboardingStatusPersisted)
+	tests := []struct {
+		name   string
+		status string
+		stage  string
+		want   bool
+	}{
+		{name: "pending", status: onboardingStatusPending, stage: onboardingStatusStripeCreated, want: false},
+		{name: "same_stage", status: onboardingStatusCognitoSynced, stage: onboardingStatusCognitoSynced, want: true},
+		{name: "earlier_stage", status: onboardingStatusPersisted, stage: onboardingStatusCognitoSynced, want: true},
+		{name: "later_stage", status: onboardingStatusCognitoSynced, stage: onboardingStatusPersisted, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := order.reached(createCustomerEvent{OnboardingStatus: tt.status}, tt.stage); got != tt.want {
+				t.Errorf("reached(%v) from %v = %v, want %v", tt.stage, tt.status, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_onboardingState_status(t *testing.T) {
+	tests := []struct {
+		name  string
+		state onboardingState
+		want  string
+	}{
+		{name: "new", state: onboardingState{}, want: ""},
+		{name: "in_progress", state: onboardingState{OnboardingStatus: onboardingStatusPersisted}, want: onboardingStatusPersisted},
+		{name: "failed", state: onboardingState{OnboardingStatus: onboardingStatusFailed, OnboardingResumeStatus: onboardingStatusStripeCreated}, want: onboardingStatusStripeCreated},
+		{name: "failed_without_resume", state: onboardingState{OnboardingStatus: onboardingStatusFailed}, want: onboardingStatusPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.state.status(); got != tt.want {
+				t.Errorf("status() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_startOnboarding(t *testing.T) {
+	tests := []struct {
+		name    string
+		db      *mockUpdateItem
+		want    createCustomerEvent
+		wantErr bool
+	}{
+		{
+			name: "new",
+			db: &mockUpdateItem{Response: &dynamodb.UpdateItemOutput{Attributes: map[string]types.AttributeValue{
+				"OnboardingStatus": &types.AttributeValueMemberS{Value: onboardingStatusPending},
+			}}},
+			want: createCustomerEvent{CognitoUserID: "12345", OnboardingStatus: onboardingStatusPending},
+		},
+		{
+			name: "resumed",
+			db: &mockUpdateItem{Response: &dynamodb.UpdateItemOutput{Attributes: map[string]types.AttributeValue{
+				"OnboardingStatus":       &types.AttributeValueMemberS{Value: onboardingStatusFailed},
+				"OnboardingResumeStatus": &types.AttributeValueMemberS{Value: onboardingStatusStripeCreated},
+				"StripeCustomerID":       &types.AttributeValueMemberS{Value: "cus_01234"},
+			}}},
+			want: createCustomerEvent{CognitoUserID: "12345", OnboardingStatus: onboardingStatusStripeCreated, StripeCustomerID: "cus_01234"},
+		},
+		{
+			name:    "error",
+			db:      &mockUpdateItem{Errors: []error{fmt.Errorf("example error")}},
+			want:    createCustomerEvent{CognitoUserID: "12345"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wg := &sync.WaitGroup{}
+			wg.Add(1)
+			ch := make(chan resultStatus, 1)
+			event := &createCustomerEvent{CognitoUserID: "12345"}
+			startOnboarding(context.TODO(), wg, ch, tt.db, "example", event)
+			close(ch)
+			if res, ok := <-ch; ok != tt.wantErr {
+				t.Errorf("startOnboarding() result = %+v, wantErr %v", res, tt.wantErr)
+			}
+			if !reflect.DeepEqual(*event, tt.want) {
+				t.Errorf("startOnboarding() event = %+v, want %+v", *event, tt.want)
+			}
+			if got := aws.ToString(tt.db.Inputs[0].UpdateExpression); got != "SET OnboardingStatus = if_not_exists(OnboardingStatus, :pending)" {
+				t.Errorf("startOnboarding() UpdateExpression = %v", got)
+			}
+		})
+	}
+}
+
+func Test_generateStatusUpdateInput(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	event := createCustomerEvent{CognitoUserID: "12345", StripeCustomerID: "cus_01234", SubscriptionID: "sub_01234", SubscriptionStatus: "incomplete"}
+	tests := []struct {
+		name       string
+		from       string
+		to         string
+		wantUpdate string
+		wantValues []string
+	}{
+		{
+			name:       "stripe_created",
+			from:       onboardingStatusPending,
+			to:         onboardingStatusStripeCreated,
+			wantUpdate: "SET OnboardingStatus = :to, OnboardingStatusUpdatedAt = :now, StripeCustomerID = :customer, GSI1PK = :stripe REMOVE OnboardingResumeStatus",
+			wantValues: []string{":to", ":from", ":failed", ":now", ":customer", ":stripe"},
+		},
+		{
+			name:       "subscription_created",
+			from:       onboardingStatusStripeCreated,
+			to:         onboardingStatusSubscriptionCreated,
+			wantUpdate: "SET OnboardingStatus = :to, OnboardingStatusUpdatedAt = :now, SubscriptionID = :subscription, SubscriptionStatus = :subscriptionStatus REMOVE OnboardingResumeStatus",
+			wantValues: []string{":to", ":from", ":failed", ":now", ":subscription", ":subscriptionStatus"},
+		},
+		{
+			name:       "persisted",
+			from:       onboardingStatusStripeCreated,
+			to:         onboardingStatusPersisted,
+			wantUpdate: "SET OnboardingStatus = :to, OnboardingStatusUpdatedAt = :now REMOVE OnboardingResumeStatus",
+			wantValues: []string{":to", ":from", ":failed", ":now"},
+		},
+		{
+			name:       "failed",
+			from:       onboardingStatusPersisted,
+			to:         onboardingStatusFailed,
+			wantUpdate: "SET OnboardingStatus = :to, OnboardingStatusUpdatedAt = :now, OnboardingResumeStatus = :from",
+			wantValues: []string{":to", ":from", ":failed", ":now"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := generateStatusUpdateInput("example", event, tt.from, tt.to, now)
+			if aws.ToString(got.UpdateExpression) != tt.wantUpdate {
+				t.Errorf("UpdateExpression = %v, want %v", aws.ToString(got.UpdateExpression), tt.wantUpdate)
+			}
+			if aws.ToString(got.ConditionExpression) != "OnboardingStatus = :from OR (OnboardingStatus = :failed AND OnboardingResumeStatus = :from)" {
+				t.Errorf("ConditionExpression = %v", aws.ToString(got.ConditionExpression))
+			}
+			if len(got.ExpressionAttributeValues) != len(tt.wantValues) {
+				t.Errorf("ExpressionAttributeValues = %v, want %v", got.ExpressionAttributeValues, tt.wantValues)
+			}
+			for _, name := range tt.wantValues {
+				if _, ok := got.ExpressionAttributeValues[name]; !ok {
+					t.Errorf("ExpressionAttributeValues missing %v", name)
+				}
+			}
+			if from := got.ExpressionAttributeValues[":from"].(*types.AttributeValueMemberS).Value; from != tt.from {
+				t.Errorf(":from = %v, want %v", from, tt.from)
+			}
+		})
+	}
+}
+
+func Test_advanceOnboarding(t *testing.T) {
+	tests := []struct {
+		name      string
+		db        *mockUpdateItem
+		statuses  []string
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "all_applied",
+			db:        &mockUpdateItem{},
+			statuses:  []string{onboardingStatusPersisted, onboardingStatusCognitoSynced},
+			wantCalls: 2,
+		},
+		{
+			name:      "stops_at_conflict",
+			db:        &mockUpdateItem{Errors: []error{&types.ConditionalCheckFailedException{}}},
+			statuses:  []string{onboardingStatusPersisted, onboardingStatusCognitoSynced},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wg := &sync.WaitGroup{}
+			wg.Add(1)
+			ch := make(chan resultStatus, 1)
+			event := createCustomerEvent{SQSMessageID: "message-1", CognitoUserID: "12345", OnboardingStatus: onboardingStatusStripeCreated}
+			advanceOnboarding(context.TODO(), wg, ch, tt.db, "example", event, tt.statuses...)
+			close(ch)
+			if len(tt.db.Inputs) != tt.wantCalls {
+				t.Errorf("advanceOnboarding() made %v calls, want %v", len(tt.db.Inputs), tt.wantCalls)
+			}
+			res, ok := <-ch
+			if ok != tt.wantErr {
+				t.Fatalf("advanceOnboarding() result = %+v, wantErr %v", res, tt.wantErr)
+			}
+			if ok && res.MessageID != "message-1" {
+				t.Errorf("advanceOnboarding() result = %+v", res)
+			}
+			if len(tt.db.Inputs) == 2 {
+				second := tt.db.Inputs[1].ExpressionAttributeValues[":from"].(*types.AttributeValueMemberS).Value
+				if second != onboardingStatusPersisted {
+					t.Errorf("advanceOnboarding() second update from = %v, want %v", second, onboardingStatusPersisted)
+				}
+			}
+		})
+	}
+}
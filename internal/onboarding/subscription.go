@@ -0,0 +1,157 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/seanturner026/maido-lambdas/internal/audit"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+	"github.com/seanturner026/maido-lambdas/internal/metrics"
+	"github.com/seanturner026/maido-lambdas/internal/tracing"
+	log "github.com/sirupsen/logrus"
+	"github.com/stripe/stripe-go/v72"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+type subscriptionConfig struct {
+	PriceID         string
+	TrialPeriodDays int64
+}
+
+// subscriptionConfigFromEnv returns nil when STRIPE_SUBSCRIPTION_PRICE_ID is not set, which drops the
+// subscription stage from the pipeline.
+func subscriptionConfigFromEnv() (*subscriptionConfig, error) {
+	priceID, ok := os.LookupEnv("STRIPE_SUBSCRIPTION_PRICE_ID")
+	if !ok || priceID == "" {
+		return nil, nil
+	}
+	cfg := &subscriptionConfig{PriceID: priceID}
+	trialPeriodDays, ok := os.LookupEnv("STRIPE_SUBSCRIPTION_TRIAL_DAYS")
+	if ok && trialPeriodDays != "" {
+		days, err := strconv.ParseInt(trialPeriodDays, 10, 64)
+		if err != nil || days < 0 {
+			return nil, fmt.Errorf("environment variable STRIPE_SUBSCRIPTION_TRIAL_DAYS must be a non-negative integer, got %q", trialPeriodDays)
+		}
+		cfg.TrialPeriodDays = days
+	}
+	return cfg, nil
+}
+
+type stripeSubscriptionCreateAPI interface {
+	New(params *stripe.SubscriptionParams) (*stripe.Subscription, error)
+}
+
+// createSubscription subscribes cognitoUserID's Stripe customer customerID to the configured price.
+func createSubscription(
+	ctx context.Context,
+	api stripeSubscriptionCreateAPI,
+	cognitoUserID, customerID string,
+	cfg subscriptionConfig,
+) (*stripe.Subscription, error) {
+	params := &stripe.SubscriptionParams{
+		Params:   stripe.Params{Context: ctx},
+		Customer: stripe.String(customerID),
+		Items: []*stripe.SubscriptionItemsParams{{
+			Price: stripe.String(cfg.PriceID),
+		}},
+		// New customers have no payment method yet, so let Stripe create the subscription in an incomplete
+		// state rather than failing when there is no trial to cover the first invoice.
+		PaymentBehavior: stripe.String("default_incomplete"),
+	}
+	if cfg.TrialPeriodDays > 0 {
+		params.TrialPeriodDays = stripe.Int64(cfg.TrialPeriodDays)
+	}
+	params.SetIdempotencyKey(idempotencyKey(stageSubscription, cognitoUserID))
+	return api.New(params)
+}
+
+// createSubscriptions subscribes the event's Stripe customer to the configured price, unless the event asks to
+// skip it or already has a subscription from an earlier run.
+func createSubscriptions(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	ch chan resultStripe,
+	apiSubscription stripeSubscriptionCreateAPI,
+	subscription subscriptionConfig,
+	event *createCustomerEvent,
+) {
+	defer wg.Done()
+	if event.SkipSubscription || event.SubscriptionID != "" {
+		return
+	}
+	defer recoverWorker(logging.WithMessage(ctx, event.SQSMessageID, event.CorrelationID), func(err error) {
+		ch <- resultStripe{Message: "Unable to create Stripe subscription", Event: *event, Error: err}
+	})
+	m := metrics.FromContext(ctx)
+	ctx, span := tracing.Start(ctx, "message", semconv.MessagingMessageIDKey.String(event.SQSMessageID))
+	defer span.End()
+	ctx = logging.WithMessage(ctx, event.SQSMessageID, event.CorrelationID)
+	_, spanSubscription := tracing.Start(ctx, "stripe.Subscriptions.New")
+	sub, err := createSubscription(ctx, apiSubscription, event.CognitoUserID, event.StripeCustomerID, subscription)
+	tracing.End(spanSubscription, err)
+	if err != nil {
+		m.Count(metricStripeSubscriptionsFailed, 1)
+		ch <- resultStripe{
+			Message: "Unable to create Stripe subscription",
+			Event:   *event,
+			Error:   err,
+		}
+		return
+	}
+	m.Count(metricStripeSubscriptionsCreated, 1)
+	event.SubscriptionID = sub.ID
+	event.SubscriptionStatus = string(sub.Status)
+	recordAudit(ctx, event.CognitoUserID, audit.Change{
+		Action:  auditStripeSubscriptionCreated,
+		Targets: map[string]string{"StripeCustomerID": event.StripeCustomerID, "StripeSubscriptionID": sub.ID},
+		After:   map[string]string{"PriceID": subscription.PriceID, "SubscriptionStatus": event.SubscriptionStatus},
+	})
+	logging.FromContext(ctx).WithFields(log.Fields{"cognito_user_id": event.CognitoUserID, "stripe_subscription_id": sub.ID}).Info("Created stripe subscription")
+}
+
+// subscriptionStage subscribes each event's Stripe customer to STRIPE_SUBSCRIPTION_PRICE_ID.
+type subscriptionStage struct {
+	workers      limiter
+	api          stripeSubscriptionCreateAPI
+	subscription subscriptionConfig
+}
+
+func newSubscriptionStage(cfg pipelineConfig) (Stage, error) {
+	if cfg.subscription == nil {
+		return nil, nil
+	}
+	return subscriptionStage{workers: cfg.workers, api: cfg.handler.Stripe.Subscriptions, subscription: *cfg.subscription}, nil
+}
+
+func (s subscriptionStage) Name() string {
+	return stageSubscription
+}
+
+func (s subscriptionStage) Status() string {
+	return onboardingStatusSubscriptionCreated
+}
+
+func (s subscriptionStage) Run(ctx context.Context, events []*createCustomerEvent) stageResult {
+	wg := &sync.WaitGroup{}
+	wg.Add(len(events))
+	ch := make(chan resultStripe, len(events))
+	for _, event := range events {
+		event := event
+		s.workers.Go(func() { createSubscriptions(ctx, wg, ch, s.api, s.subscription, event) })
+	}
+	wg.Wait()
+	close(ch)
+	result := stageResult{Failed: map[string]error{}}
+	for res := range ch {
+		result.Failed[res.Event.SQSMessageID] = res.Error
+		logging.FromContext(ctx).WithFields(log.Fields{
+			"cognito_user_id":    res.Event.CognitoUserID,
+			"stripe_customer_id": res.Event.StripeCustomerID,
+			"error":              res.Error,
+		}).Error(res.Message)
+	}
+	return result
+}
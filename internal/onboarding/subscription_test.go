@@ -0,0 +1,206 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+type mockStripeSubscription struct {
+	Response *stripe.Subscription
+	Error    error
+	Params   *stripe.SubscriptionParams
+}
+
+func (m *mockStripeSubscription) New(params *stripe.SubscriptionParams) (*stripe.Subscription, error) {
+	m.Params = params
+	return m.Response, m.Error
+}
+
+func Test_subscriptionConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    *subscriptionConfig
+		wantErr bool
+	}{
+		{
+			name:    "disabled",
+			env:     map[string]string{},
+			want:    nil,
+			wantErr: false,
+		},
+		{
+			name: "price_only",
+			env: map[string]string{
+				"STRIPE_SUBSCRIPTION_PRICE_ID": "price_01234",
+			},
+			want:    &subscriptionConfig{PriceID: "price_01234"},
+			wantErr: false,
+		},
+		{
+			name: "price_and_trial",
+			env: map[string]string{
+				"STRIPE_SUBSCRIPTION_PRICE_ID":   "price_01234",
+				"STRIPE_SUBSCRIPTION_TRIAL_DAYS": "14",
+			},
+			want:    &subscriptionConfig{PriceID: "price_01234", TrialPeriodDays: 14},
+			wantErr: false,
+		},
+		{
+			name: "invalid_trial",
+			env: map[string]string{
+				"STRIPE_SUBSCRIPTION_PRICE_ID":   "price_01234",
+				"STRIPE_SUBSCRIPTION_TRIAL_DAYS": "two weeks",
+			},
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		os.Unsetenv("STRIPE_SUBSCRIPTION_PRICE_ID")
+		os.Unsetenv("STRIPE_SUBSCRIPTION_TRIAL_DAYS")
+		for k, v := range tt.env {
+			os.Setenv(k, v)
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := subscriptionConfigFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("subscriptionConfigFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("subscriptionConfigFromEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	os.Unsetenv("STRIPE_SUBSCRIPTION_PRICE_ID")
+	os.Unsetenv("STRIPE_SUBSCRIPTION_TRIAL_DAYS")
+}
+
+func Test_createSubscription(t *testing.T) {
+	tests := []struct {
+		name          string
+		api           *mockStripeSubscription
+		cfg           subscriptionConfig
+		wantTrialDays *int64
+		wantErr       bool
+	}{
+		{
+			name: "with_trial",
+			api: &mockStripeSubscription{
+				Response: &stripe.Subscription{ID: "sub_01234", Status: stripe.SubscriptionStatusTrialing},
+			},
+			cfg:           subscriptionConfig{PriceID: "price_01234", TrialPeriodDays: 14},
+			wantTrialDays: stripe.Int64(14),
+			wantErr:       false,
+		},
+		{
+			name: "without_trial",
+			api: &mockStripeSubscription{
+				Response: &stripe.Subscription{ID: "sub_01234", Status: stripe.SubscriptionStatusIncomplete},
+			},
+			cfg:           subscriptionConfig{PriceID: "price_01234"},
+			wantTrialDays: nil,
+			wantErr:       false,
+		},
+		{
+			name: "error",
+			api: &mockStripeSubscription{
+				Error: fmt.Errorf("example error"),
+			},
+			cfg:     subscriptionConfig{PriceID: "price_01234"},
+			wantErr: true,
+		},
+	}
+	ctx := context.WithValue(context.TODO(), struct{}{}, "example")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := createSubscription(ctx, tt.api, "01234567890", "cus_01234", tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("createSubscription() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.api.Params.Context != ctx {
+				t.Errorf("createSubscription() did not pass its context to Stripe")
+			}
+			if got := *tt.api.Params.Customer; got != "cus_01234" {
+				t.Errorf("createSubscription() customer = %v, want cus_01234", got)
+			}
+			if got := *tt.api.Params.Items[0].Price; got != tt.cfg.PriceID {
+				t.Errorf("createSubscription() price = %v, want %v", got, tt.cfg.PriceID)
+			}
+			if key := tt.api.Params.IdempotencyKey; key == nil || *key != "onboarding-subscription-01234567890" {
+				t.Errorf("createSubscription() idempotency key = %v, want onboarding-subscription-01234567890", stripe.StringValue(key))
+			}
+			if !reflect.DeepEqual(tt.api.Params.TrialPeriodDays, tt.wantTrialDays) {
+				t.Errorf("createSubscription() trial days = %v, want %v", tt.api.Params.TrialPeriodDays, tt.wantTrialDays)
+			}
+		})
+	}
+}
+
+func Test_createSubscriptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		event      createCustomerEvent
+		api        *mockStripeSubscription
+		wantID     string
+		wantStatus string
+		wantCalled bool
+		wantErr    bool
+	}{
+		{
+			name:       "created",
+			event:      createCustomerEvent{CognitoUserID: "56789", StripeCustomerID: "cus_01234"},
+			api:        &mockStripeSubscription{Response: &stripe.Subscription{ID: "sub_01234", Status: stripe.SubscriptionStatusTrialing}},
+			wantID:     "sub_01234",
+			wantStatus: "trialing",
+			wantCalled: true,
+		},
+		{
+			name:  "skipped_by_event",
+			event: createCustomerEvent{CognitoUserID: "56789", StripeCustomerID: "cus_01234", SkipSubscription: true},
+			api:   &mockStripeSubscription{Response: &stripe.Subscription{ID: "sub_01234", Status: stripe.SubscriptionStatusTrialing}},
+		},
+		{
+			name:       "already_subscribed",
+			event:      createCustomerEvent{CognitoUserID: "56789", StripeCustomerID: "cus_01234", SubscriptionID: "sub_56789", SubscriptionStatus: "active"},
+			api:        &mockStripeSubscription{Response: &stripe.Subscription{ID: "sub_01234", Status: stripe.SubscriptionStatusTrialing}},
+			wantID:     "sub_56789",
+			wantStatus: "active",
+		},
+		{
+			name:       "error",
+			event:      createCustomerEvent{CognitoUserID: "56789", StripeCustomerID: "cus_01234"},
+			api:        &mockStripeSubscription{Error: fmt.Errorf("example error")},
+			wantCalled: true,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wg := &sync.WaitGroup{}
+			ch := make(chan resultStripe, 1)
+			wg.Add(1)
+			event := tt.event
+			createSubscriptions(context.TODO(), wg, ch, tt.api, subscriptionConfig{PriceID: "price_01234", TrialPeriodDays: 14}, &event)
+			wg.Wait()
+			close(ch)
+			if res, ok := <-ch; ok != tt.wantErr {
+				t.Fatalf("createSubscriptions() result = %+v, wantErr %v", res, tt.wantErr)
+			}
+			if event.SubscriptionID != tt.wantID || event.SubscriptionStatus != tt.wantStatus {
+				t.Errorf("createSubscriptions() subscription = %v/%v, want %v/%v", event.SubscriptionID, event.SubscriptionStatus, tt.wantID, tt.wantStatus)
+			}
+			if (tt.api.Params != nil) != tt.wantCalled {
+				t.Errorf("createSubscriptions() called = %v, want %v", tt.api.Params != nil, tt.wantCalled)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package onboarding
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/awsbatch"
+	"github.com/seanturner026/maido-lambdas/internal/batch"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxLoggedMessages bounds the messages in the logged summary, which must fit in one CloudWatch Logs event of
+// 256KB. Succeeded messages are left out first.
+const maxLoggedMessages = 100
+
+// maxMessageErrorLength bounds the error kept for each message, so that the logged summary and the DynamoDB items
+// stay small whatever the error wraps.
+const maxMessageErrorLength = 1024
+
+// Pipeline stages reported in a Summary. They match the tracing span names and the names in ONBOARDING_STAGES.
+const (
+	stageStatus       = "status"
+	stageStripe       = "stripe"
+	stageSubscription = "subscription"
+	stageDynamoDB     = "dynamodb"
+	stageCognito      = "cognito"
+	stageGroup        = "group"
+	stageWelcomeEmail = "welcome_email"
+	stageSQS          = "sqs"
+)
+
+// Final status of a message in a Summary.
+const (
+	MessageStatusPending   = "PENDING"
+	MessageStatusSucceeded = "SUCCEEDED"
+	MessageStatusFailed    = "FAILED"
+)
+
+// StageSummary counts the outcomes of one pipeline stage.
+type StageSummary struct {
+	Succeeded  int   `dynamodbav:"Succeeded"  json:"succeeded"`
+	Failed     int   `dynamodbav:"Failed"     json:"failed"`
+	Retries    int   `dynamodbav:"Retries"    json:"retries"`
+	DurationMs int64 `dynamodbav:"DurationMs" json:"durationMs"`
+}
+
+// MessageSummary is the outcome of one message. Acknowledged messages were deleted from the queue; the rest
+// will be delivered again, straight away when they were released.
+type MessageSummary struct {
+	MessageID     string `dynamodbav:"MessageID"             json:"messageID"`
+	CorrelationID string `dynamodbav:"CorrelationID"         json:"correlationID"`
+	CognitoUserID string `dynamodbav:"CognitoUserID"         json:"cognitoUserID"`
+	Status        string `dynamodbav:"Status"                json:"status"`
+	FailedStage   string `dynamodbav:"FailedStage,omitempty" json:"failedStage,omitempty"`
+	Error         string `dynamodbav:"Error,omitempty"       json:"error,omitempty"`
+	Acknowledged  bool   `dynamodbav:"Acknowledged"          json:"acknowledged"`
+	Released      bool   `dynamodbav:"Released,omitempty"    json:"released,omitempty"`
+}
+
+// Summary reports what happened to every message in a batch. It is logged as a single line at the end of each
+// invocation, with only the messages that did not succeed, and can be written to DynamoDB as a run item and one
+// item per message under the run's partition.
+type Summary struct {
+	PK         string                   `dynamodbav:"PK"         json:"-"`
+	SK         string                   `dynamodbav:"SK"         json:"-"`
+	RequestID  string                   `dynamodbav:"RequestID"  json:"requestID"`
+	StartedAt  string                   `dynamodbav:"StartedAt"  json:"startedAt"`
+	DurationMs int64                    `dynamodbav:"DurationMs" json:"durationMs"`
+	Received   int                      `dynamodbav:"Received"   json:"received"`
+	Stages     map[string]*StageSummary `dynamodbav:"Stages"     json:"stages"`
+	Messages   []*MessageSummary        `dynamodbav:"-"          json:"messages"`
+	// MessagesOmitted counts the messages left out of the logged summary.
+	MessagesOmitted int `dynamodbav:"-" json:"messagesOmitted,omitempty"`
+	startedAt       time.Time
+	byMessage       map[string]*MessageSummary
+	byUser          map[string][]*MessageSummary
+}
+
+func newSummary(requestID string, startedAt time.Time, received int) *Summary {
+	return &Summary{
+		RequestID: requestID,
+		StartedAt: startedAt.UTC().Format(time.RFC3339),
+		Received:  received,
+		Stages:    map[string]*StageSummary{},
+		Messages:  []*MessageSummary{},
+		startedAt: startedAt,
+		byMessage: map[string]*MessageSummary{},
+		byUser:    map[string][]*MessageSummary{},
+	}
+}
+
+// addMessages tracks events as pending until a stage reports on them.
+func (s *Summary) addMessages(events []*createCustomerEvent) {
+	for _, event := range events {
+		message := &MessageSummary{
+			MessageID:     event.SQSMessageID,
+			CorrelationID: event.CorrelationID,
+			CognitoUserID: event.CognitoUserID,
+			Status:        MessageStatusPending,
+		}
+		s.Messages = append(s.Messages, message)
+		s.byMessage[message.MessageID] = message
+		s.byUser[message.CognitoUserID] = append(s.byUser[message.CognitoUserID], message)
+	}
+}
+
+func (s *Summary) stage(name string) *StageSummary {
+	stage, ok := s.Stages[name]
+	if !ok {
+		stage = &StageSummary{}
+		s.Stages[name] = stage
+	}
+	return stage
+}
+
+// time starts timing stage and returns a function that adds the elapsed time to its duration.
+func (s *Summary) time(name string) func() {
+	start := time.Now()
+	return func() {
+		s.stage(name).DurationMs += time.Since(start).Milliseconds()
+	}
+}
+
+// fail marks message as failed at stage. Only the first failure of a message is kept.
+func (s *Summary) fail(message *MessageSummary, stage string, err error) {
+	if message == nil || message.FailedStage != "" {
+		return
+	}
+	message.Status = MessageStatusFailed
+	message.FailedStage = stage
+	if err != nil {
+		message.Error = err.Error()
+		if len(message.Error) > maxMessageErrorLength {
+			message.Error = message.Error[:maxMessageErrorLength]
+		}
+	}
+}
+
+// recordStage records the outcome of a stage that was given attempted events.
+func (s *Summary) recordStage(name string, attempted int, result stageResult) {
+	stage := s.stage(name)
+	stage.Succeeded += attempted - len(result.Failed)
+	stage.Failed += len(result.Failed)
+	stage.Retries += result.Retries
+	for messageID, err := range result.Failed {
+		s.fail(s.byMessage[messageID], name, err)
+	}
+}
+
+// recordUnfinished marks the messages of events failed at stage, which the invocation ran out of time to run.
+func (s *Summary) recordUnfinished(name string, events []*createCustomerEvent, err error) {
+	for _, event := range events {
+		s.fail(s.byMessage[event.SQSMessageID], name, err)
+	}
+}
+
+func (s *Summary) recordStatus(res resultStatus) {
+	if res.Error == nil {
+		return
+	}
+	s.stage(stageStatus).Failed++
+	s.fail(s.byMessage[res.MessageID], stageStatus, res.Error)
+}
+
+// addSucceeded adds n successes to a stage that runs more than once per invocation.
+func (s *Summary) addSucceeded(name string, n int) {
+	s.stage(name).Succeeded += n
+}
+
+func (s *Summary) recordSQS(res resultSQS) {
+	stage := s.stage(stageSQS)
+	messageIDs := res.MessageIDS
+	for _, failure := range res.FailedDeleteMessages {
+		entry := struct {
+			ID string `json:"id"`
+		}{}
+		if err := json.Unmarshal([]byte(failure), &entry); err == nil {
+			messageIDs = append(messageIDs, entry.ID)
+		}
+	}
+	err := res.Error
+	if err == nil {
+		err = fmt.Errorf("%s", res.Message)
+	}
+	for _, messageID := range messageIDs {
+		stage.Failed++
+		message, ok := s.byMessage[messageID]
+		if !ok {
+			continue
+		}
+		if message.Acknowledged {
+			message.Acknowledged = false
+			stage.Succeeded--
+		}
+		s.fail(message, stageSQS, err)
+	}
+}
+
+// acknowledge marks the messages sent for deletion as acknowledged. It is called before the delete failures
+// are recorded, which then take the acknowledgement back.
+func (s *Summary) acknowledge(items items) {
+	for _, item := range items.Items {
+		if message, ok := s.byMessage[item.SQSMessageID]; ok {
+			message.Acknowledged = true
+		}
+	}
+	s.stage(stageSQS).Succeeded += len(items.Items)
+}
+
+// release marks the messages the invocation ran out of time for and made visible again.
+func (s *Summary) release(messageIDs []string) {
+	for _, messageID := range messageIDs {
+		if message, ok := s.byMessage[messageID]; ok {
+			message.Released = true
+		}
+	}
+}
+
+// finish settles the final status of each message and the total duration.
+func (s *Summary) finish(now time.Time) {
+	for _, message := range s.Messages {
+		if message.Status == MessageStatusPending && message.Acknowledged {
+			message.Status = MessageStatusSucceeded
+		}
+	}
+	s.DurationMs = now.Sub(s.startedAt).Milliseconds()
+}
+
+// Redact rewrites the Cognito user IDs in the logged copy of the summary.
+func (s *Summary) Redact(rewrite func(field, value string) string) interface{} {
+	redacted := *s
+	redacted.Messages = make([]*MessageSummary, len(s.Messages))
+	for i, message := range s.Messages {
+		m := *message
+		m.CognitoUserID = rewrite("cognito_user_id", m.CognitoUserID)
+		redacted.Messages[i] = &m
+	}
+	return &redacted
+}
+
+// logged returns the copy of the summary that is logged: the messages that did not succeed, up to
+// maxLoggedMessages of them, with the rest counted in MessagesOmitted.
+func (s *Summary) logged() *Summary {
+	logged := *s
+	logged.Messages = []*MessageSummary{}
+	for _, message := range s.Messages {
+		if message.Status == MessageStatusSucceeded || len(logged.Messages) == maxLoggedMessages {
+			logged.MessagesOmitted++
+			continue
+		}
+		logged.Messages = append(logged.Messages, message)
+	}
+	return &logged
+}
+
+func logSummary(ctx context.Context, summary *Summary) {
+	if summary == nil {
+		return
+	}
+	logging.FromContext(ctx).WithFields(log.Fields{"summary": summary.logged()}).Info("Invocation summary")
+}
+
+type awsDynamoDBPutItemAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// awsDynamoDBSummaryAPI is the DynamoDB calls writeSummary makes.
+type awsDynamoDBSummaryAPI interface {
+	awsDynamoDBPutItemAPI
+	awsDynamoDBAPI
+}
+
+// messageSummaryItem is the item a MessageSummary is written as, keyed under its run's partition.
+type messageSummaryItem struct {
+	PK        string `dynamodbav:"PK"`
+	SK        string `dynamodbav:"SK"`
+	RequestID string `dynamodbav:"RequestID"`
+	MessageSummary
+}
+
+// runSummaryEnabled reports whether invocation summaries should be written to DynamoDB.
+func runSummaryEnabled() bool {
+	return os.Getenv("RUN_SUMMARY_ENABLED") == "true"
+}
+
+// writeSummary stores summary in the onboarding table: the stage counts in the run item and each message in an
+// item of its own beside it, so that no item grows with the size of the batch.
+func writeSummary(ctx context.Context, db awsDynamoDBSummaryAPI, summary *Summary) error {
+	tableName, ok := os.LookupEnv("DYNAMODB_TABLE_NAME")
+	if !ok {
+		return fmt.Errorf("environment variable DYNAMODB_TABLE_NAME is not set")
+	}
+	if summary.RequestID == "" {
+		return fmt.Errorf("invocation summary has no request ID")
+	}
+	summary.PK = keySchema.RunPK(summary.RequestID)
+	summary.SK = keySchema.RunSortKey
+	item, err := attributevalue.MarshalMap(summary)
+	if err != nil {
+		return err
+	}
+	_, err = db.PutItem(ctx, &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return err
+	}
+	writeRequests := make([]types.WriteRequest, 0, len(summary.Messages))
+	for _, message := range summary.Messages {
+		item, err := attributevalue.MarshalMap(messageSummaryItem{
+			PK:             summary.PK,
+			SK:             keySchema.RunMessageSK(message.MessageID),
+			RequestID:      summary.RequestID,
+			MessageSummary: *message,
+		})
+		if err != nil {
+			return err
+		}
+		writeRequests = append(writeRequests, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+	}
+	opts := batch.Options{Concurrency: 1, MaxAttempts: maxBatchWriteItemAttempts}
+	failed := 0
+	for _, res := range awsbatch.WriteItems(ctx, db, tableName, writeRequests, opts, nil) {
+		if res.Err != nil {
+			failed++
+			err = res.Err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d message summaries were not written: %w", failed, len(writeRequests), err)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package onboarding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/seanturner026/maido-lambdas/internal/fakes"
+)
+
+func newTestSummary() *Summary {
+	summary := newSummary("request-1", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), 4)
+	summary.addMessages([]*createCustomerEvent{
+		{SQSMessageID: "message-1", CorrelationID: "correlation-1", CognitoUserID: "user-1"},
+		{SQSMessageID: "message-2", CorrelationID: "message-2", CognitoUserID: "user-2"},
+		{SQSMessageID: "message-3", CorrelationID: "message-3", CognitoUserID: "user-3"},
+		{SQSMessageID: "message-4", CorrelationID: "message-4", CognitoUserID: "user-4"},
+	})
+	return summary
+}
+
+func Test_invocationSummary(t *testing.T) {
+	summary := newTestSummary()
+	summary.recordStage(stageStripe, 4, stageResult{Failed: map[string]error{"message-1": fmt.Errorf("card declined")}})
+	summary.recordStage(stageDynamoDB, 3, stageResult{Failed: map[string]error{"message-2": fmt.Errorf("throttled")}, Retries: 3})
+	summary.recordStage(stageCognito, 2, stageResult{Failed: map[string]error{"message-3": fmt.Errorf("user not found")}})
+	summary.recordStage(stageGroup, 1, stageResult{})
+	summary.acknowledge(items{Items: []createCustomerEvent{
+		{SQSMessageID: "message-2"},
+		{SQSMessageID: "message-3"},
+		{SQSMessageID: "message-4"},
+	}})
+	summary.recordSQS(resultSQS{FailedDeleteMessages: []string{`{"id":"message-4","sender_fault":false,"message":"gone"}`}, Message: "Messages failed to batch delete"})
+	summary.finish(time.Date(2022, 1, 1, 0, 0, 1, 0, time.UTC))
+
+	wantStages := map[string]*StageSummary{
+		stageStripe:   {Succeeded: 3, Failed: 1},
+		stageDynamoDB: {Succeeded: 2, Failed: 1, Retries: 3},
+		stageCognito:  {Succeeded: 1, Failed: 1},
+		stageGroup:    {Succeeded: 1},
+		stageSQS:      {Succeeded: 2, Failed: 1},
+	}
+	if !reflect.DeepEqual(summary.Stages, wantStages) {
+		for name, stage := range summary.Stages {
+			t.Errorf("stage %s = %+v, want %+v", name, *stage, wantStages[name])
+		}
+	}
+	wantMessages := []*MessageSummary{
+		{MessageID: "message-1", CorrelationID: "correlation-1", CognitoUserID: "user-1", Status: MessageStatusFailed, FailedStage: stageStripe, Error: "card declined"},
+		{MessageID: "message-2", CorrelationID: "message-2", CognitoUserID: "user-2", Status: MessageStatusFailed, FailedStage: stageDynamoDB, Error: "throttled", Acknowledged: true},
+		{MessageID: "message-3", CorrelationID: "message-3", CognitoUserID: "user-3", Status: MessageStatusFailed, FailedStage: stageCognito, Error: "user not found", Acknowledged: true},
+		{MessageID: "message-4", CorrelationID: "message-4", CognitoUserID: "user-4", Status: MessageStatusFailed, FailedStage: stageSQS, Error: "Messages failed to batch delete"},
+	}
+	for i, want := range wantMessages {
+		if got := summary.Messages[i]; !reflect.DeepEqual(got, want) {
+			t.Errorf("message %d = %+v, want %+v", i, *got, *want)
+		}
+	}
+	if summary.DurationMs != 1000 {
+		t.Errorf("DurationMs = %v, want 1000", summary.DurationMs)
+	}
+}
+
+func Test_invocationSummary_succeeded(t *testing.T) {
+	summary := newTestSummary()
+	summary.acknowledge(items{Items: []createCustomerEvent{{SQSMessageID: "message-1"}}})
+	summary.finish(time.Now())
+	want := []string{MessageStatusSucceeded, MessageStatusPending, MessageStatusPending, MessageStatusPending}
+	for i, message := range summary.Messages {
+		if message.Status != want[i] {
+			t.Errorf("message %d status = %v, want %v", i, message.Status, want[i])
+		}
+	}
+}
+
+func Test_invocationSummary_Redact(t *testing.T) {
+	summary := newTestSummary()
+	redacted := summary.Redact(func(field, value string) string {
+		if field == "cognito_user_id" {
+			return "[REDACTED]"
+		}
+		return value
+	}).(*Summary)
+	if redacted.Messages[0].CognitoUserID != "[REDACTED]" || redacted.Messages[0].MessageID != "message-1" {
+		t.Errorf("Redact() message = %+v", *redacted.Messages[0])
+	}
+	if summary.Messages[0].CognitoUserID != "user-1" {
+		t.Errorf("Redact() modified the summary: %+v", *summary.Messages[0])
+	}
+}
+
+func Test_invocationSummary_logged(t *testing.T) {
+	summary := newSummary("request-1", time.Now(), maxLoggedMessages+3)
+	events := []*createCustomerEvent{}
+	for i := 0; i < maxLoggedMessages+3; i++ {
+		id := fmt.Sprintf("message-%d", i)
+		events = append(events, &createCustomerEvent{SQSMessageID: id, CorrelationID: id, CognitoUserID: fmt.Sprintf("user-%d", i)})
+	}
+	summary.addMessages(events)
+	summary.acknowledge(items{Items: []createCustomerEvent{{SQSMessageID: "message-0"}}})
+	summary.finish(time.Now())
+
+	logged := summary.logged()
+	if len(logged.Messages) != maxLoggedMessages || logged.MessagesOmitted != 3 {
+		t.Errorf("logged() kept %d messages and omitted %d, want %d and 3", len(logged.Messages), logged.MessagesOmitted, maxLoggedMessages)
+	}
+	if logged.Messages[0].MessageID != "message-1" {
+		t.Errorf("logged() first message = %v, want message-1", logged.Messages[0].MessageID)
+	}
+	if len(summary.Messages) != maxLoggedMessages+3 || summary.MessagesOmitted != 0 {
+		t.Errorf("logged() modified the summary")
+	}
+}
+
+func Test_invocationSummary_errorLength(t *testing.T) {
+	summary := newTestSummary()
+	summary.recordStage(stageStripe, 4, stageResult{Failed: map[string]error{"message-1": errors.New(strings.Repeat("x", 2*maxMessageErrorLength))}})
+	if got := len(summary.Messages[0].Error); got != maxMessageErrorLength {
+		t.Errorf("error length = %v, want %v", got, maxMessageErrorLength)
+	}
+}
+
+func Test_writeSummary(t *testing.T) {
+	tests := []struct {
+		name         string
+		requestID    string
+		inject       func(db *fakes.DynamoDB)
+		wantErr      bool
+		wantMessages int
+	}{
+		{
+			name:         "written",
+			requestID:    "request-1",
+			wantMessages: 4,
+		},
+		{
+			name:      "no_request_id",
+			requestID: "",
+			wantErr:   true,
+		},
+		{
+			name:      "put_error",
+			requestID: "request-1",
+			inject:    func(db *fakes.DynamoDB) { db.FailOn("PutItem", 1, fmt.Errorf("example error")) },
+			wantErr:   true,
+		},
+		{
+			name:      "message_error",
+			requestID: "request-1",
+			inject:    func(db *fakes.DynamoDB) { db.FailOn("BatchWriteItem", 1, fmt.Errorf("example error")) },
+			wantErr:   true,
+		},
+	}
+	t.Setenv("DYNAMODB_TABLE_NAME", "example")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := fakes.NewDynamoDB()
+			if tt.inject != nil {
+				tt.inject(db)
+			}
+			summary := newTestSummary()
+			summary.RequestID = tt.requestID
+			err := writeSummary(context.TODO(), db, summary)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeSummary() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			run := map[string]interface{}{}
+			if err := attributevalue.UnmarshalMap(db.Item("example", "RUN#request-1", "RUN#MAIDO"), &run); err != nil {
+				t.Fatal(err)
+			}
+			if run["RequestID"] != "request-1" || run["Messages"] != nil {
+				t.Errorf("writeSummary() run item = %+v", run)
+			}
+			if got := len(db.Items("example")); got != 1+tt.wantMessages {
+				t.Errorf("writeSummary() wrote %d items, want %d", got, 1+tt.wantMessages)
+			}
+			message := messageSummaryItem{}
+			if err := attributevalue.UnmarshalMap(db.Item("example", "RUN#request-1", "MESSAGE#message-1"), &message); err != nil {
+				t.Fatal(err)
+			}
+			if message.CognitoUserID != "user-1" || message.Status != MessageStatusPending {
+				t.Errorf("writeSummary() message item = %+v", message)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package onboarding
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/seanturner026/maido-lambdas/internal/tracing/tracingtest"
+	"github.com/stripe/stripe-go/v72"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func Test_createCustomers_tracing(t *testing.T) {
+	recorder := tracingtest.NewRecorder(t, "stripe_onboarding")
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	event := &createCustomerEvent{SQSMessageID: "12345"}
+	createCustomers(context.TODO(), wg, make(chan resultStripe, 1), mockStripeCustomer{Response: &stripe.Customer{ID: "cus_01234"}}, nil, event)
+	createSubscriptions(
+		context.TODO(),
+		wg,
+		make(chan resultStripe, 1),
+		&mockStripeSubscription{Response: &stripe.Subscription{ID: "sub_01234"}},
+		subscriptionConfig{PriceID: "price_01234"},
+		event,
+	)
+	wg.Wait()
+	spans := recorder.Ended()
+	messages := []sdktrace.ReadOnlySpan{}
+	parents := map[string]trace.SpanID{}
+	for _, span := range spans {
+		if span.Name() == "message" {
+			messages = append(messages, span)
+			continue
+		}
+		parents[span.Name()] = span.Parent().SpanID()
+	}
+	if len(messages) != 2 {
+		t.Fatalf("spans = %v, want a message span from each worker", spans)
+	}
+	for i, name := range []string{"stripe.Customers.New", "stripe.Subscriptions.New"} {
+		parent, ok := parents[name]
+		if !ok {
+			t.Errorf("missing span %s", name)
+			continue
+		}
+		if parent != messages[i].SpanContext().SpanID() {
+			t.Errorf("%s parent = %v, want its worker's message span", name, parent)
+		}
+	}
+}
+
+func Test_handler_tracing(t *testing.T) {
+	recorder := tracingtest.NewRecorder(t, "stripe_onboarding")
+	os.Setenv("SQS_QUEUE_URL", "example")
+	os.Setenv("DYNAMODB_TABLE_NAME", "example")
+	event := events.SQSEvent{Records: []events.SQSMessage{}}
+	event.Records = append(event.Records, events.SQSMessage{
+		MessageId:  "12345",
+		Body:       "not json",
+		Attributes: map[string]string{"AWSTraceHeader": "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"},
+	})
+	if _, err := (&Handler{}).Handle(context.TODO(), event); err == nil {
+		t.Fatal("handler() error = nil, want unmarshal error")
+	}
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("handler() recorded %v spans, want unmarshal and root", len(spans))
+	}
+	unmarshal, root := spans[0], spans[1]
+	if unmarshal.Name() != "unmarshal" || root.Name() != "stripe_onboarding" {
+		t.Errorf("handler() spans = %v, %v", unmarshal.Name(), root.Name())
+	}
+	if got := root.SpanContext().TraceID().String(); got != "5759e988bd862e3fe1be46a994272793" {
+		t.Errorf("handler() trace ID = %v, want producer trace", got)
+	}
+	if unmarshal.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Errorf("unmarshal parent = %v, want root", unmarshal.Parent().SpanID())
+	}
+}
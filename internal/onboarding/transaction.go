@@ -0,0 +1,292 @@
+package onboarding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/metrics"
+)
+
+// transactWriteItemsLimit is the number of items DynamoDB accepts in a single TransactWriteItems call.
+const transactWriteItemsLimit = 25
+
+type awsDynamoDBTransactAPI interface {
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+// awsDynamoDBTransactUserItemAPI reads back the user item Version when a transaction fails its Version condition.
+type awsDynamoDBTransactUserItemAPI interface {
+	awsDynamoDBTransactAPI
+	awsDynamoDBGetItemAPI
+}
+
+// stripeLookupItem maps a Stripe customer back to the user it was created for. It is written in the same
+// transaction as the user item, so a customer is never visible without its user or claimed by two users.
+type stripeLookupItem struct {
+	PK               string `dynamodbav:"PK"`
+	SK               string `dynamodbav:"SK"`
+	StripeCustomerID string `dynamodbav:"StripeCustomerID"`
+	CognitoUserID    string `dynamodbav:"CognitoUserID"`
+}
+
+// transactItemCount returns the number of items generateTransactWriteItems writes for event.
+func transactItemCount(event createCustomerEvent) int {
+	if event.ReferralCode != "" {
+		return 3
+	}
+	return 2
+}
+
+// generateTransactWriteItems returns the writes for event's user item, its Stripe reverse-lookup item and its
+// referral item. The user item is updated with the same Version condition as in update mode. The other items
+// may only be created, or rewritten for the same user when a retry follows a transaction that succeeded.
+func generateTransactWriteItems(ctx context.Context, tableName string, event createCustomerEvent) ([]types.TransactWriteItem, error) {
+	update, err := generateUserItemUpdateInput(ctx, tableName, event, event.Version)
+	if err != nil {
+		return nil, err
+	}
+	lookup, err := attributevalue.MarshalMap(stripeLookupItem{
+		PK:               keySchema.StripePK(event.StripeCustomerID),
+		SK:               keySchema.UserPK(event.CognitoUserID),
+		StripeCustomerID: event.StripeCustomerID,
+		CognitoUserID:    event.CognitoUserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	user := map[string]types.AttributeValue{
+		":user": &types.AttributeValueMemberS{Value: event.CognitoUserID},
+	}
+	transactItems := []types.TransactWriteItem{
+		{Update: &types.Update{
+			Key:                       update.Key,
+			TableName:                 update.TableName,
+			UpdateExpression:          update.UpdateExpression,
+			ConditionExpression:       update.ConditionExpression,
+			ExpressionAttributeNames:  update.ExpressionAttributeNames,
+			ExpressionAttributeValues: update.ExpressionAttributeValues,
+		}},
+		{Put: &types.Put{
+			Item:                      lookup,
+			TableName:                 aws.String(tableName),
+			ConditionExpression:       aws.String("attribute_not_exists(PK) OR CognitoUserID = :user"),
+			ExpressionAttributeValues: user,
+		}},
+	}
+	if event.ReferralCode != "" {
+		referral, err := generateReferralPutRequestInput(event, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		transactItems = append(transactItems, types.TransactWriteItem{Put: &types.Put{
+			Item:                      referral,
+			TableName:                 aws.String(tableName),
+			ConditionExpression:       aws.String("attribute_not_exists(PK) OR RefereeCognitoUserID = :user"),
+			ExpressionAttributeValues: user,
+		}})
+	}
+	return transactItems, nil
+}
+
+// generateTransactionBatches groups events into transactions of at most transactWriteItemsLimit items, keeping
+// each user's items in the same transaction. Only the first event of a user is kept: duplicate messages carry the
+// same user, a transaction may not touch an item twice, and the outcome of the kept event is reported for every
+// message of the user.
+func generateTransactionBatches(events []createCustomerEvent) [][]createCustomerEvent {
+	batches := [][]createCustomerEvent{}
+	batch := []createCustomerEvent{}
+	count := 0
+	seen := map[string]bool{}
+	for _, event := range events {
+		if seen[event.CognitoUserID] {
+			continue
+		}
+		seen[event.CognitoUserID] = true
+		if count+transactItemCount(event) > transactWriteItemsLimit {
+			batches = append(batches, batch)
+			batch = []createCustomerEvent{}
+			count = 0
+		}
+		batch = append(batch, event)
+		count += transactItemCount(event)
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// cancelledUserIDs returns the users whose items caused a transaction to be cancelled. owners holds the user
+// of each item in the transaction, in the same order as reasons.
+func cancelledUserIDs(reasons []types.CancellationReason, owners []string) ([]string, []string) {
+	userIDs := []string{}
+	details := []string{}
+	seen := map[string]bool{}
+	for i, reason := range reasons {
+		code := aws.ToString(reason.Code)
+		if code == "" || code == "None" || i >= len(owners) {
+			continue
+		}
+		details = append(details, fmt.Sprintf("%s: %s", owners[i], code))
+		if !seen[owners[i]] {
+			seen[owners[i]] = true
+			userIDs = append(userIDs, owners[i])
+		}
+	}
+	sort.Strings(userIDs)
+	return userIDs, details
+}
+
+// versionConflictUserIDs returns the users whose only cancellation reasons are ConditionalCheckFailed on their
+// user item update, meaning the user item's Version changed since it was read.
+func versionConflictUserIDs(reasons []types.CancellationReason, transactItems []types.TransactWriteItem, owners []string) map[string]bool {
+	conflicts := map[string]bool{}
+	others := map[string]bool{}
+	for i, reason := range reasons {
+		code := aws.ToString(reason.Code)
+		if code == "" || code == "None" || i >= len(owners) || i >= len(transactItems) {
+			continue
+		}
+		if code == "ConditionalCheckFailed" && transactItems[i].Update != nil {
+			conflicts[owners[i]] = true
+		} else {
+			others[owners[i]] = true
+		}
+	}
+	for userID := range others {
+		delete(conflicts, userID)
+	}
+	return conflicts
+}
+
+// transactWriteItems writes events' items in a single transaction. When the transaction is cancelled, the users
+// whose items caused it are reported as failed and the transaction is tried again for everyone else. Users whose
+// user item Version changed are not failed: as in update mode, their Version is read again and they are retried,
+// up to userItemUpdateAttempts times.
+func transactWriteItems(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	ch chan resultDB,
+	db awsDynamoDBTransactUserItemAPI,
+	tableName string,
+	events []createCustomerEvent,
+) {
+	defer wg.Done()
+	defer recoverWorker(ctx, func(err error) {
+		userIDs := []string{}
+		for _, event := range events {
+			userIDs = append(userIDs, event.CognitoUserID)
+		}
+		ch <- resultDB{Error: err, UserIDS: userIDs, Message: "Error writing Stripe Customer IDs to dynamodb"}
+	})
+	m := metrics.FromContext(ctx)
+	result := resultDB{Message: "Error writing Stripe Customer IDs to dynamodb"}
+	fail := func(err error, events []createCustomerEvent) {
+		for _, event := range events {
+			m.Count(metricDynamoDBItemsFailed, transactItemCount(event))
+			result.UserIDS = append(result.UserIDS, event.CognitoUserID)
+		}
+		if result.Error != nil {
+			err = fmt.Errorf("%v; %w", result.Error, err)
+		}
+		result.Error = err
+	}
+	pending := events
+	attempts := map[string]int{}
+	for len(pending) > 0 {
+		input := &dynamodb.TransactWriteItemsInput{}
+		owners := []string{}
+		for _, event := range pending {
+			transactItems, err := generateTransactWriteItems(ctx, tableName, event)
+			if err != nil {
+				fail(err, pending)
+				ch <- result
+				return
+			}
+			input.TransactItems = append(input.TransactItems, transactItems...)
+			for range transactItems {
+				owners = append(owners, event.CognitoUserID)
+			}
+		}
+		_, err := db.TransactWriteItems(ctx, input)
+		if err == nil {
+			m.Count(metricDynamoDBItemsWritten, len(input.TransactItems))
+			auditTransactWriteItems(ctx, tableName, pending, input.TransactItems)
+			break
+		}
+		var cancelled *types.TransactionCanceledException
+		if !errors.As(err, &cancelled) {
+			fail(err, pending)
+			break
+		}
+		userIDs, details := cancelledUserIDs(cancelled.CancellationReasons, owners)
+		if len(userIDs) == 0 {
+			fail(err, pending)
+			break
+		}
+		failed := map[string]bool{}
+		for _, userID := range userIDs {
+			failed[userID] = true
+		}
+		conflicts := versionConflictUserIDs(cancelled.CancellationReasons, input.TransactItems, owners)
+		remaining := []createCustomerEvent{}
+		culprits := []createCustomerEvent{}
+		for _, event := range pending {
+			switch {
+			case !failed[event.CognitoUserID]:
+				remaining = append(remaining, event)
+			case conflicts[event.CognitoUserID]:
+				m.Count(metricDynamoDBVersionConflicts, 1)
+				attempts[event.CognitoUserID]++
+				if attempts[event.CognitoUserID] >= userItemUpdateAttempts {
+					fail(fmt.Errorf("user item version changed on each of %d attempts", userItemUpdateAttempts), []createCustomerEvent{event})
+					continue
+				}
+				version, err := getUserItemVersion(ctx, db, tableName, event.CognitoUserID)
+				if err != nil {
+					fail(err, []createCustomerEvent{event})
+					continue
+				}
+				event.Version = version
+				remaining = append(remaining, event)
+			default:
+				culprits = append(culprits, event)
+			}
+		}
+		if len(culprits) > 0 {
+			fail(fmt.Errorf("transaction cancelled (%s): %w", strings.Join(details, ", "), err), culprits)
+		}
+		if len(remaining) > 0 {
+			result.Retries++
+			m.Count(metricDynamoDBUnprocessedRetried, len(remaining))
+		}
+		pending = remaining
+	}
+	// Successful transactions only report back when they needed retries, so the invocation summary can count them.
+	if result.Error != nil || result.Retries > 0 {
+		ch <- result
+	}
+}
+
+// auditTransactWriteItems records the user item updates and the items put in a successful transaction.
+func auditTransactWriteItems(ctx context.Context, tableName string, events []createCustomerEvent, transactItems []types.TransactWriteItem) {
+	for _, event := range events {
+		auditUserItemUpdate(ctx, tableName, event, nil)
+	}
+	puts := []types.WriteRequest{}
+	for _, item := range transactItems {
+		if item.Put != nil {
+			puts = append(puts, types.WriteRequest{PutRequest: &types.PutRequest{Item: item.Put.Item}})
+		}
+	}
+	auditWrittenItems(ctx, tableName, puts)
+}
@@ -0,0 +1,256 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/fakes"
+)
+
+type mockTransactWriteItems struct {
+	// Errors are returned by successive calls; calls past the end succeed.
+	Errors []error
+	Inputs []*dynamodb.TransactWriteItemsInput
+	// Version is the user item Version returned by GetItem.
+	Version int
+	Gets    int
+}
+
+func (m *mockTransactWriteItems) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	m.Gets++
+	return &dynamodb.GetItemOutput{Item: map[string]types.AttributeValue{
+		"Version": &types.AttributeValueMemberN{Value: strconv.Itoa(m.Version)},
+	}}, nil
+}
+
+func (m *mockTransactWriteItems) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	m.Inputs = append(m.Inputs, params)
+	if len(m.Inputs) <= len(m.Errors) {
+		return nil, m.Errors[len(m.Inputs)-1]
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func cancellationReasons(codes ...string) *types.TransactionCanceledException {
+	reasons := []types.CancellationReason{}
+	for _, code := range codes {
+		reasons = append(reasons, types.CancellationReason{Code: aws.String(code)})
+	}
+	return &types.TransactionCanceledException{CancellationReasons: reasons}
+}
+
+func Test_generateTransactWriteItems(t *testing.T) {
+	tests := []struct {
+		name      string
+		event     createCustomerEvent
+		wantItems int
+	}{
+		{name: "without_referral", event: createCustomerEvent{CognitoUserID: "12345", StripeCustomerID: "cus_01234"}, wantItems: 2},
+		{name: "with_referral", event: createCustomerEvent{
+			CognitoUserID:         "12345",
+			StripeCustomerID:      "cus_01234",
+			ReferralCode:          "MAIDO-ABC",
+			ReferrerCognitoUserID: "23456",
+		}, wantItems: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := generateTransactWriteItems(context.TODO(), "example", tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.wantItems || len(got) != transactItemCount(tt.event) {
+				t.Fatalf("generateTransactWriteItems() = %v items, want %v", len(got), tt.wantItems)
+			}
+			if got[0].Update == nil || aws.ToString(got[0].Update.ConditionExpression) != "attribute_not_exists(#Version)" {
+				t.Errorf("generateTransactWriteItems() user item = %+v, want a versioned update", got[0].Update)
+			}
+			lookup := got[1].Put
+			if pk := lookup.Item["PK"].(*types.AttributeValueMemberS).Value; pk != "STRIPE#cus_01234" {
+				t.Errorf("generateTransactWriteItems() lookup PK = %v, want STRIPE#cus_01234", pk)
+			}
+			if aws.ToString(lookup.ConditionExpression) != "attribute_not_exists(PK) OR CognitoUserID = :user" {
+				t.Errorf("generateTransactWriteItems() lookup condition = %v", aws.ToString(lookup.ConditionExpression))
+			}
+		})
+	}
+}
+
+func Test_generateTransactionBatches(t *testing.T) {
+	events := []createCustomerEvent{}
+	for i := 0; i < 13; i++ {
+		events = append(events, createCustomerEvent{CognitoUserID: strconv.Itoa(i)})
+	}
+	events = append(events, createCustomerEvent{CognitoUserID: "13", ReferralCode: "MAIDO-ABC"})
+	got := generateTransactionBatches(events)
+	sizes := []int{}
+	for _, batch := range got {
+		sizes = append(sizes, len(batch))
+	}
+	if want := []int{12, 2}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("generateTransactionBatches() sizes = %v, want %v", sizes, want)
+	}
+}
+
+func Test_generateTransactionBatches_duplicateUser(t *testing.T) {
+	events := []createCustomerEvent{
+		{SQSMessageID: "message-1", CognitoUserID: "1"},
+		{SQSMessageID: "message-2", CognitoUserID: "2"},
+		{SQSMessageID: "message-3", CognitoUserID: "1"},
+	}
+	got := generateTransactionBatches(events)
+	want := [][]createCustomerEvent{{events[0], events[1]}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("generateTransactionBatches() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_dynamoDBStage_transactionDuplicateUser(t *testing.T) {
+	t.Setenv("DYNAMODB_TABLE_NAME", "example")
+	db := fakes.NewDynamoDB()
+	stage := dynamoDBStage{workers: newLimiter(0), db: db, tableName: "example", writeMode: writeModeTransaction}
+	result := stage.Run(context.TODO(), []*createCustomerEvent{
+		{SQSMessageID: "message-1", CognitoUserID: "1", StripeCustomerID: "cus_1"},
+		{SQSMessageID: "message-2", CognitoUserID: "1", StripeCustomerID: "cus_1"},
+	})
+	if len(result.Failed) != 0 {
+		t.Fatalf("Run() failed = %v, want none", result.Failed)
+	}
+	db.AssertItem(t, "example", "USER#1", "USER#MAIDO", map[string]string{"StripeCustomerID": "cus_1"})
+	db.AssertCalls(t, "TransactWriteItems", 1)
+}
+
+func Test_cancelledUserIDs(t *testing.T) {
+	reasons := cancellationReasons("None", "ConditionalCheckFailed", "None", "None", "TransactionConflict", "ConditionalCheckFailed").CancellationReasons
+	owners := []string{"1", "1", "2", "2", "3", "3"}
+	gotIDs, gotDetails := cancelledUserIDs(reasons, owners)
+	if want := []string{"1", "3"}; !reflect.DeepEqual(gotIDs, want) {
+		t.Errorf("cancelledUserIDs() = %v, want %v", gotIDs, want)
+	}
+	if want := []string{"1: ConditionalCheckFailed", "3: TransactionConflict", "3: ConditionalCheckFailed"}; !reflect.DeepEqual(gotDetails, want) {
+		t.Errorf("cancelledUserIDs() details = %v, want %v", gotDetails, want)
+	}
+}
+
+func Test_versionConflictUserIDs(t *testing.T) {
+	reasons := cancellationReasons("ConditionalCheckFailed", "None", "ConditionalCheckFailed", "ConditionalCheckFailed", "None", "ConditionalCheckFailed").CancellationReasons
+	transactItems := []types.TransactWriteItem{{Update: &types.Update{}}, {Put: &types.Put{}}, {Update: &types.Update{}}, {Put: &types.Put{}}, {Update: &types.Update{}}, {Put: &types.Put{}}}
+	owners := []string{"1", "1", "2", "2", "3", "3"}
+	got := versionConflictUserIDs(reasons, transactItems, owners)
+	if want := map[string]bool{"1": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("versionConflictUserIDs() = %v, want %v", got, want)
+	}
+}
+
+func Test_transactWriteItems(t *testing.T) {
+	events := []createCustomerEvent{
+		{CognitoUserID: "1", StripeCustomerID: "cus_1"},
+		{CognitoUserID: "2", StripeCustomerID: "cus_2"},
+	}
+	tests := []struct {
+		name        string
+		db          *mockTransactWriteItems
+		wantCalls   int
+		wantResult  bool
+		wantUserIDS []string
+		wantRetries int
+		wantGets    int
+		wantVersion int
+		// wantRetried is the number of items in the second transaction.
+		wantRetried int
+	}{
+		{
+			name:      "written",
+			db:        &mockTransactWriteItems{},
+			wantCalls: 1,
+		},
+		{
+			name:        "retried_without_cancelled_user",
+			db:          &mockTransactWriteItems{Errors: []error{cancellationReasons("None", "None", "None", "ConditionalCheckFailed")}},
+			wantCalls:   2,
+			wantResult:  true,
+			wantUserIDS: []string{"2"},
+			wantRetries: 1,
+			wantRetried: 2,
+		},
+		{
+			name:        "all_cancelled",
+			db:          &mockTransactWriteItems{Errors: []error{cancellationReasons("None", "ConditionalCheckFailed", "TransactionConflict", "None")}},
+			wantCalls:   1,
+			wantResult:  true,
+			wantUserIDS: []string{"1", "2"},
+		},
+		{
+			name:        "version_conflict_retried",
+			db:          &mockTransactWriteItems{Errors: []error{cancellationReasons("ConditionalCheckFailed", "None", "None", "None")}, Version: 3},
+			wantCalls:   2,
+			wantResult:  true,
+			wantRetries: 1,
+			wantGets:    1,
+			wantVersion: 3,
+			wantRetried: 4,
+		},
+		{
+			name: "version_conflict_exhausted",
+			db: &mockTransactWriteItems{Errors: []error{
+				cancellationReasons("ConditionalCheckFailed", "None", "None", "None"),
+				cancellationReasons("ConditionalCheckFailed", "None", "None", "None"),
+				cancellationReasons("ConditionalCheckFailed", "None", "None", "None"),
+			}, Version: 3},
+			wantCalls:   4,
+			wantResult:  true,
+			wantUserIDS: []string{"1"},
+			wantRetries: 3,
+			wantGets:    2,
+		},
+		{
+			name:        "error",
+			db:          &mockTransactWriteItems{Errors: []error{fmt.Errorf("example error")}},
+			wantCalls:   1,
+			wantResult:  true,
+			wantUserIDS: []string{"1", "2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wg := &sync.WaitGroup{}
+			wg.Add(1)
+			ch := make(chan resultDB, 1)
+			transactWriteItems(context.TODO(), wg, ch, tt.db, "example", events)
+			close(ch)
+			if len(tt.db.Inputs) != tt.wantCalls {
+				t.Errorf("transactWriteItems() made %v calls, want %v", len(tt.db.Inputs), tt.wantCalls)
+			}
+			res, ok := <-ch
+			if ok != tt.wantResult {
+				t.Fatalf("transactWriteItems() result = %+v, want result %v", res, tt.wantResult)
+			}
+			if !reflect.DeepEqual(res.UserIDS, tt.wantUserIDS) {
+				t.Errorf("transactWriteItems() UserIDS = %v, want %v", res.UserIDS, tt.wantUserIDS)
+			}
+			if res.Retries != tt.wantRetries {
+				t.Errorf("transactWriteItems() Retries = %v, want %v", res.Retries, tt.wantRetries)
+			}
+			if tt.wantRetried > 0 && len(tt.db.Inputs[1].TransactItems) != tt.wantRetried {
+				t.Errorf("transactWriteItems() retried %v items, want %v", len(tt.db.Inputs[1].TransactItems), tt.wantRetried)
+			}
+			if tt.db.Gets != tt.wantGets {
+				t.Errorf("transactWriteItems() read the Version %v times, want %v", tt.db.Gets, tt.wantGets)
+			}
+			if tt.wantVersion > 0 {
+				last := tt.db.Inputs[len(tt.db.Inputs)-1].TransactItems[0].Update
+				got := last.ExpressionAttributeValues[":version"]
+				if want := (&types.AttributeValueMemberN{Value: strconv.Itoa(tt.wantVersion)}); !reflect.DeepEqual(got, want) {
+					t.Errorf("transactWriteItems() retried with version %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
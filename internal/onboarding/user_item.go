@@ -0,0 +1,203 @@
+package onboarding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/seanturner026/maido-lambdas/internal/audit"
+	"github.com/seanturner026/maido-lambdas/internal/envelope"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+	"github.com/seanturner026/maido-lambdas/internal/metrics"
+)
+
+// DynamoDB write modes for the user item, selected with DYNAMODB_WRITE_MODE.
+const (
+	// writeModeUpdate sets only the attributes this function owns with a Version condition.
+	writeModeUpdate = "update"
+	// writeModeBatch replaces the whole item with BatchWriteItem. It is only safe when nothing else writes to
+	// the item, for example in a backfill.
+	writeModeBatch = "batch"
+	// writeModeTransaction updates the user item as writeModeUpdate does, in a transaction with its Stripe
+	// reverse-lookup and referral items.
+	writeModeTransaction = "transaction"
+)
+
+// userItemUpdateAttempts is the number of times a user item update is tried when its Version has moved on.
+const userItemUpdateAttempts = 3
+
+func writeModeFromEnv() (string, error) {
+	mode, ok := os.LookupEnv("DYNAMODB_WRITE_MODE")
+	if !ok || mode == "" {
+		return writeModeUpdate, nil
+	}
+	switch mode {
+	case writeModeUpdate, writeModeBatch, writeModeTransaction:
+		return mode, nil
+	}
+	return "", fmt.Errorf(
+		"environment variable DYNAMODB_WRITE_MODE must be %s, %s or %s, got %s",
+		writeModeUpdate,
+		writeModeBatch,
+		writeModeTransaction,
+		mode,
+	)
+}
+
+type awsDynamoDBGetItemAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+}
+
+type awsDynamoDBUserItemAPI interface {
+	awsDynamoDBGetItemAPI
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
+// generateUserItemUpdateInput sets the attributes the customer event persists on the user item and bumps its
+// Version, on condition that the item is still at version. A version of 0 expects an item without a Version.
+// OnboardingStatus is left to the status updates in status.go, and personal data is encrypted when encryption
+// is enabled.
+func generateUserItemUpdateInput(ctx context.Context, tableName string, event createCustomerEvent, version int) (*dynamodb.UpdateItemInput, error) {
+	event.GSI1PK = keySchema.StripePK(event.StripeCustomerID)
+	attributes, err := envelope.FromContext(ctx).MarshalMap(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	delete(attributes, "PK")
+	delete(attributes, "SK")
+	delete(attributes, "OnboardingStatus")
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	set := []string{}
+	expressionNames := map[string]string{"#Version": "Version"}
+	expressionValues := map[string]types.AttributeValue{
+		":nextVersion": &types.AttributeValueMemberN{Value: strconv.Itoa(version + 1)},
+	}
+	for _, name := range names {
+		set = append(set, fmt.Sprintf("#%s = :%s", name, name))
+		expressionNames["#"+name] = name
+		expressionValues[":"+name] = attributes[name]
+	}
+	set = append(set, "#Version = :nextVersion")
+	condition := "attribute_not_exists(#Version)"
+	if version > 0 {
+		condition = "#Version = :version"
+		expressionValues[":version"] = &types.AttributeValueMemberN{Value: strconv.Itoa(version)}
+	}
+	return &dynamodb.UpdateItemInput{
+		Key:                       keySchema.UserKey(event.CognitoUserID),
+		TableName:                 aws.String(tableName),
+		UpdateExpression:          aws.String("SET " + strings.Join(set, ", ")),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  expressionNames,
+		ExpressionAttributeValues: expressionValues,
+		ReturnValues:              types.ReturnValueUpdatedOld,
+	}, nil
+}
+
+func getUserItemVersion(ctx context.Context, db awsDynamoDBGetItemAPI, tableName, cognitoUserID string) (int, error) {
+	resp, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		Key:                      keySchema.UserKey(cognitoUserID),
+		TableName:                aws.String(tableName),
+		ConsistentRead:           aws.Bool(true),
+		ProjectionExpression:     aws.String("#Version"),
+		ExpressionAttributeNames: map[string]string{"#Version": "Version"},
+	})
+	if err != nil {
+		return 0, err
+	}
+	item := struct {
+		Version int `dynamodbav:"Version"`
+	}{}
+	err = attributevalue.UnmarshalMap(resp.Item, &item)
+	return item.Version, err
+}
+
+// updateUserItem writes the event's attributes to its user item. When another writer has bumped the Version
+// since it was read, the Version is read again and the update retried.
+func updateUserItem(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	ch chan resultDB,
+	db awsDynamoDBUserItemAPI,
+	tableName string,
+	event createCustomerEvent,
+) {
+	defer wg.Done()
+	defer recoverWorker(logging.WithMessage(ctx, event.SQSMessageID, event.CorrelationID), func(err error) {
+		ch <- resultDB{Error: err, UserIDS: []string{event.CognitoUserID}, Message: "Error writing Stripe Customer IDs to dynamodb"}
+	})
+	m := metrics.FromContext(ctx)
+	fail := func(err error, retries int) {
+		m.Count(metricDynamoDBItemsFailed, 1)
+		ch <- resultDB{Error: err, UserIDS: []string{event.CognitoUserID}, Retries: retries, Message: "Error writing Stripe Customer IDs to dynamodb"}
+	}
+	version := event.Version
+	for attempt := 0; attempt < userItemUpdateAttempts; attempt++ {
+		if attempt > 0 {
+			var err error
+			version, err = getUserItemVersion(ctx, db, tableName, event.CognitoUserID)
+			if err != nil {
+				fail(err, attempt)
+				return
+			}
+		}
+		input, err := generateUserItemUpdateInput(ctx, tableName, event, version)
+		if err != nil {
+			fail(err, attempt)
+			return
+		}
+		resp, err := db.UpdateItem(ctx, input)
+		var conflict *types.ConditionalCheckFailedException
+		if errors.As(err, &conflict) {
+			m.Count(metricDynamoDBVersionConflicts, 1)
+			continue
+		}
+		if err != nil {
+			fail(err, attempt)
+			return
+		}
+		m.Count(metricDynamoDBItemsWritten, 1)
+		auditUserItemUpdate(ctx, tableName, event, resp.Attributes)
+		if attempt > 0 {
+			ch <- resultDB{Retries: attempt}
+		}
+		return
+	}
+	fail(fmt.Errorf("user item version changed on each of %d attempts", userItemUpdateAttempts), userItemUpdateAttempts-1)
+}
+
+// auditUserItemUpdate records an update with the previous values DynamoDB returned for the updated attributes.
+func auditUserItemUpdate(ctx context.Context, tableName string, event createCustomerEvent, old map[string]types.AttributeValue) {
+	if audit.FromContext(ctx) == nil {
+		return
+	}
+	after := auditedItem{
+		StripeCustomerID:   event.StripeCustomerID,
+		SubscriptionID:     event.SubscriptionID,
+		SubscriptionStatus: event.SubscriptionStatus,
+		PromoCode:          event.PromoCode,
+	}
+	change := audit.Change{
+		Action:  auditDynamoDBItemWritten,
+		Targets: map[string]string{"TableName": tableName, "PK": keySchema.UserPK(event.CognitoUserID), "SK": keySchema.UserSortKey},
+		After:   after.values(),
+	}
+	before := auditedItem{}
+	if err := attributevalue.UnmarshalMap(old, &before); err == nil {
+		change.Before = before.values()
+	}
+	recordAudit(ctx, event.CognitoUserID, change)
+}
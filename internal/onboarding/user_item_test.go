@@ -0,0 +1,164 @@
+package onboarding
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type mockUserItem struct {
+	mockUpdateItem
+	Version  string
+	GetCalls int
+}
+
+func (m *mockUserItem) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	m.GetCalls++
+	return &dynamodb.GetItemOutput{Item: map[string]types.AttributeValue{
+		"Version": &types.AttributeValueMemberN{Value: m.Version},
+	}}, nil
+}
+
+func Test_writeModeFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "default", value: "", want: writeModeUpdate},
+		{name: "batch", value: "batch", want: writeModeBatch},
+		{name: "transaction", value: "transaction", want: writeModeTransaction},
+		{name: "unknown", value: "example", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DYNAMODB_WRITE_MODE", tt.value)
+			got, err := writeModeFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeModeFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("writeModeFromEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_generateUserItemUpdateInput(t *testing.T) {
+	event := createCustomerEvent{
+		CognitoUserID:    "12345",
+		StripeCustomerID: "cus_01234",
+		OnboardingStatus: onboardingStatusStripeCreated,
+	}
+	tests := []struct {
+		name          string
+		version       int
+		wantCondition string
+		wantNext      string
+	}{
+		{name: "new_item", version: 0, wantCondition: "attribute_not_exists(#Version)", wantNext: "1"},
+		{name: "existing_item", version: 3, wantCondition: "#Version = :version", wantNext: "4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := generateUserItemUpdateInput(context.TODO(), "example", event, tt.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if aws.ToString(got.ConditionExpression) != tt.wantCondition {
+				t.Errorf("ConditionExpression = %v, want %v", aws.ToString(got.ConditionExpression), tt.wantCondition)
+			}
+			if next := got.ExpressionAttributeValues[":nextVersion"].(*types.AttributeValueMemberN).Value; next != tt.wantNext {
+				t.Errorf(":nextVersion = %v, want %v", next, tt.wantNext)
+			}
+			update := aws.ToString(got.UpdateExpression)
+			if !strings.Contains(update, "#StripeCustomerID = :StripeCustomerID") {
+				t.Errorf("UpdateExpression = %v, want StripeCustomerID set", update)
+			}
+			if gsi := got.ExpressionAttributeValues[":GSI1PK"].(*types.AttributeValueMemberS).Value; gsi != "STRIPE#cus_01234" {
+				t.Errorf(":GSI1PK = %v, want STRIPE#cus_01234", gsi)
+			}
+			for _, name := range []string{"PK", "SK", "OnboardingStatus"} {
+				if _, ok := got.ExpressionAttributeNames["#"+name]; ok {
+					t.Errorf("UpdateExpression = %v, should not set %v", update, name)
+				}
+			}
+		})
+	}
+}
+
+func Test_updateUserItem(t *testing.T) {
+	conflict := &types.ConditionalCheckFailedException{}
+	tests := []struct {
+		name         string
+		db           *mockUserItem
+		wantCalls    int
+		wantGetCalls int
+		wantResult   bool
+		wantErr      bool
+	}{
+		{
+			name:      "written",
+			db:        &mockUserItem{},
+			wantCalls: 1,
+		},
+		{
+			name:         "retried_after_conflict",
+			db:           &mockUserItem{mockUpdateItem: mockUpdateItem{Errors: []error{conflict}}, Version: "2"},
+			wantCalls:    2,
+			wantGetCalls: 1,
+			wantResult:   true,
+		},
+		{
+			name:         "gives_up_after_conflicts",
+			db:           &mockUserItem{mockUpdateItem: mockUpdateItem{Errors: []error{conflict, conflict, conflict}}, Version: "2"},
+			wantCalls:    userItemUpdateAttempts,
+			wantGetCalls: userItemUpdateAttempts - 1,
+			wantResult:   true,
+			wantErr:      true,
+		},
+		{
+			name:       "error",
+			db:         &mockUserItem{mockUpdateItem: mockUpdateItem{Errors: []error{fmt.Errorf("example error")}}},
+			wantCalls:  1,
+			wantResult: true,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wg := &sync.WaitGroup{}
+			wg.Add(1)
+			ch := make(chan resultDB, 1)
+			event := createCustomerEvent{CognitoUserID: "12345", StripeCustomerID: "cus_01234", Version: 1}
+			updateUserItem(context.TODO(), wg, ch, tt.db, "example", event)
+			close(ch)
+			if len(tt.db.Inputs) != tt.wantCalls {
+				t.Errorf("updateUserItem() made %v updates, want %v", len(tt.db.Inputs), tt.wantCalls)
+			}
+			if tt.db.GetCalls != tt.wantGetCalls {
+				t.Errorf("updateUserItem() made %v reads, want %v", tt.db.GetCalls, tt.wantGetCalls)
+			}
+			res, ok := <-ch
+			if ok != tt.wantResult {
+				t.Fatalf("updateUserItem() result = %+v, want result %v", res, tt.wantResult)
+			}
+			if (res.Error != nil) != tt.wantErr {
+				t.Errorf("updateUserItem() error = %v, wantErr %v", res.Error, tt.wantErr)
+			}
+			if tt.wantGetCalls > 0 {
+				retried := tt.db.Inputs[1].ExpressionAttributeValues[":version"].(*types.AttributeValueMemberN).Value
+				if retried != "2" {
+					t.Errorf("updateUserItem() retried at version %v, want 2", retried)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package onboarding
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/seanturner026/maido-lambdas/internal/envelope"
+	"github.com/seanturner026/maido-lambdas/internal/logging"
+	"github.com/seanturner026/maido-lambdas/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+type awsSQSSendMessageAPI interface {
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+// welcomeEmail is the message the welcome email stage sends for the mailer to render and deliver. When PII
+// encryption is enabled, EmailAddress and FirstName are sealed with the Encrypter under the same names as the
+// user item's attributes, and carry envelope.Prefix for the mailer to decrypt them.
+type welcomeEmail struct {
+	CognitoUserID    string `json:"cognitoUserID"`
+	EmailAddress     string `json:"email"`
+	FirstName        string `json:"firstName"`
+	StripeCustomerID string `json:"stripeCustomerID"`
+}
+
+func generateWelcomeEmailInput(ctx context.Context, queueURL string, event createCustomerEvent) (*sqs.SendMessageInput, error) {
+	email := welcomeEmail{
+		CognitoUserID:    event.CognitoUserID,
+		EmailAddress:     event.EmailAddress,
+		FirstName:        event.FirstName,
+		StripeCustomerID: event.StripeCustomerID,
+	}
+	if e := envelope.FromContext(ctx); e != nil {
+		var err error
+		if email.EmailAddress, err = e.Encrypt(ctx, "EmailAddress", email.EmailAddress); err != nil {
+			return nil, err
+		}
+		if email.FirstName, err = e.Encrypt(ctx, "FirstName", email.FirstName); err != nil {
+			return nil, err
+		}
+	}
+	body, err := json.Marshal(email)
+	if err != nil {
+		return nil, err
+	}
+	return &sqs.SendMessageInput{
+		MessageBody: aws.String(string(body)),
+		QueueUrl:    aws.String(queueURL),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			correlationIDAttribute: {DataType: aws.String("String"), StringValue: aws.String(event.CorrelationID)},
+		},
+	}, nil
+}
+
+// sendWelcomeEmail queues the welcome email of the event's user. A message delivered again after a later stage
+// failed does not send it twice, as the user has already reached WELCOME_EMAIL_SENT, but the mailer should still
+// expect the occasional duplicate from SQS.
+func sendWelcomeEmail(ctx context.Context, queue awsSQSSendMessageAPI, queueURL string, event *createCustomerEvent) error {
+	input, err := generateWelcomeEmailInput(ctx, queueURL, *event)
+	if err == nil {
+		_, err = queue.SendMessage(ctx, input)
+	}
+	if err != nil {
+		metrics.FromContext(ctx).Count(metricWelcomeEmailsFailed, 1)
+		logging.FromContext(ctx).WithFields(log.Fields{"cognito_user_id": event.CognitoUserID, "error": err}).Error("Unable to send welcome email")
+		return err
+	}
+	return nil
+}
+
+// welcomeEmailStage queues a welcome email for each event on WELCOME_EMAIL_QUEUE_URL.
+type welcomeEmailStage struct {
+	workers  limiter
+	queue    awsSQSSendMessageAPI
+	queueURL string
+}
+
+func newWelcomeEmailStage(cfg pipelineConfig) (Stage, error) {
+	queueURL, ok := os.LookupEnv("WELCOME_EMAIL_QUEUE_URL")
+	if !ok {
+		return nil, fmt.Errorf("environment variable WELCOME_EMAIL_QUEUE_URL is not set")
+	}
+	return welcomeEmailStage{workers: cfg.workers, queue: cfg.handler.SQS, queueURL: queueURL}, nil
+}
+
+func (s welcomeEmailStage) Name() string {
+	return stageWelcomeEmail
+}
+
+func (s welcomeEmailStage) Status() string {
+	return onboardingStatusWelcomeEmailSent
+}
+
+func (s welcomeEmailStage) Run(ctx context.Context, events []*createCustomerEvent) stageResult {
+	return stageResult{Failed: forEachEvent(ctx, s.workers, events, func(ctx context.Context, event *createCustomerEvent) error {
+		return sendWelcomeEmail(ctx, s.queue, s.queueURL, event)
+	})}
+}
@@ -0,0 +1,103 @@
+package onboarding
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/seanturner026/maido-lambdas/internal/envelope"
+	"github.com/seanturner026/maido-lambdas/internal/fakes"
+)
+
+func Test_generateWelcomeEmailInput(t *testing.T) {
+	event := createCustomerEvent{
+		CognitoUserID:    "01234567890",
+		EmailAddress:     "example@example.com",
+		FirstName:        "first",
+		SurName:          "last",
+		StripeCustomerID: "cus_01234",
+		CorrelationID:    "correlation-1",
+	}
+	got, err := generateWelcomeEmailInput(context.TODO(), "example_queue_url", event)
+	if err != nil {
+		t.Fatalf("generateWelcomeEmailInput() error = %v", err)
+	}
+	want := &sqs.SendMessageInput{
+		MessageBody: aws.String(`{"cognitoUserID":"01234567890","email":"example@example.com","firstName":"first","stripeCustomerID":"cus_01234"}`),
+		QueueUrl:    aws.String("example_queue_url"),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			correlationIDAttribute: {DataType: aws.String("String"), StringValue: aws.String("correlation-1")},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("generateWelcomeEmailInput() = %v, want %v", got, want)
+	}
+}
+
+func Test_generateWelcomeEmailInput_encrypted(t *testing.T) {
+	e := envelope.New(envelope.NewLocalKMS(), "alias/example")
+	ctx := envelope.NewContext(context.TODO(), e)
+	event := createCustomerEvent{
+		CognitoUserID:    "01234567890",
+		EmailAddress:     "example@example.com",
+		FirstName:        "Aiko",
+		StripeCustomerID: "cus_01234",
+	}
+	input, err := generateWelcomeEmailInput(ctx, "example_queue_url", event)
+	if err != nil {
+		t.Fatalf("generateWelcomeEmailInput() error = %v", err)
+	}
+	body := aws.ToString(input.MessageBody)
+	if strings.Contains(body, event.EmailAddress) || strings.Contains(body, event.FirstName) {
+		t.Errorf("generateWelcomeEmailInput() body = %v, want no plaintext PII", body)
+	}
+	got := welcomeEmail{}
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.CognitoUserID != event.CognitoUserID || got.StripeCustomerID != event.StripeCustomerID {
+		t.Errorf("generateWelcomeEmailInput() IDs = %v, %v, want them in plaintext", got.CognitoUserID, got.StripeCustomerID)
+	}
+	for name, value := range map[string]string{"EmailAddress": got.EmailAddress, "FirstName": got.FirstName} {
+		plaintext, err := e.Decrypt(ctx, name, value)
+		if err != nil {
+			t.Fatalf("Decrypt(%v) error = %v", name, err)
+		}
+		if want := map[string]string{"EmailAddress": event.EmailAddress, "FirstName": event.FirstName}[name]; plaintext != want {
+			t.Errorf("Decrypt(%v) = %v, want %v", name, plaintext, want)
+		}
+	}
+}
+
+func Test_sendWelcomeEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    bool
+		wantErr bool
+	}{
+		{name: "sent"},
+		{name: "error", fail: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := fakes.NewSQS()
+			if tt.fail {
+				queue.FailOn("SendMessage", 0, fmt.Errorf("example error"))
+			}
+			event := &createCustomerEvent{CognitoUserID: "01234567890", EmailAddress: "example@example.com"}
+			err := sendWelcomeEmail(context.TODO(), queue, "example_queue_url", event)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("sendWelcomeEmail() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if sent := queue.SentTo("example_queue_url"); (len(sent) == 1) == tt.wantErr {
+				t.Errorf("sendWelcomeEmail() sent %v", sent)
+			}
+		})
+	}
+}
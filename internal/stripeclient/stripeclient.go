@@ -0,0 +1,44 @@
+// Package stripeclient builds the Stripe client used by the lambdas. The API is reached at api.stripe.com unless
+// STRIPE_API_BASE_URL points it somewhere else, such as cmd/fake_stripe in integration tests.
+package stripeclient
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/client"
+)
+
+// Backends returns backends that send every request to baseURL, or nil for Stripe's own backends when baseURL is
+// empty.
+func Backends(baseURL string) (*stripe.Backends, error) {
+	if baseURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse Stripe base URL %q: %w", baseURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("the Stripe base URL must be an absolute http or https URL, got %q", baseURL)
+	}
+	backend := func(backendType stripe.SupportedBackend) stripe.Backend {
+		return stripe.GetBackendWithConfig(backendType, &stripe.BackendConfig{URL: stripe.String(baseURL)})
+	}
+	return &stripe.Backends{
+		API:     backend(stripe.APIBackend),
+		Connect: backend(stripe.ConnectBackend),
+		Uploads: backend(stripe.UploadsBackend),
+	}, nil
+}
+
+// FromEnv returns a client for the key in STRIPE_API_KEY that talks to STRIPE_API_BASE_URL when it is set.
+func FromEnv() (*client.API, error) {
+	backends, err := Backends(os.Getenv("STRIPE_API_BASE_URL"))
+	if err != nil {
+		return nil, fmt.Errorf("environment variable STRIPE_API_BASE_URL is invalid: %w", err)
+	}
+	return client.New(os.Getenv("STRIPE_API_KEY"), backends), nil
+}
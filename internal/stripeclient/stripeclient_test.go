@@ -0,0 +1,49 @@
+package stripeclient
+
+import "testing"
+
+func TestBackends(t *testing.T) {
+	tests := []struct {
+		name    string
+		baseURL string
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "default", baseURL: "", wantNil: true},
+		{name: "local", baseURL: "http://localhost:12111"},
+		{name: "https", baseURL: "https://stripe.example.com/"},
+		{name: "relative", baseURL: "localhost:12111", wantErr: true},
+		{name: "unsupported_scheme", baseURL: "ftp://localhost", wantErr: true},
+		{name: "unparseable", baseURL: "http://[::1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Backends(tt.baseURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Backends() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (got == nil) != tt.wantNil {
+				t.Errorf("Backends() = %v, wantNil %v", got, tt.wantNil)
+			}
+			if got != nil && (got.API == nil || got.Connect == nil || got.Uploads == nil) {
+				t.Errorf("Backends() = %+v, want every backend set", got)
+			}
+		})
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("STRIPE_API_KEY", "sk_test_fake")
+	t.Setenv("STRIPE_API_BASE_URL", "not a url")
+	if _, err := FromEnv(); err == nil {
+		t.Errorf("FromEnv() error = nil, want an error for an invalid STRIPE_API_BASE_URL")
+	}
+	t.Setenv("STRIPE_API_BASE_URL", "http://localhost:12111")
+	sc, err := FromEnv()
+	if err != nil || sc.Customers == nil {
+		t.Errorf("FromEnv() = %v, %v", sc, err)
+	}
+}
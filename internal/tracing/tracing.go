@@ -0,0 +1,121 @@
+// Package tracing configures OpenTelemetry for the lambdas with X-Ray compatible trace IDs and propagation,
+// so spans exported through the AWS Distro for OpenTelemetry collector land in X-Ray and continue the trace
+// of whichever producer sent the SQS message being processed.
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/seanturner026/maido-lambdas"
+
+// sqsTraceHeaderAttribute is the SQS system attribute carrying the X-Ray trace header of the producer.
+const sqsTraceHeaderAttribute = "AWSTraceHeader"
+
+// Init installs a global TracerProvider that generates X-Ray compatible IDs and propagates the X-Ray trace
+// header. Spans are only exported when OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is
+// set, in which case they are sent over OTLP/HTTP, typically to the ADOT collector Lambda layer. The returned
+// function flushes buffered spans and must be called before each invocation returns, because the Lambda
+// environment is frozen between invocations.
+func Init(ctx context.Context, serviceName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(xray.Propagator{})
+	if !exporterConfigured() {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	provider := NewProvider(serviceName, sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+	return provider.ForceFlush, nil
+}
+
+// NewProvider returns a TracerProvider that samples every span and generates X-Ray compatible IDs.
+func NewProvider(serviceName string, opts ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	opts = append([]sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+		sdktrace.WithIDGenerator(xray.NewIDGenerator()),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceNameKey.String(serviceName))),
+	}, opts...)
+	return sdktrace.NewTracerProvider(opts...)
+}
+
+func exporterConfigured() bool {
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"} {
+		if os.Getenv(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Start starts a span named name as a child of the span in ctx.
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.GetTracerProvider().Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End records err, if any, on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// ContextFromSQSMessage returns ctx with the remote span context from the AWSTraceHeader system attribute of
+// message, or ctx unchanged when the message carries no valid trace header.
+func ContextFromSQSMessage(ctx context.Context, message events.SQSMessage) context.Context {
+	header := message.Attributes[sqsTraceHeaderAttribute]
+	if header == "" {
+		return ctx
+	}
+	return xray.Propagator{}.Extract(ctx, propagation.MapCarrier{"X-Amzn-Trace-Id": header})
+}
+
+// StartFromSQSEvent starts the root span of an SQS triggered invocation. The span continues the trace of the
+// first message that carries a trace header and links to the traces of the remaining messages, because a
+// batch can contain messages from several producers.
+func StartFromSQSEvent(ctx context.Context, name string, event events.SQSEvent) (context.Context, trace.Span) {
+	parent := ctx
+	links := []trace.Link{}
+	for _, record := range event.Records {
+		remote := trace.SpanContextFromContext(ContextFromSQSMessage(ctx, record))
+		if !remote.IsValid() {
+			continue
+		}
+		if !trace.SpanContextFromContext(parent).IsValid() {
+			parent = trace.ContextWithRemoteSpanContext(ctx, remote)
+			continue
+		}
+		links = append(links, trace.Link{
+			SpanContext: remote,
+			Attributes:  []attribute.KeyValue{semconv.MessagingMessageIDKey.String(record.MessageId)},
+		})
+	}
+	return otel.GetTracerProvider().Tracer(instrumentationName).Start(
+		parent,
+		name,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("AmazonSQS"),
+			semconv.MessagingOperationProcess,
+			attribute.Int("messaging.batch.message_count", len(event.Records)),
+		),
+	)
+}
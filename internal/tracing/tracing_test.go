@@ -0,0 +1,101 @@
+package tracing_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/seanturner026/maido-lambdas/internal/tracing"
+	"github.com/seanturner026/maido-lambdas/internal/tracing/tracingtest"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	producerTraceHeader = "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"
+	producerTraceID     = "5759e988bd862e3fe1be46a994272793"
+	otherTraceHeader    = "Root=1-61f1b1a0-0123456789abcdef01234567;Parent=0123456789abcdef;Sampled=1"
+)
+
+func TestContextFromSQSMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		message     events.SQSMessage
+		wantValid   bool
+		wantTraceID string
+	}{
+		{
+			name:        "trace_header",
+			message:     events.SQSMessage{Attributes: map[string]string{"AWSTraceHeader": producerTraceHeader}},
+			wantValid:   true,
+			wantTraceID: producerTraceID,
+		},
+		{
+			name:    "no_trace_header",
+			message: events.SQSMessage{Attributes: map[string]string{}},
+		},
+		{
+			name:    "malformed_trace_header",
+			message: events.SQSMessage{Attributes: map[string]string{"AWSTraceHeader": "Root=invalid"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := trace.SpanContextFromContext(tracing.ContextFromSQSMessage(context.Background(), tt.message))
+			if got.IsValid() != tt.wantValid {
+				t.Fatalf("ContextFromSQSMessage() valid = %v, want %v", got.IsValid(), tt.wantValid)
+			}
+			if tt.wantValid && got.TraceID().String() != tt.wantTraceID {
+				t.Errorf("ContextFromSQSMessage() trace ID = %v, want %v", got.TraceID(), tt.wantTraceID)
+			}
+		})
+	}
+}
+
+func TestStartFromSQSEvent(t *testing.T) {
+	recorder := tracingtest.NewRecorder(t, "test")
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		{MessageId: "1"},
+		{MessageId: "2", Attributes: map[string]string{"AWSTraceHeader": producerTraceHeader}},
+		{MessageId: "3", Attributes: map[string]string{"AWSTraceHeader": otherTraceHeader}},
+	}}
+	ctx, span := tracing.StartFromSQSEvent(context.Background(), "handler", event)
+	_, child := tracing.Start(ctx, "stripe")
+	tracing.End(child, fmt.Errorf("example error"))
+	tracing.End(span, nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("recorded %v spans, want 2", len(spans))
+	}
+	root := spans[1]
+	if got := root.SpanContext().TraceID().String(); got != producerTraceID {
+		t.Errorf("root trace ID = %v, want %v", got, producerTraceID)
+	}
+	if got := root.Parent().SpanID().String(); got != "53995c3f42cd8ad8" {
+		t.Errorf("root parent span ID = %v, want 53995c3f42cd8ad8", got)
+	}
+	if len(root.Links()) != 1 || root.Links()[0].SpanContext.TraceID().String() != "61f1b1a00123456789abcdef01234567" {
+		t.Errorf("root links = %v, want one link to the second producer", root.Links())
+	}
+	stage := spans[0]
+	if stage.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Errorf("stage parent = %v, want %v", stage.Parent().SpanID(), root.SpanContext().SpanID())
+	}
+	if stage.Status().Code != codes.Error {
+		t.Errorf("stage status = %v, want error", stage.Status().Code)
+	}
+}
+
+func TestInit_withoutExporter(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	flush, err := tracing.Init(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := flush(context.Background()); err != nil {
+		t.Errorf("flush() error = %v", err)
+	}
+}
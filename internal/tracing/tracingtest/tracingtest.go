@@ -0,0 +1,22 @@
+// Package tracingtest records the spans ended by code under test.
+package tracingtest
+
+import (
+	"testing"
+
+	"github.com/seanturner026/maido-lambdas/internal/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// NewRecorder sets a global TracerProvider built like the lambdas' own that records every span of serviceName,
+// and restores the previous provider when the test finishes.
+func NewRecorder(t testing.TB, serviceName string) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(tracing.NewProvider(serviceName, sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}